@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/unknwon/com"
@@ -35,6 +36,17 @@ func (c *RedisCache) Initialize(client *redis.Client, occupyMode bool) {
 	c.occupyMode = occupyMode
 }
 
+// WithContext 返回使用ctx访问redis的缓存，与原缓存共用同一个pipeline
+// 在消息处理中应传入request.Context()，连接断开或处理超时后尚未执行的操作会被跳过
+func (c *RedisCache) WithContext(ctx context.Context) *RedisCache {
+	return &RedisCache{
+		plr:        c.plr.WithContext(ctx),
+		hsetName:   c.hsetName,
+		prefix:     c.prefix,
+		occupyMode: c.occupyMode,
+	}
+}
+
 // Put 设置缓存。如果expire为0，永不删除
 func (c *RedisCache) Put(key string, val interface{}, expire time.Duration) error {
 	key = c.prefix + key
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"gonet/config"
+	gnet "gonet/net"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// options 压测参数
+type options struct {
+	//客户端使用的配置，为nil时使用config.Default()
+	Conf     *config.GlobalObj
+	Network  string
+	Addr     string
+	Conns    int
+	Duration time.Duration
+	//全部连接每秒发送的消息数，0表示每个连接收到回复后立即发送下一条
+	Rate    float64
+	Timeout time.Duration
+	Mix     *mix
+	Seed    int64
+}
+
+// connStats 一个连接的统计
+type connStats struct {
+	latencies    []time.Duration
+	sent         int64
+	received     int64
+	errors       int64
+	timeouts     int64
+	mismatches   int64
+	disconnected bool
+	dialFailed   bool
+}
+
+// run 开启opts.Conns个连接同时发送，Duration结束后等待已经发送的消息的回复
+func run(opts options) *report {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Duration)
+	defer cancel()
+
+	stats := make([]*connStats, opts.Conns)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < opts.Conns; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stats[i] = runConn(ctx, opts, i)
+		}(i)
+	}
+	wg.Wait()
+	return newReport(opts, time.Since(start), stats)
+}
+
+// runConn 在一个连接上循环发送消息并等待回复，同一时间只有一条消息等待回复
+// 限速时延迟从计划的发送时间开始计算，避免服务器变慢时少发的消息掩盖真实的延迟
+func runConn(ctx context.Context, opts options, id int) *connStats {
+	stats := &connStats{}
+	client, err := gnet.Dial(opts.Network, opts.Addr, gnet.WithDialConfig(opts.Conf))
+	if err != nil {
+		stats.dialFailed = true
+		return stats
+	}
+	defer client.Close()
+
+	r := rand.New(rand.NewSource(opts.Seed + int64(id)))
+	payloads := make(map[mixEntry][]byte)
+	var interval time.Duration
+	if opts.Rate > 0 {
+		interval = time.Duration(float64(time.Second) * float64(opts.Conns) / opts.Rate)
+	}
+	//错开各个连接的第一次发送
+	next := time.Now()
+	if interval > 0 {
+		next = next.Add(time.Duration(r.Int63n(int64(interval))))
+	}
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for seq := uint64(0); ; seq++ {
+		scheduled := time.Now()
+		if interval > 0 {
+			if wait := time.Until(next); wait > 0 {
+				resetTimer(timer, wait)
+				select {
+				case <-ctx.Done():
+					return stats
+				case <-timer.C:
+				}
+			}
+			scheduled = next
+			next = next.Add(interval)
+		}
+		if ctx.Err() != nil {
+			return stats
+		}
+
+		e := opts.Mix.pick(r)
+		data := payloadFor(payloads, e, r, seq)
+		if err := client.SendMsg(context.Background(), e.MsgID, data); err != nil {
+			stats.errors++
+			stats.disconnected = true
+			return stats
+		}
+		stats.sent++
+
+		resetTimer(timer, opts.Timeout)
+		select {
+		case msg, ok := <-client.Messages():
+			if !ok {
+				stats.disconnected = true
+				return stats
+			}
+			if msg.GetMsgId() != e.MsgID || !bytes.Equal(msg.GetData(), data) {
+				stats.mismatches++
+				continue
+			}
+			stats.received++
+			stats.latencies = append(stats.latencies, time.Since(scheduled))
+		case <-timer.C:
+			//超时的回复会打乱之后的消息，放弃该连接
+			stats.timeouts++
+			return stats
+		}
+	}
+}
+
+// payloadFor 返回e对应的消息内容，前8字节写入序号，用于校验回复
+func payloadFor(payloads map[mixEntry][]byte, e mixEntry, r *rand.Rand, seq uint64) []byte {
+	data, ok := payloads[e]
+	if !ok {
+		data = make([]byte, e.Size)
+		r.Read(data)
+		payloads[e] = data
+	}
+	if len(data) >= 8 {
+		binary.LittleEndian.PutUint64(data, seq)
+	}
+	return data
+}
+
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
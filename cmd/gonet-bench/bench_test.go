@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"gonet/config"
+)
+
+// benchConfig 测试环境不一定有私有IP地址，echo服务器使用固定的机器ID
+func benchConfig() *config.GlobalObj {
+	conf := config.Default()
+	conf.MachineID = 1
+	return conf
+}
+
+func TestParseMix(t *testing.T) {
+	m, err := parseMix("1:64:3, 2:0,1:128")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.entries) != 3 || m.total != 5 {
+		t.Fatalf("unexpected mix %+v", m)
+	}
+	if ids := m.msgIDs(); len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Fatalf("unexpected msgIDs %v", ids)
+	}
+	counts := make(map[mixEntry]int)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		counts[m.pick(r)]++
+	}
+	if c := counts[m.entries[0]]; c < 2700 || c > 3300 {
+		t.Fatalf("weight 3 of 5 picked %d times of 5000", c)
+	}
+	for _, bad := range []string{"", "1", "x:1", "1:-1", "1:1:0", "1:1:1:1"} {
+		if _, err := parseMix(bad); err == nil {
+			t.Fatalf("mix %q should fail", bad)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 100; i >= 1; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	l := latencyOf(latencies)
+	if l.Min != 1 || l.P50 != 50 || l.P90 != 90 || l.P99 != 99 || l.P999 != 100 || l.Max != 100 || l.Mean != 50.5 {
+		t.Fatalf("unexpected latency %+v", l)
+	}
+	if l := latencyOf(nil); l != (latency{}) {
+		t.Fatalf("empty latency should be zero, got %+v", l)
+	}
+}
+
+func TestRun_EchoServer(t *testing.T) {
+	m, _ := parseMix("1:16:1,2:1024:1")
+	s, addr, err := startEchoServer(benchConfig(), "127.0.0.1:0", 100, m.msgIDs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	r := run(options{
+		Network:  addr.Network(),
+		Addr:     addr.String(),
+		Conns:    4,
+		Duration: 300 * time.Millisecond,
+		Rate:     400,
+		Timeout:  time.Second,
+		Mix:      m,
+		Seed:     1,
+	})
+	if r.Received == 0 || r.Received != r.Sent {
+		t.Fatalf("sent %d, received %d", r.Sent, r.Received)
+	}
+	if r.Errors+r.Timeouts+r.Mismatches+r.Disconnects+r.DialErrors != 0 {
+		t.Fatalf("unexpected failures %+v", r)
+	}
+	//限速400条每秒，300毫秒内不应超过限速太多
+	if r.Sent > 160 {
+		t.Fatalf("rate limit exceeded, sent %d", r.Sent)
+	}
+	if r.Latency.P50 <= 0 || r.Latency.P99 < r.Latency.P50 {
+		t.Fatalf("unexpected latency %+v", r.Latency)
+	}
+
+	var buf bytes.Buffer
+	if err := r.writeJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || decoded.Received != r.Received {
+		t.Fatalf("json report: %v %+v", err, decoded)
+	}
+	buf.Reset()
+	_ = r.writeText(&buf)
+	if !strings.Contains(buf.String(), "p99") {
+		t.Fatalf("text report missing latency: %s", buf.String())
+	}
+
+	//服务器关闭后连接失败
+	s.Stop()
+	r = run(options{Network: addr.Network(), Addr: addr.String(), Conns: 2, Duration: 50 * time.Millisecond, Timeout: time.Second, Mix: m})
+	if r.DialErrors != 2 {
+		t.Fatalf("want 2 dial errors, got %+v", r)
+	}
+}
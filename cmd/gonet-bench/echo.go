@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"gonet/config"
+	"gonet/interfaces"
+	gnet "gonet/net"
+	"net"
+)
+
+// echoRouter 将收到的消息原样返回
+type echoRouter struct {
+	gnet.BaseRouter
+}
+
+func (r *echoRouter) Handle(request interfaces.IRequest) {
+	_ = request.GetConn().SendMsg(request.GetMsgID(), request.GetData())
+}
+
+// startEchoServer 使用conf在addr上启动一个echo服务器，为mix中的每个msgID注册echoRouter
+func startEchoServer(conf *config.GlobalObj, addr string, maxConn int, msgIDs []uint32) (*gnet.Server, net.Addr, error) {
+	s := gnet.NewServerWithConfig(conf,
+		gnet.WithName("gonet-bench"),
+		gnet.WithMaxConn(maxConn),
+		gnet.WithUDPPort(0),
+		gnet.WithListener(&gnet.ListenerConfig{
+			Name:    gnet.DefaultListenerName,
+			Network: "tcp",
+			Address: addr,
+		}),
+	).(*gnet.Server)
+	for _, id := range msgIDs {
+		s.AddRouter(id, &echoRouter{})
+	}
+	s.Start()
+	listenAddr, ok := s.ListenerAddrs()[gnet.DefaultListenerName]
+	if !ok {
+		s.Stop()
+		return nil, nil, errors.New("echo server listen " + addr + " failed")
+	}
+	return s, listenAddr, nil
+}
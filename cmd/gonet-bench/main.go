@@ -0,0 +1,118 @@
+// gonet-bench GoNet服务器的压测工具
+// 未指定-addr时在本机启动一个自带echo路由的服务器并对其压测，-serve只启动该echo服务器
+//
+//	gonet-bench -conns 100 -duration 10s -rate 20000 -mix 1:64:9,2:4096:1 -format json
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gonet/config"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	var (
+		addr     = flag.String("addr", "", "压测的服务器地址，为空时在本机启动自带的echo服务器")
+		network  = flag.String("network", "tcp", "tcp、tcp4、tcp6或unix")
+		listen   = flag.String("listen", "127.0.0.1:0", "自带echo服务器的监听地址")
+		serve    = flag.Bool("serve", false, "只启动自带的echo服务器，直到收到SIGINT/SIGTERM")
+		conns    = flag.Int("conns", 50, "并发的连接数")
+		duration = flag.Duration("duration", 10*time.Second, "压测时长")
+		rate     = flag.Float64("rate", 0, "全部连接每秒发送的消息数，0表示收到回复后立即发送")
+		timeout  = flag.Duration("timeout", 5*time.Second, "等待回复的超时时间")
+		mixFlag  = flag.String("mix", "1:64", "发送的消息，msgID:size[:weight]，多种消息用逗号分隔")
+		format   = flag.String("format", "text", "结果的格式，text或json")
+		seed     = flag.Int64("seed", time.Now().UnixNano(), "随机数种子")
+		confPath = flag.String("config", "", "配置文件，为空时只读取GONET_开头的环境变量")
+	)
+	confFlags := config.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	conf, err := loadConfig(*confPath, confFlags)
+	if err != nil {
+		fatal(err)
+	}
+
+	m, err := parseMix(*mixFlag)
+	if err != nil {
+		fatal(err)
+	}
+	for _, e := range m.entries {
+		if uint32(e.Size) > conf.MaxPacketSize {
+			fatal(fmt.Errorf("msgID %d size %d exceeds MaxPacketSize %d", e.MsgID, e.Size, conf.MaxPacketSize))
+		}
+	}
+	if *format != "text" && *format != "json" {
+		fatal(fmt.Errorf("unknown format %q", *format))
+	}
+
+	if *addr == "" || *serve {
+		maxConn := conf.MaxConn
+		if *conns > maxConn {
+			maxConn = *conns
+		}
+		s, listenAddr, err := startEchoServer(conf, *listen, maxConn, m.msgIDs())
+		if err != nil {
+			fatal(err)
+		}
+		defer s.Stop()
+		if *serve {
+			fmt.Fprintf(os.Stderr, "echo server listening at %s\n", listenAddr)
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+			<-signals
+			return
+		}
+		*addr = listenAddr.String()
+		*network = listenAddr.Network()
+	}
+
+	r := run(options{
+		Conf:     conf,
+		Network:  *network,
+		Addr:     *addr,
+		Conns:    *conns,
+		Duration: *duration,
+		Rate:     *rate,
+		Timeout:  *timeout,
+		Mix:      m,
+		Seed:     *seed,
+	})
+	if *format == "json" {
+		err = r.writeJSON(os.Stdout)
+	} else {
+		err = r.writeText(os.Stdout)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// loadConfig 读取配置文件或环境变量，再使用命令行参数覆盖
+func loadConfig(path string, flags *config.Flags) (*config.GlobalObj, error) {
+	var (
+		conf *config.GlobalObj
+		err  error
+	)
+	if path != "" {
+		conf, err = config.Load(path)
+	} else {
+		conf, err = config.LoadEnv()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := flags.Apply(conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "gonet-bench:", err)
+	os.Exit(1)
+}
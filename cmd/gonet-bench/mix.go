@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// mixEntry 一种发送的消息：msgID、消息内容的长度及被选中的权重
+type mixEntry struct {
+	MsgID  uint32 `json:"msg_id"`
+	Size   int    `json:"size"`
+	Weight int    `json:"weight"`
+}
+
+// mix 按权重随机选择发送的消息
+type mix struct {
+	entries []mixEntry
+	total   int
+}
+
+// parseMix 解析msgID:size[:weight]，多种消息用逗号分隔，例如 1:64:9,2:4096:1
+func parseMix(s string) (*mix, error) {
+	m := &mix{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.Split(item, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("mix %q: want msgID:size[:weight]", item)
+		}
+		msgID, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("mix %q: bad msgID: %v", item, err)
+		}
+		size, err := strconv.Atoi(parts[1])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("mix %q: bad size", item)
+		}
+		weight := 1
+		if len(parts) == 3 {
+			if weight, err = strconv.Atoi(parts[2]); err != nil || weight <= 0 {
+				return nil, fmt.Errorf("mix %q: bad weight", item)
+			}
+		}
+		m.entries = append(m.entries, mixEntry{MsgID: uint32(msgID), Size: size, Weight: weight})
+		m.total += weight
+	}
+	if len(m.entries) == 0 {
+		return nil, errors.New("mix is empty")
+	}
+	return m, nil
+}
+
+// pick 按权重选择一种消息
+func (m *mix) pick(r *rand.Rand) mixEntry {
+	n := r.Intn(m.total)
+	for _, e := range m.entries {
+		if n < e.Weight {
+			return e
+		}
+		n -= e.Weight
+	}
+	return m.entries[len(m.entries)-1]
+}
+
+// msgIDs 返回全部的msgID，用于给自带的echo服务器注册路由
+func (m *mix) msgIDs() []uint32 {
+	ids := make([]uint32, 0, len(m.entries))
+	seen := make(map[uint32]bool)
+	for _, e := range m.entries {
+		if !seen[e.MsgID] {
+			seen[e.MsgID] = true
+			ids = append(ids, e.MsgID)
+		}
+	}
+	return ids
+}
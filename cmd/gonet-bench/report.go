@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// latency 延迟统计，单位毫秒
+type latency struct {
+	Min  float64 `json:"min_ms"`
+	Mean float64 `json:"mean_ms"`
+	P50  float64 `json:"p50_ms"`
+	P90  float64 `json:"p90_ms"`
+	P99  float64 `json:"p99_ms"`
+	P999 float64 `json:"p999_ms"`
+	Max  float64 `json:"max_ms"`
+}
+
+// report 压测结果
+type report struct {
+	Addr        string     `json:"addr"`
+	Conns       int        `json:"conns"`
+	Rate        float64    `json:"target_rate"`
+	Mix         []mixEntry `json:"mix"`
+	Elapsed     float64    `json:"elapsed_s"`
+	Sent        int64      `json:"sent"`
+	Received    int64      `json:"received"`
+	Throughput  float64    `json:"throughput"`
+	Errors      int64      `json:"errors"`
+	Timeouts    int64      `json:"timeouts"`
+	Mismatches  int64      `json:"mismatches"`
+	Disconnects int64      `json:"disconnects"`
+	DialErrors  int64      `json:"dial_errors"`
+	Latency     latency    `json:"latency"`
+}
+
+func newReport(opts options, elapsed time.Duration, stats []*connStats) *report {
+	r := &report{
+		Addr:    opts.Addr,
+		Conns:   opts.Conns,
+		Rate:    opts.Rate,
+		Mix:     opts.Mix.entries,
+		Elapsed: elapsed.Seconds(),
+	}
+	var latencies []time.Duration
+	for _, s := range stats {
+		if s.dialFailed {
+			r.DialErrors++
+			continue
+		}
+		r.Sent += s.sent
+		r.Received += s.received
+		r.Errors += s.errors
+		r.Timeouts += s.timeouts
+		r.Mismatches += s.mismatches
+		if s.disconnected {
+			r.Disconnects++
+		}
+		latencies = append(latencies, s.latencies...)
+	}
+	if elapsed > 0 {
+		r.Throughput = float64(r.Received) / elapsed.Seconds()
+	}
+	r.Latency = latencyOf(latencies)
+	return r
+}
+
+// latencyOf 计算延迟的分位数，使用最近排名法
+func latencyOf(latencies []time.Duration) latency {
+	if len(latencies) == 0 {
+		return latency{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var sum time.Duration
+	for _, l := range latencies {
+		sum += l
+	}
+	return latency{
+		Min:  ms(latencies[0]),
+		Mean: ms(sum / time.Duration(len(latencies))),
+		P50:  ms(percentile(latencies, 50)),
+		P90:  ms(percentile(latencies, 90)),
+		P99:  ms(percentile(latencies, 99)),
+		P999: ms(percentile(latencies, 99.9)),
+		Max:  ms(latencies[len(latencies)-1]),
+	}
+}
+
+// percentile sorted需要已经升序排列
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p/100*float64(len(sorted))+0.999999) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (r *report) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r *report) writeText(w io.Writer) error {
+	rate := "unlimited"
+	if r.Rate > 0 {
+		rate = fmt.Sprintf("%.0f msg/s", r.Rate)
+	}
+	_, err := fmt.Fprintf(w, `target:      %s
+conns:       %d
+rate:        %s
+elapsed:     %.2fs
+sent:        %d
+received:    %d
+throughput:  %.1f msg/s
+errors:      %d
+timeouts:    %d
+mismatches:  %d
+disconnects: %d
+dial errors: %d
+latency(ms): min %.3f  mean %.3f  p50 %.3f  p90 %.3f  p99 %.3f  p99.9 %.3f  max %.3f
+`,
+		r.Addr, r.Conns, rate, r.Elapsed, r.Sent, r.Received, r.Throughput,
+		r.Errors, r.Timeouts, r.Mismatches, r.Disconnects, r.DialErrors,
+		r.Latency.Min, r.Latency.Mean, r.Latency.P50, r.Latency.P90, r.Latency.P99, r.Latency.P999, r.Latency.Max)
+	return err
+}
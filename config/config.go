@@ -8,12 +8,29 @@
 package config
 
 import (
+	"errors"
 	"github.com/go-ini/ini"
 	"os"
+	"strings"
 
 	interfaces "gonet/interfaces"
 )
 
+// listenerPrefix 监听配置的section名称前缀
+const listenerPrefix = "Listener."
+
+// ListenerConf 监听配置，对应配置文件中的[Listener.xxx]
+type ListenerConf struct {
+	Name      string   // 监听名称，即section名称中Listener.之后的部分
+	Network   string   // tcp、tcp4、tcp6或unix
+	Address   string   // host:port或unix socket路径
+	Tags      []string // 该监听接受的连接所携带的标签
+	MaxConn   int      // 该监听允许的最大连接数，0表示只受Server的MaxConn限制
+	CertFile  string   // TLS证书，与KeyFile同时配置时启用TLS
+	KeyFile   string   // TLS私钥
+	ReusePort int      // 大于1时使用SO_REUSEPORT开启多个监听，每个监听独立accept
+}
+
 /*
 存储一切全局参数，供其他模块使用
 一些参数也可以通过server.json来配置
@@ -27,13 +44,48 @@ type GlobalObj struct {
 	Host      string             // 当前服务器主机IP
 	TCPPort   int                // 当前服务器主机监听端口号
 	Name      string             // 当前服务器名称
+	UDPPort   int                // 当前服务器UDP监听端口号，0表示不启用
+
+	// 额外的监听配置，未配置时只监听Host:TCPPort
+	Listeners []ListenerConf
 
-	Version          string // 当前服务版本号
-	MaxPacketSize    uint32 // 都需数据包的最大值
-	MaxConn          int    // 当前服务器主机允许的最大链接个数
-	WorkerPoolSize   uint   // 业务工作Worker池的数量
-	MaxWorkerTaskLen uint32 // 业务工作Worker对应负责的任务队列最大任务存储数量
-	MaxMsgChanLen    uint32 // SendBuffMsg发送消息的缓冲最大长度
+	Version           string // 当前服务版本号
+	MaxPacketSize     uint32 // 都需数据包的最大值
+	MaxConn           int    // 当前服务器主机允许的最大链接个数
+	WorkerPoolSize    uint   // 业务工作Worker池的数量
+	MaxWorkerTaskLen  uint32 // 业务工作Worker对应负责的任务队列最大任务存储数量
+	MaxMsgChanLen     uint32 // SendBuffMsg发送消息的缓冲最大长度
+	ConnMode          string // 连接处理模式，goroutine为每个连接一个读协程和一个写协程，epoll为少量poller协程统一处理(仅linux)
+	EpollPollers      int    // epoll模式下poller协程的个数，0表示使用CPU核数
+	EpollWriteTimeout int    // epoll模式下同步写的超时时间(毫秒)，超时的连接被关闭，0表示不限制
+	HandlerTimeout    int    // 消息处理的默认超时时间(毫秒)，超时后请求的ctx被取消，0表示不限制
+	MachineID         int    // 连接ID生成器(sonyflake)的机器ID，同一集群中每个进程必须不同，0表示使用私有IP地址的低16位
+
+	/*
+		socket
+	*/
+	TCPNoDelay     bool // 是否关闭Nagle算法
+	TCPKeepAlive   int  // TCP keepalive周期(秒)，0表示使用系统默认值，小于0表示关闭
+	TCPReadBuffer  int  // socket接收缓冲区大小(字节)，0表示使用系统默认值
+	TCPWriteBuffer int  // socket发送缓冲区大小(字节)，0表示使用系统默认值
+	TCPLinger      int  // SO_LINGER(秒)，小于0表示使用系统默认行为
+	TCPReusePort   int  // 大于1时默认监听使用SO_REUSEPORT开启多个accept
+
+	/*
+		udp
+	*/
+	UDPMtu         int // UDP数据包的最大长度
+	UDPInterval    int // ARQ刷新间隔(毫秒)
+	UDPSendWindow  int // ARQ发送窗口(segment个数)
+	UDPRecvWindow  int // ARQ接收窗口(segment个数)
+	UDPIdleTimeout int // UDP会话的空闲超时时间(秒)
+
+	/*
+		reliable
+	*/
+	ReliableWindowSize  int // 每个可靠会话允许的最大未确认消息数
+	ReliableRetention   int // 连接断开后可靠会话的保留时间(秒)
+	ReliableMaxSessions int // 最多保留的可靠会话个数，0表示不限制
 
 	/*
 		config file path
@@ -41,16 +93,29 @@ type GlobalObj struct {
 	ConfFilePath string
 	ConfigFile   *ini.File
 
+	/*
+		record
+	*/
+	RecordPath string // 消息录制文件，为空时不录制，扩展名为.jsonl时使用JSONL格式
+
+	/*
+		log
+	*/
+	LogLevel string // 日志级别：debug、info、warn、error
+
 	/*
 		fluentd
 	*/
-	FluentdHost      string
-	FluentdPort      int
-	FluentdDebugMode bool
+	FluentdEnable    bool   // 是否将日志发送给fluentd
+	FluentdHost      string // fluentd地址
+	FluentdPort      int    // fluentd forward端口
+	FluentdTag       string // fluentd中的日志标签
+	FluentdDebugMode bool   // 发送给fluentd的同时是否输出到标准输出
 }
 
 /*
 定义一个全局的对象
+未调用Load或Reload时为默认配置，NewServer()在未传入配置时使用该对象
 */
 var GlobalServerConfig *GlobalObj
 
@@ -66,60 +131,183 @@ func PathExists(path string) (bool, error) {
 	return false, err
 }
 
-// Reload 读取用户的配置文件
-func (g *GlobalObj) Reload() {
+// Default 返回全部使用默认值的配置，不读取任何文件及环境变量
+func Default() *GlobalObj {
+	g := &GlobalObj{}
+	pwd, err := os.Getwd()
+	if err != nil {
+		pwd = "."
+	}
+	g.ConfFilePath = pwd + "/conf/server.ini"
+	g.parse(ini.Empty(), &Errors{})
+	return g
+}
+
+// Load 读取path指定的配置文件，再使用环境变量覆盖，未配置的项使用默认值
+// 配置有误时返回Errors，包含全部错误
+func Load(path string) (*GlobalObj, error) {
+	g := Default()
+	g.ConfFilePath = path
+	if err := g.Reload(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// LoadEnv 不读取配置文件，只使用环境变量覆盖默认配置
+func LoadEnv() (*GlobalObj, error) {
+	g := Default()
+	file := ini.Empty()
+	applyEnv(file, os.Environ())
+	if err := g.load(file); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// LoadGlobal 读取配置文件并替换GlobalServerConfig，需要在创建Server之前调用
+func LoadGlobal(path string) error {
+	g, err := Load(path)
+	if err != nil {
+		return err
+	}
+	GlobalServerConfig = g
+	return nil
+}
+
+// Reload 重新读取ConfFilePath指定的配置文件及环境变量
+func (g *GlobalObj) Reload() error {
 	if confFileExists, _ := PathExists(g.ConfFilePath); !confFileExists {
-		panic("config file " + g.ConfFilePath + " is not exist!!")
-		return
+		return errors.New("config file " + g.ConfFilePath + " is not exist")
 	}
 
-	file, err := ini.Load(g.ConfFilePath)
+	file, err := readFile(g.ConfFilePath)
 	if err != nil {
-		panic("load config file " + g.ConfFilePath + " failed:" + err.Error())
-		return
+		return errors.New("load config file " + g.ConfFilePath + " failed: " + err.Error())
 	}
+	applyEnv(file, os.Environ())
+	return g.load(file)
+}
+
+// load 读取file中的全部配置并检查，格式错误及检查的错误一起返回
+func (g *GlobalObj) load(file *ini.File) error {
+	var errs Errors
+	g.parse(file, &errs)
+	errs = append(errs, g.validate()...)
+	return errs.err()
+}
+
+// parse 从配置文件中读取全部配置，格式错误的项使用默认值并记录到errs
+func (g *GlobalObj) parse(file *ini.File, errs *Errors) {
 	// 缓存配置给其他parser读取
 	g.ConfigFile = file
 
-	parseServer(g, file)
-	parseFluentd(g, file)
+	parseServer(g, file, errs)
+	parseListeners(g, file, errs)
+	parseSocket(g, file, errs)
+	parseUDP(g, file, errs)
+	parseReliable(g, file, errs)
+	parseRecord(g, file, errs)
+	parseLog(g, file, errs)
+	parseFluentd(g, file, errs)
 }
 
 /*
-提供init方法，默认加载
+提供init方法，默认使用默认配置，配置文件需要通过Load或LoadGlobal显式读取
 */
 func init() {
-	pwd, err := os.Getwd()
-	if err != nil {
-		pwd = "."
-	}
-	// 初始化GlobalObject变量，设置配置文件路径
-	GlobalServerConfig = &GlobalObj{
-		ConfFilePath: pwd + "/conf/server.ini",
-	}
-	// 从配置文件中加载一些用户配置的参数
-	GlobalServerConfig.Reload()
+	GlobalServerConfig = Default()
 }
 
 // 读取服务器配置信息
-func parseServer(config *GlobalObj, file *ini.File) {
-	section := file.Section("Server")
-	config.Name = section.Key("Name").MustString("hoxi-server")
-	config.Host = section.Key("Host").MustString("0.0.0.0")
-	config.TCPPort = section.Key("TCPPort").MustInt(8999)
-	config.IPVersion = section.Key("IPVersion").MustString("tcp4")
-	config.Version = section.Key("Version").MustString("V1")
-	config.MaxPacketSize = uint32(section.Key("MaxPacketSize").MustUint(4096))
-	config.MaxConn = section.Key("MaxConn").MustInt(12000)
-	config.WorkerPoolSize = section.Key("WorkerPoolSize").MustUint(10)
-	config.MaxWorkerTaskLen = uint32(section.Key("MaxWorkerTaskLen").MustUint(1024))
-	config.MaxMsgChanLen = uint32(section.Key("MaxMsgChanLen").MustUint(1024))
+func parseServer(config *GlobalObj, file *ini.File, errs *Errors) {
+	section := newKeyReader(file.Section("Server"), errs)
+	config.Name = section.String("Name", "hoxi-server")
+	config.Host = section.String("Host", "0.0.0.0")
+	config.TCPPort = section.Int("TCPPort", 8999)
+	config.UDPPort = section.Int("UDPPort", 0)
+	config.IPVersion = section.In("IPVersion", "tcp4", []string{"tcp", "tcp4", "tcp6"})
+	config.Version = section.String("Version", "V1")
+	config.MaxPacketSize = section.Uint32("MaxPacketSize", 4096)
+	config.MaxConn = section.Int("MaxConn", 12000)
+	config.WorkerPoolSize = section.Uint("WorkerPoolSize", 10)
+	config.MaxWorkerTaskLen = section.Uint32("MaxWorkerTaskLen", 1024)
+	config.MaxMsgChanLen = section.Uint32("MaxMsgChanLen", 1024)
+	config.ConnMode = section.In("ConnMode", "goroutine", []string{"goroutine", "epoll"})
+	config.EpollPollers = section.Int("EpollPollers", 0)
+	config.EpollWriteTimeout = section.Int("EpollWriteTimeout", 5000)
+	config.HandlerTimeout = section.Int("HandlerTimeout", 0)
+	config.MachineID = section.Int("MachineID", 0)
+}
+
+// 读取监听配置，每个[Listener.xxx]对应一个监听
+func parseListeners(config *GlobalObj, file *ini.File, errs *Errors) {
+	config.Listeners = nil
+	for _, s := range file.Sections() {
+		if !strings.HasPrefix(s.Name(), listenerPrefix) {
+			continue
+		}
+		section := newKeyReader(s, errs)
+		config.Listeners = append(config.Listeners, ListenerConf{
+			Name:      strings.TrimPrefix(s.Name(), listenerPrefix),
+			Network:   section.In("Network", "tcp", []string{"tcp", "tcp4", "tcp6", "unix"}),
+			Address:   section.String("Address", ""),
+			Tags:      section.Strings("Tags"),
+			MaxConn:   section.Int("MaxConn", 0),
+			CertFile:  section.String("CertFile", ""),
+			KeyFile:   section.String("KeyFile", ""),
+			ReusePort: section.Int("ReusePort", 0),
+		})
+	}
+}
+
+// 读取socket选项配置
+func parseSocket(config *GlobalObj, file *ini.File, errs *Errors) {
+	section := newKeyReader(file.Section("Socket"), errs)
+	config.TCPNoDelay = section.Bool("NoDelay", true)
+	config.TCPKeepAlive = section.Int("KeepAlive", 0)
+	config.TCPReadBuffer = section.Int("ReadBuffer", 0)
+	config.TCPWriteBuffer = section.Int("WriteBuffer", 0)
+	config.TCPLinger = section.Int("Linger", -1)
+	config.TCPReusePort = section.Int("ReusePort", 0)
+}
+
+// 读取UDP配置
+func parseUDP(config *GlobalObj, file *ini.File, errs *Errors) {
+	section := newKeyReader(file.Section("UDP"), errs)
+	config.UDPMtu = section.Int("MTU", 1200)
+	config.UDPInterval = section.Int("Interval", 10)
+	config.UDPSendWindow = section.Int("SendWindow", 128)
+	config.UDPRecvWindow = section.Int("RecvWindow", 128)
+	config.UDPIdleTimeout = section.Int("IdleTimeout", 30)
+}
+
+// 读取可靠消息配置
+func parseReliable(config *GlobalObj, file *ini.File, errs *Errors) {
+	section := newKeyReader(file.Section("Reliable"), errs)
+	config.ReliableWindowSize = section.Int("WindowSize", 256)
+	config.ReliableRetention = section.Int("Retention", 60)
+	config.ReliableMaxSessions = section.Int("MaxSessions", 10000)
+}
+
+// 读取消息录制配置
+func parseRecord(config *GlobalObj, file *ini.File, errs *Errors) {
+	section := newKeyReader(file.Section("Record"), errs)
+	config.RecordPath = section.String("Path", "")
+}
+
+// 读取日志配置
+func parseLog(config *GlobalObj, file *ini.File, errs *Errors) {
+	section := newKeyReader(file.Section("Log"), errs)
+	config.LogLevel = section.In("Level", "info", []string{"debug", "info", "warn", "error"})
 }
 
 // 读取Fluentd配置
-func parseFluentd(config *GlobalObj, file *ini.File) {
-	section := file.Section("Fluentd")
-	config.FluentdHost = section.Key("Host").MustString("127.0.0.1")
-	config.FluentdPort = section.Key("Port").MustInt(24224)
-	config.FluentdDebugMode = section.Key("DebugMode").MustBool(true)
+func parseFluentd(config *GlobalObj, file *ini.File, errs *Errors) {
+	section := newKeyReader(file.Section("Fluentd"), errs)
+	config.FluentdEnable = section.Bool("Enable", false)
+	config.FluentdTag = section.String("Tag", "gonet")
+	config.FluentdHost = section.String("Host", "127.0.0.1")
+	config.FluentdPort = section.Int("Port", 24224)
+	config.FluentdDebugMode = section.Bool("DebugMode", true)
 }
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDefault(t *testing.T) {
+	g := Default()
+	if g.TCPPort != 8999 || g.MaxPacketSize != 4096 || g.WorkerPoolSize != 10 || g.ConnMode != "goroutine" || g.LogLevel != "info" {
+		t.Fatalf("unexpected default config %+v", g)
+	}
+	if GlobalServerConfig == nil || GlobalServerConfig.MaxConn != g.MaxConn {
+		t.Fatal("global config should use default values")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "server.ini")
+	ini := "[Server]\nTCPPort=9001\nMaxPacketSize=128\n[Listener.admin]\nAddress=127.0.0.1:9002\n"
+	if err := os.WriteFile(path, []byte(ini), 0644); err != nil {
+		t.Fatal(err)
+	}
+	g, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.TCPPort != 9001 || g.MaxPacketSize != 128 || g.WorkerPoolSize != 10 {
+		t.Fatalf("unexpected config %+v", g)
+	}
+	if len(g.Listeners) != 1 || g.Listeners[0].Name != "admin" {
+		t.Fatalf("unexpected listeners %+v", g.Listeners)
+	}
+	//读取配置文件不影响全局配置
+	if GlobalServerConfig.TCPPort != 8999 {
+		t.Fatal("Load should not change global config")
+	}
+
+	if _, err := Load(filepath.Join(dir, "missing.ini")); err == nil {
+		t.Fatal("missing config file should fail")
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_Formats(t *testing.T) {
+	files := map[string]string{
+		"server.json": `{"Server": {"TCPPort": 9001, "MaxPacketSize": 128},
+			"Listener": {"admin": {"Address": "127.0.0.1:9002", "Tags": ["admin", "internal"]}}}`,
+		"server.yaml": "Server:\n  TCPPort: 9001\n  MaxPacketSize: 128\nListener:\n  admin:\n    Address: 127.0.0.1:9002\n    Tags: [admin, internal]\n",
+		"server.toml": "[Server]\nTCPPort = 9001\nMaxPacketSize = 128\n[Listener.admin]\nAddress = \"127.0.0.1:9002\"\nTags = [\"admin\", \"internal\"]\n",
+	}
+	for name, content := range files {
+		g, err := Load(writeFile(t, name, content))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if g.TCPPort != 9001 || g.MaxPacketSize != 128 || g.MaxConn != 12000 {
+			t.Fatalf("%s: unexpected config %+v", name, g)
+		}
+		if len(g.Listeners) != 1 || g.Listeners[0].Address != "127.0.0.1:9002" || len(g.Listeners[0].Tags) != 2 {
+			t.Fatalf("%s: unexpected listeners %+v", name, g.Listeners)
+		}
+	}
+
+	if _, err := Load(writeFile(t, "server.json", `{"Server": 1}`)); err == nil {
+		t.Fatal("section should be an object")
+	}
+}
+
+func TestLoad_Env(t *testing.T) {
+	t.Setenv("GONET_SERVER_TCPPORT", "9100")
+	t.Setenv("GONET_SOCKET_NODELAY", "false")
+	t.Setenv("GONET_LISTENER_ADMIN_ADDRESS", "127.0.0.1:9101")
+	t.Setenv("GONET_LISTENER_ADMIN_MAXCONN", "5")
+	t.Setenv("GONET_UNKNOWN", "1")
+
+	path := writeFile(t, "server.ini", "[Server]\nTCPPort=9001\nMaxConn=10\n[Listener.Admin]\nNetwork=tcp4\n")
+	g, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.TCPPort != 9100 || g.MaxConn != 10 || g.TCPNoDelay {
+		t.Fatalf("unexpected config %+v", g)
+	}
+	if len(g.Listeners) != 1 || g.Listeners[0].Name != "Admin" || g.Listeners[0].Network != "tcp4" ||
+		g.Listeners[0].Address != "127.0.0.1:9101" || g.Listeners[0].MaxConn != 5 {
+		t.Fatalf("unexpected listeners %+v", g.Listeners)
+	}
+
+	g, err = LoadEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.TCPPort != 9100 || len(g.Listeners) != 1 || g.Listeners[0].Name != "admin" {
+		t.Fatalf("unexpected config %+v", g)
+	}
+}
+
+func TestLoad_Validate(t *testing.T) {
+	path := writeFile(t, "server.ini", "[Server]\nTCPPort=70000\nWorkerPoolSize=0\nMaxConn=abc\nConnMode=thread\n"+
+		"MaxPacketSize=67108865\nMachineID=65536\n[Listener.admin]\nCertFile=a.pem\n")
+	_, err := Load(path)
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("expected Errors, got %v", err)
+	}
+	for _, field := range []string{"Server.TCPPort", "Server.WorkerPoolSize", "Server.MaxConn", "Server.ConnMode",
+		"Server.MaxPacketSize", "Server.MachineID", "Listener.admin.Address", "Listener.admin.CertFile"} {
+		if !strings.Contains(errs.Error(), field+":") {
+			t.Errorf("missing error for %s in %q", field, errs.Error())
+		}
+	}
+	if len(errs) != 8 {
+		t.Fatalf("expected 8 errors, got %d: %v", len(errs), errs)
+	}
+
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("default config should be valid: %v", err)
+	}
+	//MaxPacketSize为0表示不限制
+	unlimited := Default()
+	unlimited.MaxPacketSize = 0
+	if err := unlimited.Validate(); err != nil {
+		t.Fatalf("zero MaxPacketSize should be valid: %v", err)
+	}
+}
+
+func TestBindFlags(t *testing.T) {
+	fs := flag.NewFlagSet("gonet", flag.ContinueOnError)
+	flags := BindFlags(fs)
+	if err := fs.Parse([]string{"-server.tcpport", "9200", "-log.level", "debug"}); err != nil {
+		t.Fatal(err)
+	}
+	g := Default()
+	if err := flags.Apply(g); err != nil {
+		t.Fatal(err)
+	}
+	if g.TCPPort != 9200 || g.LogLevel != "debug" || g.MaxConn != 12000 {
+		t.Fatalf("unexpected config %+v", g)
+	}
+
+	fs = flag.NewFlagSet("gonet", flag.ContinueOnError)
+	flags = BindFlags(fs)
+	_ = fs.Parse([]string{"-udp.mtu", "10"})
+	if err := flags.Apply(Default()); err == nil {
+		t.Fatal("invalid flag value should fail")
+	}
+}
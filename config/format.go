@@ -0,0 +1,129 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-ini/ini"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+	配置文件格式
+	根据扩展名选择：.json、.yaml/.yml、.toml，其余按ini读取
+	json/yaml/toml的第一层为section，section中的对象为子section，
+	例如Listener下的admin对应ini中的[Listener.admin]，数组对应以逗号分隔的值
+
+	yaml:
+		Server:
+		  TCPPort: 9000
+		Listener:
+		  admin:
+		    Address: 127.0.0.1:9001
+		    Tags: [admin, internal]
+*/
+
+// readFile 根据扩展名读取配置文件，转换为与ini相同的结构
+func readFile(path string) (*ini.File, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".json" && ext != ".yaml" && ext != ".yml" && ext != ".toml" {
+		return ini.Load(path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc := map[string]interface{}{}
+	switch ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		//保留数字的原始格式，避免大整数变成浮点数
+		dec.UseNumber()
+		err = dec.Decode(&doc)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromMap(doc)
+}
+
+// fromMap 将第一层为section的map转换为ini.File
+func fromMap(doc map[string]interface{}) (*ini.File, error) {
+	file := ini.Empty()
+	for _, name := range sortedKeys(doc) {
+		values, ok := doc[name].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: section should be an object", name)
+		}
+		if err := addSection(file, name, values); err != nil {
+			return nil, err
+		}
+	}
+	return file, nil
+}
+
+func addSection(file *ini.File, name string, values map[string]interface{}) error {
+	for _, key := range sortedKeys(values) {
+		if sub, ok := values[key].(map[string]interface{}); ok {
+			if err := addSection(file, name+"."+key, sub); err != nil {
+				return err
+			}
+			continue
+		}
+		value, err := formatValue(values[key])
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", name, key, err)
+		}
+		if _, err := file.Section(name).NewKey(key, value); err != nil {
+			return fmt.Errorf("%s.%s: %v", name, key, err)
+		}
+	}
+	return nil
+}
+
+// formatValue 转换为ini中的值，数组以逗号分隔
+func formatValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			s, err := formatValue(e)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, s)
+		}
+		return strings.Join(values, ","), nil
+	case map[string]interface{}:
+		return "", fmt.Errorf("unexpected object")
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
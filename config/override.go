@@ -0,0 +1,130 @@
+package config
+
+import (
+	"flag"
+	"sort"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+/*
+	环境变量及命令行参数
+	优先级：命令行参数 > 环境变量 > 配置文件 > 默认值
+*/
+
+// EnvPrefix 环境变量的前缀
+// GONET_SERVER_TCPPORT覆盖[Server]中的TCPPort，GONET_LISTENER_ADMIN_ADDRESS覆盖[Listener.admin]中的Address，
+// 监听不存在时使用小写的名称创建
+const EnvPrefix = "GONET_"
+
+// schema 全部配置项及默认值，[Listener._]中为监听的配置项
+var schema = newSchema()
+
+func newSchema() *ini.File {
+	file := ini.Empty()
+	_, _ = file.NewSection(listenerPrefix + "_")
+	(&GlobalObj{}).parse(file, &Errors{})
+	return file
+}
+
+// schemaSections 除监听之外的全部section
+func schemaSections() []*ini.Section {
+	var sections []*ini.Section
+	for _, s := range schema.Sections() {
+		if s.Name() == ini.DefaultSection || strings.HasPrefix(s.Name(), listenerPrefix) {
+			continue
+		}
+		sections = append(sections, s)
+	}
+	return sections
+}
+
+// envName 配置项对应的环境变量名称
+func envName(section string, key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(section, ".", "_")+"_"+key)
+}
+
+// applyEnv 使用environ中以EnvPrefix开头的环境变量覆盖file中的配置，未知的变量被忽略
+func applyEnv(file *ini.File, environ []string) {
+	env := map[string]string{}
+	var names []string
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if ok && strings.HasPrefix(name, EnvPrefix) {
+			env[name] = value
+			names = append(names, name)
+		}
+	}
+	if len(env) == 0 {
+		return
+	}
+
+	for _, section := range schemaSections() {
+		for _, key := range section.KeyStrings() {
+			if value, ok := env[envName(section.Name(), key)]; ok {
+				file.Section(section.Name()).Key(key).SetValue(value)
+			}
+		}
+	}
+
+	//监听的名称不确定，按配置项的名称从后往前匹配
+	sort.Strings(names)
+	listenerKeys := schema.Section(listenerPrefix + "_").KeyStrings()
+	for _, name := range names {
+		rest := strings.TrimPrefix(name, envName("Listener", ""))
+		if rest == name {
+			continue
+		}
+		for _, key := range listenerKeys {
+			suffix := "_" + strings.ToUpper(key)
+			if len(rest) > len(suffix) && strings.HasSuffix(rest, suffix) {
+				listenerSection(file, rest[:len(rest)-len(suffix)]).Key(key).SetValue(env[name])
+				break
+			}
+		}
+	}
+}
+
+// listenerSection 返回名称与envListener匹配的监听，不存在时创建
+func listenerSection(file *ini.File, envListener string) *ini.Section {
+	for _, s := range file.Sections() {
+		if strings.HasPrefix(s.Name(), listenerPrefix) && envName(s.Name(), "") == envName("Listener."+envListener, "") {
+			return s
+		}
+	}
+	return file.Section(listenerPrefix + strings.ToLower(envListener))
+}
+
+// Flags 与命令行参数绑定的配置项，参数名为小写的section.key，例如-server.tcpport
+type Flags struct {
+	fs   *flag.FlagSet
+	keys map[string][2]string
+}
+
+// BindFlags 在fs中为除监听之外的每个配置项注册一个参数，fs.Parse之后调用Apply
+func BindFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{fs: fs, keys: map[string][2]string{}}
+	for _, section := range schemaSections() {
+		for _, key := range section.Keys() {
+			name := strings.ToLower(section.Name() + "." + key.Name())
+			fs.String(name, "", "["+section.Name()+"] "+key.Name()+" (default "+key.Value()+")")
+			f.keys[name] = [2]string{section.Name(), key.Name()}
+		}
+	}
+	return f
+}
+
+// Apply 使用命令行中设置过的参数覆盖g，返回覆盖之后的检查结果
+func (f *Flags) Apply(g *GlobalObj) error {
+	file := g.ConfigFile
+	if file == nil {
+		file = ini.Empty()
+	}
+	f.fs.Visit(func(fl *flag.Flag) {
+		if k, ok := f.keys[fl.Name]; ok {
+			file.Section(k[0]).Key(k[1]).SetValue(fl.Value.String())
+		}
+	})
+	return g.load(file)
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+/*
+	读取配置项
+	未配置或为空时使用默认值，格式错误时同样使用默认值并记录错误
+	使用的值会写回section，读取之后ConfigFile包含全部配置项
+*/
+
+type keyReader struct {
+	section *ini.Section
+	errs    *Errors
+}
+
+func newKeyReader(section *ini.Section, errs *Errors) keyReader {
+	return keyReader{section: section, errs: errs}
+}
+
+// key 返回name对应的配置项，未配置或为空时写入默认值并返回nil
+func (r keyReader) key(name string, def string) *ini.Key {
+	key := r.section.Key(name)
+	if strings.TrimSpace(key.String()) == "" {
+		key.SetValue(def)
+		return nil
+	}
+	return key
+}
+
+// invalid 记录格式错误并写入默认值
+func (r keyReader) invalid(key *ini.Key, expect string, def string) {
+	r.errs.add(r.section.Name()+"."+key.Name(), "invalid value %q, expect %s", key.String(), expect)
+	key.SetValue(def)
+}
+
+func (r keyReader) String(name string, def string) string {
+	key := r.key(name, def)
+	if key == nil {
+		return def
+	}
+	return key.String()
+}
+
+func (r keyReader) Strings(name string) []string {
+	key := r.key(name, "")
+	if key == nil {
+		return nil
+	}
+	return key.Strings(",")
+}
+
+func (r keyReader) Int(name string, def int) int {
+	key := r.key(name, strconv.Itoa(def))
+	if key == nil {
+		return def
+	}
+	v, err := key.Int()
+	if err != nil {
+		r.invalid(key, "int", strconv.Itoa(def))
+		return def
+	}
+	return v
+}
+
+func (r keyReader) Uint(name string, def uint) uint {
+	key := r.key(name, strconv.FormatUint(uint64(def), 10))
+	if key == nil {
+		return def
+	}
+	v, err := key.Uint()
+	if err != nil {
+		r.invalid(key, "uint", strconv.FormatUint(uint64(def), 10))
+		return def
+	}
+	return v
+}
+
+func (r keyReader) Uint32(name string, def uint32) uint32 {
+	key := r.key(name, strconv.FormatUint(uint64(def), 10))
+	if key == nil {
+		return def
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(key.String()), 10, 32)
+	if err != nil {
+		r.invalid(key, "uint32", strconv.FormatUint(uint64(def), 10))
+		return def
+	}
+	return uint32(v)
+}
+
+func (r keyReader) Bool(name string, def bool) bool {
+	key := r.key(name, strconv.FormatBool(def))
+	if key == nil {
+		return def
+	}
+	v, err := key.Bool()
+	if err != nil {
+		r.invalid(key, "bool", strconv.FormatBool(def))
+		return def
+	}
+	return v
+}
+
+// In 值必须是candidates之一
+func (r keyReader) In(name string, def string, candidates []string) string {
+	key := r.key(name, def)
+	if key == nil {
+		return def
+	}
+	v := key.String()
+	for _, c := range candidates {
+		if v == c {
+			return v
+		}
+	}
+	r.invalid(key, "one of "+strings.Join(candidates, ", "), def)
+	return def
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaxPacketSizeLimit 配置中MaxPacketSize允许的最大值
+const MaxPacketSizeLimit = 64 << 20
+
+// Errors 配置中的全部错误，每个错误以Section.Key开头
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// add 记录field的错误，field为Section.Key
+func (e *Errors) add(field string, format string, args ...interface{}) {
+	*e = append(*e, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+}
+
+// checkRange 检查v是否在[min, max]之间
+func (e *Errors) checkRange(field string, v int64, min int64, max int64) {
+	if v < min || v > max {
+		e.add(field, "%d out of range [%d, %d]", v, min, max)
+	}
+}
+
+// checkMin 检查v是否不小于min
+func (e *Errors) checkMin(field string, v int64, min int64) {
+	if v < min {
+		e.add(field, "%d should be at least %d", v, min)
+	}
+}
+
+// err 没有错误时返回nil
+func (e Errors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate 检查配置的取值范围，返回的Errors包含全部错误
+func (g *GlobalObj) Validate() error {
+	return g.validate().err()
+}
+
+func (g *GlobalObj) validate() Errors {
+	var errs Errors
+
+	errs.checkRange("Server.TCPPort", int64(g.TCPPort), 0, 65535)
+	errs.checkRange("Server.UDPPort", int64(g.UDPPort), 0, 65535)
+	errs.checkRange("Server.MachineID", int64(g.MachineID), 0, 65535)
+	//MaxPacketSize为0时不限制数据包的长度
+	errs.checkRange("Server.MaxPacketSize", int64(g.MaxPacketSize), 0, MaxPacketSizeLimit)
+	errs.checkMin("Server.MaxConn", int64(g.MaxConn), 1)
+	errs.checkMin("Server.WorkerPoolSize", int64(g.WorkerPoolSize), 1)
+	errs.checkMin("Server.MaxWorkerTaskLen", int64(g.MaxWorkerTaskLen), 1)
+	errs.checkMin("Server.EpollPollers", int64(g.EpollPollers), 0)
+	errs.checkMin("Server.EpollWriteTimeout", int64(g.EpollWriteTimeout), 0)
+	errs.checkMin("Server.HandlerTimeout", int64(g.HandlerTimeout), 0)
+
+	for _, l := range g.Listeners {
+		prefix := listenerPrefix + l.Name + "."
+		if l.Address == "" {
+			errs.add(prefix+"Address", "required")
+		}
+		if (l.CertFile == "") != (l.KeyFile == "") {
+			errs.add(prefix+"CertFile", "CertFile and KeyFile should be set together")
+		}
+		errs.checkMin(prefix+"MaxConn", int64(l.MaxConn), 0)
+		errs.checkMin(prefix+"ReusePort", int64(l.ReusePort), 0)
+	}
+
+	errs.checkMin("Socket.ReadBuffer", int64(g.TCPReadBuffer), 0)
+	errs.checkMin("Socket.WriteBuffer", int64(g.TCPWriteBuffer), 0)
+	errs.checkMin("Socket.ReusePort", int64(g.TCPReusePort), 0)
+
+	errs.checkRange("UDP.MTU", int64(g.UDPMtu), 64, 65507)
+	errs.checkMin("UDP.Interval", int64(g.UDPInterval), 1)
+	errs.checkMin("UDP.SendWindow", int64(g.UDPSendWindow), 1)
+	errs.checkMin("UDP.RecvWindow", int64(g.UDPRecvWindow), 1)
+	errs.checkMin("UDP.IdleTimeout", int64(g.UDPIdleTimeout), 1)
+
+	errs.checkMin("Reliable.WindowSize", int64(g.ReliableWindowSize), 1)
+	errs.checkMin("Reliable.Retention", int64(g.ReliableRetention), 0)
+	errs.checkMin("Reliable.MaxSessions", int64(g.ReliableMaxSessions), 0)
+
+	if g.FluentdEnable {
+		if g.FluentdHost == "" {
+			errs.add("Fluentd.Host", "required")
+		}
+		errs.checkRange("Fluentd.Port", int64(g.FluentdPort), 1, 65535)
+		if g.FluentdTag == "" {
+			errs.add("Fluentd.Tag", "required")
+		}
+	}
+	return errs
+}
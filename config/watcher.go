@@ -0,0 +1,242 @@
+package config
+
+import (
+	"crypto/sha256"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"gonet/logger"
+)
+
+/*
+	配置热加载
+	Watcher定期检查配置文件的内容，变化时重新读取并检查，通过检查之后通知订阅者
+	读取或检查失败时保留原来的配置，文件再次变化时重新读取
+	每个订阅者单独记录已经生效的配置，订阅者拒绝的配置项不会生效，之后文件变化时再次通知
+	只通知文件中变化的配置项，订阅者通过其他方式(如服务器的选项)设置的值在文件修改该项之前保持不变
+	重新读取时同样应用环境变量及WithFlags传入的命令行参数，优先级保持为文件<环境变量<命令行参数
+*/
+
+// Change 一个配置项的变化，Field为GlobalObj中的字段名
+type Change struct {
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+// Diff 返回两个配置之间变化的配置项，不比较TCPServer、ConfFilePath及ConfigFile
+func Diff(prev *GlobalObj, next *GlobalObj) []Change {
+	var changes []Change
+	pv, nv := reflect.ValueOf(prev).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < pv.NumField(); i++ {
+		switch name := pv.Type().Field(i).Name; name {
+		case "TCPServer", "ConfFilePath", "ConfigFile":
+		default:
+			old, cur := pv.Field(i).Interface(), nv.Field(i).Interface()
+			if !reflect.DeepEqual(old, cur) {
+				changes = append(changes, Change{Field: name, Old: old, New: cur})
+			}
+		}
+	}
+	return changes
+}
+
+// Apply 返回conf应用changes之后的副本，不修改conf
+func Apply(conf *GlobalObj, changes []Change) *GlobalObj {
+	next := *conf
+	v := reflect.ValueOf(&next).Elem()
+	for _, c := range changes {
+		if field := v.FieldByName(c.Field); field.IsValid() {
+			field.Set(reflect.ValueOf(c.New))
+		}
+	}
+	return &next
+}
+
+// Subscriber 配置变化的订阅者，prev为该订阅者已经生效的配置，changes不为空，返回实际生效的配置项
+type Subscriber func(prev *GlobalObj, next *GlobalObj, changes []Change) (applied []Change)
+
+type subscription struct {
+	fn Subscriber
+	//该订阅者已经生效的配置
+	current *GlobalObj
+	//该订阅者已经接受的文件中的配置，与新配置不同的配置项需要通知
+	seen *GlobalObj
+}
+
+// WatcherOption Watcher的选项
+type WatcherOption func(*Watcher)
+
+// WithFlags 重新读取配置文件之后再次应用f中设置过的命令行参数
+func WithFlags(f *Flags) WatcherOption {
+	return func(w *Watcher) { w.flags = f }
+}
+
+// Watcher 监视配置文件的变化
+type Watcher struct {
+	path     string
+	interval time.Duration
+	//Check串行执行
+	checkLock sync.Mutex
+	//保护current及subs
+	lock sync.Mutex
+	//最近一次读取并通过检查的配置
+	current *GlobalObj
+	//重新读取之后应用的命令行参数，为nil时不应用
+	flags  *Flags
+	digest [sha256.Size]byte
+	subs   []*subscription
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	done      chan struct{}
+}
+
+// NewWatcher 监视conf.ConfFilePath，conf为当前生效的配置，interval为检查的间隔，不大于0时为1秒
+func NewWatcher(conf *GlobalObj, interval time.Duration, opts ...WatcherOption) *Watcher {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	w := &Watcher{
+		path:     conf.ConfFilePath,
+		interval: interval,
+		current:  conf,
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if data, err := os.ReadFile(w.path); err == nil {
+		w.digest = sha256.Sum256(data)
+	}
+	return w
+}
+
+// Current 返回最近一次读取并通过检查的配置，订阅者拒绝的配置项同样包含在内
+func (w *Watcher) Current() *GlobalObj {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.current
+}
+
+// Subscribe 订阅配置的变化，订阅者按订阅的顺序在检查的协程中依次调用，返回取消订阅的函数
+// 订阅时的Current作为该订阅者已经生效的配置
+func (w *Watcher) Subscribe(fn Subscriber) (cancel func()) {
+	return w.SubscribeFrom(nil, fn)
+}
+
+// SubscribeFrom 与Subscribe相同，current为订阅者已经生效的配置，为nil时使用Current
+func (w *Watcher) SubscribeFrom(current *GlobalObj, fn Subscriber) (cancel func()) {
+	sub := &subscription{fn: fn, current: current}
+	w.lock.Lock()
+	sub.seen = w.current
+	if sub.current == nil {
+		sub.current = w.current
+	}
+	w.subs = append(w.subs, sub)
+	w.lock.Unlock()
+	return func() {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		for i, s := range w.subs {
+			if s == sub {
+				w.subs = append(w.subs[:i:i], w.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Check 立即检查一次配置文件，内容变化时重新读取并通知订阅者，返回变化的配置项
+func (w *Watcher) Check() ([]Change, error) {
+	w.checkLock.Lock()
+	defer w.checkLock.Unlock()
+
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(data)
+	if digest == w.digest {
+		return nil, nil
+	}
+	//读取失败时同样记录摘要，避免每次检查都输出相同的错误
+	w.digest = digest
+	next, err := Load(w.path)
+	if err != nil {
+		return nil, err
+	}
+	if w.flags != nil {
+		if err := w.flags.Apply(next); err != nil {
+			return nil, err
+		}
+	}
+
+	w.lock.Lock()
+	prev := w.current
+	w.current = next
+	subs := append([]*subscription(nil), w.subs...)
+	w.lock.Unlock()
+
+	//每个订阅者与各自已经接受的文件配置比较，之前被拒绝的配置项会再次通知，Old为订阅者已经生效的值
+	for _, sub := range subs {
+		var subChanges, same []Change
+		cv := reflect.ValueOf(sub.current).Elem()
+		for _, c := range Diff(sub.seen, next) {
+			c.Old = cv.FieldByName(c.Field).Interface()
+			//已经生效的值与文件中的新值相同时不需要通知
+			if reflect.DeepEqual(c.Old, c.New) {
+				same = append(same, c)
+				continue
+			}
+			subChanges = append(subChanges, c)
+		}
+		sub.seen = Apply(sub.seen, same)
+		if len(subChanges) == 0 {
+			continue
+		}
+		applied := sub.fn(sub.current, next, subChanges)
+		sub.current, sub.seen = Apply(sub.current, applied), Apply(sub.seen, applied)
+	}
+	changes := Diff(prev, next)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return changes, nil
+}
+
+// Start 开启定期检查
+func (w *Watcher) Start() {
+	w.startOnce.Do(func() {
+		go w.loop()
+	})
+}
+
+// Stop 停止定期检查
+func (w *Watcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.done)
+	})
+}
+
+func (w *Watcher) loop() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			changes, err := w.Check()
+			if err != nil {
+				logger.Default().WithFields(logger.Fields{"path": w.path, logger.FieldError: err}).Error("reload config failed")
+				continue
+			}
+			for _, c := range changes {
+				logger.Default().WithField("path", w.path).Infof("config %s changed from %v to %v", c.Field, c.Old, c.New)
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
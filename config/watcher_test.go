@@ -0,0 +1,200 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	path := writeFile(t, "server.ini", "[Server]\nMaxConn=10\n")
+	conf, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWatcher(conf, 10*time.Millisecond)
+	notified := make(chan []Change, 4)
+	w.Subscribe(func(prev *GlobalObj, next *GlobalObj, changes []Change) []Change {
+		if prev.MaxConn != 10 {
+			t.Errorf("unexpected previous config %+v", prev)
+		}
+		notified <- changes
+		return changes
+	})
+	cancel := w.Subscribe(func(*GlobalObj, *GlobalObj, []Change) []Change {
+		t.Error("cancelled subscriber should not be called")
+		return nil
+	})
+	cancel()
+
+	//内容未变化时不通知
+	if changes, err := w.Check(); err != nil || changes != nil {
+		t.Fatalf("unexpected check result %v %v", changes, err)
+	}
+
+	//检查失败时保留原来的配置
+	if err := os.WriteFile(path, []byte("[Server]\nMaxConn=0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Check(); err == nil {
+		t.Fatal("invalid config should fail")
+	}
+	if w.Current() != conf {
+		t.Fatal("invalid config should not be applied")
+	}
+
+	w.Start()
+	defer w.Stop()
+	if err := os.WriteFile(path, []byte("[Server]\nMaxConn=20\n[Log]\nLevel=debug\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case changes := <-notified:
+		if len(changes) != 2 || changes[0].Field != "MaxConn" || changes[0].Old != 10 || changes[0].New != 20 ||
+			changes[1].Field != "LogLevel" || changes[1].New != "debug" {
+			t.Fatalf("unexpected changes %+v", changes)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watcher should notify the change")
+	}
+	if w.Current().MaxConn != 20 {
+		t.Fatal("current config should be replaced")
+	}
+}
+
+func TestWatcher_Rejected(t *testing.T) {
+	path := writeFile(t, "server.ini", "[Server]\nMaxConn=10\nTCPPort=8000\n")
+	conf, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWatcher(conf, time.Second)
+	var (
+		prevs    []*GlobalObj
+		notified [][]Change
+	)
+	//订阅者只接受MaxConn的变化
+	w.Subscribe(func(prev *GlobalObj, next *GlobalObj, changes []Change) []Change {
+		prevs, notified = append(prevs, prev), append(notified, changes)
+		var applied []Change
+		for _, c := range changes {
+			if c.Field == "MaxConn" {
+				applied = append(applied, c)
+			}
+		}
+		return applied
+	})
+
+	if err := os.WriteFile(path, []byte("[Server]\nMaxConn=20\nTCPPort=9000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("[Server]\nMaxConn=30\nTCPPort=9000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if len(notified) != 2 {
+		t.Fatalf("unexpected notifications %+v", notified)
+	}
+	//被拒绝的TCPPort没有生效，再次通知
+	if prev := prevs[1]; prev.MaxConn != 20 || prev.TCPPort != 8000 {
+		t.Fatalf("rejected field should not take effect, got MaxConn %d TCPPort %d", prev.MaxConn, prev.TCPPort)
+	}
+	if len(notified[1]) != 2 || notified[1][0].Field != "TCPPort" || notified[1][0].Old != 8000 {
+		t.Fatalf("rejected field should be notified again, got %+v", notified[1])
+	}
+	if w.Current().TCPPort != 9000 {
+		t.Fatal("current config should follow the file")
+	}
+}
+
+func TestWatcher_Flags(t *testing.T) {
+	path := writeFile(t, "server.ini", "[Server]\nMaxConn=10\n")
+	fs := flag.NewFlagSet("gonet", flag.ContinueOnError)
+	flags := BindFlags(fs)
+	if err := fs.Parse([]string{"-log.level", "debug"}); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := flags.Apply(conf); err != nil {
+		t.Fatal(err)
+	}
+	w := NewWatcher(conf, time.Second, WithFlags(flags))
+	var notified []Change
+	w.Subscribe(func(prev *GlobalObj, next *GlobalObj, changes []Change) []Change {
+		notified = append(notified, changes...)
+		return changes
+	})
+
+	//重新读取之后命令行参数仍然覆盖文件中的值
+	if err := os.WriteFile(path, []byte("[Server]\nMaxConn=20\n[Log]\nLevel=error\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if c := w.Current(); c.MaxConn != 20 || c.LogLevel != "debug" {
+		t.Fatalf("flags should take precedence over the file, got MaxConn %d LogLevel %s", c.MaxConn, c.LogLevel)
+	}
+	if len(notified) != 1 || notified[0].Field != "MaxConn" {
+		t.Fatalf("unexpected changes %+v", notified)
+	}
+}
+
+func TestWatcher_SubscribeFrom(t *testing.T) {
+	path := writeFile(t, "server.ini", "[Server]\nMaxConn=10\nMaxPacketSize=1024\n")
+	conf, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWatcher(conf, time.Second)
+	//订阅者通过其他方式设置了MaxPacketSize
+	effective := *conf
+	effective.MaxPacketSize = 512
+	var (
+		prev     *GlobalObj
+		notified []Change
+	)
+	w.SubscribeFrom(&effective, func(p *GlobalObj, next *GlobalObj, changes []Change) []Change {
+		prev, notified = p, changes
+		return changes
+	})
+
+	//文件中未变化的配置项不通知，订阅者设置的值保持不变
+	if err := os.WriteFile(path, []byte("[Server]\nMaxConn=20\nMaxPacketSize=1024\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if prev.MaxPacketSize != 512 || len(notified) != 1 || notified[0].Field != "MaxConn" || notified[0].Old != 10 {
+		t.Fatalf("unexpected notification prev %+v changes %+v", prev, notified)
+	}
+
+	//文件修改该项之后通知，Old为订阅者生效的值
+	if err := os.WriteFile(path, []byte("[Server]\nMaxConn=20\nMaxPacketSize=2048\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if len(notified) != 1 || notified[0].Field != "MaxPacketSize" || notified[0].Old != uint32(512) {
+		t.Fatalf("unexpected changes %+v", notified)
+	}
+}
+
+func TestApply(t *testing.T) {
+	conf := &GlobalObj{MaxConn: 10, Name: "a"}
+	next := Apply(conf, []Change{{Field: "MaxConn", Old: 10, New: 20}})
+	if next.MaxConn != 20 || next.Name != "a" || conf.MaxConn != 10 {
+		t.Fatalf("unexpected apply result %+v, original %+v", next, conf)
+	}
+}
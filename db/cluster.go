@@ -0,0 +1,234 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"gonet/logger"
+	"gonet/timer"
+	"sync"
+	"time"
+)
+
+/*
+	集群任务的认领
+	RedisLocker为每次触发单独加锁，RedisLease只让持有租约的实例执行
+	加锁使用Setnx之后再Expire，实例在两者之间退出时锁没有过期时间，其他实例认领失败时会补上过期时间
+	fencing token由同一个计数器Incr得到，在整个集群中单调递增
+	租约的续约及释放使用lua脚本，只有租约的值仍然是当前实例的标识时才执行，避免修改其他实例已经获取的租约
+*/
+
+// fenceSuffix fencing token计数器的key后缀
+const fenceSuffix = "fence"
+
+// ErrInvalidTTL redis的过期时间以秒为单位，认领及租约的ttl至少为1秒
+var ErrInvalidTTL = errors.New("cluster ttl must be at least 1s")
+
+const (
+	// compareAndExpire key的值等于ARGV[1]时将过期时间设置为ARGV[2]毫秒
+	compareAndExpire = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) end return 0`
+	// compareAndDelete key的值等于ARGV[1]时删除key
+	compareAndDelete = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) end return 0`
+)
+
+// repairTTL 为没有过期时间的key补上过期时间
+func repairTTL(r *PipeLinedRedis, key string, ttl time.Duration) {
+	if d, err := r.TTL(key).Result(); err == nil && d == -1 {
+		_ = r.Expire(key, ttl).Err()
+	}
+}
+
+// RedisLocker 通过redis锁认领集群任务的每次触发
+type RedisLocker struct {
+	plr    *PipeLinedRedis
+	prefix string
+	id     string
+}
+
+var _ timer.Locker = (*RedisLocker)(nil)
+
+// NewRedisLocker 创建一个redis锁，锁的key为prefix加上触发的key，id为当前实例的标识，保存在锁的值中
+// redis的过期时间以秒为单位，认领时的ttl小于1秒时Claim返回ErrInvalidTTL
+func NewRedisLocker(plr *PipeLinedRedis, prefix string, id string) *RedisLocker {
+	return &RedisLocker{plr: plr, prefix: prefix, id: id}
+}
+
+// Claim 实现timer.Locker
+func (l *RedisLocker) Claim(ctx context.Context, key string, ttl time.Duration) (int64, bool, error) {
+	if ttl < time.Second {
+		return 0, false, ErrInvalidTTL
+	}
+	r := l.plr.WithContext(ctx)
+	lockKey := l.prefix + key
+	ok, err := r.Setnx(lockKey, l.id).Result()
+	if err != nil {
+		return 0, false, err
+	}
+	if !ok {
+		repairTTL(r, lockKey, ttl)
+		return 0, false, nil
+	}
+	if err := r.Expire(lockKey, ttl).Err(); err != nil {
+		logger.Default().WithFields(logger.Fields{"role": "redis-locker", logger.FieldError: err}).Warn("expire lock failed")
+	}
+	token, err := r.Incr(l.prefix + fenceSuffix).Result()
+	if err != nil {
+		return 0, false, err
+	}
+	return token, true, nil
+}
+
+// RedisLease 通过redis租约选出集群中的一个实例，只有持有租约的实例执行集群任务
+// 持有租约的实例每隔ttl/3续约，续约失败且租约到期后放弃，其他实例在租约过期后接替
+type RedisLease struct {
+	plr *PipeLinedRedis
+	key string
+	id  string
+	ttl time.Duration
+
+	lock   sync.Mutex
+	leader bool
+	token  int64
+	//本地认为租约有效的截止时间，从发起续约之前开始计算
+	validUntil time.Time
+
+	stop      chan struct{}
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+var _ timer.Locker = (*RedisLease)(nil)
+
+// NewRedisLease 创建一个租约，key为租约在redis中的key，id为当前实例的标识
+// redis的过期时间以秒为单位，ttl小于1秒时返回ErrInvalidTTL
+func NewRedisLease(plr *PipeLinedRedis, key string, id string, ttl time.Duration) (*RedisLease, error) {
+	if ttl < time.Second {
+		return nil, ErrInvalidTTL
+	}
+	return &RedisLease{
+		plr:  plr,
+		key:  key,
+		id:   id,
+		ttl:  ttl,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}, nil
+}
+
+// Start 开始竞选及续约，只有第一次调用生效，Stop之后不能再次Start
+func (l *RedisLease) Start() {
+	l.startOnce.Do(func() { go l.run() })
+}
+
+func (l *RedisLease) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		l.campaign()
+		select {
+		case <-ticker.C:
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// campaign 持有租约时续约，否则尝试获取租约
+func (l *RedisLease) campaign() {
+	ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+	defer cancel()
+	r := l.plr.WithContext(ctx)
+	start := time.Now()
+	log := logger.Default().WithFields(logger.Fields{"role": "redis-lease", "lease": l.key})
+
+	if leader, _ := l.Leader(); leader {
+		renewed, err := l.renew(r)
+		if renewed {
+			l.lock.Lock()
+			l.validUntil = start.Add(l.ttl)
+			l.lock.Unlock()
+			return
+		}
+		if err != nil {
+			log.WithField(logger.FieldError, err).Warn("renew lease failed")
+			//无法确认租约状态时，在本地的有效期内继续持有
+			if l.valid(time.Now()) {
+				return
+			}
+		}
+		l.lock.Lock()
+		l.leader = false
+		l.lock.Unlock()
+		log.Info("lease lost")
+	}
+
+	ok, err := r.Setnx(l.key, l.id).Result()
+	if err != nil {
+		log.WithField(logger.FieldError, err).Warn("acquire lease failed")
+		return
+	}
+	if !ok {
+		repairTTL(r, l.key, l.ttl)
+		return
+	}
+	if err := r.Expire(l.key, l.ttl).Err(); err != nil {
+		log.WithField(logger.FieldError, err).Warn("expire lease failed")
+	}
+	token, err := r.Incr(l.key + ":" + fenceSuffix).Result()
+	if err != nil {
+		log.WithField(logger.FieldError, err).Warn("acquire fencing token failed")
+		return
+	}
+	l.lock.Lock()
+	l.leader, l.token, l.validUntil = true, token, start.Add(l.ttl)
+	l.lock.Unlock()
+	log.WithField("token", token).Info("lease acquired")
+}
+
+// renew 租约仍然属于当前实例时续约
+func (l *RedisLease) renew(r *PipeLinedRedis) (bool, error) {
+	n, err := r.Eval(compareAndExpire, []string{l.key}, l.id, l.ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+// valid 租约在now时是否仍然有效
+func (l *RedisLease) valid(now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.leader && now.Before(l.validUntil)
+}
+
+// Leader 返回当前实例是否持有租约及获取租约时得到的fencing token
+func (l *RedisLease) Leader() (bool, int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.leader && time.Now().Before(l.validUntil), l.token
+}
+
+// Claim 实现timer.Locker，持有租约时认领全部触发
+func (l *RedisLease) Claim(_ context.Context, _ string, _ time.Duration) (int64, bool, error) {
+	leader, token := l.Leader()
+	return token, leader, nil
+}
+
+// Stop 停止续约并释放持有的租约，ctx结束时不再等待
+func (l *RedisLease) Stop(ctx context.Context) error {
+	//没有Start过的租约直接标记为已停止
+	l.startOnce.Do(func() { close(l.done) })
+	l.stopOnce.Do(func() { close(l.stop) })
+	select {
+	case <-l.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	l.lock.Lock()
+	leader := l.leader
+	l.leader = false
+	l.lock.Unlock()
+	if !leader {
+		return nil
+	}
+	//租约已经被其他实例获取时不删除
+	return l.plr.WithContext(ctx).Eval(compareAndDelete, []string{l.key}, l.id).Err()
+}
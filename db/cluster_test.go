@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gonet/timer"
+)
+
+func TestRedisLocker(t *testing.T) {
+	mr, client := localRedis(t)
+	plr := CreatePipeLinedRedis(client, 4)
+	defer plr.Exit()
+	a := NewRedisLocker(plr, "lock:", "a")
+	b := NewRedisLocker(plr, "lock:", "b")
+	ctx := context.Background()
+
+	tokenA, ok, err := a.Claim(ctx, "reset:1", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("first claim should succeed: %v", err)
+	}
+	if _, ok, _ := b.Claim(ctx, "reset:1", time.Minute); ok {
+		t.Fatal("claimed trigger should not be claimed again")
+	}
+	//锁过期之后可以再次认领，fencing token递增
+	mr.FastForward(time.Minute)
+	tokenB, ok, err := b.Claim(ctx, "reset:1", time.Minute)
+	if err != nil || !ok || tokenB <= tokenA {
+		t.Fatalf("claim after expire should succeed with larger token: %v %d %d", err, tokenA, tokenB)
+	}
+
+	//Setnx之后没有设置过期时间的锁，认领失败时补上过期时间
+	mr.Set("lock:reset:2", "dead")
+	if _, ok, _ := a.Claim(ctx, "reset:2", time.Minute); ok {
+		t.Fatal("existing lock should not be claimed")
+	}
+	if ttl := mr.TTL("lock:reset:2"); ttl != time.Minute {
+		t.Fatalf("lock without ttl should be repaired, got %v", ttl)
+	}
+}
+
+func TestRedisLease_Failover(t *testing.T) {
+	mr, clientB := localRedis(t)
+	clientA := localRedisClient(t, mr.Addr())
+	const ttl = time.Second
+	plrA, plrB := CreatePipeLinedRedis(clientA, 4), CreatePipeLinedRedis(clientB, 4)
+	defer plrA.Exit()
+	defer plrB.Exit()
+	leaseA, err := NewRedisLease(plrA, "lease:reset", "a", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaseB, err := NewRedisLease(plrB, "lease:reset", "b", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	defer leaseB.Stop(ctx)
+
+	var (
+		lock sync.Mutex
+		runs = map[string]int{}
+	)
+	for _, instance := range []struct {
+		id    string
+		lease *RedisLease
+	}{{"a", leaseA}, {"b", leaseB}} {
+		id := instance.id
+		ts := timer.NewAutoExecTimerScheduler()
+		defer ts.Stop(ctx)
+		ts.Every(timer.NewTaskFunc("reset", func(ctx context.Context) error {
+			lock.Lock()
+			runs[id]++
+			lock.Unlock()
+			return nil
+		}), 50*time.Millisecond, timer.WithCluster(instance.lease, "reset", ttl))
+	}
+
+	leaseA.Start()
+	waitFor(t, "a should become leader", func() bool { leader, _ := leaseA.Leader(); return leader })
+	_, tokenA := leaseA.Leader()
+	leaseB.Start()
+	time.Sleep(300 * time.Millisecond)
+	lock.Lock()
+	if runs["a"] == 0 || runs["b"] != 0 {
+		t.Fatalf("only the leader should run, got %v", runs)
+	}
+	lock.Unlock()
+
+	//a与redis断开，本地租约到期后放弃，redis中的租约过期后b接替
+	_ = clientA.Close()
+	waitFor(t, "a should lose the lease", func() bool { leader, _ := leaseA.Leader(); return !leader })
+	lock.Lock()
+	runsA := runs["a"]
+	lock.Unlock()
+	mr.FastForward(ttl)
+	waitFor(t, "b should become leader", func() bool { leader, _ := leaseB.Leader(); return leader })
+	if _, tokenB := leaseB.Leader(); tokenB <= tokenA {
+		t.Fatalf("fencing token should increase, got %d after %d", tokenB, tokenA)
+	}
+	time.Sleep(300 * time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	if runs["b"] == 0 || runs["a"] != runsA {
+		t.Fatalf("b should take over, got %v", runs)
+	}
+	_ = leaseA.Stop(ctx)
+}
+
+func TestRedisLease_OwnerChecked(t *testing.T) {
+	mr, client := localRedis(t)
+	plr := CreatePipeLinedRedis(client, 4)
+	defer plr.Exit()
+	lease, err := NewRedisLease(plr, "lease:owner", "a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a曾经持有租约，之后租约被b获取，续约及释放都不能修改b的租约
+	lease.leader, lease.validUntil = true, time.Now().Add(time.Minute)
+	mr.Set("lease:owner", "b")
+	if renewed, err := lease.renew(plr); err != nil || renewed {
+		t.Fatalf("renew should not touch a lease owned by b: %v %v", renewed, err)
+	}
+	if ttl := mr.TTL("lease:owner"); ttl != 0 {
+		t.Fatalf("lease owned by b should keep its ttl, got %v", ttl)
+	}
+	if err := lease.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if owner, err := mr.Get("lease:owner"); err != nil || owner != "b" {
+		t.Fatalf("stop should not delete a lease owned by b: %q %v", owner, err)
+	}
+}
+
+func TestRedisCluster_InvalidTTL(t *testing.T) {
+	_, client := localRedis(t)
+	plr := CreatePipeLinedRedis(client, 4)
+	defer plr.Exit()
+	if _, err := NewRedisLease(plr, "lease:ttl", "a", 500*time.Millisecond); err != ErrInvalidTTL {
+		t.Fatalf("lease with ttl < 1s should fail, got %v", err)
+	}
+	locker := NewRedisLocker(plr, "lock:", "a")
+	if _, ok, err := locker.Claim(context.Background(), "ttl", 500*time.Millisecond); ok || err != ErrInvalidTTL {
+		t.Fatalf("claim with ttl < 1s should fail, got %v %v", ok, err)
+	}
+}
+
+// localRedisClient 连接addr上的redis，测试结束时关闭
+func localRedisClient(t *testing.T, addr string) *redis.Client {
+	client := redis.NewClient(&redis.Options{Addr: addr, MaxRetries: -1})
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
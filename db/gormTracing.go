@@ -0,0 +1,76 @@
+package db
+
+import (
+	"gonet/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// gormSpanKey 保存在gorm实例中的span
+const gormSpanKey = "gonet:span"
+
+var _ gorm.Plugin = (*TracingPlugin)(nil)
+
+// TracingPlugin 为gorm的每次查询创建span，父span来自db.WithContext(ctx)传入的ctx
+type TracingPlugin struct{}
+
+func (p *TracingPlugin) Name() string {
+	return "gonet:tracing"
+}
+
+// Initialize 在gorm的增删改查回调前后注册span的创建及结束
+func (p *TracingPlugin) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	errs := []error{
+		callback.Create().Before("gorm:create").Register("gonet:before_create", startSpan("create")),
+		callback.Create().After("gorm:create").Register("gonet:after_create", endSpan),
+		callback.Query().Before("gorm:query").Register("gonet:before_query", startSpan("query")),
+		callback.Query().After("gorm:query").Register("gonet:after_query", endSpan),
+		callback.Update().Before("gorm:update").Register("gonet:before_update", startSpan("update")),
+		callback.Update().After("gorm:update").Register("gonet:after_update", endSpan),
+		callback.Delete().Before("gorm:delete").Register("gonet:before_delete", startSpan("delete")),
+		callback.Delete().After("gorm:delete").Register("gonet:after_delete", endSpan),
+		callback.Row().Before("gorm:row").Register("gonet:before_row", startSpan("row")),
+		callback.Row().After("gorm:row").Register("gonet:after_row", endSpan),
+		callback.Raw().Before("gorm:raw").Register("gonet:before_raw", startSpan("raw")),
+		callback.Raw().After("gorm:raw").Register("gonet:after_raw", endSpan),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startSpan(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx, span := tracing.Tracer().Start(db.Statement.Context, "gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemMySQL, semconv.DBOperationKey.String(operation)))
+		db.Statement.Context = ctx
+		db.InstanceSet(gormSpanKey, span)
+	}
+}
+
+func endSpan(db *gorm.DB) {
+	value, ok := db.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	defer span.End()
+	span.SetAttributes(
+		semconv.DBStatementKey.String(db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+	if db.Statement.Table != "" {
+		span.SetAttributes(attribute.String("db.sql.table", db.Statement.Table))
+	}
+	if db.Error != nil && db.Error != gorm.ErrRecordNotFound {
+		span.RecordError(db.Error)
+	}
+}
@@ -1,23 +1,30 @@
 package db
 
 import (
-	"github.com/sirupsen/logrus"
+	"gonet/logger"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/plugin/dbresolver"
 	"time"
 )
 
+// ConnectMysql 连接mysql，replicas为只读副本
+// 在消息处理中应通过db.WithContext(request.Context())执行查询，连接断开或处理超时后查询会被取消
 func ConnectMysql(dsn string, replicas ...string) *gorm.DB {
 	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
 	if err != nil {
-		logrus.WithField("role", "mysql").Error(err)
+		logger.Default().WithFields(logger.Fields{"role": "mysql", logger.FieldError: err}).Error("open mysql failed")
 		return nil
 	}
 	var splittingDB []gorm.Dialector
 	for _, dsn := range replicas {
 		splittingDB = append(splittingDB, mysql.Open(dsn))
 	}
+	//为查询创建span，父span来自db.WithContext传入的ctx
+	if err = db.Use(&TracingPlugin{}); err != nil {
+		logger.Default().WithFields(logger.Fields{"role": "connect-mysql", logger.FieldError: err}).Error("use tracing plugin failed")
+		return nil
+	}
 	err = db.Use(dbresolver.Register(dbresolver.Config{Replicas: splittingDB}).
 		SetConnMaxIdleTime(time.Hour).
 		SetConnMaxLifetime(24 * time.Hour).
@@ -25,7 +32,7 @@ func ConnectMysql(dsn string, replicas ...string) *gorm.DB {
 		SetMaxOpenConns(200))
 
 	if err != nil {
-		logrus.WithField("role", "connect-mysql").Error(err)
+		logger.Default().WithFields(logger.Fields{"role": "connect-mysql", logger.FieldError: err}).Error("use mysql plugin failed")
 		return nil
 	}
 	return db
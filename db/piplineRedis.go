@@ -3,9 +3,14 @@ package db
 import (
 	"context"
 	"github.com/go-redis/redis/v8"
+	"gonet/tracing"
 	"gonet/utils"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type redisOpName int
@@ -25,6 +30,7 @@ const (
 	hgetOp
 	hdelOp
 	hkeysOp
+	hgetAllOp
 	saddOp
 	smembersOp
 	scardOp
@@ -32,24 +38,62 @@ const (
 	ltrimOp
 	llenOp
 	lrangeOp
+	evalOp
 )
 
+var redisOpNames = [...]string{
+	setOp:      "set",
+	getOp:      "get",
+	delOp:      "del",
+	setnxOp:    "setnx",
+	existsOp:   "exists",
+	incrOp:     "incr",
+	decrOp:     "decr",
+	flushAllOp: "flushall",
+	ttlOp:      "ttl",
+	expireOp:   "expire",
+	hsetOp:     "hset",
+	hgetOp:     "hget",
+	hdelOp:     "hdel",
+	hkeysOp:    "hkeys",
+	hgetAllOp:  "hgetall",
+	saddOp:     "sadd",
+	smembersOp: "smembers",
+	scardOp:    "scard",
+	lpushOp:    "lpush",
+	ltrimOp:    "ltrim",
+	llenOp:     "llen",
+	lrangeOp:   "lrange",
+	evalOp:     "eval",
+}
+
+func (n redisOpName) String() string {
+	return redisOpNames[n]
+}
+
+// PipeLinedRedis 将并发的redis操作合并为pipeline批量执行
+// 在消息处理中应通过WithContext(request.Context())使用，连接断开或处理超时后尚未执行的操作会被跳过
 type PipeLinedRedis struct {
 	client         *redis.Client
 	opChan         chan *redisOp
 	pipeLineLength int
 	exitFlag       chan bool
+	//发起操作时使用的ctx，为nil时不限制
+	ctx context.Context
 	sync.Mutex
 }
 
 type redisOp struct {
 	op       redisOpName
+	ctx      context.Context
+	span     trace.Span
 	key      string
 	keys     []string
 	members  []interface{}
 	hKey     string
 	val      interface{}
 	expire   time.Duration
+	script   string
 	cmd      redis.Cmder
 	isFinish chan bool
 	start    int64
@@ -61,6 +105,31 @@ func (op *redisOp) finish(cmder redis.Cmder) {
 	op.isFinish <- true
 }
 
+// errCmd 创建与操作类型对应的带错误的cmd，用于未执行的操作
+func (op *redisOp) errCmd(err error) redis.Cmder {
+	var cmd redis.Cmder
+	switch op.op {
+	case setOp, flushAllOp, ltrimOp:
+		cmd = redis.NewStatusCmd(op.ctx)
+	case getOp, hgetOp:
+		cmd = redis.NewStringCmd(op.ctx)
+	case setnxOp, expireOp:
+		cmd = redis.NewBoolCmd(op.ctx)
+	case ttlOp:
+		cmd = redis.NewDurationCmd(op.ctx, time.Second)
+	case hkeysOp, smembersOp, lrangeOp:
+		cmd = redis.NewStringSliceCmd(op.ctx)
+	case hgetAllOp:
+		cmd = redis.NewStringStringMapCmd(op.ctx)
+	case evalOp:
+		cmd = redis.NewCmd(op.ctx)
+	default:
+		cmd = redis.NewIntCmd(op.ctx)
+	}
+	cmd.SetErr(err)
+	return cmd
+}
+
 func (op *redisOp) exec(ctx context.Context, pipeline redis.Pipeliner) {
 	switch op.op {
 	case setOp:
@@ -91,6 +160,8 @@ func (op *redisOp) exec(ctx context.Context, pipeline redis.Pipeliner) {
 		op.cmd = pipeline.HDel(ctx, op.key, op.hKey)
 	case hkeysOp:
 		op.cmd = pipeline.HKeys(ctx, op.key)
+	case hgetAllOp:
+		op.cmd = pipeline.HGetAll(ctx, op.key)
 	case saddOp:
 		op.cmd = pipeline.SAdd(ctx, op.key, op.members...)
 	case scardOp:
@@ -105,6 +176,8 @@ func (op *redisOp) exec(ctx context.Context, pipeline redis.Pipeliner) {
 		op.cmd = pipeline.LLen(ctx, op.key)
 	case lrangeOp:
 		op.cmd = pipeline.LRange(ctx, op.key, op.start, op.end)
+	case evalOp:
+		op.cmd = pipeline.Eval(ctx, op.script, op.keys, op.members...)
 	}
 }
 
@@ -114,6 +187,7 @@ func CreatePipeLinedRedis(client *redis.Client, pipeLineLength int) *PipeLinedRe
 		opChan:         make(chan *redisOp, pipeLineLength*8),
 		pipeLineLength: pipeLineLength,
 		exitFlag:       make(chan bool, 1),
+		ctx:            context.Background(),
 	}
 
 	go plr.runLoop()
@@ -130,34 +204,20 @@ func (plr *PipeLinedRedis) runLoop() {
 		// 等待通道有值
 		select {
 		case op := <-plr.opChan:
-			opList := make([]*redisOp, plr.pipeLineLength)
-			opList[0] = op
+			opList := make([]*redisOp, 0, plr.pipeLineLength)
+			opList = append(opList, op)
 			// 有值的时候，循环取出，直到取空或者取够pipeLineLength的数量
 			isEmpty := false
-			i := 1
-			for ; i < plr.pipeLineLength && !isEmpty; i++ {
+			for len(opList) < plr.pipeLineLength && !isEmpty {
 				select {
 				case op = <-plr.opChan:
-					opList[i] = op
+					opList = append(opList, op)
 				default:
 					isEmpty = true
 				}
 			}
-			opList = opList[:i-1]
 			// todo 优化效果，对相同的操作去重
-			// 加锁执行操作
-			plr.Lock()
-			ctx, cancel := utils.MakeCtx(time.Minute)
-			pipeLine := plr.client.Pipeline()
-			for _, op := range opList {
-				op.exec(ctx, pipeLine)
-			}
-			cmdList, _ := pipeLine.Exec(ctx)
-			for j, op := range opList {
-				op.finish(cmdList[j])
-			}
-			cancel()
-			plr.Unlock()
+			plr.execBatch(opList)
 		case exit := <-plr.exitFlag:
 			if exit {
 				return
@@ -166,267 +226,256 @@ func (plr *PipeLinedRedis) runLoop() {
 	}
 }
 
-func (plr *PipeLinedRedis) Set(key string, val interface{}, expire time.Duration) *redis.StatusCmd {
-	op := &redisOp{
-		op:       setOp,
-		key:      key,
-		val:      val,
-		expire:   expire,
-		isFinish: make(chan bool, 1),
+// execBatch 加锁批量执行操作，ctx已经结束的操作不再发送给redis
+func (plr *PipeLinedRedis) execBatch(opList []*redisOp) {
+	plr.Lock()
+	defer plr.Unlock()
+	ctx, cancel := utils.MakeCtx(time.Minute)
+	defer cancel()
+	pipeLine := plr.client.Pipeline()
+	execList := opList[:0]
+	links := make([]trace.Link, 0, len(opList))
+	for _, op := range opList {
+		if err := op.ctx.Err(); err != nil {
+			op.finish(op.errCmd(err))
+			continue
+		}
+		op.exec(ctx, pipeLine)
+		execList = append(execList, op)
+		op.span.SetAttributes(attribute.Int("gonet.pipeline.batch_size", len(opList)))
+		links = append(links, trace.Link{SpanContext: op.span.SpanContext()})
 	}
-	plr.opChan <- op
-	<-op.isFinish
+	if len(execList) == 0 {
+		return
+	}
+	//一个批次包含多个请求的操作，批次span通过link关联各个操作的span
+	ctx, span := tracing.Tracer().Start(ctx, "redis.pipeline",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithLinks(links...),
+		trace.WithAttributes(semconv.DBSystemRedis, attribute.Int("gonet.pipeline.batch_size", len(execList))))
+	defer span.End()
+	cmdList, err := pipeLine.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		span.RecordError(err)
+	}
+	for j, op := range execList {
+		op.finish(cmdList[j])
+	}
+}
 
-	return op.cmd.(*redis.StatusCmd)
+// WithContext 返回使用ctx发起操作的PipeLinedRedis，与原对象共用同一个批量执行协程
+func (plr *PipeLinedRedis) WithContext(ctx context.Context) *PipeLinedRedis {
+	return &PipeLinedRedis{
+		client:         plr.client,
+		opChan:         plr.opChan,
+		pipeLineLength: plr.pipeLineLength,
+		exitFlag:       plr.exitFlag,
+		ctx:            ctx,
+	}
 }
 
-func (plr *PipeLinedRedis) Get(key string) *redis.StringCmd {
-	op := &redisOp{
-		op:       getOp,
-		key:      key,
-		isFinish: make(chan bool, 1),
+// do 将操作交给批量执行协程并等待结果，ctx在排队时结束则直接返回ctx的错误
+// 每个操作创建一个子span，覆盖排队及批量执行的时间
+func (plr *PipeLinedRedis) do(op *redisOp) (cmd redis.Cmder) {
+	ctx := plr.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	op.ctx, op.span = tracing.Tracer().Start(ctx, "redis."+op.op.String(),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemRedis, semconv.DBOperationKey.String(op.op.String())))
+	defer func() {
+		if err := cmd.Err(); err != nil && err != redis.Nil {
+			op.span.RecordError(err)
+		}
+		op.span.End()
+	}()
+	op.isFinish = make(chan bool, 1)
+	if err := op.ctx.Err(); err != nil {
+		return op.errCmd(err)
+	}
+	select {
+	case plr.opChan <- op:
+	case <-op.ctx.Done():
+		return op.errCmd(op.ctx.Err())
 	}
-	plr.opChan <- op
 	<-op.isFinish
+	return op.cmd
+}
 
-	return op.cmd.(*redis.StringCmd)
+func (plr *PipeLinedRedis) Set(key string, val interface{}, expire time.Duration) *redis.StatusCmd {
+	return plr.do(&redisOp{
+		op:     setOp,
+		key:    key,
+		val:    val,
+		expire: expire,
+	}).(*redis.StatusCmd)
 }
 
-func (plr *PipeLinedRedis) Del(keys ...string) *redis.IntCmd {
-	op := &redisOp{
-		op:       delOp,
-		keys:     keys,
-		isFinish: make(chan bool, 1),
-	}
-	plr.opChan <- op
-	<-op.isFinish
+func (plr *PipeLinedRedis) Get(key string) *redis.StringCmd {
+	return plr.do(&redisOp{
+		op:  getOp,
+		key: key,
+	}).(*redis.StringCmd)
+}
 
-	return op.cmd.(*redis.IntCmd)
+func (plr *PipeLinedRedis) Del(keys ...string) *redis.IntCmd {
+	return plr.do(&redisOp{
+		op:   delOp,
+		keys: keys,
+	}).(*redis.IntCmd)
 }
 
 func (plr *PipeLinedRedis) Setnx(key string, val interface{}) *redis.BoolCmd {
-	op := &redisOp{
-		op:       setnxOp,
-		key:      key,
-		val:      val,
-		isFinish: make(chan bool, 1),
-	}
-	plr.opChan <- op
-	<-op.isFinish
-
-	return op.cmd.(*redis.BoolCmd)
+	return plr.do(&redisOp{
+		op:  setnxOp,
+		key: key,
+		val: val,
+	}).(*redis.BoolCmd)
 }
 
 func (plr *PipeLinedRedis) Incr(key string) *redis.IntCmd {
-	op := &redisOp{
-		op:       incrOp,
-		key:      key,
-		isFinish: make(chan bool, 1),
-	}
-	plr.opChan <- op
-	<-op.isFinish
-
-	return op.cmd.(*redis.IntCmd)
+	return plr.do(&redisOp{
+		op:  incrOp,
+		key: key,
+	}).(*redis.IntCmd)
 }
 
 func (plr *PipeLinedRedis) Decr(key string) *redis.IntCmd {
-	op := &redisOp{
-		op:       decrOp,
-		key:      key,
-		isFinish: make(chan bool, 1),
-	}
-	plr.opChan <- op
-	<-op.isFinish
-
-	return op.cmd.(*redis.IntCmd)
+	return plr.do(&redisOp{
+		op:  decrOp,
+		key: key,
+	}).(*redis.IntCmd)
 }
 
 func (plr *PipeLinedRedis) Exists(keys ...string) *redis.IntCmd {
-	op := &redisOp{
-		op:       existsOp,
-		keys:     keys,
-		isFinish: make(chan bool, 1),
-	}
-	plr.opChan <- op
-	<-op.isFinish
-
-	return op.cmd.(*redis.IntCmd)
+	return plr.do(&redisOp{
+		op:   existsOp,
+		keys: keys,
+	}).(*redis.IntCmd)
 }
 
 func (plr *PipeLinedRedis) FlushAll() *redis.StatusCmd {
-	op := &redisOp{
-		op:       flushAllOp,
-		isFinish: make(chan bool, 1),
-	}
-	plr.opChan <- op
-	<-op.isFinish
-
-	return op.cmd.(*redis.StatusCmd)
+	return plr.do(&redisOp{
+		op: flushAllOp,
+	}).(*redis.StatusCmd)
 }
 
 func (plr *PipeLinedRedis) TTL(key string) *redis.DurationCmd {
-	op := &redisOp{
-		op:       ttlOp,
-		key:      key,
-		isFinish: make(chan bool, 1),
-	}
-	plr.opChan <- op
-	<-op.isFinish
-
-	return op.cmd.(*redis.DurationCmd)
+	return plr.do(&redisOp{
+		op:  ttlOp,
+		key: key,
+	}).(*redis.DurationCmd)
 }
 
 func (plr *PipeLinedRedis) Expire(key string, expire time.Duration) *redis.BoolCmd {
-	op := &redisOp{
-		op:       expireOp,
-		key:      key,
-		expire:   expire,
-		isFinish: make(chan bool, 1),
-	}
-	plr.opChan <- op
-	<-op.isFinish
-
-	return op.cmd.(*redis.BoolCmd)
+	return plr.do(&redisOp{
+		op:     expireOp,
+		key:    key,
+		expire: expire,
+	}).(*redis.BoolCmd)
 }
 
 func (plr *PipeLinedRedis) HSet(key string, hKey string, val interface{}) *redis.IntCmd {
-	op := &redisOp{
-		op:       hsetOp,
-		key:      key,
-		hKey:     hKey,
-		val:      val,
-		isFinish: make(chan bool, 1),
-	}
-	plr.opChan <- op
-	<-op.isFinish
-
-	return op.cmd.(*redis.IntCmd)
+	return plr.do(&redisOp{
+		op:   hsetOp,
+		key:  key,
+		hKey: hKey,
+		val:  val,
+	}).(*redis.IntCmd)
 }
 
 func (plr *PipeLinedRedis) HGet(key string, hKey string) *redis.StringCmd {
-	op := &redisOp{
-		op:       hgetOp,
-		key:      key,
-		hKey:     hKey,
-		isFinish: make(chan bool, 1),
-	}
-	plr.opChan <- op
-	<-op.isFinish
-
-	return op.cmd.(*redis.StringCmd)
+	return plr.do(&redisOp{
+		op:   hgetOp,
+		key:  key,
+		hKey: hKey,
+	}).(*redis.StringCmd)
 }
 
 func (plr *PipeLinedRedis) HDel(key string, hKey string) *redis.IntCmd {
-	op := &redisOp{
-		op:       hdelOp,
-		key:      key,
-		hKey:     hKey,
-		isFinish: make(chan bool, 1),
-	}
-	plr.opChan <- op
-	<-op.isFinish
-
-	return op.cmd.(*redis.IntCmd)
+	return plr.do(&redisOp{
+		op:   hdelOp,
+		key:  key,
+		hKey: hKey,
+	}).(*redis.IntCmd)
 }
 
 func (plr *PipeLinedRedis) HKeys(key string) *redis.StringSliceCmd {
-	op := &redisOp{
-		op:       hkeysOp,
-		key:      key,
-		isFinish: make(chan bool, 1),
-	}
-	plr.opChan <- op
-	<-op.isFinish
+	return plr.do(&redisOp{
+		op:  hkeysOp,
+		key: key,
+	}).(*redis.StringSliceCmd)
+}
 
-	return op.cmd.(*redis.StringSliceCmd)
+func (plr *PipeLinedRedis) HGetAll(key string) *redis.StringStringMapCmd {
+	return plr.do(&redisOp{
+		op:  hgetAllOp,
+		key: key,
+	}).(*redis.StringStringMapCmd)
 }
 
 func (plr *PipeLinedRedis) SAdd(key string, members []interface{}) *redis.IntCmd {
-	op := &redisOp{
-		op:       saddOp,
-		key:      key,
-		members:  members,
-		isFinish: make(chan bool, 1),
-	}
-	plr.opChan <- op
-	<-op.isFinish
-
-	return op.cmd.(*redis.IntCmd)
+	return plr.do(&redisOp{
+		op:      saddOp,
+		key:     key,
+		members: members,
+	}).(*redis.IntCmd)
 }
 
 func (plr *PipeLinedRedis) SMembers(key string) *redis.StringSliceCmd {
-	op := &redisOp{
-		op:       smembersOp,
-		key:      key,
-		isFinish: make(chan bool, 1),
-	}
-	plr.opChan <- op
-	<-op.isFinish
-
-	return op.cmd.(*redis.StringSliceCmd)
+	return plr.do(&redisOp{
+		op:  smembersOp,
+		key: key,
+	}).(*redis.StringSliceCmd)
 }
 
 func (plr *PipeLinedRedis) SCard(key string) *redis.IntCmd {
-	op := &redisOp{
-		op:       scardOp,
-		key:      key,
-		isFinish: make(chan bool, 1),
-	}
-	plr.opChan <- op
-	<-op.isFinish
-
-	return op.cmd.(*redis.IntCmd)
+	return plr.do(&redisOp{
+		op:  scardOp,
+		key: key,
+	}).(*redis.IntCmd)
 }
 
 func (plr *PipeLinedRedis) LPush(key string, members []interface{}) *redis.IntCmd {
-	op := &redisOp{
-		op:       lpushOp,
-		key:      key,
-		isFinish: make(chan bool, 1),
-		members:  members,
-	}
-	plr.opChan <- op
-	<-op.isFinish
-
-	return op.cmd.(*redis.IntCmd)
+	return plr.do(&redisOp{
+		op:      lpushOp,
+		key:     key,
+		members: members,
+	}).(*redis.IntCmd)
 }
 
 func (plr *PipeLinedRedis) LTrim(key string, start, end int64) *redis.StatusCmd {
-	op := &redisOp{
-		op:       ltrimOp,
-		key:      key,
-		isFinish: make(chan bool, 1),
-		start:    start,
-		end:      end,
-	}
-	plr.opChan <- op
-	<-op.isFinish
-
-	return op.cmd.(*redis.StatusCmd)
+	return plr.do(&redisOp{
+		op:    ltrimOp,
+		key:   key,
+		start: start,
+		end:   end,
+	}).(*redis.StatusCmd)
 }
 
 func (plr *PipeLinedRedis) LLen(key string) *redis.IntCmd {
-	op := &redisOp{
-		op:       llenOp,
-		key:      key,
-		isFinish: make(chan bool, 1),
-	}
-	plr.opChan <- op
-	<-op.isFinish
-
-	return op.cmd.(*redis.IntCmd)
+	return plr.do(&redisOp{
+		op:  llenOp,
+		key: key,
+	}).(*redis.IntCmd)
 }
 
 func (plr *PipeLinedRedis) LRange(key string, start, end int64) *redis.StringSliceCmd {
-	op := &redisOp{
-		op:       lrangeOp,
-		key:      key,
-		isFinish: make(chan bool, 1),
-		start:    start,
-		end:      end,
-	}
-	plr.opChan <- op
-	<-op.isFinish
+	return plr.do(&redisOp{
+		op:    lrangeOp,
+		key:   key,
+		start: start,
+		end:   end,
+	}).(*redis.StringSliceCmd)
+}
 
-	return op.cmd.(*redis.StringSliceCmd)
+// Eval 执行lua脚本，脚本在redis中原子地执行
+func (plr *PipeLinedRedis) Eval(script string, keys []string, args ...interface{}) *redis.Cmd {
+	return plr.do(&redisOp{
+		op:      evalOp,
+		script:  script,
+		keys:    keys,
+		members: args,
+	}).(*redis.Cmd)
 }
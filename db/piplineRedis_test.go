@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// unreachableClient 连接一个不存在的redis，所有命令都会返回错误
+func unreachableClient() *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 100 * time.Millisecond,
+		MaxRetries:  -1,
+	})
+}
+
+func TestPipeLinedRedis_WithContextCanceled(t *testing.T) {
+	plr := CreatePipeLinedRedis(unreachableClient(), 4)
+	defer plr.Exit()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := plr.WithContext(ctx).Get("key").Err(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if err := plr.WithContext(ctx).TTL("key").Err(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestPipeLinedRedis_FullBatch(t *testing.T) {
+	plr := CreatePipeLinedRedis(unreachableClient(), 2)
+	defer plr.Exit()
+	//批量取满pipeLineLength个操作时，每个操作都应该得到结果
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := plr.Incr("key").Err(); err == nil {
+				t.Error("incr on unreachable redis should fail")
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("ops should all finish")
+	}
+}
@@ -3,7 +3,7 @@ package db
 import (
 	"context"
 	"github.com/go-redis/redis/v8"
-	"github.com/sirupsen/logrus"
+	"gonet/logger"
 	"gonet/utils"
 	"os"
 	"time"
@@ -18,13 +18,18 @@ func ConnectRedis(uri, pwd string, db int) *redis.Client {
 	//心跳机制
 	pong, err := client.Ping(ctx).Result()
 	if err != nil {
-		logrus.WithField("role", "connect-redis").Error(pong, err)
+		logger.Default().WithFields(logger.Fields{"role": "connect-redis", logger.FieldError: err}).Errorf("ping failed: %s", pong)
 	}
 	return client
 }
 
 func RunScript(client *redis.Client, src string, keys []string, args ...interface{}) *redis.Cmd {
-	return redis.NewScript(src).Run(client.Context(), client, keys, args...)
+	return RunScriptCtx(client.Context(), client, src, keys, args...)
+}
+
+// RunScriptCtx 使用ctx执行lua脚本，消息处理中应传入request.Context()
+func RunScriptCtx(ctx context.Context, client *redis.Client, src string, keys []string, args ...interface{}) *redis.Cmd {
+	return redis.NewScript(src).Run(ctx, client, keys, args...)
 }
 
 func RunScriptFromFile(client *redis.Client, path string, keys []string, args ...interface{}) (*redis.Cmd, error) {
@@ -38,8 +43,12 @@ func RunScriptFromFile(client *redis.Client, path string, keys []string, args ..
 
 // 发布
 func Publish(client *redis.Client, channel, data string) (err error) {
-	err = client.Publish(context.Background(), channel, data)
-	return err
+	return PublishCtx(context.Background(), client, channel, data)
+}
+
+// PublishCtx 使用ctx发布，消息处理中应传入request.Context()
+func PublishCtx(ctx context.Context, client *redis.Client, channel, data string) error {
+	return client.Publish(ctx, channel, data).Err()
 }
 
 // 订阅
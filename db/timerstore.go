@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"gonet/timer"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+/*
+	持久化定时器的存储
+	redis中每个定时器是hash中的一个字段，值为定时器定义的json，通过PipeLinedRedis与其他操作合并执行
+	mysql中每个定时器是表中的一行，以名称为主键
+*/
+
+// DefaultTimerKey redis中保存定时器的hash的默认key
+const DefaultTimerKey = "gonet:timers"
+
+// RedisTimerStore 保存在redis中的定时器
+type RedisTimerStore struct {
+	plr *PipeLinedRedis
+	key string
+}
+
+var _ timer.Store = (*RedisTimerStore)(nil)
+
+// NewRedisTimerStore 创建一个保存在redis的key中的定时器存储，key为空时使用DefaultTimerKey
+func NewRedisTimerStore(plr *PipeLinedRedis, key string) *RedisTimerStore {
+	if key == "" {
+		key = DefaultTimerKey
+	}
+	return &RedisTimerStore{plr: plr, key: key}
+}
+
+func (s *RedisTimerStore) Save(ctx context.Context, rec *timer.TimerRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.plr.WithContext(ctx).HSet(s.key, rec.Name, data).Err()
+}
+
+func (s *RedisTimerStore) Delete(ctx context.Context, name string) error {
+	return s.plr.WithContext(ctx).HDel(s.key, name).Err()
+}
+
+func (s *RedisTimerStore) LoadAll(ctx context.Context) ([]*timer.TimerRecord, error) {
+	values, err := s.plr.WithContext(ctx).HGetAll(s.key).Result()
+	if err != nil {
+		return nil, err
+	}
+	recs := make([]*timer.TimerRecord, 0, len(values))
+	for _, v := range values {
+		rec := &timer.TimerRecord{}
+		if err := json.Unmarshal([]byte(v), rec); err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+// TimerModel mysql中的定时器
+type TimerModel struct {
+	Name    string    `gorm:"primaryKey;size:191"`
+	FireAt  time.Time `gorm:"index"`
+	Payload []byte
+	Handler string `gorm:"size:64"`
+}
+
+// TableName 表名
+func (TimerModel) TableName() string {
+	return "gonet_timers"
+}
+
+// GormTimerStore 通过gorm保存在mysql中的定时器
+type GormTimerStore struct {
+	db *gorm.DB
+}
+
+var _ timer.Store = (*GormTimerStore)(nil)
+
+// NewGormTimerStore 创建一个保存在db中的定时器存储，表需要通过Migrate创建
+func NewGormTimerStore(db *gorm.DB) *GormTimerStore {
+	return &GormTimerStore{db: db}
+}
+
+// Migrate 创建或更新定时器表
+func (s *GormTimerStore) Migrate(ctx context.Context) error {
+	return s.db.WithContext(ctx).AutoMigrate(&TimerModel{})
+}
+
+func (s *GormTimerStore) Save(ctx context.Context, rec *timer.TimerRecord) error {
+	m := &TimerModel{Name: rec.Name, FireAt: rec.FireAt, Payload: rec.Payload, Handler: rec.Handler}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(m).Error
+}
+
+func (s *GormTimerStore) Delete(ctx context.Context, name string) error {
+	return s.db.WithContext(ctx).Where("name = ?", name).Delete(&TimerModel{}).Error
+}
+
+func (s *GormTimerStore) LoadAll(ctx context.Context) ([]*timer.TimerRecord, error) {
+	var models []TimerModel
+	if err := s.db.WithContext(ctx).Order("fire_at").Find(&models).Error; err != nil {
+		return nil, err
+	}
+	recs := make([]*timer.TimerRecord, 0, len(models))
+	for _, m := range models {
+		recs = append(recs, &timer.TimerRecord{Name: m.Name, FireAt: m.FireAt, Payload: m.Payload, Handler: m.Handler})
+	}
+	return recs, nil
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"gonet/timer"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// localRedis 启动一个内存中的redis，测试结束时关闭
+func localRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return mr, client
+}
+
+func TestRedisTimerStore(t *testing.T) {
+	_, client := localRedis(t)
+	plr := CreatePipeLinedRedis(client, 4)
+	defer plr.Exit()
+	ctx := context.Background()
+	store := NewRedisTimerStore(plr, "")
+	fireAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	for _, name := range []string{"auction:1", "building:1"} {
+		if err := store.Save(ctx, &timer.TimerRecord{Name: name, FireAt: fireAt, Payload: []byte(`{"id":1}`), Handler: "end"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete(ctx, "building:1"); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := store.LoadAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0].Name != "auction:1" || !recs[0].FireAt.Equal(fireAt) || string(recs[0].Payload) != `{"id":1}` || recs[0].Handler != "end" {
+		t.Fatalf("unexpected records %+v", recs)
+	}
+}
+
+func TestRedisTimerStore_Restart(t *testing.T) {
+	_, client := localRedis(t)
+	plr := CreatePipeLinedRedis(client, 4)
+	defer plr.Exit()
+	ctx := context.Background()
+	newScheduler := func() (*timer.TimerScheduler, *timer.PersistentScheduler, chan string) {
+		ts := timer.NewAutoExecTimerScheduler()
+		ps := timer.NewPersistentScheduler(ts, NewRedisTimerStore(plr, "test:timers"))
+		fired := make(chan string, 4)
+		ps.Handle("notify", func(ctx context.Context, rec *timer.TimerRecord) error {
+			fired <- rec.Name
+			return nil
+		})
+		return ts, ps, fired
+	}
+
+	//第一个进程创建定时器之后退出
+	ts, ps, _ := newScheduler()
+	if err := ps.Schedule(ctx, "building:1", time.Now().Add(300*time.Millisecond), "notify", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	//新的进程加载定时器并触发
+	ts, ps, fired := newScheduler()
+	if err := ps.Restore(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case name := <-fired:
+		if name != "building:1" {
+			t.Fatalf("unexpected timer %s", name)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("restored timer should fire")
+	}
+	//等待处理方法返回及删除完成
+	deadline := time.Now().Add(time.Second)
+	for ts.ExecutorStats().Executed != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("timer task should finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := ts.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := client.HLen(ctx, "test:timers").Val(); n != 0 {
+		t.Fatalf("fired timer should be deleted from redis, %d left", n)
+	}
+}
+
+func TestGormTimerStore(t *testing.T) {
+	recorder := recordSpans(t)
+	//DryRun只生成SQL不连接数据库，写操作不开启事务
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "user:pwd@tcp(127.0.0.1:1)/test",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(&TracingPlugin{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	store := NewGormTimerStore(db)
+	if err := store.Save(ctx, &timer.TimerRecord{Name: "auction:1", FireAt: time.Now(), Handler: "end"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "auction:1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var statements []string
+	for _, span := range recorder.Completed() {
+		statements = append(statements, span.Attributes()["db.statement"].AsString())
+	}
+	want := []string{
+		"INSERT INTO `gonet_timers`",
+		"ON DUPLICATE KEY UPDATE",
+		"DELETE FROM `gonet_timers` WHERE name = ?",
+		"SELECT * FROM `gonet_timers` ORDER BY fire_at",
+	}
+	all := strings.Join(statements, "\n")
+	for _, w := range want {
+		if !strings.Contains(all, w) {
+			t.Fatalf("statements %q should contain %q", all, w)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/oteltest"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func recordSpans(t *testing.T) *oteltest.SpanRecorder {
+	recorder := new(oteltest.SpanRecorder)
+	old := otel.GetTracerProvider()
+	otel.SetTracerProvider(oteltest.NewTracerProvider(oteltest.WithSpanRecorder(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(old) })
+	return recorder
+}
+
+func TestPipeLinedRedis_Tracing(t *testing.T) {
+	recorder := recordSpans(t)
+	plr := CreatePipeLinedRedis(unreachableClient(), 4)
+	defer plr.Exit()
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "handle")
+	_ = plr.WithContext(ctx).Get("key")
+	parent.End()
+
+	var opSpan, batchSpan *oteltest.Span
+	for _, span := range recorder.Completed() {
+		switch span.Name() {
+		case "redis.get":
+			opSpan = span
+		case "redis.pipeline":
+			batchSpan = span
+		}
+	}
+	if opSpan == nil || batchSpan == nil {
+		t.Fatal("op and batch spans should be recorded")
+	}
+	if opSpan.ParentSpanID() != parent.SpanContext().SpanID {
+		t.Fatal("op span should be child of request span")
+	}
+	//连接失败的错误记录在span上
+	if len(opSpan.Events()) == 0 {
+		t.Fatal("op span should record the error")
+	}
+	links := batchSpan.Links()
+	if len(links) != 1 || links[0].SpanContext.SpanID != opSpan.SpanContext().SpanID {
+		t.Fatalf("batch span should link to op span, got %v", links)
+	}
+}
+
+type tracedUser struct {
+	ID   uint
+	Name string
+}
+
+func TestTracingPlugin(t *testing.T) {
+	recorder := recordSpans(t)
+	//DryRun只生成SQL不连接数据库
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "user:pwd@tcp(127.0.0.1:1)/test",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(&TracingPlugin{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "handle")
+	var user tracedUser
+	db.WithContext(ctx).Where("name = ?", "gonet").First(&user)
+	parent.End()
+
+	var querySpan *oteltest.Span
+	for _, span := range recorder.Completed() {
+		if span.Name() == "gorm.query" {
+			querySpan = span
+		}
+	}
+	if querySpan == nil {
+		t.Fatal("query span should be recorded")
+	}
+	if querySpan.ParentSpanID() != parent.SpanContext().SpanID {
+		t.Fatal("query span should be child of request span")
+	}
+	if querySpan.SpanKind() != trace.SpanKindClient {
+		t.Fatalf("got span kind %v", querySpan.SpanKind())
+	}
+	statement := querySpan.Attributes()["db.statement"].AsString()
+	if statement == "" {
+		t.Fatal("query span should carry the statement")
+	}
+}
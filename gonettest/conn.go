@@ -0,0 +1,222 @@
+// Package gonettest 提供不依赖真实网络的测试工具
+// FakeConn和NewRequest用于直接测试IRouter，Server和Client通过内存中的net.Pipe测试完整的服务器
+package gonettest
+
+import (
+	"context"
+	"errors"
+	"gonet/interfaces"
+	gnet "gonet/net"
+	"gonet/pack"
+	"gonet/timer"
+	"net"
+	"sync"
+	"time"
+)
+
+var _ interfaces.IConnection = (*FakeConn)(nil)
+
+// fakeAddr FakeConn的远程地址
+type fakeAddr string
+
+func (a fakeAddr) Network() string { return "fake" }
+func (a fakeAddr) String() string  { return string(a) }
+
+// FakeConn 内存中的连接，记录SendMsg及SendReliableMsg发送的消息
+type FakeConn struct {
+	ConnID       uint64
+	ListenerName string
+	Tags         []string
+	Addr         net.Addr
+	// Scheduler AfterFunc及Every使用的调度器，为空时使用包内共用的调度器
+	Scheduler *timer.TimerScheduler
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	sent   *inbox
+
+	lock     sync.RWMutex
+	closed   bool
+	reliable bool
+	property map[string]interface{}
+	timers   *gnet.ConnTimers
+}
+
+var (
+	defaultScheduler     *timer.TimerScheduler
+	defaultSchedulerOnce sync.Once
+)
+
+// sharedScheduler 包内共用的调度器，第一次使用时启动
+func sharedScheduler() *timer.TimerScheduler {
+	defaultSchedulerOnce.Do(func() {
+		defaultScheduler = timer.NewAutoExecTimerScheduler()
+		defaultScheduler.Start()
+	})
+	return defaultScheduler
+}
+
+// NewFakeConn 创建一个内存中的连接
+func NewFakeConn(connID uint64) *FakeConn {
+	c := &FakeConn{
+		ConnID:   connID,
+		Addr:     fakeAddr("fake"),
+		sent:     newInbox(),
+		property: make(map[string]interface{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+// NewRequest 创建conn上的一个请求，可以直接交给IRouter或MsgHandle处理
+func NewRequest(conn interfaces.IConnection, msgID uint32, data []byte) interfaces.IRequest {
+	return gnet.NewRequest(conn, pack.NewMessage(msgID, data))
+}
+
+// Sent 返回该连接发送过的全部消息
+func (c *FakeConn) Sent() []interfaces.IMessage {
+	return c.sent.all()
+}
+
+// ExpectMessage 等待该连接发送的下一条消息，消息ID不是msgID或超时时返回错误
+func (c *FakeConn) ExpectMessage(msgID uint32, timeout time.Duration) (interfaces.IMessage, error) {
+	return c.sent.expect(msgID, timeout)
+}
+
+// ExpectNoMessage 在wait时间内该连接没有发送新的消息
+func (c *FakeConn) ExpectNoMessage(wait time.Duration) error {
+	return c.sent.expectNone(wait)
+}
+
+// IsClosed 连接是否已经被Stop
+func (c *FakeConn) IsClosed() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.closed
+}
+
+// IsReliable 是否调用过EnableReliable
+func (c *FakeConn) IsReliable() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.reliable
+}
+
+func (c *FakeConn) Start() {}
+
+func (c *FakeConn) Stop() {
+	c.lock.Lock()
+	c.closed = true
+	timers := c.timers
+	c.lock.Unlock()
+	c.cancel()
+	c.sent.close()
+	if timers != nil {
+		timers.Stop()
+	}
+}
+
+// connTimers 第一次使用时创建连接的定时器，回调在调度器的协程中直接执行
+func (c *FakeConn) connTimers() *gnet.ConnTimers {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.timers == nil {
+		ts := c.Scheduler
+		if ts == nil {
+			ts = sharedScheduler()
+		}
+		c.timers = gnet.NewConnTimers(c, ts, func(fn func()) { fn() })
+		if c.closed {
+			c.timers.Stop()
+		}
+	}
+	return c.timers
+}
+
+// AfterFunc 在d之后调用fn，连接Stop时自动取消
+func (c *FakeConn) AfterFunc(d time.Duration, fn func(conn interfaces.IConnection)) interfaces.IConnTimer {
+	return c.connTimers().AfterFunc(d, fn)
+}
+
+// Every 每隔d调用一次fn，连接Stop时自动停止
+func (c *FakeConn) Every(d time.Duration, fn func(conn interfaces.IConnection)) interfaces.IConnTimer {
+	return c.connTimers().Every(d, fn)
+}
+
+func (c *FakeConn) Context() context.Context {
+	return c.ctx
+}
+
+func (c *FakeConn) GetTCPConnection() *net.TCPConn {
+	return nil
+}
+
+func (c *FakeConn) GetConnection() net.Conn {
+	return nil
+}
+
+func (c *FakeConn) GetListenerName() string {
+	return c.ListenerName
+}
+
+func (c *FakeConn) GetTags() []string {
+	return c.Tags
+}
+
+func (c *FakeConn) HasTag(tag string) bool {
+	for _, t := range c.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *FakeConn) GetConnID() uint64 {
+	return c.ConnID
+}
+
+func (c *FakeConn) RemoteAddr() net.Addr {
+	return c.Addr
+}
+
+func (c *FakeConn) SendMsg(msgID uint32, data []byte) error {
+	if c.IsClosed() {
+		return errors.New("connection closed when send msg")
+	}
+	c.sent.push(pack.NewMessage(msgID, append([]byte(nil), data...)))
+	return nil
+}
+
+func (c *FakeConn) EnableReliable() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.reliable = true
+	return nil
+}
+
+// SendReliableMsg 与SendMsg相同，不进行可靠通道的封装
+func (c *FakeConn) SendReliableMsg(msgID uint32, data []byte) error {
+	return c.SendMsg(msgID, data)
+}
+
+func (c *FakeConn) SetProperty(key string, value interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.property[key] = value
+}
+
+func (c *FakeConn) GetProperty(key string) (interface{}, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if value, ok := c.property[key]; ok {
+		return value, nil
+	}
+	return nil, errors.New("no property found")
+}
+
+func (c *FakeConn) DeleteProperty(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.property, key)
+}
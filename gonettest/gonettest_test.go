@@ -0,0 +1,137 @@
+package gonettest
+
+import (
+	"bytes"
+	"errors"
+	"gonet/interfaces"
+	gnet "gonet/net"
+	"testing"
+	"time"
+)
+
+type pingRouter struct {
+	gnet.BaseRouter
+}
+
+func (r *pingRouter) Handle(request interfaces.IRequest) {
+	_ = request.GetConn().SendMsg(2, append([]byte("pong:"), request.GetData()...))
+}
+
+func TestFakeConn(t *testing.T) {
+	t.Parallel()
+	conn := NewFakeConn(7)
+	(&pingRouter{}).Handle(NewRequest(conn, 1, []byte("a")))
+
+	msg, err := conn.ExpectMessage(2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.GetData()) != "pong:a" {
+		t.Fatalf("unexpected data %q", msg.GetData())
+	}
+	if err := conn.ExpectNoMessage(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	//消息ID不一致
+	_ = conn.SendMsg(3, nil)
+	if _, err := conn.ExpectMessage(2, time.Second); err == nil {
+		t.Fatal("expect msgID 2 should fail on msgID 3")
+	}
+	if len(conn.Sent()) != 2 {
+		t.Fatalf("want 2 sent msgs, got %d", len(conn.Sent()))
+	}
+
+	conn.Stop()
+	if !conn.IsClosed() || conn.Context().Err() == nil {
+		t.Fatal("conn should be closed")
+	}
+	if err := conn.SendMsg(2, nil); err == nil {
+		t.Fatal("send after stop should fail")
+	}
+	if _, err := conn.ExpectMessage(2, time.Second); !errors.Is(err, ErrClosed) {
+		t.Fatalf("want ErrClosed, got %v", err)
+	}
+}
+
+func TestFakeConn_AfterFunc(t *testing.T) {
+	t.Parallel()
+	conn := NewFakeConn(8)
+	conn.AfterFunc(10*time.Millisecond, func(c interfaces.IConnection) {
+		_ = c.SendMsg(3, nil)
+	})
+	if _, err := conn.ExpectMessage(3, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	//Stop之后定时器不再触发
+	fired := make(chan struct{}, 1)
+	conn.AfterFunc(50*time.Millisecond, func(interfaces.IConnection) { fired <- struct{}{} })
+	conn.Stop()
+	select {
+	case <-fired:
+		t.Fatal("timer should be cancelled by Stop")
+	case <-time.After(150 * time.Millisecond):
+	}
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+	s := NewServer()
+	s.AddRouter(1, &pingRouter{})
+	s.Start()
+	defer s.Stop()
+
+	client, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendMsg(1, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := client.ExpectMessage(2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.GetData()) != "pong:b" {
+		t.Fatalf("unexpected data %q", msg.GetData())
+	}
+	if _, err := client.ExpectMessage(2, 20*time.Millisecond); err == nil {
+		t.Fatal("expect should time out")
+	}
+
+	//服务器停止后客户端收到关闭
+	s.Stop()
+	if _, err := client.ExpectMessage(2, time.Second); !errors.Is(err, ErrClosed) {
+		t.Fatalf("want ErrClosed, got %v", err)
+	}
+	if _, err := s.Dial(); err == nil {
+		t.Fatal("dial after stop should fail")
+	}
+}
+
+func TestServer_DialMaxPacketSize(t *testing.T) {
+	t.Parallel()
+	//客户端按服务器的配置拆包，超过全局默认长度的消息同样可以收到
+	s := NewServer(gnet.WithMaxPacketSize(0))
+	s.AddRouter(1, &pingRouter{})
+	s.Start()
+	defer s.Stop()
+
+	client, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	data := bytes.Repeat([]byte("x"), 64*1024)
+	if err := client.SendMsg(1, data); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := client.ExpectMessage(2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.GetData()) != len("pong:")+len(data) {
+		t.Fatalf("unexpected data len %d", len(msg.GetData()))
+	}
+}
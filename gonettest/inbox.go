@@ -0,0 +1,91 @@
+package gonettest
+
+import (
+	"errors"
+	"fmt"
+	"gonet/interfaces"
+	"sync"
+	"time"
+)
+
+// ErrClosed 连接已经关闭且没有更多的消息
+var ErrClosed = errors.New("gonettest: connection closed")
+
+// inbox 按顺序保存收到的消息，ExpectMessage依次取出
+type inbox struct {
+	lock sync.Mutex
+	msgs []interfaces.IMessage
+	//下一条被ExpectMessage取出的消息
+	next int
+	//有新消息或关闭时被关闭并替换
+	notify chan struct{}
+	closed bool
+}
+
+func newInbox() *inbox {
+	return &inbox{notify: make(chan struct{})}
+}
+
+func (b *inbox) push(msg interfaces.IMessage) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.msgs = append(b.msgs, msg)
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+func (b *inbox) close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// all 返回全部收到的消息，包括已经被取出的
+func (b *inbox) all() []interfaces.IMessage {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]interfaces.IMessage(nil), b.msgs...)
+}
+
+// expect 等待下一条消息，消息ID不是msgID时返回错误
+func (b *inbox) expect(msgID uint32, timeout time.Duration) (interfaces.IMessage, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		b.lock.Lock()
+		if b.next < len(b.msgs) {
+			msg := b.msgs[b.next]
+			b.next++
+			b.lock.Unlock()
+			if msg.GetMsgId() != msgID {
+				return msg, fmt.Errorf("gonettest: expect msgID %d, got %d", msgID, msg.GetMsgId())
+			}
+			return msg, nil
+		}
+		if b.closed {
+			b.lock.Unlock()
+			return nil, ErrClosed
+		}
+		notify := b.notify
+		b.lock.Unlock()
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			return nil, fmt.Errorf("gonettest: expect msgID %d, timeout after %v", msgID, timeout)
+		}
+	}
+}
+
+// expectNone 在wait时间内没有新的消息
+func (b *inbox) expectNone(wait time.Duration) error {
+	if msg, _ := b.expect(0, wait); msg != nil {
+		return fmt.Errorf("gonettest: unexpected msgID %d", msg.GetMsgId())
+	}
+	return nil
+}
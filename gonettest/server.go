@@ -0,0 +1,167 @@
+package gonettest
+
+import (
+	"gonet/config"
+	"gonet/interfaces"
+	gnet "gonet/net"
+	"gonet/pack"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// PipeListenerName Server使用的内存监听名称
+const PipeListenerName = "pipe"
+
+// pipeListener 内存中的监听，每次Dial通过net.Pipe创建一对连接
+type pipeListener struct {
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return fakeAddr(PipeListenerName)
+}
+
+// dial 创建一对连接，服务端的一端交给Accept
+func (l *pipeListener) dial() (net.Conn, error) {
+	serverConn, clientConn := net.Pipe()
+	select {
+	case l.conns <- serverConn:
+		return clientConn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Server 只在内存中监听的服务器，连接、worker、钩子的行为与真实的服务器相同
+type Server struct {
+	*gnet.Server
+	ln *pipeListener
+}
+
+// NewServer 创建一个只在内存中监听的服务器，注册路由及钩子之后调用Start
+// 使用默认配置，不读取任何配置文件，机器ID默认为1，opts与gonet/net.NewServer相同
+func NewServer(opts ...gnet.Option) *Server {
+	ln := newPipeListener()
+	opts = append([]gnet.Option{gnet.WithName("gonettest"), gnet.WithMachineID(1)}, opts...)
+	opts = append(opts,
+		gnet.WithUDPPort(0),
+		gnet.WithConnMode(gnet.ConnModeGoroutine, 0),
+		gnet.WithListener(&gnet.ListenerConfig{
+			Name:     PipeListenerName,
+			Network:  "pipe",
+			Listener: ln,
+		}),
+	)
+	s := gnet.NewServerWithConfig(config.Default(), opts...).(*gnet.Server)
+	return &Server{Server: s, ln: ln}
+}
+
+// Dial 连接服务器，返回的Client在服务器创建对应的连接之后才能收到消息
+func (s *Server) Dial() (*Client, error) {
+	conn, err := s.ln.dial()
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, pack.FactoryInstance.NewPackWithMaxSize(interfaces.GoNetDataPack, s.Config().MaxPacketSize)), nil
+}
+
+// Client 与Server配套的客户端，按服务器的封包格式收发消息
+type Client struct {
+	conn   net.Conn
+	packet interfaces.IDataPack
+	inbox  *inbox
+	//net.Pipe的写是同步的，同一时间只能有一个写
+	writeLock sync.Mutex
+}
+
+// NewClient 在一个已经建立的连接上创建客户端，packet应与服务器的封包格式及最大消息长度一致
+func NewClient(conn net.Conn, packet interfaces.IDataPack) *Client {
+	c := &Client{
+		conn:   conn,
+		packet: packet,
+		inbox:  newInbox(),
+	}
+	go c.read()
+	return c
+}
+
+func (c *Client) read() {
+	defer c.inbox.close()
+	for {
+		head := make([]byte, c.packet.GetHeadLen())
+		if _, err := io.ReadFull(c.conn, head); err != nil {
+			return
+		}
+		msg, err := c.packet.UnPack(head)
+		if err != nil {
+			return
+		}
+		var data []byte
+		if msg.GetMsgLen() > 0 {
+			data = make([]byte, msg.GetMsgLen())
+			if _, err := io.ReadFull(c.conn, data); err != nil {
+				return
+			}
+		}
+		msg.SetMsgData(data)
+		c.inbox.push(msg)
+	}
+}
+
+// SendMsg 发送一条消息，服务器读取之后返回
+func (c *Client) SendMsg(msgID uint32, data []byte) error {
+	binaryMsg, err := c.packet.Pack(pack.NewMessage(msgID, data))
+	if err != nil {
+		return err
+	}
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err = c.conn.Write(binaryMsg)
+	return err
+}
+
+// ExpectMessage 等待服务器发送的下一条消息，消息ID不是msgID或超时时返回错误
+// 服务器关闭连接且没有剩余的消息时返回ErrClosed
+func (c *Client) ExpectMessage(msgID uint32, timeout time.Duration) (interfaces.IMessage, error) {
+	return c.inbox.expect(msgID, timeout)
+}
+
+// ExpectNoMessage 在wait时间内服务器没有发送新的消息
+func (c *Client) ExpectNoMessage(wait time.Duration) error {
+	return c.inbox.expectNone(wait)
+}
+
+// Received 返回收到的全部消息
+func (c *Client) Received() []interfaces.IMessage {
+	return c.inbox.all()
+}
+
+// Close 关闭客户端的连接，服务器会随之停止对应的连接
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -1,6 +1,16 @@
 package interfaces
 
-import "net"
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// IConnTimer 连接的定时器或周期任务
+type IConnTimer interface {
+	// Stop 停止定时器，之后不再调用回调，返回停止之前是否仍在等待触发
+	Stop() bool
+}
 
 type IConnection interface {
 	// Start 启动连接，让当前连接开始工作
@@ -9,9 +19,24 @@ type IConnection interface {
 	// Stop 停止链接 结束当前连接的工作
 	Stop()
 
-	// GetTCPConnection 获取当前连接绑定的conn套接字
+	// Context 获取当前连接的ctx，连接停止时被取消
+	Context() context.Context
+
+	// GetTCPConnection 获取当前连接绑定的TCP套接字，非TCP连接返回nil
 	GetTCPConnection() *net.TCPConn
 
+	// GetConnection 获取当前连接绑定的socket套接字
+	GetConnection() net.Conn
+
+	// GetListenerName 获取当前连接所属的监听名称
+	GetListenerName() string
+
+	// GetTags 获取当前连接所属监听的标签
+	GetTags() []string
+
+	// HasTag 判断当前连接是否携带某个标签
+	HasTag(string) bool
+
 	// GetConnID 获取当前连接模块的连接ID
 	GetConnID() uint64
 
@@ -21,6 +46,12 @@ type IConnection interface {
 	// SendMsg 发送数据，将数据发送给远程的客户端
 	SendMsg(uint32, []byte) error
 
+	// EnableReliable 为当前连接启用可靠通道，需要在ConnID确定之后调用
+	EnableReliable() error
+
+	// SendReliableMsg 发送可靠消息，消息会被分配序列号并保留至客户端确认
+	SendReliableMsg(uint32, []byte) error
+
 	// SetProperty 设置连接属性
 	SetProperty(string, interface{})
 
@@ -29,4 +60,10 @@ type IConnection interface {
 
 	// DeleteProperty 删除连接属性
 	DeleteProperty(string)
+
+	// AfterFunc 在d之后调用fn，fn与该连接的消息在同一个worker中按顺序执行，连接停止时自动取消
+	AfterFunc(d time.Duration, fn func(conn IConnection)) IConnTimer
+
+	// Every 每隔d调用一次fn，fn与该连接的消息在同一个worker中按顺序执行，连接停止时自动停止
+	Every(d time.Duration, fn func(conn IConnection)) IConnTimer
 }
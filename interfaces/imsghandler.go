@@ -1,5 +1,7 @@
 package interfaces
 
+import "time"
+
 type IMsgHandle interface {
 
 	// DoMsgHandle 调度/执行对应的Router消息处理方法
@@ -8,6 +10,9 @@ type IMsgHandle interface {
 	// AddRouter 为消息添加具体的处理逻辑
 	AddRouter(uint32, IRouter)
 
+	// SetHandlerTimeout 设置某个msgID的处理超时时间，超时后请求的ctx被取消，0表示不限制
+	SetHandlerTimeout(uint32, time.Duration)
+
 	// StartWorkerPool 启动一个worker工作池
 	StartWorkerPool()
 
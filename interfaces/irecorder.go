@@ -0,0 +1,34 @@
+package interfaces
+
+/*
+	消息录制模块
+	按连接录制收到和发送的消息，用于线上问题的复现
+*/
+
+// RecordDirection 消息的方向
+type RecordDirection uint8
+
+const (
+	// RecordInbound 客户端发送给服务器的消息
+	RecordInbound RecordDirection = iota + 1
+	// RecordOutbound 服务器发送给客户端的消息
+	RecordOutbound
+)
+
+// String 返回方向的名称，in或out
+func (d RecordDirection) String() string {
+	switch d {
+	case RecordInbound:
+		return "in"
+	case RecordOutbound:
+		return "out"
+	}
+	return "unknown"
+}
+
+type IRecorder interface {
+	// Record 录制连接上的一条消息，录制失败不影响消息的处理
+	Record(conn IConnection, dir RecordDirection, msg IMessage)
+	// Close 关闭录制，写入剩余的数据
+	Close() error
+}
@@ -0,0 +1,19 @@
+package interfaces
+
+/*
+	可靠消息模块
+	为连接提供带序列号和确认机制的可靠通道，保证断线重连后的至少一次送达
+*/
+
+type IReliableMgr interface {
+	// Attach 为连接启用可靠通道，如果存在相同ConnID的未过期会话，则恢复会话并重传未确认的消息
+	Attach(conn IConnection) error
+	// Detach 连接断开时解除绑定，会话会在保留期内等待重连
+	Detach(conn IConnection)
+	// Send 为消息分配序列号，保留至客户端确认后发送
+	Send(conn IConnection, msgID uint32, data []byte) error
+	// HandleAck 处理客户端发送的确认消息
+	HandleAck(conn IConnection, data []byte) error
+	// HandleData 处理客户端发送的可靠消息，返回解封装后的消息，重复的消息返回nil
+	HandleData(conn IConnection, data []byte) (IMessage, error)
+}
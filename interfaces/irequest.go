@@ -1,5 +1,7 @@
 package interfaces
 
+import "context"
+
 type IRequest interface {
 	// GetConn  得到当前连接
 	GetConn() IConnection
@@ -7,4 +9,9 @@ type IRequest interface {
 	GetData() []byte
 	// GetMsgID 得到请求数据的ID
 	GetMsgID() uint32
+	// Context 得到请求的ctx，由连接的ctx派生，连接停止或处理超时时被取消
+	// 可以携带traceID、userID等请求范围内的值，调用db、cache时应传递该ctx
+	Context() context.Context
+	// SetContext 替换请求的ctx，用于在PreHandle中附加请求范围内的值
+	SetContext(context.Context)
 }
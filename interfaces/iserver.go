@@ -1,5 +1,10 @@
 package interfaces
 
+import (
+	"gonet/logger"
+	"gonet/timer"
+)
+
 //定义一个服务器接口
 
 type IServer interface {
@@ -21,6 +26,9 @@ type IServer interface {
 	// GetConnMgr 返回一个连接管理模块
 	GetConnMgr() IConnMgr
 
+	// GetReliableMgr 返回可靠消息管理模块
+	GetReliableMgr() IReliableMgr
+
 	// SetOnConnStart 注册OnConnStart钩子函数的方法
 	SetOnConnStart(func(conn IConnection))
 
@@ -34,4 +42,19 @@ type IServer interface {
 	CallOnConnStop(conn IConnection)
 	// Packet 封/拆包方式
 	Packet() IDataPack
+
+	// GetLogger 返回该服务器的日志，连接、worker等子模块在此基础上附加各自的字段
+	GetLogger() logger.Logger
+
+	// SetLogger 替换该服务器的日志，需要在Start之前调用
+	SetLogger(logger.Logger)
+
+	// GetRecorder 返回消息录制，未开启录制时返回nil
+	GetRecorder() IRecorder
+
+	// SetRecorder 设置消息录制，需要在Start之前调用
+	SetRecorder(IRecorder)
+
+	// GetTimerScheduler 返回该服务器的定时器调度器，随服务器Start启动、Stop停止
+	GetTimerScheduler() *timer.TimerScheduler
 }
@@ -0,0 +1,160 @@
+package logger
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+	fluentd输出
+	使用forward协议的Message模式：每条日志编码为msgpack数组[tag, time, record]
+	日志先放入缓冲队列，由单独的go发送，连接断开时自动重连，队列满时丢弃并计数，不阻塞业务
+	Close最多等待到ctx结束，之后不再连接fluentd，队列中剩余的日志计为丢弃
+*/
+
+const (
+	fluentdQueueLen     = 1024
+	fluentdDialTimeout  = 3 * time.Second
+	fluentdWriteTimeout = 3 * time.Second
+)
+
+var _ logrus.Hook = (*FluentdHook)(nil)
+
+// dialFunc 连接fluentd的方法，ctx取消时放弃连接
+type dialFunc func(ctx context.Context, address string) (net.Conn, error)
+
+// FluentdHook 将日志以forward协议发送给fluentd的logrus hook
+type FluentdHook struct {
+	address string
+	tag     string
+	dial    dialFunc
+	conn    net.Conn
+	entries chan []byte
+	done    chan struct{}
+	//Close等待超时之后取消，不再连接及发送
+	abort       context.Context
+	cancelAbort context.CancelFunc
+	//保护entries的关闭
+	lock   sync.RWMutex
+	closed bool
+	//队列满或发送失败丢弃的日志条数
+	dropped uint64
+}
+
+// NewFluentdHook 创建fluentd输出，address为fluentd的host:port，tag为fluentd中的日志标签
+func NewFluentdHook(address, tag string) *FluentdHook {
+	dialer := &net.Dialer{Timeout: fluentdDialTimeout}
+	return newFluentdHook(address, tag, func(ctx context.Context, address string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "tcp", address)
+	})
+}
+
+func newFluentdHook(address, tag string, dial dialFunc) *FluentdHook {
+	h := &FluentdHook{
+		address: address,
+		tag:     tag,
+		dial:    dial,
+		entries: make(chan []byte, fluentdQueueLen),
+		done:    make(chan struct{}),
+	}
+	h.abort, h.cancelAbort = context.WithCancel(context.Background())
+	go h.run()
+	return h
+}
+
+func (h *FluentdHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire 将日志编码后放入发送队列
+func (h *FluentdHook) Fire(entry *logrus.Entry) error {
+	record := make(map[string]interface{}, len(entry.Data)+2)
+	for key, value := range entry.Data {
+		record[key] = value
+	}
+	record["level"] = entry.Level.String()
+	record["message"] = entry.Message
+
+	buf := appendArrayHead(nil, 3)
+	buf = appendString(buf, h.tag)
+	buf = appendInt(buf, entry.Time.Unix())
+	buf = appendMap(buf, record)
+
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	if h.closed {
+		return nil
+	}
+	select {
+	case h.entries <- buf:
+	default:
+		atomic.AddUint64(&h.dropped, 1)
+	}
+	return nil
+}
+
+// Dropped 返回丢弃的日志条数
+func (h *FluentdHook) Dropped() uint64 {
+	return atomic.LoadUint64(&h.dropped)
+}
+
+func (h *FluentdHook) run() {
+	defer close(h.done)
+	defer h.cancelAbort()
+	for buf := range h.entries {
+		if h.abort.Err() != nil {
+			atomic.AddUint64(&h.dropped, 1)
+			continue
+		}
+		//发送失败时重连后再尝试一次
+		if err := h.write(buf); err != nil {
+			if err = h.write(buf); err != nil {
+				atomic.AddUint64(&h.dropped, 1)
+			}
+		}
+	}
+	if h.conn != nil {
+		_ = h.conn.Close()
+	}
+}
+
+func (h *FluentdHook) write(buf []byte) error {
+	if h.conn == nil {
+		conn, err := h.dial(h.abort, h.address)
+		if err != nil {
+			return err
+		}
+		h.conn = conn
+	}
+	_ = h.conn.SetWriteDeadline(time.Now().Add(fluentdWriteTimeout))
+	if _, err := h.conn.Write(buf); err != nil {
+		_ = h.conn.Close()
+		h.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Close 发送完队列中剩余的日志后关闭连接，ctx结束时放弃剩余的日志并返回ctx.Err()
+func (h *FluentdHook) Close(ctx context.Context) error {
+	h.lock.Lock()
+	if h.closed {
+		h.lock.Unlock()
+		return nil
+	}
+	h.closed = true
+	close(h.entries)
+	h.lock.Unlock()
+	select {
+	case <-h.done:
+		return nil
+	case <-ctx.Done():
+		h.cancelAbort()
+		return ctx.Err()
+	}
+}
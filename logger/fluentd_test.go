@@ -0,0 +1,219 @@
+package logger
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// decodeMsgpack 解码测试用到的msgpack子集
+func decodeMsgpack(r *bufio.Reader) (interface{}, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	readN := func(n int) ([]byte, error) {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(r, buf)
+		return buf, err
+	}
+	readLen := func(size int) (int, error) {
+		buf, err := readN(size)
+		if err != nil {
+			return 0, err
+		}
+		switch size {
+		case 1:
+			return int(buf[0]), nil
+		case 2:
+			return int(binary.BigEndian.Uint16(buf)), nil
+		default:
+			return int(binary.BigEndian.Uint32(buf)), nil
+		}
+	}
+	decodeArray := func(n int) (interface{}, error) {
+		arr := make([]interface{}, n)
+		for i := range arr {
+			if arr[i], err = decodeMsgpack(r); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+	decodeMap := func(n int) (interface{}, error) {
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			key, err := decodeMsgpack(r)
+			if err != nil {
+				return nil, err
+			}
+			if m[key.(string)], err = decodeMsgpack(r); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	switch {
+	case b < 0x80:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xf0 == 0x80:
+		return decodeMap(int(b & 0x0f))
+	case b&0xf0 == 0x90:
+		return decodeArray(int(b & 0x0f))
+	case b&0xe0 == 0xa0:
+		buf, err := readN(int(b & 0x1f))
+		return string(buf), err
+	}
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce:
+		n, err := readLen(1 << (b - 0xcc))
+		return int64(n), err
+	case 0xcf, 0xd3:
+		buf, err := readN(8)
+		return int64(binary.BigEndian.Uint64(buf)), err
+	case 0xcb:
+		buf, err := readN(8)
+		return math.Float64frombits(binary.BigEndian.Uint64(buf)), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := readLen(1 << (b - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		buf, err := readN(n)
+		return string(buf), err
+	case 0xdc:
+		n, err := readLen(2)
+		if err != nil {
+			return nil, err
+		}
+		return decodeArray(n)
+	case 0xde:
+		n, err := readLen(2)
+		if err != nil {
+			return nil, err
+		}
+		return decodeMap(n)
+	}
+	return nil, fmt.Errorf("unsupported msgpack type 0x%x", b)
+}
+
+func TestFluentdHook(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	events := make(chan []interface{}, 4)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			v, err := decodeMsgpack(r)
+			if err != nil {
+				return
+			}
+			events <- v.([]interface{})
+		}
+	}()
+
+	hook := NewFluentdHook(ln.Addr().String(), "gonet.test")
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	l.AddHook(hook)
+	log := NewLogrus(l).WithFields(Fields{FieldConnID: uint64(42), FieldMsgID: uint32(7)})
+	log.WithField(FieldError, errors.New("boom")).Error("handle failed")
+	_ = hook.Close(context.Background())
+
+	select {
+	case event := <-events:
+		if len(event) != 3 || event[0] != "gonet.test" {
+			t.Fatalf("unexpected event %v", event)
+		}
+		if ts := event[1].(int64); time.Since(time.Unix(ts, 0)) > time.Minute {
+			t.Fatalf("unexpected time %d", ts)
+		}
+		record := event[2].(map[string]interface{})
+		want := map[string]interface{}{
+			"message":   "handle failed",
+			"level":     "error",
+			FieldConnID: int64(42),
+			FieldMsgID:  int64(7),
+			FieldError:  "boom",
+		}
+		for key, value := range want {
+			if record[key] != value {
+				t.Fatalf("record[%s] = %v, want %v", key, record[key], value)
+			}
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no event received")
+	}
+	if hook.Dropped() != 0 {
+		t.Fatalf("%d events dropped", hook.Dropped())
+	}
+}
+
+func TestFluentdHook_Unreachable(t *testing.T) {
+	//fluentd不可用时日志被丢弃，不阻塞调用方
+	hook := NewFluentdHook("127.0.0.1:1", "gonet.test")
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	l.AddHook(hook)
+	NewLogrus(l).Info("lost")
+	_ = hook.Close(context.Background())
+	if hook.Dropped() != 1 {
+		t.Fatalf("dropped %d, want 1", hook.Dropped())
+	}
+}
+
+func TestFluentdHook_CloseTimeout(t *testing.T) {
+	//fluentd无法连接且每次连接都等到超时，Close在ctx结束时返回，剩余的日志计为丢弃
+	hook := newFluentdHook("fluentd:24224", "gonet.test", func(ctx context.Context, _ string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	l.AddHook(hook)
+	for i := 0; i < 10; i++ {
+		NewLogrus(l).Info("lost")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := hook.Close(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("want DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("close should be bounded by ctx, took %v", elapsed)
+	}
+	select {
+	case <-hook.done:
+	case <-time.After(time.Second):
+		t.Fatal("sender should exit after close timeout")
+	}
+	if hook.Dropped() != 10 {
+		t.Fatalf("dropped %d, want 10", hook.Dropped())
+	}
+}
@@ -0,0 +1,114 @@
+package logger
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+	日志模块
+	Logger为可替换的日志接口，默认使用logrus实现
+	各子模块通过WithFields附加结构化字段(conn_id、msg_id、remote_addr、worker_id等)，输出时自动携带
+*/
+
+// 子模块自动附加的字段名
+const (
+	FieldConnID     = "conn_id"
+	FieldMsgID      = "msg_id"
+	FieldRemoteAddr = "remote_addr"
+	FieldWorkerID   = "worker_id"
+	FieldListener   = "listener"
+	FieldServer     = "server"
+	FieldTimer      = "timer"
+	FieldTask       = "task"
+	FieldRoom       = "room"
+	FieldError      = "error"
+)
+
+// Fields 结构化字段
+type Fields map[string]interface{}
+
+// Level 日志级别
+type Level uint32
+
+const (
+	ErrorLevel Level = iota
+	WarnLevel
+	InfoLevel
+	DebugLevel
+)
+
+var levelNames = [...]string{
+	ErrorLevel: "error",
+	WarnLevel:  "warn",
+	InfoLevel:  "info",
+	DebugLevel: "debug",
+}
+
+func (l Level) String() string {
+	if int(l) < len(levelNames) {
+		return levelNames[l]
+	}
+	return "unknown"
+}
+
+// ParseLevel 解析配置文件中的日志级别
+func ParseLevel(level string) (Level, error) {
+	switch strings.ToLower(level) {
+	case "error":
+		return ErrorLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "info":
+		return InfoLevel, nil
+	case "debug":
+		return DebugLevel, nil
+	}
+	return InfoLevel, errors.New("unknown log level: " + level)
+}
+
+// Logger 日志接口
+type Logger interface {
+	Debug(args ...interface{})
+	Debugf(format string, args ...interface{})
+	Info(args ...interface{})
+	Infof(format string, args ...interface{})
+	Warn(args ...interface{})
+	Warnf(format string, args ...interface{})
+	Error(args ...interface{})
+	Errorf(format string, args ...interface{})
+
+	// WithField 返回附加了字段的Logger，与原Logger共用输出及级别
+	WithField(key string, value interface{}) Logger
+	// WithFields 返回附加了多个字段的Logger，与原Logger共用输出及级别
+	WithFields(fields Fields) Logger
+
+	// SetLevel 设置日志级别，对所有派生的Logger生效
+	SetLevel(level Level)
+	// GetLevel 获取日志级别
+	GetLevel() Level
+}
+
+// loggerHolder atomic.Value要求每次存入相同的具体类型
+type loggerHolder struct {
+	Logger
+}
+
+var defaultLogger atomic.Value
+
+func init() {
+	defaultLogger.Store(loggerHolder{NewLogrus(logrus.StandardLogger())})
+}
+
+// Default 获取默认Logger，供没有绑定Server的模块(db、timer、utils等)使用
+func Default() Logger {
+	return defaultLogger.Load().(loggerHolder).Logger
+}
+
+// SetDefault 替换默认Logger
+func SetDefault(l Logger) {
+	defaultLogger.Store(loggerHolder{l})
+}
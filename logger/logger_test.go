@@ -0,0 +1,49 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestLogrusLogger_FieldsAndLevel(t *testing.T) {
+	var out bytes.Buffer
+	l := logrus.New()
+	l.SetOutput(&out)
+	l.SetFormatter(&logrus.JSONFormatter{})
+	root := NewLogrus(l)
+	root.SetLevel(WarnLevel)
+
+	connLog := root.WithField(FieldConnID, 1).WithFields(Fields{FieldRemoteAddr: "127.0.0.1:1000"})
+	connLog.Info("filtered")
+	if out.Len() != 0 {
+		t.Fatalf("info should be filtered at warn level: %s", out.String())
+	}
+	connLog.Warnf("slow %s", "handler")
+	var record map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["msg"] != "slow handler" || record[FieldConnID] != float64(1) || record[FieldRemoteAddr] != "127.0.0.1:1000" {
+		t.Fatalf("unexpected record %v", record)
+	}
+
+	//派生的Logger共用级别
+	connLog.SetLevel(DebugLevel)
+	if root.GetLevel() != DebugLevel {
+		t.Fatalf("root level %v, want debug", root.GetLevel())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, want := range map[string]Level{"debug": DebugLevel, "INFO": InfoLevel, "warning": WarnLevel, "error": ErrorLevel} {
+		if got, err := ParseLevel(name); err != nil || got != want {
+			t.Fatalf("ParseLevel(%s) = %v, %v", name, got, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Fatal("unknown level should fail")
+	}
+}
@@ -0,0 +1,69 @@
+package logger
+
+import "github.com/sirupsen/logrus"
+
+var _ Logger = (*logrusLogger)(nil)
+
+// logrusLogger 基于logrus的Logger实现
+type logrusLogger struct {
+	entry *logrus.Entry
+}
+
+// NewLogrus 使用logrus.Logger创建Logger，可以通过l.AddHook添加其他输出(如FluentdHook)
+func NewLogrus(l *logrus.Logger) Logger {
+	return &logrusLogger{entry: logrus.NewEntry(l)}
+}
+
+func (l *logrusLogger) Debug(args ...interface{}) { l.entry.Debug(args...) }
+
+func (l *logrusLogger) Debugf(format string, args ...interface{}) { l.entry.Debugf(format, args...) }
+
+func (l *logrusLogger) Info(args ...interface{}) { l.entry.Info(args...) }
+
+func (l *logrusLogger) Infof(format string, args ...interface{}) { l.entry.Infof(format, args...) }
+
+func (l *logrusLogger) Warn(args ...interface{}) { l.entry.Warn(args...) }
+
+func (l *logrusLogger) Warnf(format string, args ...interface{}) { l.entry.Warnf(format, args...) }
+
+func (l *logrusLogger) Error(args ...interface{}) { l.entry.Error(args...) }
+
+func (l *logrusLogger) Errorf(format string, args ...interface{}) { l.entry.Errorf(format, args...) }
+
+func (l *logrusLogger) WithField(key string, value interface{}) Logger {
+	return &logrusLogger{entry: l.entry.WithField(key, value)}
+}
+
+func (l *logrusLogger) WithFields(fields Fields) Logger {
+	return &logrusLogger{entry: l.entry.WithFields(logrus.Fields(fields))}
+}
+
+func (l *logrusLogger) SetLevel(level Level) {
+	l.entry.Logger.SetLevel(toLogrusLevel(level))
+}
+
+func (l *logrusLogger) GetLevel() Level {
+	switch l.entry.Logger.GetLevel() {
+	case logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel:
+		return ErrorLevel
+	case logrus.WarnLevel:
+		return WarnLevel
+	case logrus.InfoLevel:
+		return InfoLevel
+	default:
+		return DebugLevel
+	}
+}
+
+func toLogrusLevel(level Level) logrus.Level {
+	switch level {
+	case ErrorLevel:
+		return logrus.ErrorLevel
+	case WarnLevel:
+		return logrus.WarnLevel
+	case DebugLevel:
+		return logrus.DebugLevel
+	default:
+		return logrus.InfoLevel
+	}
+}
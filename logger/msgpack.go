@@ -0,0 +1,173 @@
+package logger
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// appendMsgpack 将v以msgpack格式追加到buf，只支持日志中常见的类型，其他类型按字符串处理
+func appendMsgpack(buf []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, 0xc0)
+	case bool:
+		if v {
+			return append(buf, 0xc3)
+		}
+		return append(buf, 0xc2)
+	case int:
+		return appendInt(buf, int64(v))
+	case int8:
+		return appendInt(buf, int64(v))
+	case int16:
+		return appendInt(buf, int64(v))
+	case int32:
+		return appendInt(buf, int64(v))
+	case int64:
+		return appendInt(buf, v)
+	case uint:
+		return appendUint(buf, uint64(v))
+	case uint8:
+		return appendUint(buf, uint64(v))
+	case uint16:
+		return appendUint(buf, uint64(v))
+	case uint32:
+		return appendUint(buf, uint64(v))
+	case uint64:
+		return appendUint(buf, v)
+	case float32:
+		return appendFloat(buf, float64(v))
+	case float64:
+		return appendFloat(buf, v)
+	case string:
+		return appendString(buf, v)
+	case []byte:
+		return appendBinary(buf, v)
+	case error:
+		return appendString(buf, v.Error())
+	case time.Time:
+		return appendString(buf, v.Format(time.RFC3339Nano))
+	case []interface{}:
+		buf = appendArrayHead(buf, len(v))
+		for _, item := range v {
+			buf = appendMsgpack(buf, item)
+		}
+		return buf
+	case map[string]interface{}:
+		return appendMap(buf, v)
+	case Fields:
+		return appendMap(buf, v)
+	default:
+		return appendString(buf, fmt.Sprint(v))
+	}
+}
+
+func appendInt(buf []byte, v int64) []byte {
+	if v >= 0 {
+		return appendUint(buf, uint64(v))
+	}
+	if v >= -32 {
+		return append(buf, byte(v))
+	}
+	buf = append(buf, 0xd3)
+	return binary.BigEndian.AppendUint64(buf, uint64(v))
+}
+
+func appendUint(buf []byte, v uint64) []byte {
+	switch {
+	case v < 128:
+		return append(buf, byte(v))
+	case v <= math.MaxUint8:
+		return append(buf, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		buf = append(buf, 0xcd)
+		return binary.BigEndian.AppendUint16(buf, uint16(v))
+	case v <= math.MaxUint32:
+		buf = append(buf, 0xce)
+		return binary.BigEndian.AppendUint32(buf, uint32(v))
+	default:
+		buf = append(buf, 0xcf)
+		return binary.BigEndian.AppendUint64(buf, v)
+	}
+}
+
+func appendFloat(buf []byte, v float64) []byte {
+	buf = append(buf, 0xcb)
+	return binary.BigEndian.AppendUint64(buf, math.Float64bits(v))
+}
+
+func appendString(buf []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		buf = append(buf, 0xda)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, 0xdb)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(n))
+	}
+	return append(buf, s...)
+}
+
+func appendBinary(buf []byte, b []byte) []byte {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		buf = append(buf, 0xc5)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, 0xc6)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(n))
+	}
+	return append(buf, b...)
+}
+
+func appendArrayHead(buf []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(buf, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		buf = append(buf, 0xdc)
+		return binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, 0xdd)
+		return binary.BigEndian.AppendUint32(buf, uint32(n))
+	}
+}
+
+func appendMapHead(buf []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(buf, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		buf = append(buf, 0xde)
+		return binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, 0xdf)
+		return binary.BigEndian.AppendUint32(buf, uint32(n))
+	}
+}
+
+// appendMap 按key排序输出，保证相同的记录编码结果相同
+func appendMap(buf []byte, m map[string]interface{}) []byte {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	buf = appendMapHead(buf, len(m))
+	for _, key := range keys {
+		buf = appendString(buf, key)
+		buf = appendMsgpack(buf, m[key])
+	}
+	return buf
+}
@@ -0,0 +1,448 @@
+package net
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+/*
+	ARQ模块，参考KCP的设计，为UDP提供可靠有序的消息通道以及不可靠的有序通道
+	每个UDP数据包包含一个或多个segment:
+		Cmd(1字节)|Chn(1字节)|Frg(2字节)|Sn(4字节)|Una(4字节)|Len(2字节)|Data
+	Chn为0表示可靠通道，其余为不可靠的有序通道，只保留比已收到的更新的消息
+	Una为发送方期望收到的下一个序列号，用于累计确认，Ack段对单个序列号进行选择确认
+*/
+
+const (
+	arqCmdPush       uint8 = iota + 1 // 可靠数据
+	arqCmdAck                         // 选择确认
+	arqCmdUnreliable                  // 不可靠的有序数据
+	arqCmdFin                         // 关闭会话
+
+	// arqHeadLen segment头部长度
+	arqHeadLen = 14
+
+	// ReliableChannel 可靠通道编号
+	ReliableChannel uint8 = 0
+
+	// arqFastResend 被跳过多少次确认之后快速重传
+	arqFastResend = 2
+	// arqDeadLink 单个segment的最大重传次数，超过后认为连接已断开
+	arqDeadLink = 20
+	// arqMinRTO 最小重传超时
+	arqMinRTO = 30 * time.Millisecond
+	// arqMaxRTO 最大重传超时
+	arqMaxRTO = 5 * time.Second
+)
+
+var (
+	ErrARQMsgTooLarge = errors.New("arq msg too large")
+	ErrARQDeadLink    = errors.New("arq dead link")
+)
+
+// arqSegment ARQ传输的最小单元
+type arqSegment struct {
+	cmd uint8
+	chn uint8
+	frg uint16
+	sn  uint32
+	una uint32
+
+	data []byte
+	//发送相关的状态
+	sentAt   time.Time
+	resendAt time.Time
+	xmit     int
+	fastAck  int
+}
+
+// encode 将segment写入buf，返回写入后的buf
+func (seg *arqSegment) encode(buf []byte) []byte {
+	var head [arqHeadLen]byte
+	head[0] = seg.cmd
+	head[1] = seg.chn
+	binary.LittleEndian.PutUint16(head[2:], seg.frg)
+	binary.LittleEndian.PutUint32(head[4:], seg.sn)
+	binary.LittleEndian.PutUint32(head[8:], seg.una)
+	binary.LittleEndian.PutUint16(head[12:], uint16(len(seg.data)))
+	buf = append(buf, head[:]...)
+	return append(buf, seg.data...)
+}
+
+// seqBefore 考虑序列号回绕，判断a是否在b之前
+func seqBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// arq ARQ状态机，与具体的传输方式无关，通过output发送数据包
+type arq struct {
+	//数据包的最大长度
+	mtu int
+	//发送窗口与接收窗口的大小(segment个数)
+	sndWnd int
+	rcvWnd int
+
+	//下一个待分配的发送序列号
+	sndNxt uint32
+	//最早的未确认序列号
+	sndUna uint32
+	//等待进入发送窗口的segment
+	sndQueue []*arqSegment
+	//已发送未确认的segment，按序列号排序
+	sndBuf []*arqSegment
+
+	//下一个期望接收的序列号
+	rcvNxt uint32
+	//乱序到达的segment
+	rcvBuf map[uint32]*arqSegment
+	//已经组装完成的消息
+	rcvQueue [][]byte
+	//待发送的确认序列号
+	ackList []uint32
+
+	//不可靠通道的发送序列号与已收到的最新序列号
+	unreliableSnd map[uint8]uint32
+	unreliableRcv map[uint8]uint32
+
+	//平滑往返时间及重传超时
+	srtt   time.Duration
+	rttVar time.Duration
+	rto    time.Duration
+
+	//连接是否已经失效
+	dead bool
+
+	output func([]byte)
+	sync.Mutex
+}
+
+func newARQ(mtu int, sndWnd int, rcvWnd int, output func([]byte)) *arq {
+	return &arq{
+		mtu:           mtu,
+		sndWnd:        sndWnd,
+		rcvWnd:        rcvWnd,
+		rcvBuf:        make(map[uint32]*arqSegment),
+		unreliableSnd: make(map[uint8]uint32),
+		unreliableRcv: make(map[uint8]uint32),
+		rto:           200 * time.Millisecond,
+		output:        output,
+	}
+}
+
+// mss 单个segment最多承载的数据长度
+func (a *arq) mss() int {
+	return a.mtu - arqHeadLen
+}
+
+// Send 将消息分片后放入发送队列，等待Flush发送
+func (a *arq) Send(data []byte) error {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.dead {
+		return ErrARQDeadLink
+	}
+	count := (len(data) + a.mss() - 1) / a.mss()
+	if count == 0 {
+		count = 1
+	}
+	if count > a.rcvWnd || count > 0xFFFF {
+		return ErrARQMsgTooLarge
+	}
+	for i := 0; i < count; i++ {
+		size := len(data)
+		if size > a.mss() {
+			size = a.mss()
+		}
+		seg := &arqSegment{
+			cmd:  arqCmdPush,
+			chn:  ReliableChannel,
+			frg:  uint16(count - i - 1),
+			data: append([]byte(nil), data[:size]...),
+		}
+		a.sndQueue = append(a.sndQueue, seg)
+		data = data[size:]
+	}
+	return nil
+}
+
+// SendUnreliable 立即发送一个不可靠的有序消息，接收方会丢弃比已收到的更旧的消息
+func (a *arq) SendUnreliable(chn uint8, data []byte) error {
+	if chn == ReliableChannel {
+		return errors.New("channel 0 is reserved for reliable msg")
+	}
+	if len(data) > a.mss() {
+		return ErrARQMsgTooLarge
+	}
+	a.Lock()
+	a.unreliableSnd[chn]++
+	seg := &arqSegment{
+		cmd:  arqCmdUnreliable,
+		chn:  chn,
+		sn:   a.unreliableSnd[chn],
+		una:  a.rcvNxt,
+		data: data,
+	}
+	a.Unlock()
+	a.output(seg.encode(make([]byte, 0, arqHeadLen+len(data))))
+	return nil
+}
+
+// Input 处理收到的数据包，返回不可靠通道上收到的消息，可靠通道的消息通过Recv获取
+func (a *arq) Input(packet []byte, now time.Time) (unreliable [][]byte, fin bool, err error) {
+	a.Lock()
+	defer a.Unlock()
+
+	for len(packet) > 0 {
+		if len(packet) < arqHeadLen {
+			return unreliable, fin, errors.New("arq packet too short")
+		}
+		seg := &arqSegment{
+			cmd: packet[0],
+			chn: packet[1],
+			frg: binary.LittleEndian.Uint16(packet[2:]),
+			sn:  binary.LittleEndian.Uint32(packet[4:]),
+			una: binary.LittleEndian.Uint32(packet[8:]),
+		}
+		length := int(binary.LittleEndian.Uint16(packet[12:]))
+		if len(packet) < arqHeadLen+length {
+			return unreliable, fin, errors.New("arq segment data too short")
+		}
+		seg.data = append([]byte(nil), packet[arqHeadLen:arqHeadLen+length]...)
+		packet = packet[arqHeadLen+length:]
+
+		a.parseUna(seg.una)
+		switch seg.cmd {
+		case arqCmdPush:
+			a.parsePush(seg)
+		case arqCmdAck:
+			a.parseAck(seg.sn, now)
+		case arqCmdUnreliable:
+			last, ok := a.unreliableRcv[seg.chn]
+			if !ok || seqBefore(last, seg.sn) {
+				a.unreliableRcv[seg.chn] = seg.sn
+				unreliable = append(unreliable, seg.data)
+			}
+		case arqCmdFin:
+			fin = true
+		default:
+			return unreliable, fin, errors.New("arq unknown cmd")
+		}
+	}
+	return unreliable, fin, nil
+}
+
+// isInitialPacket 判断数据包是否可能是新会话的第一个数据包
+// 所有segment的长度与数据包一致，第一个segment为序列号0的可靠数据或序列号1的不可靠数据，且尚未确认对端的数据
+func isInitialPacket(packet []byte) bool {
+	if len(packet) < arqHeadLen {
+		return false
+	}
+	cmd, chn := packet[0], packet[1]
+	sn, una := binary.LittleEndian.Uint32(packet[4:]), binary.LittleEndian.Uint32(packet[8:])
+	switch {
+	case una != 0:
+		return false
+	case cmd == arqCmdPush && chn == ReliableChannel && sn == 0:
+	case cmd == arqCmdUnreliable && chn != ReliableChannel && sn == 1:
+	default:
+		return false
+	}
+	for len(packet) > 0 {
+		if len(packet) < arqHeadLen {
+			return false
+		}
+		switch packet[0] {
+		case arqCmdPush, arqCmdAck, arqCmdUnreliable, arqCmdFin:
+		default:
+			return false
+		}
+		length := arqHeadLen + int(binary.LittleEndian.Uint16(packet[12:]))
+		if len(packet) < length {
+			return false
+		}
+		packet = packet[length:]
+	}
+	return true
+}
+
+// Recv 取出已经按序组装完成的可靠消息
+func (a *arq) Recv() [][]byte {
+	a.Lock()
+	defer a.Unlock()
+
+	msgs := a.rcvQueue
+	a.rcvQueue = nil
+	return msgs
+}
+
+// Flush 发送确认、新数据以及需要重传的数据
+func (a *arq) Flush(now time.Time) error {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.dead {
+		return ErrARQDeadLink
+	}
+	buf := make([]byte, 0, a.mtu)
+	write := func(seg *arqSegment) {
+		if len(buf)+arqHeadLen+len(seg.data) > a.mtu {
+			a.output(buf)
+			buf = make([]byte, 0, a.mtu)
+		}
+		seg.una = a.rcvNxt
+		buf = seg.encode(buf)
+	}
+
+	//选择确认
+	for _, sn := range a.ackList {
+		write(&arqSegment{cmd: arqCmdAck, sn: sn})
+	}
+	a.ackList = a.ackList[:0]
+
+	//将发送队列中的segment移入发送窗口
+	for len(a.sndQueue) > 0 && len(a.sndBuf) < a.sndWnd {
+		seg := a.sndQueue[0]
+		a.sndQueue = a.sndQueue[1:]
+		seg.sn = a.sndNxt
+		a.sndNxt++
+		a.sndBuf = append(a.sndBuf, seg)
+	}
+
+	for _, seg := range a.sndBuf {
+		switch {
+		case seg.xmit == 0:
+			seg.resendAt = now.Add(a.rto)
+		case !now.Before(seg.resendAt):
+			//超时重传，退避
+			seg.resendAt = now.Add(a.rto + a.rto/2*time.Duration(seg.xmit))
+		case seg.fastAck >= arqFastResend:
+			seg.resendAt = now.Add(a.rto)
+		default:
+			continue
+		}
+		seg.xmit++
+		seg.fastAck = 0
+		seg.sentAt = now
+		if seg.xmit > arqDeadLink {
+			a.dead = true
+			return ErrARQDeadLink
+		}
+		write(seg)
+	}
+	if len(buf) > 0 {
+		a.output(buf)
+	}
+	return nil
+}
+
+// Fin 通知对端关闭会话
+func (a *arq) Fin() {
+	a.Lock()
+	seg := &arqSegment{cmd: arqCmdFin, una: a.rcvNxt}
+	a.Unlock()
+	a.output(seg.encode(nil))
+}
+
+// WaitSnd 等待发送及确认的segment个数
+func (a *arq) WaitSnd() int {
+	a.Lock()
+	defer a.Unlock()
+	return len(a.sndQueue) + len(a.sndBuf)
+}
+
+// parseUna 对端已经收到una之前的全部segment
+func (a *arq) parseUna(una uint32) {
+	index := 0
+	for index < len(a.sndBuf) && seqBefore(a.sndBuf[index].sn, una) {
+		index++
+	}
+	if index > 0 {
+		a.sndBuf = a.sndBuf[index:]
+	}
+	if seqBefore(a.sndUna, una) {
+		a.sndUna = una
+	}
+}
+
+// parseAck 选择确认，删除对应的segment并更新rto，跳过的segment累计快速重传计数
+func (a *arq) parseAck(sn uint32, now time.Time) {
+	for i, seg := range a.sndBuf {
+		if seg.sn == sn {
+			//只对没有重传过的segment计算rtt
+			if seg.xmit == 1 {
+				a.updateRTT(now.Sub(seg.sentAt))
+			}
+			a.sndBuf = append(a.sndBuf[:i], a.sndBuf[i+1:]...)
+			return
+		}
+		if seqBefore(sn, seg.sn) {
+			return
+		}
+		seg.fastAck++
+	}
+}
+
+// parsePush 收到可靠数据，记录确认并按序组装消息
+func (a *arq) parsePush(seg *arqSegment) {
+	if !seqBefore(seg.sn, a.rcvNxt+uint32(a.rcvWnd)) {
+		//超出接收窗口，丢弃并等待重传
+		return
+	}
+	a.ackList = append(a.ackList, seg.sn)
+	if seqBefore(seg.sn, a.rcvNxt) {
+		return
+	}
+	a.rcvBuf[seg.sn] = seg
+
+	//从rcvNxt开始取出连续的segment，遇到frg为0时组装成一个完整的消息
+	for {
+		var count uint32
+		complete := false
+		for {
+			s, ok := a.rcvBuf[a.rcvNxt+count]
+			if !ok {
+				break
+			}
+			count++
+			if s.frg == 0 {
+				complete = true
+				break
+			}
+		}
+		if !complete {
+			return
+		}
+		var msg []byte
+		for i := uint32(0); i < count; i++ {
+			msg = append(msg, a.rcvBuf[a.rcvNxt].data...)
+			delete(a.rcvBuf, a.rcvNxt)
+			a.rcvNxt++
+		}
+		a.rcvQueue = append(a.rcvQueue, msg)
+	}
+}
+
+// updateRTT 参考RFC6298计算rto
+func (a *arq) updateRTT(rtt time.Duration) {
+	if a.srtt == 0 {
+		a.srtt = rtt
+		a.rttVar = rtt / 2
+	} else {
+		delta := rtt - a.srtt
+		if delta < 0 {
+			delta = -delta
+		}
+		a.rttVar = (3*a.rttVar + delta) / 4
+		a.srtt = (7*a.srtt + rtt) / 8
+	}
+	a.rto = a.srtt + 4*a.rttVar
+	if a.rto < arqMinRTO {
+		a.rto = arqMinRTO
+	}
+	if a.rto > arqMaxRTO {
+		a.rto = arqMaxRTO
+	}
+}
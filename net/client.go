@@ -0,0 +1,129 @@
+package net
+
+import (
+	"context"
+	"gonet/config"
+	"gonet/interfaces"
+	"gonet/logger"
+	"gonet/pack"
+	"gonet/tracing"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Client GoNet的TCP客户端，用于服务之间互相发送消息
+// 发送时ctx中的链路信息通过头部扩展传递给对端，对端的处理span成为当前span的子span
+type Client struct {
+	conn   net.Conn
+	packet interfaces.IDataPack
+	//保证并发发送时消息不交错
+	writeLock sync.Mutex
+	//收到的消息
+	msgChan chan interfaces.IMessage
+}
+
+// DialOption Dial及DialUDP的选项
+type DialOption func(*dialOptions)
+
+type dialOptions struct {
+	conf *config.GlobalObj
+}
+
+// WithDialConfig 客户端的接收缓冲、最大消息长度及UDP参数从conf中读取，不设置时使用config.Default()
+func WithDialConfig(conf *config.GlobalObj) DialOption {
+	return func(o *dialOptions) { o.conf = conf }
+}
+
+// newDialOptions 客户端不读取全局配置，需要时通过WithDialConfig传入
+func newDialOptions(opts []DialOption) *dialOptions {
+	o := &dialOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.conf == nil {
+		o.conf = config.Default()
+	}
+	return o
+}
+
+// Dial 连接一个GoNet服务器，network为tcp、tcp4、tcp6或unix
+func Dial(network, address string, opts ...DialOption) (*Client, error) {
+	o := newDialOptions(opts)
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		conn:    conn,
+		packet:  pack.FactoryInstance.NewPackWithMaxSize(interfaces.GoNetDataPack, o.conf.MaxPacketSize),
+		msgChan: make(chan interfaces.IMessage, o.conf.MaxMsgChanLen),
+	}
+	go c.read()
+	return c, nil
+}
+
+func (c *Client) read() {
+	defer close(c.msgChan)
+	for {
+		headData := make([]byte, c.packet.GetHeadLen())
+		if _, err := io.ReadFull(c.conn, headData); err != nil {
+			return
+		}
+		msg, err := c.packet.UnPack(headData)
+		if err != nil {
+			logger.Default().WithField(logger.FieldError, err).Error("client unpack failed")
+			return
+		}
+		var data []byte
+		if msg.GetMsgLen() > 0 {
+			data = make([]byte, msg.GetMsgLen())
+			if _, err := io.ReadFull(c.conn, data); err != nil {
+				return
+			}
+		}
+		msg.SetMsgData(data)
+		c.msgChan <- msg
+	}
+}
+
+// SendMsg 发送消息，ctx中有有效的span时创建客户端span并将链路信息传递给对端
+func (c *Client) SendMsg(ctx context.Context, msgId uint32, data []byte) error {
+	ctx, span := tracing.Tracer().Start(ctx, "gonet.send/"+strconv.Itoa(int(msgId)),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int64("gonet.msg_id", int64(msgId))))
+	defer span.End()
+
+	wrappedID, wrappedData := encodeTrace(ctx, msgId, data)
+	binaryMsg, err := c.packet.Pack(pack.NewMessage(wrappedID, wrappedData))
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = c.conn.SetWriteDeadline(deadline)
+		defer func() { _ = c.conn.SetWriteDeadline(time.Time{}) }()
+	}
+	if _, err := c.conn.Write(binaryMsg); err != nil {
+		span.RecordError(err)
+		return err
+	}
+	return nil
+}
+
+// Messages 返回收到的消息，连接关闭后通道关闭
+func (c *Client) Messages() <-chan interfaces.IMessage {
+	return c.msgChan
+}
+
+// Close 关闭客户端
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
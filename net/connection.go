@@ -2,15 +2,18 @@ package net
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
-	"fmt"
-	"github.com/sirupsen/logrus"
 	"gonet/config"
 	"gonet/interfaces"
+	"gonet/logger"
 	"gonet/pack"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 /*
@@ -18,25 +21,27 @@ import (
 */
 var _ interfaces.IConnection = (*Connection)(nil)
 
+var errConnClosed = errors.New("connection closed when send msg")
+
 type Connection struct {
 	//当前connection属于哪个server
 	TcpServer interfaces.IServer
 
-	//当前连接的TCP socket套接字
-	Conn *net.TCPConn
+	//当前连接的socket套接字，可以是TCP、unix socket或TLS连接
+	Conn net.Conn
 
 	//连接的ID, 也可以称作为SessionID，ID全局唯一
 	ConnID uint64
 
-	//当前的连接状态
-	isClosed bool
+	//当前的连接状态，1表示已关闭，Stop开始时设置，发送消息时不加锁读取
+	isClosed int32
 
 	//告知当前连接已经退出/停止的channel(由Reader告知Writer停止)
 	//ExitBuffChan chan bool
 	// 告知该链接已经退出/停止的channel
 	ctx    context.Context
 	cancel context.CancelFunc
-	//无缓冲管道，用于读、写Goroutine之间的消息通信
+	//发送缓冲，用于读、写Goroutine之间的消息通信，Stop时不关闭，避免与正在发送的SendMsg竞争
 	msgChan chan []byte
 	sync.RWMutex
 
@@ -49,19 +54,53 @@ type Connection struct {
 	//保护连接属性的锁
 	//因为使用了map
 	propertyLock sync.RWMutex
+
+	//是否已经启用可靠通道
+	reliable bool
+
+	//当前连接所属的监听
+	listener *listener
+
+	//epoll模式下负责该连接读事件的事件循环，为nil时使用goroutine模式
+	loop eventLoop
+	//epoll模式下的socket描述符
+	rawConn syscall.RawConn
+	fd      int
+	//epoll模式下尚未读取完整的消息
+	inBuf []byte
+	//epoll模式下同步写的锁
+	writeLock sync.Mutex
+	//epoll模式下同步写的超时时间，0表示不限制
+	writeTimeout time.Duration
+
+	//连接的定时器，连接停止时全部取消
+	timers *ConnTimers
 }
 
-// NewConnection 初始化连接的方法
-func NewConnection(server interfaces.IServer, conn *net.TCPConn, msgHandler interfaces.IMsgHandle) *Connection {
+// NewConnection 初始化连接的方法，发送缓冲的长度使用服务器的设置，不是*Server时使用默认配置
+func NewConnection(server interfaces.IServer, conn net.Conn, msgHandler interfaces.IMsgHandle) *Connection {
+	maxMsgChanLen := config.Default().MaxMsgChanLen
+	if s, ok := server.(*Server); ok {
+		maxMsgChanLen = s.MaxMsgChanLen
+	}
+	return NewConnectionWithParam(server, conn, msgHandler, maxMsgChanLen)
+}
+
+// NewConnectionWithParam 初始化连接的方法，maxMsgChanLen为发送缓冲的长度
+func NewConnectionWithParam(server interfaces.IServer, conn net.Conn, msgHandler interfaces.IMsgHandle, maxMsgChanLen uint32) *Connection {
 	c := &Connection{
 		TcpServer:    server,
 		Conn:         conn,
-		isClosed:     false,
-		msgChan:      make(chan []byte, config.GlobalServerConfig.MaxMsgChanLen),
+		msgChan:      make(chan []byte, maxMsgChanLen),
 		MsgHandler:   msgHandler,
 		property:     make(map[string]interface{}),
 		propertyLock: sync.RWMutex{},
 	}
+	//连接加入ConnMgr后可能在Start之前被Stop，ctx需要在创建时生成
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.timers = NewConnTimers(c, server.GetTimerScheduler(), func(fn func()) {
+		dispatchTask(c, c.MsgHandler, fn)
+	})
 	return c
 }
 
@@ -69,13 +108,20 @@ func NewConnection(server interfaces.IServer, conn *net.TCPConn, msgHandler inte
  * 启动连接，开始工作
  */
 func (c *Connection) Start() {
-	//赋值
-	c.ctx, c.cancel = context.WithCancel(context.Background())
-	logrus.Debug("Conn Start()...ConnID=", c.ConnID)
-	//启动从当前连接的读数据的业务
-	go c.StartReader()
-	//启动从当前连接写数据的业务
-	go c.StartWriter()
+	connLogger(c.TcpServer, c).Debug("conn start")
+	if c.loop != nil {
+		//epoll模式下由事件循环负责读，发送时同步写
+		if err := c.loop.register(c); err != nil {
+			connLogger(c.TcpServer, c).WithField(logger.FieldError, err).Error("register conn to event loop failed")
+			c.Stop()
+			return
+		}
+	} else {
+		//启动从当前连接的读数据的业务
+		go c.StartReader()
+		//启动从当前连接写数据的业务
+		go c.StartWriter()
+	}
 	//调用开发者注册的 创建连接之后 需要执行的业务Hook函数
 	c.TcpServer.CallOnConnStart(c)
 }
@@ -84,8 +130,8 @@ func (c *Connection) Start() {
 StartReader 读消息的Goroutine，专门读取来自客户端的消息
 */
 func (c *Connection) StartReader() {
-	logrus.Debug("[Reader Goroutine is running]...")
-	defer logrus.Debug("ConnID = ", c.ConnID, "[Reader is exit] ,remote addr is ", c.RemoteAddr().String())
+	connLogger(c.TcpServer, c).Debug("reader goroutine is running")
+	defer func() { connLogger(c.TcpServer, c).Debug("reader exit") }()
 	defer c.Stop()
 
 	for {
@@ -98,13 +144,13 @@ func (c *Connection) StartReader() {
 			//读取客户端的msg Head 二进制流8字节
 			headData := make([]byte, dp.GetHeadLen())
 			if _, err := io.ReadFull(c.Conn, headData); err != nil {
-				logrus.Error("client msg head err: ", err)
-				break
+				connLogger(c.TcpServer, c).WithField(logger.FieldError, err).Error("read msg head failed")
+				return
 			}
 			//拆包，得到msgID 和msgDataLen放在msg消息中
 			msg, err := dp.UnPack(headData)
 			if err != nil {
-				logrus.Error("client unpack err: ", err)
+				connLogger(c.TcpServer, c).WithField(logger.FieldError, err).Error("unpack failed")
 				return
 			}
 			if msg == nil {
@@ -115,57 +161,89 @@ func (c *Connection) StartReader() {
 			if msg.GetMsgLen() > 0 {
 				data = make([]byte, msg.GetMsgLen())
 				if _, err := io.ReadFull(c.Conn, data); err != nil {
-					logrus.Error("client read data err: ", err)
+					connLogger(c.TcpServer, c).WithField(logger.FieldError, err).Error("read msg data failed")
 					return
 				}
 			}
 			msg.SetMsgData(data)
 
-			//将当前得到的conn数据封装为Request请求
-			req := Request{
-				conn: c,
-				msg:  msg,
-			}
+			dispatchMsg(c, c.TcpServer, c.MsgHandler, msg)
+		}
 
-			//从路由中找到绑定注册的conn对应的router
-			//修改为根据绑定好的msgID找到对应的api处理业务
-			//修改为交给worker工作池处理
-			if config.GlobalServerConfig.WorkerPoolSize > 0 {
-				c.MsgHandler.SendMsgToTaskQueue(&req)
-			} else {
-				//如果工作池未启动只能自己启动一个协程进行处理
-				go c.MsgHandler.DoMsgHandle(&req)
-			}
+	}
+}
+
+// connLogger 返回附加了连接ID及远端地址的日志
+// ConnID可能在连接建立后通过SetConnID修改，因此每次使用时重新附加
+func connLogger(server interfaces.IServer, conn interfaces.IConnection) logger.Logger {
+	fields := logger.Fields{logger.FieldConnID: conn.GetConnID()}
+	if addr := conn.RemoteAddr(); addr != nil {
+		fields[logger.FieldRemoteAddr] = addr.String()
+	}
+	return server.GetLogger().WithFields(fields)
+}
+
+// dispatchMsg 处理可靠通道的确认及去重，然后将消息封装为Request交给worker工作池
+// TCP连接与UDP会话共用
+func dispatchMsg(conn interfaces.IConnection, server interfaces.IServer, handler interfaces.IMsgHandle, msg interfaces.IMessage) {
+	//可靠通道的确认及去重，在交给路由之前处理
+	switch msg.GetMsgId() {
+	case ReliableAckMsgID:
+		if err := server.GetReliableMgr().HandleAck(conn, msg.GetData()); err != nil {
+			connLogger(server, conn).WithField(logger.FieldError, err).Error("reliable ack failed")
+		}
+		return
+	case ReliableMsgID:
+		var err error
+		msg, err = server.GetReliableMgr().HandleData(conn, msg.GetData())
+		if err != nil {
+			connLogger(server, conn).WithField(logger.FieldError, err).Error("reliable msg failed")
+			return
+		}
+		if msg == nil {
+			//重复的消息直接丢弃
+			return
 		}
+	}
 
+	//将当前得到的conn数据封装为Request请求
+	req := Request{
+		conn: conn,
+		msg:  msg,
+	}
+	//携带链路信息的消息，以远端的span作为请求ctx的父span
+	if msg.GetMsgId() == TraceMsgID {
+		ctx, inner, err := decodeTrace(conn.Context(), msg.GetData())
+		if err != nil {
+			connLogger(server, conn).WithField(logger.FieldError, err).Error("trace msg failed")
+			return
+		}
+		req.msg = inner
+		req.ctx = ctx
 	}
+	recordMsg(server, conn, interfaces.RecordInbound, req.msg)
+
+	//从路由中找到绑定注册的conn对应的router
+	//修改为根据绑定好的msgID找到对应的api处理业务
+	//修改为交给worker工作池处理，工作池未启动时由MsgHandle单独启动协程处理
+	handler.SendMsgToTaskQueue(&req)
 }
 
 /*
 StartWriter 写消息Goroutine，专门发送消息给客户端的模块
 */
 func (c *Connection) StartWriter() {
-	fmt.Println("[Writer Goroutine is running]...")
-	defer fmt.Println("ConnID = ", c.ConnID, "[Conn Writer exit] ,remote addr is ", c.RemoteAddr().String())
+	connLogger(c.TcpServer, c).Debug("writer goroutine is running")
+	defer func() { connLogger(c.TcpServer, c).Debug("writer exit") }()
 	//不断循环等待channel的消息
 	for {
 		select {
 		case data := <-c.msgChan:
 			//有数据写给客户端
 			if _, err := c.Conn.Write(data); err != nil {
-				fmt.Println("Send data error", err)
+				connLogger(c.TcpServer, c).WithField(logger.FieldError, err).Error("send data failed")
 				return
 			}
-		case data, ok := <-c.msgChan:
-			if ok {
-				if _, err := c.Conn.Write(data); err != nil {
-					logrus.Errorf("Produce Buff Data error: %v, Conn Writer exit", err)
-					return
-				}
-			} else {
-				logrus.Debug("msgChan is Closed")
-			}
-			//优雅的关闭chan
 		case <-c.ctx.Done():
 			//代表Reader已经退出，此时Writer也要退出
 			return
@@ -173,48 +251,143 @@ func (c *Connection) StartWriter() {
 	}
 }
 
-// SendMsg 将数据发送给channel
+// SendMsg 将数据发送给channel，发送缓冲已满时阻塞直到连接停止
 func (c *Connection) SendMsg(msgId uint32, data []byte) error {
-	if c.isClosed == true {
-		return errors.New("connection closed when send msg")
+	if atomic.LoadInt32(&c.isClosed) == 1 {
+		return errConnClosed
 	}
 	dp := c.TcpServer.Packet()
+	msg := pack.NewMessage(msgId, data)
 	// MsgDataLen|MsgID|MsgData 二进制数据流
-	binaryMsg, err := dp.Pack(pack.NewMessage(msgId, data))
+	binaryMsg, err := dp.Pack(msg)
 	if err != nil {
-		fmt.Println("Pack msg err: ", err)
+		connLogger(c.TcpServer, c).WithFields(logger.Fields{logger.FieldMsgID: msgId, logger.FieldError: err}).Error("pack msg failed")
 		return errors.New("pack msg error")
 	}
-	c.msgChan <- binaryMsg
+	recordMsg(c.TcpServer, c, interfaces.RecordOutbound, msg)
+	if c.loop != nil {
+		//epoll模式下没有写协程，直接在调用方协程中写
+		return c.writeSync(binaryMsg)
+	}
+	select {
+	case c.msgChan <- binaryMsg:
+		return nil
+	case <-c.ctx.Done():
+		return errConnClosed
+	}
+}
+
+// EnableReliable 为当前连接启用可靠通道，需要在ConnID确定之后调用
+// 重连时如果存在相同ConnID的会话，会重传上次未确认的消息
+func (c *Connection) EnableReliable() error {
+	if err := c.TcpServer.GetReliableMgr().Attach(c); err != nil {
+		return err
+	}
+	c.Lock()
+	c.reliable = true
+	c.Unlock()
 	return nil
 }
 
+// SendReliableMsg 发送可靠消息，消息会被分配序列号并保留至客户端确认
+func (c *Connection) SendReliableMsg(msgId uint32, data []byte) error {
+	recordMsg(c.TcpServer, c, interfaces.RecordOutbound, pack.NewMessage(msgId, data))
+	return c.TcpServer.GetReliableMgr().Send(c, msgId, data)
+}
+
+// Stop 停止连接，可以重复调用
+// 首先标记为已关闭，之后的SendMsg直接返回错误，阻塞在发送缓冲上的SendMsg在ctx取消时返回
 func (c *Connection) Stop() {
-	c.Lock()
-	defer c.Unlock()
-	if c.isClosed == true {
+	if !atomic.CompareAndSwapInt32(&c.isClosed, 0, 1) {
 		return
 	}
-	logrus.Debug("Conn stop()...ConnID=", c.ConnID)
+	c.Lock()
+	defer c.Unlock()
+	connLogger(c.TcpServer, c).Debug("conn stop")
 	//调用开发者注册的 销毁连接之前 需要执行的业务Hook函数
 	c.TcpServer.CallOnConnStop(c)
+	//epoll模式下需要在关闭socket之前停止监听读事件
+	if c.loop != nil {
+		c.loop.unregister(c)
+	}
 	//关闭socket连接
 	_ = c.Conn.Close()
 
 	//告知Writer关闭
 	c.cancel()
+	c.timers.Stop()
+
+	//保留可靠会话，等待重连
+	if c.reliable {
+		c.TcpServer.GetReliableMgr().Detach(c)
+	}
 
 	//将当前conn从ConnMgr中删除
 	c.TcpServer.GetConnMgr().DeleteConn(c)
-	//回收资源
-	close(c.msgChan)
-	c.isClosed = true
+	if c.listener != nil {
+		atomic.AddInt32(c.listener.connCount, -1)
+	}
+}
+
+// AfterFunc 在d之后于该连接的worker中调用fn，连接停止时自动取消
+func (c *Connection) AfterFunc(d time.Duration, fn func(conn interfaces.IConnection)) interfaces.IConnTimer {
+	return c.timers.AfterFunc(d, fn)
+}
 
+// Every 每隔d于该连接的worker中调用一次fn，连接停止时自动停止
+func (c *Connection) Every(d time.Duration, fn func(conn interfaces.IConnection)) interfaces.IConnTimer {
+	return c.timers.Every(d, fn)
 }
+
+// Context 获取当前连接的ctx，连接停止时被取消
+func (c *Connection) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// GetTCPConnection 获取当前连接的TCP套接字，unix socket连接返回nil
 func (c *Connection) GetTCPConnection() *net.TCPConn {
+	conn := c.Conn
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcpConn, _ := conn.(*net.TCPConn)
+	return tcpConn
+}
+
+// GetConnection 获取当前连接绑定的socket套接字
+func (c *Connection) GetConnection() net.Conn {
 	return c.Conn
 }
 
+// GetListenerName 获取当前连接所属的监听名称
+func (c *Connection) GetListenerName() string {
+	if c.listener == nil {
+		return ""
+	}
+	return c.listener.Name
+}
+
+// GetTags 获取当前连接所属监听的标签
+func (c *Connection) GetTags() []string {
+	if c.listener == nil {
+		return nil
+	}
+	return c.listener.Tags
+}
+
+// HasTag 判断当前连接是否携带某个标签
+func (c *Connection) HasTag(tag string) bool {
+	for _, t := range c.GetTags() {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
 // GetConnID 	获取连接ID
 func (c *Connection) GetConnID() uint64 {
 	return c.ConnID
@@ -229,7 +402,7 @@ func (c *Connection) SetConnID(val uint64) {
 	}
 	sameConn, _ := c.TcpServer.GetConnMgr().GetConn(val)
 	if sameConn != nil {
-		logrus.Warnf("remove duplicated name conn[%v]", val)
+		connLogger(c.TcpServer, sameConn).Warn("remove duplicated conn")
 		sameConn.Stop()
 	}
 	c.ConnID = val
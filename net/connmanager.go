@@ -2,8 +2,8 @@ package net
 
 import (
 	"errors"
-	"fmt"
 	"gonet/interfaces"
+	"gonet/logger"
 	"sync"
 )
 
@@ -15,11 +15,14 @@ type ConnManager struct {
 	connections map[uint64]interfaces.IConnection
 	//保护连接集合的读写锁
 	connLock sync.RWMutex
+	//日志
+	Logger logger.Logger
 }
 
 func NewConnManager() *ConnManager {
 	return &ConnManager{
 		connections: make(map[uint64]interfaces.IConnection),
+		Logger:      logger.Default(),
 	}
 }
 
@@ -31,7 +34,7 @@ func (cm *ConnManager) AddConn(conn interfaces.IConnection) {
 
 	//将conn加入connManager中
 	cm.connections[conn.GetConnID()] = conn
-	fmt.Println("connection add to connManager successfully: conn num= ", cm.GetConnLen())
+	cm.Logger.WithField(logger.FieldConnID, conn.GetConnID()).Debugf("connection added, conn num = %d", len(cm.connections))
 }
 
 // DeleteConn  删除连接
@@ -41,7 +44,7 @@ func (cm *ConnManager) DeleteConn(conn interfaces.IConnection) {
 	defer cm.connLock.Unlock()
 
 	delete(cm.connections, conn.GetConnID())
-	fmt.Println("ConnID = ", conn.GetConnID(), " ,delete from connManager successfully: conn num= ", cm.GetConnLen())
+	cm.Logger.WithField(logger.FieldConnID, conn.GetConnID()).Debugf("connection deleted, conn num = %d", len(cm.connections))
 }
 
 // GetConn  根据ConnID返回连接
@@ -58,20 +61,27 @@ func (cm *ConnManager) GetConn(connID uint64) (conn interfaces.IConnection, err
 
 // GetConnLen 得到当前连接数
 func (cm *ConnManager) GetConnLen() int {
+	//多个监听会并发读取连接数,加读锁
+	cm.connLock.RLock()
+	defer cm.connLock.RUnlock()
 	return len(cm.connections)
 }
 
 // ClearConn  清除所有连接
 func (cm *ConnManager) ClearConn() {
 	//保护共享资源,加写锁
+	//conn.Stop()中会调用DeleteConn，所以需要在锁外停止连接
 	cm.connLock.Lock()
-	defer cm.connLock.Unlock()
-
-	//删除conn并停止conn的工作
+	conns := make([]interfaces.IConnection, 0, len(cm.connections))
 	for connID, conn := range cm.connections {
-		//停止
-		conn.Stop()
+		conns = append(conns, conn)
 		//删除
 		delete(cm.connections, connID)
 	}
+	cm.connLock.Unlock()
+
+	//停止conn的工作
+	for _, conn := range conns {
+		conn.Stop()
+	}
 }
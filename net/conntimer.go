@@ -0,0 +1,117 @@
+package net
+
+import (
+	"gonet/interfaces"
+	"gonet/pack"
+	"gonet/timer"
+	"sync"
+	"time"
+)
+
+/*
+	连接的定时器
+	定时器由服务器共用的TimerScheduler调度，触发时将回调交给连接的worker，与连接的消息按顺序执行
+	连接停止时取消全部定时器，已经交给worker但尚未执行的回调也不再执行
+	没有启用工作池时每个消息及回调都在单独的协程中执行，不保证顺序
+*/
+
+// ConnTimers 一个连接的全部定时器
+type ConnTimers struct {
+	conn interfaces.IConnection
+	ts   *timer.TimerScheduler
+	//将回调交给连接的worker执行
+	dispatch func(fn func())
+
+	lock    sync.Mutex
+	stopped bool
+	timers  map[*timer.Timer]struct{}
+	jobs    map[*timer.Job]struct{}
+}
+
+// NewConnTimers 创建conn的定时器，由ts调度，触发时通过dispatch执行回调
+func NewConnTimers(conn interfaces.IConnection, ts *timer.TimerScheduler, dispatch func(fn func())) *ConnTimers {
+	return &ConnTimers{
+		conn:     conn,
+		ts:       ts,
+		dispatch: dispatch,
+		timers:   make(map[*timer.Timer]struct{}),
+		jobs:     make(map[*timer.Job]struct{}),
+	}
+}
+
+// AfterFunc 在d之后调用fn，连接已经停止时返回的定时器不会触发
+func (ct *ConnTimers) AfterFunc(d time.Duration, fn func(conn interfaces.IConnection)) interfaces.IConnTimer {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	var t *timer.Timer
+	t = ct.ts.AfterFunc(timer.NewDelayFunc(func(...interface{}) {
+		ct.lock.Lock()
+		delete(ct.timers, t)
+		ct.lock.Unlock()
+		ct.dispatch(ct.wrap(fn))
+	}, nil), d)
+	if ct.stopped {
+		t.Stop()
+		return t
+	}
+	ct.timers[t] = struct{}{}
+	return t
+}
+
+// Every 每隔d调用一次fn，连接已经停止时返回的任务不会触发
+func (ct *ConnTimers) Every(d time.Duration, fn func(conn interfaces.IConnection)) interfaces.IConnTimer {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	job := ct.ts.Every(timer.NewDelayFunc(func(...interface{}) {
+		ct.dispatch(ct.wrap(fn))
+	}, nil), d)
+	if ct.stopped {
+		job.Stop()
+		return job
+	}
+	ct.jobs[job] = struct{}{}
+	return job
+}
+
+// wrap 执行时连接已经停止则跳过回调
+func (ct *ConnTimers) wrap(fn func(conn interfaces.IConnection)) func() {
+	return func() {
+		if ct.conn.Context().Err() != nil {
+			return
+		}
+		fn(ct.conn)
+	}
+}
+
+// Stop 取消全部定时器，之后创建的定时器不会触发
+func (ct *ConnTimers) Stop() {
+	ct.lock.Lock()
+	ct.stopped = true
+	timers, jobs := ct.timers, ct.jobs
+	ct.timers, ct.jobs = nil, nil
+	ct.lock.Unlock()
+	for t := range timers {
+		t.Stop()
+	}
+	for job := range jobs {
+		job.Stop()
+	}
+}
+
+// taskRequest 连接的定时器触发时交给worker的请求，与连接的消息使用同一个worker
+type taskRequest struct {
+	Request
+	fn func()
+}
+
+// dispatchTask 将fn交给连接所在的worker执行，消息处理模块不是MsgHandle时直接执行
+func dispatchTask(conn interfaces.IConnection, handler interfaces.IMsgHandle, fn func()) {
+	if _, ok := handler.(*MsgHandle); !ok {
+		fn()
+		return
+	}
+	handler.SendMsgToTaskQueue(&taskRequest{
+		Request: Request{conn: conn, msg: pack.NewMessage(0, nil)},
+		fn:      fn,
+	})
+}
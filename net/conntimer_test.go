@@ -0,0 +1,162 @@
+package net_test
+
+import (
+	"testing"
+	"time"
+
+	"gonet/gonettest"
+	"gonet/interfaces"
+	gnet "gonet/net"
+)
+
+// funcRouter 使用一个方法处理消息的路由
+type funcRouter struct {
+	gnet.BaseRouter
+	handle func(request interfaces.IRequest)
+}
+
+func (r *funcRouter) Handle(request interfaces.IRequest) {
+	r.handle(request)
+}
+
+func TestConnection_AfterFuncOrdering(t *testing.T) {
+	t.Parallel()
+	//只有一个worker，定时器的回调与消息在同一个worker中按顺序执行
+	s := gonettest.NewServer(gnet.WithWorkerPool(1, 16))
+	//只在worker中读写，-race可以发现并发执行
+	handled := 0
+	release := make(chan struct{})
+	s.AddRouter(1, &funcRouter{handle: func(request interfaces.IRequest) {
+		handled++
+		request.GetConn().AfterFunc(20*time.Millisecond, func(conn interfaces.IConnection) {
+			handled++
+			_ = conn.SendMsg(10, nil)
+		})
+	}})
+	s.AddRouter(2, &funcRouter{handle: func(request interfaces.IRequest) {
+		<-release
+		handled++
+		_ = request.GetConn().SendMsg(3, nil)
+	}})
+	s.AddRouter(4, &funcRouter{handle: func(request interfaces.IRequest) {
+		handled++
+		_ = request.GetConn().SendMsg(5, nil)
+	}})
+	s.Start()
+	defer s.Stop()
+
+	client, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	_ = client.SendMsg(1, nil)
+	//消息2阻塞worker期间定时器触发，回调排在消息2之后、消息4之前
+	_ = client.SendMsg(2, nil)
+	time.Sleep(200 * time.Millisecond)
+	_ = client.SendMsg(4, nil)
+	close(release)
+	for _, id := range []uint32{3, 10, 5} {
+		if _, err := client.ExpectMessage(id, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConnection_TimersStop(t *testing.T) {
+	t.Parallel()
+	s := gonettest.NewServer()
+	fired := make(chan string, 64)
+	s.AddRouter(1, &funcRouter{handle: func(request interfaces.IRequest) {
+		conn := request.GetConn()
+		conn.AfterFunc(300*time.Millisecond, func(interfaces.IConnection) {
+			fired <- "after"
+		})
+		conn.Every(20*time.Millisecond, func(interfaces.IConnection) {
+			fired <- "every"
+		})
+		_ = conn.SendMsg(2, nil)
+	}})
+	stopped := make(chan interfaces.IConnection, 1)
+	s.SetOnConnStop(func(conn interfaces.IConnection) {
+		stopped <- conn
+	})
+	s.Start()
+	defer s.Stop()
+
+	client, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = client.SendMsg(1, nil)
+	if _, err := client.ExpectMessage(2, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case name := <-fired:
+		if name != "every" {
+			t.Fatalf("want every, got %s", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Every should fire")
+	}
+
+	//连接停止后全部定时器取消，之后创建的定时器也不会触发
+	_ = client.Close()
+	var conn interfaces.IConnection
+	select {
+	case conn = <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("OnConnStop should be called")
+	}
+	time.Sleep(50 * time.Millisecond)
+	for len(fired) > 0 {
+		<-fired
+	}
+	if conn.AfterFunc(10*time.Millisecond, func(interfaces.IConnection) { fired <- "late" }).Stop() {
+		t.Fatal("timer created after stop should already be stopped")
+	}
+	select {
+	case name := <-fired:
+		t.Fatalf("%s fired after connection stop", name)
+	case <-time.After(400 * time.Millisecond):
+	}
+}
+
+func TestConnection_TimerSendDuringStop(t *testing.T) {
+	t.Parallel()
+	s := gonettest.NewServer(gnet.WithMaxMsgChanLen(1))
+	done := make(chan error, 1)
+	s.AddRouter(1, &funcRouter{handle: func(request interfaces.IRequest) {
+		//定时器的回调在连接停止期间持续发送，停止之后SendMsg返回错误而不是panic
+		request.GetConn().AfterFunc(time.Millisecond, func(conn interfaces.IConnection) {
+			for {
+				if err := conn.SendMsg(2, make([]byte, 512)); err != nil {
+					done <- err
+					return
+				}
+			}
+		})
+	}})
+	conns := make(chan interfaces.IConnection, 1)
+	s.SetOnConnStart(func(conn interfaces.IConnection) { conns <- conn })
+	s.Start()
+	defer s.Stop()
+
+	client, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn := <-conns
+	_ = client.SendMsg(1, nil)
+	if _, err := client.ExpectMessage(2, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	conn.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("SendMsg should fail after Stop")
+	}
+}
@@ -0,0 +1,121 @@
+package net
+
+import (
+	"crypto/tls"
+	"errors"
+	"gonet/interfaces"
+	"gonet/logger"
+	"net"
+	"syscall"
+	"time"
+)
+
+/*
+	事件循环模块
+	goroutine模式下每个连接占用一个读协程和一个写协程，大量空闲连接时协程栈占用了大部分内存
+	epoll模式下由少量poller协程统一等待所有连接的读事件，读到完整的消息后交给同一个MsgHandle处理，
+	发送消息时直接在调用方协程中同步写，连接本身不再占用任何协程，
+	同步写设置了超时时间，对端长时间不读取时关闭连接，避免阻塞调用方(worker、定时器等)
+*/
+
+const (
+	// ConnModeGoroutine 每个连接一个读协程和一个写协程
+	ConnModeGoroutine = "goroutine"
+	// ConnModeEpoll 由少量poller协程处理全部连接的读事件，仅linux可用
+	ConnModeEpoll = "epoll"
+)
+
+var errEventLoopClosed = errors.New("event loop closed")
+
+// eventLoop 事件循环，负责一组连接的读事件
+type eventLoop interface {
+	// register 开始监听连接的读事件
+	register(c *Connection) error
+	// unregister 停止监听连接的读事件，需要在关闭socket之前调用
+	unregister(c *Connection)
+}
+
+// reactor 一组事件循环，新的连接轮流分配给其中一个
+type reactor interface {
+	next() eventLoop
+	close()
+}
+
+// canUseEventLoop 判断连接能否交给事件循环处理
+// TLS连接需要在用户态解密，仍使用goroutine模式
+func canUseEventLoop(conn net.Conn) bool {
+	if _, ok := conn.(*tls.Conn); ok {
+		return false
+	}
+	_, ok := conn.(syscall.Conn)
+	return ok
+}
+
+// parseFrames 从data中拆出全部完整的消息并依次交给handle，返回剩余的不完整部分
+// 交给handle的消息数据是拷贝出来的，返回的剩余部分与data共用底层数组
+func parseFrames(dp interfaces.IDataPack, data []byte, handle func(interfaces.IMessage)) ([]byte, error) {
+	headLen := int(dp.GetHeadLen())
+	for len(data) >= headLen {
+		msg, err := dp.UnPack(data[:headLen])
+		if err != nil {
+			return nil, err
+		}
+		total := headLen + int(msg.GetMsgLen())
+		if len(data) < total {
+			break
+		}
+		var body []byte
+		if msg.GetMsgLen() > 0 {
+			body = make([]byte, msg.GetMsgLen())
+			copy(body, data[headLen:total])
+		}
+		msg.SetMsgData(body)
+		handle(msg)
+		data = data[total:]
+	}
+	return data, nil
+}
+
+// useEventLoop 将连接交给事件循环处理，不再创建读写协程及发送缓冲管道
+func (c *Connection) useEventLoop(loop eventLoop, writeTimeout time.Duration) {
+	c.loop = loop
+	c.writeTimeout = writeTimeout
+	c.msgChan = nil
+}
+
+// writeSync epoll模式下在调用方协程中同步写，写入失败或超时时消息可能只写了一部分，连接随即被关闭
+func (c *Connection) writeSync(data []byte) error {
+	c.writeLock.Lock()
+	if c.writeTimeout > 0 {
+		_ = c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	_, err := c.Conn.Write(data)
+	c.writeLock.Unlock()
+	if err != nil {
+		connLogger(c.TcpServer, c).WithField(logger.FieldError, err).Error("send data failed")
+		c.Stop()
+	}
+	return err
+}
+
+// onData 处理事件循环读到的数据，buf为poller共用的读缓冲，不能被保留
+func (c *Connection) onData(buf []byte) {
+	data := buf
+	if len(c.inBuf) > 0 {
+		data = append(c.inBuf, buf...)
+	}
+	rest, err := parseFrames(c.TcpServer.Packet(), data, func(msg interfaces.IMessage) {
+		dispatchMsg(c, c.TcpServer, c.MsgHandler, msg)
+	})
+	if err != nil {
+		connLogger(c.TcpServer, c).WithField(logger.FieldError, err).Error("unpack failed")
+		c.Stop()
+		return
+	}
+	//只为不完整的消息保留缓冲，空闲连接不占用读缓冲
+	if len(rest) == 0 {
+		c.inBuf = nil
+	} else {
+		c.inBuf = append([]byte(nil), rest...)
+	}
+}
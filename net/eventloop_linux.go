@@ -0,0 +1,197 @@
+//go:build linux
+
+package net
+
+import (
+	"encoding/binary"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"gonet/logger"
+)
+
+// epollReadBufferSize 每个poller共用的读缓冲大小
+const epollReadBufferSize = 64 * 1024
+
+// epollReactor 基于epoll的reactor，每个poller用一个go来承载
+type epollReactor struct {
+	pollers []*epoller
+	index   uint32
+}
+
+// newReactor 创建pollers个epoll事件循环，pollers小于等于0时使用CPU核数
+func newReactor(pollers int) (reactor, error) {
+	if pollers <= 0 {
+		pollers = runtime.NumCPU()
+	}
+	r := &epollReactor{}
+	for i := 0; i < pollers; i++ {
+		p, err := newEpoller()
+		if err != nil {
+			r.close()
+			return nil, err
+		}
+		r.pollers = append(r.pollers, p)
+		go p.run()
+	}
+	return r, nil
+}
+
+func (r *epollReactor) next() eventLoop {
+	i := atomic.AddUint32(&r.index, 1)
+	return r.pollers[int(i)%len(r.pollers)]
+}
+
+func (r *epollReactor) close() {
+	for _, p := range r.pollers {
+		p.close()
+	}
+}
+
+// epoller 一个epoll事件循环
+type epoller struct {
+	epfd int
+	//用于唤醒epoll_wait并退出事件循环
+	wakeFd int
+	//socket描述符对应的连接
+	conns  map[int]*Connection
+	lock   sync.Mutex
+	closed bool
+	buf    []byte
+	done   chan struct{}
+}
+
+func newEpoller() (*epoller, error) {
+	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	wakeFd, err := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	if err != nil {
+		_ = unix.Close(epfd)
+		return nil, err
+	}
+	err = unix.EpollCtl(epfd, unix.EPOLL_CTL_ADD, wakeFd, &unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(wakeFd)})
+	if err != nil {
+		_ = unix.Close(wakeFd)
+		_ = unix.Close(epfd)
+		return nil, err
+	}
+	return &epoller{
+		epfd:   epfd,
+		wakeFd: wakeFd,
+		conns:  make(map[int]*Connection),
+		buf:    make([]byte, epollReadBufferSize),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// register 使用水平触发监听连接的读事件
+func (p *epoller) register(c *Connection) error {
+	rawConn, err := c.Conn.(syscall.Conn).SyscallConn()
+	if err != nil {
+		return err
+	}
+	var opErr error
+	err = rawConn.Control(func(fd uintptr) {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		if p.closed {
+			opErr = errEventLoopClosed
+			return
+		}
+		event := &unix.EpollEvent{Events: unix.EPOLLIN | unix.EPOLLRDHUP, Fd: int32(fd)}
+		if opErr = unix.EpollCtl(p.epfd, unix.EPOLL_CTL_ADD, int(fd), event); opErr == nil {
+			c.rawConn = rawConn
+			c.fd = int(fd)
+			p.conns[c.fd] = c
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
+
+func (p *epoller) unregister(c *Connection) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.conns[c.fd] != c {
+		return
+	}
+	delete(p.conns, c.fd)
+	if !p.closed {
+		_ = unix.EpollCtl(p.epfd, unix.EPOLL_CTL_DEL, c.fd, nil)
+	}
+}
+
+// run 阻塞等待读事件，每次可读时读取一次，剩余的数据由水平触发再次通知
+func (p *epoller) run() {
+	defer close(p.done)
+	events := make([]unix.EpollEvent, 128)
+	for {
+		n, err := unix.EpollWait(p.epfd, events, -1)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			logger.Default().WithField(logger.FieldError, err).Error("epoll wait failed")
+			return
+		}
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			if fd == p.wakeFd {
+				_ = unix.Close(p.wakeFd)
+				_ = unix.Close(p.epfd)
+				return
+			}
+			p.lock.Lock()
+			c := p.conns[fd]
+			p.lock.Unlock()
+			if c != nil {
+				p.read(c)
+			}
+		}
+	}
+}
+
+// read 从连接读取一次数据
+func (p *epoller) read(c *Connection) {
+	var n int
+	var readErr error
+	//Control期间持有socket的引用，即使连接在其他协程被关闭，描述符也不会被复用
+	err := c.rawConn.Control(func(fd uintptr) {
+		n, readErr = unix.Read(int(fd), p.buf)
+	})
+	if err != nil {
+		//连接已经关闭
+		return
+	}
+	if readErr == unix.EAGAIN || readErr == unix.EINTR {
+		return
+	}
+	if readErr != nil || n == 0 {
+		connLogger(c.TcpServer, c).WithField(logger.FieldError, readErr).Debug("read closed")
+		c.Stop()
+		return
+	}
+	c.onData(p.buf[:n])
+}
+
+// close 退出事件循环，需要在连接全部停止之后调用
+func (p *epoller) close() {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return
+	}
+	p.closed = true
+	p.lock.Unlock()
+	var one [8]byte
+	binary.LittleEndian.PutUint64(one[:], 1)
+	_, _ = unix.Write(p.wakeFd, one[:])
+	<-p.done
+}
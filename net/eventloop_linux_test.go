@@ -0,0 +1,146 @@
+//go:build linux
+
+package net
+
+import (
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"gonet/interfaces"
+)
+
+func TestServer_EpollMode(t *testing.T) {
+	s := NewServerWithParam("epoll", "tcp4", "127.0.0.1", 0, 100).(*Server)
+	s.ConnMode = ConnModeEpoll
+	s.EpollPollers = 2
+	s.AddRouter(1, &echoRouter{})
+	stopped := make(chan uint64, 1)
+	s.SetOnConnStop(func(conn interfaces.IConnection) {
+		stopped <- conn.GetConnID()
+	})
+	s.Start()
+	defer s.Stop()
+	if s.reactor == nil {
+		t.Fatal("epoll reactor should be started")
+	}
+	addr := s.ListenerAddrs()[DefaultListenerName].String()
+
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := echoOnce(t, conn, 1, []byte("epoll")); string(msg.GetData()) != "epoll" {
+		t.Fatalf("unexpected echo %q", msg.GetData())
+	}
+
+	//消息被拆成多次发送
+	frame := mustPack(t, 1, []byte("split frame"))
+	for _, part := range [][]byte{frame[:3], frame[3:10], frame[10:]} {
+		if _, err := conn.Write(part); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if msg := echoOnce(t, conn, 1, []byte("next")); string(msg.GetData()) != "split frame" {
+		t.Fatalf("unexpected echo %q", msg.GetData())
+	}
+	if msg := echoOnce(t, conn, 1, nil); string(msg.GetData()) != "next" {
+		t.Fatalf("unexpected echo %q", msg.GetData())
+	}
+
+	//客户端关闭后连接被停止
+	_ = conn.Close()
+	select {
+	case <-stopped:
+	case <-time.After(3 * time.Second):
+		t.Fatal("conn should be stopped after client close")
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for s.ConnMgr.GetConnLen() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d conns left", s.ConnMgr.GetConnLen())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServer_EpollWriteTimeout(t *testing.T) {
+	s := NewServerWithParam("epoll-write", "tcp4", "127.0.0.1", 0, 100).(*Server)
+	s.ConnMode = ConnModeEpoll
+	s.EpollWriteTimeout = 100 * time.Millisecond
+	conns := make(chan interfaces.IConnection, 1)
+	s.SetOnConnStart(func(conn interfaces.IConnection) { conns <- conn })
+	s.Start()
+	defer s.Stop()
+
+	//客户端不读取，发送缓冲写满后同步写超时，连接被关闭
+	client, err := net.Dial("tcp4", s.ListenerAddrs()[DefaultListenerName].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn := <-conns
+	data := make([]byte, 1024)
+	done := make(chan error, 1)
+	go func() {
+		for {
+			if err := conn.SendMsg(1, data); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("send to a stalled client should time out")
+	}
+	if conn.Context().Err() == nil {
+		t.Fatal("conn should be stopped after write timeout")
+	}
+}
+
+// benchmarkConnMemory 建立一批空闲连接，统计每个连接占用的堆及协程栈内存
+func benchmarkConnMemory(b *testing.B, mode string) {
+	const conns = 1000
+	for i := 0; i < b.N; i++ {
+		s := NewServerWithParam("bench", "tcp4", "127.0.0.1", 0, conns+1).(*Server)
+		s.ConnMode = mode
+		s.Start()
+		addr := s.ListenerAddrs()[DefaultListenerName].String()
+
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		clients := make([]net.Conn, 0, conns)
+		for j := 0; j < conns; j++ {
+			conn, err := net.Dial("tcp4", addr)
+			if err != nil {
+				b.Fatal(err)
+			}
+			clients = append(clients, conn)
+		}
+		for s.ConnMgr.GetConnLen() < conns {
+			time.Sleep(time.Millisecond)
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		used := float64(after.HeapInuse+after.StackInuse) - float64(before.HeapInuse+before.StackInuse)
+		b.ReportMetric(used/conns, "B/conn")
+
+		for _, conn := range clients {
+			_ = conn.Close()
+		}
+		s.Stop()
+	}
+}
+
+func BenchmarkConnMemory_Goroutine(b *testing.B) {
+	benchmarkConnMemory(b, ConnModeGoroutine)
+}
+
+func BenchmarkConnMemory_Epoll(b *testing.B) {
+	benchmarkConnMemory(b, ConnModeEpoll)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package net
+
+import "errors"
+
+// newReactor epoll模式仅linux可用，其他平台使用goroutine模式
+func newReactor(pollers int) (reactor, error) {
+	return nil, errors.New("epoll conn mode is only supported on linux")
+}
@@ -0,0 +1,41 @@
+package net
+
+import (
+	"bytes"
+	"testing"
+
+	"gonet/interfaces"
+	"gonet/pack"
+)
+
+func TestParseFrames(t *testing.T) {
+	var stream []byte
+	stream = append(stream, mustPack(t, 1, []byte("hello"))...)
+	stream = append(stream, mustPack(t, 2, nil)...)
+	stream = append(stream, mustPack(t, 3, []byte("world"))...)
+
+	//逐字节切分，每次只能拆出已经完整的消息
+	var got []interfaces.IMessage
+	var pending []byte
+	for _, b := range stream {
+		pending = append(pending, b)
+		rest, err := parseFrames(pack.NewDataPack(), pending, func(msg interfaces.IMessage) {
+			got = append(got, msg)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending = append([]byte(nil), rest...)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("%d bytes left", len(pending))
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d msgs, want 3", len(got))
+	}
+	for i, want := range []string{"hello", "", "world"} {
+		if got[i].GetMsgId() != uint32(i+1) || !bytes.Equal(got[i].GetData(), []byte(want)) {
+			t.Fatalf("msg %d = %d %q", i, got[i].GetMsgId(), got[i].GetData())
+		}
+	}
+}
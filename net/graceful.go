@@ -0,0 +1,320 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gonet/logger"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/*
+	热升级模块
+	旧进程通过ExtraFiles将监听套接字及UDP套接字传递给新启动的子进程，子进程在Start时直接使用继承的套接字，
+	监听准备完成后通过管道通知旧进程，旧进程随后停止accept、等待已有连接处理完毕后退出，
+	整个过程中端口始终处于监听状态
+*/
+
+const (
+	// EnvInheritedListeners 继承的监听名称列表，按顺序对应从3开始的文件描述符
+	EnvInheritedListeners = "GONET_INHERITED_LISTENERS"
+	// EnvInheritedUDP 继承的UDP套接字的文件描述符
+	EnvInheritedUDP = "GONET_INHERITED_UDP"
+	// EnvReadyFD 子进程准备完成后通知父进程的管道描述符
+	EnvReadyFD = "GONET_READY_FD"
+
+	// upgradeReadyTimeout 等待子进程准备完成的最长时间
+	upgradeReadyTimeout = 30 * time.Second
+)
+
+var (
+	inheritOnce sync.Once
+	//监听名称对应的继承监听
+	inherited map[string]net.Listener
+	//继承的UDP套接字
+	inheritedUDP  *net.UDPConn
+	inheritedLock sync.Mutex
+	readyOnce     sync.Once
+)
+
+// loadInheritedListeners 解析父进程传递的监听套接字及UDP套接字
+func loadInheritedListeners() {
+	inherited = make(map[string]net.Listener)
+	loadInheritedUDP()
+	names := os.Getenv(EnvInheritedListeners)
+	if names == "" {
+		return
+	}
+	for i, name := range strings.Split(names, ",") {
+		file := os.NewFile(uintptr(3+i), name)
+		if file == nil {
+			continue
+		}
+		ln, err := net.FileListener(file)
+		//FileListener会复制一份描述符，原来的可以关闭
+		_ = file.Close()
+		if err != nil {
+			logger.Default().WithFields(logger.Fields{logger.FieldListener: name, logger.FieldError: err}).Error("inherit listener failed")
+			continue
+		}
+		inherited[name] = ln
+	}
+	_ = os.Unsetenv(EnvInheritedListeners)
+}
+
+// loadInheritedUDP 解析父进程传递的UDP套接字
+func loadInheritedUDP() {
+	fdStr := os.Getenv(EnvInheritedUDP)
+	if fdStr == "" {
+		return
+	}
+	_ = os.Unsetenv(EnvInheritedUDP)
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return
+	}
+	file := os.NewFile(uintptr(fd), UDPListenerName)
+	if file == nil {
+		return
+	}
+	conn, err := net.FilePacketConn(file)
+	_ = file.Close()
+	if err != nil {
+		logger.Default().WithFields(logger.Fields{logger.FieldListener: UDPListenerName, logger.FieldError: err}).Error("inherit udp socket failed")
+		return
+	}
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		_ = conn.Close()
+		return
+	}
+	inheritedUDP = udpConn
+}
+
+// inheritedUDPConn 获取并取走继承的UDP套接字，不存在时返回nil
+func inheritedUDPConn() *net.UDPConn {
+	inheritOnce.Do(loadInheritedListeners)
+	inheritedLock.Lock()
+	defer inheritedLock.Unlock()
+	conn := inheritedUDP
+	inheritedUDP = nil
+	return conn
+}
+
+// inheritedListener 获取并取走指定名称的继承监听，不存在时返回nil
+func inheritedListener(name string) net.Listener {
+	inheritOnce.Do(loadInheritedListeners)
+	inheritedLock.Lock()
+	defer inheritedLock.Unlock()
+	ln, ok := inherited[name]
+	if !ok {
+		return nil
+	}
+	delete(inherited, name)
+	return ln
+}
+
+// IsUpgradedProcess 当前进程是否是由热升级启动的子进程
+func IsUpgradedProcess() bool {
+	return os.Getenv(EnvReadyFD) != ""
+}
+
+// notifyReady 子进程的监听全部准备完成后通知父进程，只通知一次
+func notifyReady() {
+	readyOnce.Do(func() {
+		fdStr := os.Getenv(EnvReadyFD)
+		if fdStr == "" {
+			return
+		}
+		fd, err := strconv.Atoi(fdStr)
+		if err != nil {
+			return
+		}
+		file := os.NewFile(uintptr(fd), "gonet-ready")
+		_, _ = file.Write([]byte{1})
+		_ = file.Close()
+		_ = os.Unsetenv(EnvReadyFD)
+	})
+}
+
+// Upgrade 以当前的可执行文件及启动参数启动新进程，并将监听套接字传递给它
+func (s *Server) Upgrade() (*os.Process, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return s.UpgradeWith(path, os.Args[1:], nil)
+}
+
+// UpgradeWith 启动指定的新进程并将监听套接字及UDP套接字传递给它，新进程准备完成后返回
+// 返回之后当前进程可以调用Shutdown停止accept并等待已有连接处理完毕
+func (s *Server) UpgradeWith(path string, args []string, env []string) (*os.Process, error) {
+	s.listenerLock.Lock()
+	names := make([]string, 0, len(s.listeners))
+	files := make([]*os.File, 0, len(s.listeners)+1)
+	for _, l := range s.listeners {
+		//SO_REUSEPORT监听由新进程直接绑定同一个端口
+		if l.ReusePort > 1 {
+			continue
+		}
+		sc, ok := l.raw.(syscall.Conn)
+		if !ok {
+			continue
+		}
+		file, err := dupFile(sc, l.Name)
+		if err != nil {
+			s.listenerLock.Unlock()
+			closeFiles(files)
+			return nil, err
+		}
+		names = append(names, l.Name)
+		files = append(files, file)
+	}
+	s.listenerLock.Unlock()
+	//UDP套接字放在监听之后
+	udpFD := ""
+	if s.udp != nil {
+		file, err := dupFile(s.udp.conn, UDPListenerName)
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+		udpFD = strconv.Itoa(3 + len(files))
+		files = append(files, file)
+	}
+	defer closeFiles(files)
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyReader.Close()
+
+	cmd := exec.Command(path, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, readyWriter)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Env = append(cmd.Env,
+		fmt.Sprintf("%s=%s", EnvInheritedListeners, strings.Join(names, ",")),
+		fmt.Sprintf("%s=%s", EnvInheritedUDP, udpFD),
+		fmt.Sprintf("%s=%d", EnvReadyFD, 3+len(files)),
+	)
+	err = cmd.Start()
+	_ = readyWriter.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	//等待子进程准备完成，失败时结束子进程，当前进程继续提供服务
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := readyReader.Read(buf)
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-time.After(upgradeReadyTimeout):
+		err = errors.New("wait upgraded process ready timeout")
+	}
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, err
+	}
+	//子进程退出后回收资源
+	go func() { _ = cmd.Wait() }()
+	s.logger.Infof("upgraded process started, pid = %d", cmd.Process.Pid)
+	return cmd.Process, nil
+}
+
+// Shutdown 停止接受新的连接，等待已有连接处理完毕，ctx结束时强制关闭剩余的连接
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.logger.Debug("server is shutting down")
+	s.listenerLock.Lock()
+	for _, l := range s.listeners {
+		//unix socket的文件可能已经交给了新进程，关闭监听时不能删除
+		if unixLn, ok := l.raw.(*net.UnixListener); ok {
+			unixLn.SetUnlinkOnClose(false)
+		}
+	}
+	s.listenerLock.Unlock()
+	s.closeListeners()
+	s.unwatch()
+	//UDP会话无法在关闭套接字之后继续收发，直接停止，之后对端的数据包由新进程处理
+	if s.udp != nil {
+		s.udp.close()
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for s.ConnMgr.GetConnLen() > 0 {
+		select {
+		case <-ctx.Done():
+			s.ConnMgr.ClearConn()
+			if s.reactor != nil {
+				s.reactor.close()
+			}
+			s.closeRecorder()
+			s.stopScheduler(ctx)
+			s.closeFluentd(ctx)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	if s.reactor != nil {
+		s.reactor.close()
+	}
+	s.closeRecorder()
+	s.stopScheduler(ctx)
+	s.closeFluentd(ctx)
+	return nil
+}
+
+// ServeGraceful 启动服务并阻塞处理信号
+// 收到升级信号(SIGUSR2)时启动新进程并退出，收到SIGINT/SIGTERM时优雅退出，drainTimeout为等待连接处理完毕的最长时间
+func (s *Server) ServeGraceful(drainTimeout time.Duration) error {
+	s.Start()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append(upgradeSignals, syscall.SIGINT, syscall.SIGTERM)...)
+	defer signal.Stop(signals)
+
+	for sig := range signals {
+		if isUpgradeSignal(sig) {
+			if _, err := s.Upgrade(); err != nil {
+				s.logger.WithField(logger.FieldError, err).Error("upgrade failed")
+				continue
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		err := s.Shutdown(ctx)
+		cancel()
+		return err
+	}
+	return nil
+}
+
+func isUpgradeSignal(sig os.Signal) bool {
+	for _, upgradeSig := range upgradeSignals {
+		if sig == upgradeSig {
+			return true
+		}
+	}
+	return false
+}
+
+func closeFiles(files []*os.File) {
+	for _, file := range files {
+		_ = file.Close()
+	}
+}
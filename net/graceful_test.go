@@ -0,0 +1,198 @@
+//go:build !windows
+
+package net
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"gonet/interfaces"
+)
+
+const (
+	envUpgradeChild = "GONET_TEST_UPGRADE_CHILD"
+	//父进程的UDP端口，子进程使用同一个端口配置
+	envUpgradeUDPPort = "GONET_TEST_UPGRADE_UDP_PORT"
+	//子进程额外监听的地址，父进程没有传递该监听，子进程监听失败
+	envUpgradeMissingAddr = "GONET_TEST_UPGRADE_MISSING_ADDR"
+)
+
+// replyRouter 回复固定内容，用于区分新旧进程
+type replyRouter struct {
+	BaseRouter
+	reply string
+}
+
+func (r *replyRouter) Handle(request interfaces.IRequest) {
+	_ = request.GetConn().SendMsg(request.GetMsgID(), []byte(r.reply))
+}
+
+// quitRouter 收到消息后通知子进程退出
+type quitRouter struct {
+	BaseRouter
+	quit chan struct{}
+}
+
+func (r *quitRouter) Handle(interfaces.IRequest) {
+	close(r.quit)
+}
+
+// TestGracefulUpgradeChild 由TestGracefulUpgrade以子进程的方式启动
+func TestGracefulUpgradeChild(t *testing.T) {
+	if os.Getenv(envUpgradeChild) != "1" {
+		t.Skip("only run as upgraded child process")
+	}
+	if !IsUpgradedProcess() {
+		t.Fatal("child should be started by Upgrade")
+	}
+	s := NewServerWithParam("child", "tcp4", "127.0.0.1", 0, 100).(*Server)
+	s.UDPPort, _ = strconv.Atoi(os.Getenv(envUpgradeUDPPort))
+	s.AddRouter(1, &replyRouter{reply: "child"})
+	quit := make(chan struct{})
+	s.AddRouter(2, &quitRouter{quit: quit})
+	missingAddr := os.Getenv(envUpgradeMissingAddr)
+	if missingAddr != "" {
+		s.AddListener(&ListenerConfig{Name: "missing", Network: "tcp4", Address: missingAddr})
+	}
+	s.Start()
+	defer s.Stop()
+	if missingAddr != "" {
+		//监听失败时不通知父进程，直接退出
+		return
+	}
+	select {
+	case <-quit:
+	case <-time.After(10 * time.Second):
+	}
+}
+
+func TestGracefulUpgrade(t *testing.T) {
+	if os.Getenv(envUpgradeChild) == "1" {
+		t.Skip("running as child")
+	}
+	s := NewServerWithParam("parent", "tcp4", "127.0.0.1", 0, 100).(*Server)
+	s.UDPPort = freeUDPPort(t)
+	s.AddRouter(1, &replyRouter{reply: "parent"})
+	s.Start()
+	addr := s.ListenerAddrs()[DefaultListenerName].String()
+	udpAddr := fmt.Sprintf("127.0.0.1:%d", s.UDPPort)
+
+	oldConn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := echoOnce(t, oldConn, 1, nil); string(msg.GetData()) != "parent" {
+		t.Fatalf("got %q before upgrade", msg.GetData())
+	}
+	oldUDP, err := DialUDP(udpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer oldUDP.Close()
+	if reply := udpEchoOnce(t, oldUDP); reply != "parent" {
+		t.Fatalf("got %q from udp before upgrade", reply)
+	}
+
+	process, err := s.UpgradeWith(os.Args[0], []string{"-test.run=^TestGracefulUpgradeChild$"},
+		[]string{envUpgradeChild + "=1", envUpgradeUDPPort + "=" + strconv.Itoa(s.UDPPort)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer process.Kill()
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- s.Shutdown(ctx)
+	}()
+	//UDP套接字关闭后旧进程的UDP会话立即停止，只剩下排空中的TCP连接
+	for len(s.ListenerAddrs()) > 0 || s.ConnMgr.GetConnLen() > 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	//旧连接在排空期间仍由旧进程处理
+	if msg := echoOnce(t, oldConn, 1, nil); string(msg.GetData()) != "parent" {
+		t.Fatalf("got %q from draining conn", msg.GetData())
+	}
+	//新连接由新进程通过继承的监听接受
+	newConn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer newConn.Close()
+	if msg := echoOnce(t, newConn, 1, nil); string(msg.GetData()) != "child" {
+		t.Fatalf("got %q after upgrade", msg.GetData())
+	}
+	//新的UDP会话由新进程通过继承的UDP套接字处理
+	newUDP, err := DialUDP(udpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer newUDP.Close()
+	if reply := udpEchoOnce(t, newUDP); reply != "child" {
+		t.Fatalf("got %q from udp after upgrade", reply)
+	}
+
+	_ = oldConn.Close()
+	if err := <-shutdown; err != nil {
+		t.Fatalf("shutdown err: %v", err)
+	}
+	_, _ = newConn.Write(mustPack(t, 2, nil))
+	//子进程由UpgradeWith负责回收，这里只等待其退出
+	deadline := time.Now().Add(5 * time.Second)
+	for process.Signal(syscall.Signal(0)) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("child should exit after quit msg")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGracefulUpgrade_ListenFailed(t *testing.T) {
+	if os.Getenv(envUpgradeChild) == "1" {
+		t.Skip("running as child")
+	}
+	s := NewServerWithParam("parent", "tcp4", "127.0.0.1", 0, 100).(*Server)
+	s.AddRouter(1, &replyRouter{reply: "parent"})
+	s.Start()
+	defer s.Stop()
+	addr := s.ListenerAddrs()[DefaultListenerName].String()
+
+	//子进程的监听没有从父进程继承，地址仍被父进程占用，子进程不能通知准备完成
+	_, err := s.UpgradeWith(os.Args[0], []string{"-test.run=^TestGracefulUpgradeChild$"},
+		[]string{envUpgradeChild + "=1", envUpgradeMissingAddr + "=" + addr})
+	if err == nil {
+		t.Fatal("upgrade should fail when the child cannot listen")
+	}
+	//父进程继续提供服务
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if msg := echoOnce(t, conn, 1, nil); string(msg.GetData()) != "parent" {
+		t.Fatalf("got %q after failed upgrade", msg.GetData())
+	}
+}
+
+// udpEchoOnce 发送消息1并返回回复的内容
+func udpEchoOnce(t *testing.T, client *UDPClient) string {
+	t.Helper()
+	if err := client.SendMsg(1, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-client.Messages():
+		return string(msg.GetData())
+	case <-time.After(2 * time.Second):
+		t.Fatal("udp reply timeout")
+	}
+	return ""
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+
+package net
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignals 触发热升级的信号
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
+
+// dupFile 复制套接字的描述符，传递给子进程
+// net.Conn的File()返回的文件在启动子进程时会将共享的套接字改为阻塞模式，
+// 之后当前进程的读协程阻塞在系统调用中，Close无法返回，这里自行复制描述符以保持非阻塞模式
+func dupFile(conn syscall.Conn, name string) (*os.File, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var fd int
+	var dupErr error
+	if err := rawConn.Control(func(sysfd uintptr) {
+		fd, dupErr = syscall.Dup(int(sysfd))
+	}); err != nil {
+		return nil, err
+	}
+	if dupErr != nil {
+		return nil, os.NewSyscallError("dup", dupErr)
+	}
+	syscall.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), name), nil
+}
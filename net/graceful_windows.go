@@ -0,0 +1,17 @@
+//go:build windows
+
+package net
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// upgradeSignals windows不支持传递监听套接字，不处理热升级信号
+var upgradeSignals []os.Signal
+
+// dupFile windows不支持传递套接字
+func dupFile(syscall.Conn, string) (*os.File, error) {
+	return nil, errors.New("passing sockets is not supported on windows")
+}
@@ -0,0 +1,241 @@
+package net
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"gonet/config"
+	"gonet/logger"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	监听模块
+	一个Server可以拥有多个监听，每个监听可以使用不同的传输方式(tcp/unix)、TLS以及准入策略
+	所有监听共用Server的MsgHandle和ConnMgr，连接通过标签区分来源
+*/
+
+// DefaultListenerName 未配置监听时，根据Host:Port创建的默认监听名称
+const DefaultListenerName = "default"
+
+// ListenerConfig 监听配置
+type ListenerConfig struct {
+	//监听名称
+	Name string
+	//tcp、tcp4、tcp6或unix
+	Network string
+	//host:port或unix socket路径
+	Address string
+	//不为nil时启用TLS
+	TLSConfig *tls.Config
+	//该监听接受的连接所携带的标签
+	Tags []string
+	//该监听允许的最大连接数，0表示只受Server的MaxConn限制
+	MaxConn int
+	//准入策略，返回错误时拒绝该连接
+	Admit func(conn net.Conn) error
+	//大于1时使用SO_REUSEPORT开启多个监听，每个监听独立accept，分散连接风暴时的accept压力
+	ReusePort int
+	//已经创建好的监听，例如测试中使用的内存监听，不为nil时忽略Network、Address及ReusePort
+	Listener net.Listener
+}
+
+// listener 运行中的监听
+type listener struct {
+	*ListenerConfig
+	ln net.Listener
+	//TLS封装之前的监听，用于热升级时传递套接字
+	raw net.Listener
+	//当前该监听下的连接数，同一配置的多个SO_REUSEPORT监听共用
+	connCount *int32
+}
+
+// ListenerConfigFromConf 根据配置文件中的监听配置生成ListenerConfig，配置了证书时加载TLS
+func ListenerConfigFromConf(conf config.ListenerConf) (*ListenerConfig, error) {
+	lc := &ListenerConfig{
+		Name:      conf.Name,
+		Network:   conf.Network,
+		Address:   conf.Address,
+		Tags:      conf.Tags,
+		MaxConn:   conf.MaxConn,
+		ReusePort: conf.ReusePort,
+	}
+	if conf.CertFile != "" && conf.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		lc.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	return lc, nil
+}
+
+// AddListener 给Server添加一个监听，需要在Start之前调用
+func (s *Server) AddListener(lc *ListenerConfig) {
+	s.listenerConfigs = append(s.listenerConfigs, lc)
+}
+
+// listen 根据监听配置创建监听，优先使用热升级时父进程传递的同名监听
+// ReusePort大于1时创建多个SO_REUSEPORT监听
+func (s *Server) listen(lc *ListenerConfig) ([]*listener, error) {
+	connCount := new(int32)
+	if lc.Listener != nil {
+		return []*listener{newListener(lc, lc.Listener, connCount)}, nil
+	}
+	if lc.ReusePort > 1 {
+		return listenReusePort(lc, connCount)
+	}
+
+	raw := inheritedListener(lc.Name)
+	if raw == nil {
+		if lc.Network == "unix" {
+			removeStaleSocket(lc.Address)
+		}
+		var err error
+		raw, err = net.Listen(lc.Network, lc.Address)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		s.logger.WithField(logger.FieldListener, lc.Name).Infof("use inherited listener at %s", raw.Addr())
+	}
+	return []*listener{newListener(lc, raw, connCount)}, nil
+}
+
+// removeStaleSocket 删除上次未正常退出遗留的socket文件
+// 只有连接失败时才说明没有进程在监听，仍然可以连接时保留文件，随后的Listen返回地址已被使用
+func removeStaleSocket(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return
+	}
+	_ = os.Remove(path)
+}
+
+// listenReusePort 创建ReusePort个绑定在同一地址上的SO_REUSEPORT监听，由内核在它们之间分配新连接
+// 热升级时这类监听不需要传递，新进程直接绑定同一个端口即可
+func listenReusePort(lc *ListenerConfig, connCount *int32) ([]*listener, error) {
+	listenConfig := net.ListenConfig{Control: reusePortControl}
+	address := lc.Address
+	listeners := make([]*listener, 0, lc.ReusePort)
+	for i := 0; i < lc.ReusePort; i++ {
+		raw, err := listenConfig.Listen(context.Background(), lc.Network, address)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.ln.Close()
+			}
+			return nil, err
+		}
+		//端口为0时，后续的监听需要绑定到第一个监听随机分配的端口上
+		address = raw.Addr().String()
+		listeners = append(listeners, newListener(lc, raw, connCount))
+	}
+	return listeners, nil
+}
+
+func newListener(lc *ListenerConfig, raw net.Listener, connCount *int32) *listener {
+	ln := raw
+	if lc.TLSConfig != nil {
+		ln = tls.NewListener(raw, lc.TLSConfig)
+	}
+	return &listener{ListenerConfig: lc, ln: ln, raw: raw, connCount: connCount}
+}
+
+// getListenerConfigs 返回需要开启的全部监听配置，未配置时使用Host:Port作为默认监听
+func (s *Server) getListenerConfigs() []*ListenerConfig {
+	if len(s.listenerConfigs) > 0 {
+		return s.listenerConfigs
+	}
+	return []*ListenerConfig{{
+		Name:      DefaultListenerName,
+		Network:   s.IPVersion,
+		Address:   fmt.Sprintf("%s:%d", s.Host, s.Port),
+		ReusePort: s.ReusePort,
+	}}
+}
+
+// acceptLoop 阻塞等待该监听上的客户端连接
+func (s *Server) acceptLoop(l *listener) {
+	log := s.logger.WithField(logger.FieldListener, l.Name)
+	for {
+		//阻塞等待客户端建立连接请求
+		conn, err := l.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.WithField(logger.FieldError, err).Error("accept failed")
+			continue
+		}
+
+		//设置服务器最大连接控制,如果超过最大连接，那么则关闭此新的连接
+		if maxConn := s.GetMaxConn(); s.ConnMgr.GetConnLen() >= maxConn {
+			log.WithField(logger.FieldRemoteAddr, conn.RemoteAddr().String()).Debugf("too many connections, MaxConn = %d", maxConn)
+			_ = conn.Close()
+			continue
+		}
+		//该监听的最大连接控制
+		if l.MaxConn > 0 && int(atomic.LoadInt32(l.connCount)) >= l.MaxConn {
+			log.WithField(logger.FieldRemoteAddr, conn.RemoteAddr().String()).Debugf("too many connections on listener, MaxConn = %d", l.MaxConn)
+			_ = conn.Close()
+			continue
+		}
+		//该监听的准入策略
+		if l.Admit != nil {
+			if err := l.Admit(conn); err != nil {
+				log.WithFields(logger.Fields{logger.FieldRemoteAddr: conn.RemoteAddr().String(), logger.FieldError: err}).Debug("reject conn")
+				_ = conn.Close()
+				continue
+			}
+		}
+
+		//在创建Connection之前设置socket选项
+		if err := s.SocketOptions.apply(conn); err != nil {
+			log.WithFields(logger.Fields{logger.FieldRemoteAddr: conn.RemoteAddr().String(), logger.FieldError: err}).Error("set socket options failed")
+			_ = conn.Close()
+			continue
+		}
+
+		//处理该新连接请求的业务方法， 此时应该有 handler 和 conn是绑定的
+		//server和connection集成
+		atomic.AddInt32(l.connCount, 1)
+		dealConn := NewConnectionWithParam(s, conn, s.MsgHandler, s.MaxMsgChanLen)
+		if s.reactor != nil && canUseEventLoop(conn) {
+			dealConn.useEventLoop(s.reactor.next(), s.EpollWriteTimeout)
+		}
+		dealConn.listener = l
+		dealConn.SetConnID(s.GenNextID())
+		//启动当前的连接业务处理
+		go dealConn.Start()
+	}
+}
+
+// closeListeners 关闭全部监听，不再接受新的连接
+func (s *Server) closeListeners() {
+	s.listenerLock.Lock()
+	defer s.listenerLock.Unlock()
+	for _, l := range s.listeners {
+		_ = l.ln.Close()
+	}
+	s.listeners = nil
+}
+
+// ListenerAddrs 返回正在监听的地址，用于获取随机分配的端口
+func (s *Server) ListenerAddrs() map[string]net.Addr {
+	s.listenerLock.Lock()
+	defer s.listenerLock.Unlock()
+	addrs := make(map[string]net.Addr, len(s.listeners))
+	for _, l := range s.listeners {
+		addrs[l.Name] = l.ln.Addr()
+	}
+	return addrs
+}
@@ -0,0 +1,191 @@
+package net
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gonet/interfaces"
+	"gonet/pack"
+)
+
+func mustPack(t *testing.T, msgID uint32, data []byte) []byte {
+	t.Helper()
+	binaryMsg, err := pack.NewDataPack().Pack(pack.NewMessage(msgID, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return binaryMsg
+}
+
+// echoOnce 通过conn发送一条消息并读取回复
+func echoOnce(t *testing.T, conn net.Conn, msgID uint32, data []byte) interfaces.IMessage {
+	t.Helper()
+	dp := pack.NewDataPack()
+	if _, err := conn.Write(mustPack(t, msgID, data)); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	head := make([]byte, dp.GetHeadLen())
+	if _, err := io.ReadFull(conn, head); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := dp.UnPack(head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, msg.GetMsgLen())
+	if _, err := io.ReadFull(conn, body); err != nil {
+		t.Fatal(err)
+	}
+	msg.SetMsgData(body)
+	return msg
+}
+
+// selfSignedTLS 生成测试用的自签名证书
+func selfSignedTLS(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func TestServer_MultiListener(t *testing.T) {
+	s := NewServerWithParam("multi-listener", "tcp4", "127.0.0.1", 0, 100).(*Server)
+	s.AddRouter(1, &echoRouter{})
+	sockPath := filepath.Join(t.TempDir(), "gonet.sock")
+	s.AddListener(&ListenerConfig{
+		Name:    "public",
+		Network: "tcp4",
+		Address: "127.0.0.1:0",
+		Tags:    []string{"public"},
+		MaxConn: 1,
+		Admit: func(conn net.Conn) error {
+			if conn.RemoteAddr().(*net.TCPAddr).IP.IsLoopback() {
+				return nil
+			}
+			return errors.New("only loopback")
+		},
+	})
+	s.AddListener(&ListenerConfig{
+		Name:    "internal",
+		Network: "unix",
+		Address: sockPath,
+		Tags:    []string{"internal"},
+	})
+	s.AddListener(&ListenerConfig{
+		Name:      "tls",
+		Network:   "tcp4",
+		Address:   "127.0.0.1:0",
+		TLSConfig: selfSignedTLS(t),
+	})
+	listeners := make(chan string, 4)
+	s.SetOnConnStart(func(conn interfaces.IConnection) {
+		if conn.GetListenerName() == "internal" && !conn.HasTag("internal") {
+			t.Error("internal conn should carry internal tag")
+		}
+		listeners <- conn.GetListenerName()
+	})
+	s.Start()
+	defer s.Stop()
+
+	addrs := s.ListenerAddrs()
+	if len(addrs) != 3 {
+		t.Fatalf("listening on %d listeners, want 3", len(addrs))
+	}
+
+	unixConn, err := net.Dial("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unixConn.Close()
+	if msg := echoOnce(t, unixConn, 1, []byte("internal")); string(msg.GetData()) != "internal" {
+		t.Fatalf("unexpected echo %q", msg.GetData())
+	}
+
+	tlsConn, err := tls.Dial("tcp4", addrs["tls"].String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tlsConn.Close()
+	if msg := echoOnce(t, tlsConn, 1, []byte("secure")); string(msg.GetData()) != "secure" {
+		t.Fatalf("unexpected echo %q", msg.GetData())
+	}
+
+	publicConn, err := net.Dial("tcp4", addrs["public"].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publicConn.Close()
+	echoOnce(t, publicConn, 1, []byte("public"))
+
+	//超过该监听的最大连接数，新的连接会被关闭
+	extraConn, err := net.Dial("tcp4", addrs["public"].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer extraConn.Close()
+	_ = extraConn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := extraConn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("extra conn should be closed, got %v", err)
+	}
+
+	got := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		got[<-listeners] = true
+	}
+	for _, name := range []string{"public", "internal", "tls"} {
+		if !got[name] {
+			t.Fatalf("no conn started on listener %s", name)
+		}
+	}
+}
+
+func TestServer_ListenUnixStaleSocket(t *testing.T) {
+	s := NewServerWithParam("unix-stale", "tcp4", "127.0.0.1", 0, 100).(*Server)
+	sockPath := filepath.Join(t.TempDir(), "gonet.sock")
+	lc := &ListenerConfig{Name: "internal", Network: "unix", Address: sockPath}
+
+	//仍有进程在监听的socket文件不能删除
+	live, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.listen(lc); err == nil {
+		t.Fatal("listen on a live socket should fail")
+	}
+	conn, err := net.Dial("unix", sockPath)
+	if err != nil {
+		t.Fatalf("live socket should still accept: %v", err)
+	}
+	_ = conn.Close()
+
+	//进程退出后遗留的socket文件被删除后重新监听
+	live.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = live.Close()
+	listeners, err := s.listen(lc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = listeners[0].ln.Close()
+}
@@ -0,0 +1,84 @@
+package net
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"gonet/config"
+	"gonet/logger"
+)
+
+func TestServer_LoggerFields(t *testing.T) {
+	l, hook := test.NewNullLogger()
+	s := NewServerWithParam("logger", "tcp4", "127.0.0.1", 0, 100).(*Server)
+	s.SetLogger(logger.NewLogrus(l).WithField(logger.FieldServer, "logger"))
+	s.Start()
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp4", s.ListenerAddrs()[DefaultListenerName].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	//未注册的msgID会记录一条带连接信息的警告
+	if _, err := conn.Write(mustPack(t, 99, []byte("unknown"))); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		for _, entry := range hook.AllEntries() {
+			if entry.Level != logrus.WarnLevel {
+				continue
+			}
+			if entry.Data[logger.FieldMsgID] != uint32(99) {
+				t.Fatalf("unexpected msg_id %v", entry.Data[logger.FieldMsgID])
+			}
+			if entry.Data[logger.FieldRemoteAddr] != conn.LocalAddr().String() {
+				t.Fatalf("unexpected remote_addr %v", entry.Data[logger.FieldRemoteAddr])
+			}
+			if _, ok := entry.Data[logger.FieldConnID]; !ok {
+				t.Fatal("conn_id should be attached")
+			}
+			if entry.Data[logger.FieldServer] != "logger" {
+				t.Fatalf("unexpected server %v", entry.Data[logger.FieldServer])
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("warn log not found")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServer_CloseFluentd(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conf := config.Default()
+	conf.FluentdEnable = true
+	conf.FluentdDebugMode = true
+	conf.FluentdHost = "127.0.0.1"
+	conf.FluentdPort = ln.Addr().(*net.TCPAddr).Port
+	s := NewServerWithConfig(conf, WithMachineID(1), WithAddress("127.0.0.1", 0)).(*Server)
+	s.Start()
+
+	//启动的日志发送给fluentd，Stop之后fluentd的连接被关闭
+	fluentd, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fluentd.Close()
+	s.Stop()
+	_ = fluentd.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := io.Copy(io.Discard, fluentd); err != nil {
+		t.Fatalf("fluentd connection should be closed after Stop: %v", err)
+	}
+}
@@ -1,12 +1,16 @@
 package net
 
 import (
-	"fmt"
-	"github.com/sirupsen/logrus"
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gonet/config"
 	"gonet/interfaces"
+	"gonet/logger"
+	"gonet/tracing"
 	"math/rand"
 	"strconv"
+	"time"
 )
 
 /*
@@ -20,20 +24,52 @@ type MsgHandle struct {
 	Apis map[uint32]interfaces.IRouter
 	//负责Worker取消息的消息队列
 	TaskQueue []chan interfaces.IRequest
-	//业务工作worker池中的worker数量
+	//业务工作worker池中的worker数量，0表示不使用worker池，每个请求由单独的协程处理
 	WorkerPoolSize uint
+	//每个worker的任务队列长度
+	MaxWorkerTaskLen uint32
+	//默认的处理超时时间，0表示不限制
+	HandlerTimeout time.Duration
+	//每个msgID单独设置的处理超时时间
+	Timeouts map[uint32]time.Duration
+	//日志
+	Logger logger.Logger
 }
 
+// NewMsgHandle 使用默认配置创建消息处理模块
 func NewMsgHandle() *MsgHandle {
+	return NewMsgHandleWithConfig(config.Default())
+}
+
+// NewMsgHandleWithConfig 使用conf中的worker池及处理超时的配置创建消息处理模块
+func NewMsgHandleWithConfig(conf *config.GlobalObj) *MsgHandle {
+	return NewMsgHandleWithParam(
+		conf.WorkerPoolSize,
+		conf.MaxWorkerTaskLen,
+		time.Duration(conf.HandlerTimeout)*time.Millisecond,
+	)
+}
+
+// NewMsgHandleWithParam 创建消息处理模块
+func NewMsgHandleWithParam(workerPoolSize uint, maxWorkerTaskLen uint32, handlerTimeout time.Duration) *MsgHandle {
 	return &MsgHandle{
-		Apis:           make(map[uint32]interfaces.IRouter),
-		WorkerPoolSize: config.GlobalServerConfig.WorkerPoolSize,
-		TaskQueue:      make([]chan interfaces.IRequest, config.GlobalServerConfig.WorkerPoolSize),
+		Apis:             make(map[uint32]interfaces.IRouter),
+		WorkerPoolSize:   workerPoolSize,
+		MaxWorkerTaskLen: maxWorkerTaskLen,
+		TaskQueue:        make([]chan interfaces.IRequest, workerPoolSize),
+		HandlerTimeout:   handlerTimeout,
+		Timeouts:         make(map[uint32]time.Duration),
+		Logger:           logger.Default(),
 	}
 }
 
 // SendMsgToTaskQueue 将消息交给TaskQueue，由Worker进行处理
 func (mh *MsgHandle) SendMsgToTaskQueue(request interfaces.IRequest) {
+	//如果工作池未启动只能自己启动一个协程进行处理
+	if mh.WorkerPoolSize == 0 {
+		go mh.DoMsgHandle(request)
+		return
+	}
 	//1.将消息平均分给不同的Worker
 	//根据客户端建立的ConnID来进行分配，使用基本的轮询法则
 	var workerID uint
@@ -43,26 +79,71 @@ func (mh *MsgHandle) SendMsgToTaskQueue(request interfaces.IRequest) {
 	} else {
 		workerID = uint(request.GetConn().GetConnID()) % mh.WorkerPoolSize
 	}
-	logrus.Debug("Add ConnID = ", request.GetConn().GetConnID(), "request MsgID= ",
-		request.GetMsgID(), "to WorkerID= ", workerID)
+	mh.requestLogger(request).WithField(logger.FieldWorkerID, workerID).Debug("add request to task queue")
 	//2.将消息发送给对应的worker的TaskQueue
 	mh.TaskQueue[workerID] <- request
 }
 
 // DoMsgHandle 调度/执行对应的Router消息处理方法
 func (mh *MsgHandle) DoMsgHandle(request interfaces.IRequest) {
+	//连接的定时器回调
+	if task, ok := request.(*taskRequest); ok {
+		task.fn()
+		return
+	}
 	//1.从Request中找到msgID
 	handler, ok := mh.Apis[request.GetMsgID()]
 	if !ok {
-		fmt.Println("api msgID=" + strconv.Itoa(int(request.GetMsgID())) + "Not Found! Need Register!")
+		mh.requestLogger(request).Warn("api not found, need register")
 		return
 	}
-	//2.根据msgID调度对应的处理方法
+	//2.从请求当前的ctx派生出带处理超时的ctx，处理完成后释放
+	ctx, cancel := mh.handlerContext(request)
+	defer cancel()
+	//以该msgID创建服务端span，请求携带链路信息时作为远端span的子span
+	ctx, span := tracing.Tracer().Start(ctx, "gonet.handle/"+strconv.Itoa(int(request.GetMsgID())),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.Int64("gonet.msg_id", int64(request.GetMsgID())),
+			attribute.String("gonet.conn_id", strconv.FormatUint(request.GetConn().GetConnID(), 10)),
+		))
+	defer span.End()
+	request.SetContext(ctx)
+	//3.根据msgID调度对应的处理方法
 	handler.PreHandle(request)
 	handler.Handle(request)
 	handler.PostHandle(request)
 }
 
+// requestLogger 返回附加了请求所属连接及msgID的日志
+func (mh *MsgHandle) requestLogger(request interfaces.IRequest) logger.Logger {
+	fields := logger.Fields{
+		logger.FieldConnID: request.GetConn().GetConnID(),
+		logger.FieldMsgID:  request.GetMsgID(),
+	}
+	if addr := request.GetConn().RemoteAddr(); addr != nil {
+		fields[logger.FieldRemoteAddr] = addr.String()
+	}
+	return mh.Logger.WithFields(fields)
+}
+
+// handlerContext 根据msgID对应的处理超时时间派生请求的ctx
+func (mh *MsgHandle) handlerContext(request interfaces.IRequest) (context.Context, context.CancelFunc) {
+	timeout := mh.HandlerTimeout
+	if t, ok := mh.Timeouts[request.GetMsgID()]; ok {
+		timeout = t
+	}
+	if timeout > 0 {
+		return context.WithTimeout(request.Context(), timeout)
+	}
+	return context.WithCancel(request.Context())
+}
+
+// SetHandlerTimeout 设置某个msgID的处理超时时间，需要在Start之前调用
+func (mh *MsgHandle) SetHandlerTimeout(msgID uint32, timeout time.Duration) {
+	mh.Timeouts[msgID] = timeout
+}
+
 // AddRouter 为消息添加具体的处理逻辑
 func (mh *MsgHandle) AddRouter(msgID uint32, router interfaces.IRouter) {
 	//1. 判断当前msg绑定的api的处理方法是否已经存在
@@ -72,7 +153,7 @@ func (mh *MsgHandle) AddRouter(msgID uint32, router interfaces.IRouter) {
 	}
 	//2.添加msg与api的绑定关系
 	mh.Apis[msgID] = router
-	fmt.Println("Add api MsgID= ", msgID, "successful!")
+	mh.Logger.WithField(logger.FieldMsgID, msgID).Debug("add api")
 }
 
 // StartWorkerPool 启动一个worker工作池
@@ -81,7 +162,7 @@ func (mh *MsgHandle) StartWorkerPool() {
 	//根据WorkerPoolSize分别开始Worker，每个Worker用一个go来承载
 	for i := 0; i < int(mh.WorkerPoolSize); i++ {
 		//1.当前worker对应的channel消息队列，开辟空间，第0个worker就用第0个channel
-		mh.TaskQueue[i] = make(chan interfaces.IRequest, config.GlobalServerConfig.MaxWorkerTaskLen)
+		mh.TaskQueue[i] = make(chan interfaces.IRequest, mh.MaxWorkerTaskLen)
 		//2.启动当前的worker，阻塞等待消息从channel中到来
 		go mh.StartOneWorker(i, mh.TaskQueue[i])
 	}
@@ -89,7 +170,7 @@ func (mh *MsgHandle) StartWorkerPool() {
 
 // StartOneWorker 启动一个Worker工作流程
 func (mh *MsgHandle) StartOneWorker(workerID int, taskQueue chan interfaces.IRequest) {
-	fmt.Println("WorkerID = ", workerID, "is starting...")
+	mh.Logger.WithField(logger.FieldWorkerID, workerID).Debug("worker is starting")
 	for {
 		select {
 		//如果有消息过来，出队列的就是一个客户端的Request，执行当前Request所绑定的业务
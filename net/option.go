@@ -0,0 +1,130 @@
+package net
+
+import (
+	"gonet/config"
+	"gonet/interfaces"
+	"gonet/logger"
+	"gonet/timer"
+	"time"
+)
+
+/*
+	Server的创建选项
+	选项在配置的副本上修改，同一进程中可以同时存在多个配置不同的Server
+*/
+
+// Option 创建Server时的选项
+type Option func(*serverOptions)
+
+type serverOptions struct {
+	//配置的副本，选项直接修改该副本
+	conf     *config.GlobalObj
+	logger   logger.Logger
+	recorder interfaces.IRecorder
+	packet   interfaces.IDataPack
+	//定时器调度器
+	scheduler *timer.TimerScheduler
+	//额外的监听
+	listeners []*ListenerConfig
+	//是否开启配置中的监听
+	confListeners bool
+}
+
+// WithName 服务器名称
+func WithName(name string) Option {
+	return func(o *serverOptions) { o.conf.Name = name }
+}
+
+// WithIPVersion 默认监听的网络类型，tcp、tcp4或tcp6
+func WithIPVersion(version string) Option {
+	return func(o *serverOptions) { o.conf.IPVersion = version }
+}
+
+// WithAddress 默认监听的地址，port为0时随机分配端口
+func WithAddress(host string, port int) Option {
+	return func(o *serverOptions) {
+		o.conf.Host = host
+		o.conf.TCPPort = port
+	}
+}
+
+// WithUDPPort UDP监听的端口，0表示不启用
+func WithUDPPort(port int) Option {
+	return func(o *serverOptions) { o.conf.UDPPort = port }
+}
+
+// WithMaxConn 最大连接数
+func WithMaxConn(maxConn int) Option {
+	return func(o *serverOptions) { o.conf.MaxConn = maxConn }
+}
+
+// WithMaxPacketSize 允许的最大消息长度，0表示不限制
+func WithMaxPacketSize(size uint32) Option {
+	return func(o *serverOptions) { o.conf.MaxPacketSize = size }
+}
+
+// WithWorkerPool worker池的大小及每个worker的任务队列长度，size为0时每个请求由单独的协程处理
+func WithWorkerPool(size uint, maxTaskLen uint32) Option {
+	return func(o *serverOptions) {
+		o.conf.WorkerPoolSize = size
+		o.conf.MaxWorkerTaskLen = maxTaskLen
+	}
+}
+
+// WithMaxMsgChanLen 每个连接发送缓冲的长度
+func WithMaxMsgChanLen(n uint32) Option {
+	return func(o *serverOptions) { o.conf.MaxMsgChanLen = n }
+}
+
+// WithConnMode 连接处理模式，ConnModeGoroutine或ConnModeEpoll，pollers为0时使用CPU核数
+func WithConnMode(mode string, pollers int) Option {
+	return func(o *serverOptions) {
+		o.conf.ConnMode = mode
+		o.conf.EpollPollers = pollers
+	}
+}
+
+// WithEpollWriteTimeout epoll模式下同步写的超时时间，超时的连接被关闭，0表示不限制
+func WithEpollWriteTimeout(timeout time.Duration) Option {
+	return func(o *serverOptions) { o.conf.EpollWriteTimeout = int(timeout / time.Millisecond) }
+}
+
+// WithHandlerTimeout 消息处理的默认超时时间，0表示不限制
+func WithHandlerTimeout(timeout time.Duration) Option {
+	return func(o *serverOptions) { o.conf.HandlerTimeout = int(timeout / time.Millisecond) }
+}
+
+// WithMachineID 连接ID生成器的机器ID，同一集群中每个进程必须不同，0表示使用私有IP地址的低16位
+func WithMachineID(id uint16) Option {
+	return func(o *serverOptions) { o.conf.MachineID = int(id) }
+}
+
+// WithReusePort 默认监听的SO_REUSEPORT监听个数
+func WithReusePort(n int) Option {
+	return func(o *serverOptions) { o.conf.TCPReusePort = n }
+}
+
+// WithLogger 使用指定的日志，不再根据配置创建
+func WithLogger(l logger.Logger) Option {
+	return func(o *serverOptions) { o.logger = l }
+}
+
+// WithRecorder 使用指定的消息录制，不再根据配置创建
+func WithRecorder(r interfaces.IRecorder) Option {
+	return func(o *serverOptions) { o.recorder = r }
+}
+
+// WithPacket 使用指定的封包拆包方式，此时MaxPacketSize由该对象自己控制
+func WithPacket(dp interfaces.IDataPack) Option {
+	return func(o *serverOptions) { o.packet = dp }
+}
+
+// WithTimerScheduler 使用指定的定时器调度器，不再创建默认的调度器(timer.BackendHeap)，调度器同样随服务器启动及停止
+func WithTimerScheduler(ts *timer.TimerScheduler) Option {
+	return func(o *serverOptions) { o.scheduler = ts }
+}
+
+// WithListener 添加一个监听，与配置中的监听一起开启
+func WithListener(lc *ListenerConfig) Option {
+	return func(o *serverOptions) { o.listeners = append(o.listeners, lc) }
+}
@@ -0,0 +1,124 @@
+package net_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"gonet/config"
+	"gonet/gonettest"
+	"gonet/interfaces"
+	gnet "gonet/net"
+)
+
+type echoRouter struct {
+	gnet.BaseRouter
+}
+
+func (r *echoRouter) Handle(request interfaces.IRequest) {
+	_ = request.GetConn().SendMsg(request.GetMsgID(), request.GetData())
+}
+
+func TestServer_Options(t *testing.T) {
+	t.Parallel()
+	//同一进程中两个配置不同的服务器互不影响
+	small := gonettest.NewServer(gnet.WithName("small"), gnet.WithMaxPacketSize(16), gnet.WithWorkerPool(2, 8))
+	large := gonettest.NewServer(gnet.WithName("large"), gnet.WithMaxPacketSize(1024), gnet.WithWorkerPool(0, 0))
+	for _, s := range []*gonettest.Server{small, large} {
+		s.AddRouter(1, &echoRouter{})
+		s.Start()
+		defer s.Stop()
+	}
+	if small.Config().MaxPacketSize != 16 || large.Config().MaxPacketSize != 1024 {
+		t.Fatal("servers should keep their own config")
+	}
+
+	data := bytes.Repeat([]byte("x"), 64)
+	lc, err := large.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+	if err := lc.SendMsg(1, data); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := lc.ExpectMessage(1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg.GetData(), data) {
+		t.Fatal("unexpected echo")
+	}
+
+	//超过MaxPacketSize的消息导致连接关闭
+	sc, err := small.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	go func() { _ = sc.SendMsg(1, data) }()
+	if _, err := sc.ExpectMessage(1, time.Second); err != gonettest.ErrClosed {
+		t.Fatalf("expected closed connection, got %v", err)
+	}
+}
+
+func TestDial_Config(t *testing.T) {
+	t.Parallel()
+	s := gnet.NewServerWithParam("dial", "tcp4", "127.0.0.1", 0, 10).(*gnet.Server)
+	s.AddRouter(1, &echoRouter{})
+	s.Start()
+	defer s.Stop()
+	addr := s.ListenerAddrs()[gnet.DefaultListenerName].String()
+	data := bytes.Repeat([]byte("x"), 64)
+
+	//客户端的最大消息长度来自传入的配置，超过时断开
+	conf := config.Default()
+	conf.MaxPacketSize = 16
+	small, err := gnet.Dial("tcp4", addr, gnet.WithDialConfig(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer small.Close()
+	if err := small.SendMsg(context.Background(), 1, data[:8]); err != nil {
+		t.Fatal(err)
+	}
+	if err := small.SendMsg(context.Background(), 1, data); err != nil {
+		t.Fatal(err)
+	}
+	received := 0
+	timeout := time.After(time.Second)
+	for done := false; !done; {
+		select {
+		case _, ok := <-small.Messages():
+			if !ok {
+				done = true
+				break
+			}
+			received++
+		case <-timeout:
+			t.Fatal("client should stop reading the oversized reply")
+		}
+	}
+	if received != 1 {
+		t.Fatalf("want 1 message before the oversized reply, got %d", received)
+	}
+
+	//不设置时使用默认配置
+	client, err := gnet.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.SendMsg(context.Background(), 1, data); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-client.Messages():
+		if !bytes.Equal(msg.GetData(), data) {
+			t.Fatalf("unexpected reply %q", msg.GetData())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client should receive the reply")
+	}
+}
@@ -0,0 +1,36 @@
+package net
+
+import (
+	"gonet/interfaces"
+	"gonet/logger"
+	"gonet/record"
+)
+
+// isProtocolMsgID 可靠通道、链路信息等框架内部使用的msgID，不交给路由
+func isProtocolMsgID(msgID uint32) bool {
+	return msgID >= ReliableMsgID
+}
+
+// recordMsg 录制连接上的业务消息，框架内部的封装消息只录制解封装后的内容
+func recordMsg(server interfaces.IServer, conn interfaces.IConnection, dir interfaces.RecordDirection, msg interfaces.IMessage) {
+	if isProtocolMsgID(msg.GetMsgId()) {
+		return
+	}
+	if recorder := server.GetRecorder(); recorder != nil {
+		recorder.Record(conn, dir, msg)
+	}
+}
+
+// recorderFromConfig 配置了录制文件时创建录制
+func recorderFromConfig(path string, log logger.Logger) interfaces.IRecorder {
+	if path == "" {
+		return nil
+	}
+	recorder, err := record.Create(path)
+	if err != nil {
+		log.WithField(logger.FieldError, err).Errorf("create recorder %s failed", path)
+		return nil
+	}
+	log.Infof("recording messages to %s", path)
+	return recorder
+}
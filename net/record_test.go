@@ -0,0 +1,89 @@
+package net
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gonet/interfaces"
+	"gonet/record"
+)
+
+func TestServer_RecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	recorder, err := record.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServerWithParam("record", "tcp4", "127.0.0.1", 0, 100).(*Server)
+	s.SetRecorder(recorder)
+	s.AddRouter(1, &echoRouter{})
+	s.Start()
+
+	conn, err := net.Dial("tcp4", s.ListenerAddrs()[DefaultListenerName].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"first", "second"} {
+		if msg := echoOnce(t, conn, 1, []byte(data)); string(msg.GetData()) != data {
+			t.Fatalf("unexpected echo %q", msg.GetData())
+		}
+	}
+	_ = conn.Close()
+	//Stop时关闭录制
+	s.Stop()
+	time.Sleep(10 * time.Millisecond)
+
+	captures, err := record.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captures) != 4 {
+		t.Fatalf("want 4 captures, got %d", len(captures))
+	}
+	dirs := []interfaces.RecordDirection{interfaces.RecordInbound, interfaces.RecordOutbound, interfaces.RecordInbound, interfaces.RecordOutbound}
+	for i, c := range captures {
+		if c.Direction != dirs[i] || c.MsgID != 1 {
+			t.Fatalf("capture %d: unexpected %+v", i, c)
+		}
+	}
+
+	//使用新的服务器处理方法重放
+	replay := NewServerWithParam("replay", "tcp4", "127.0.0.1", 0, 100).(*Server)
+	replay.AddRouter(1, &echoRouter{})
+	if result := record.Replay(replay.MsgHandler, captures); !result.OK() {
+		t.Fatalf("replay mismatches: %v", result.Mismatches)
+	}
+}
+
+// countRecorder 记录Close的调用次数
+type countRecorder struct {
+	closed int32
+}
+
+func (r *countRecorder) Record(interfaces.IConnection, interfaces.RecordDirection, interfaces.IMessage) {
+}
+
+func (r *countRecorder) Close() error {
+	atomic.AddInt32(&r.closed, 1)
+	return nil
+}
+
+func TestServer_CloseRecorderOnce(t *testing.T) {
+	recorder := &countRecorder{}
+	s := NewServerWithParam("record", "tcp4", "127.0.0.1", 0, 100).(*Server)
+	s.SetRecorder(recorder)
+	s.Start()
+	s.Stop()
+	_ = s.Shutdown(context.Background())
+	if got := atomic.LoadInt32(&recorder.closed); got != 1 {
+		t.Fatalf("recorder should be closed once, got %d", got)
+	}
+	//关闭之后GetRecorder仍然返回同一个录制
+	if s.GetRecorder() != recorder {
+		t.Fatal("recorder should not be reset after close")
+	}
+}
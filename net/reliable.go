@@ -0,0 +1,299 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"gonet/interfaces"
+	"gonet/pack"
+	"sync"
+	"time"
+)
+
+/*
+	可靠消息模块的实现
+	可靠消息使用保留的MsgID进行封装：
+		ReliableMsgID:    Seq(8字节)|MsgID(4字节)|MsgData
+		ReliableAckMsgID: AckSeq(8字节)，表示该序列号及之前的消息均已收到
+	会话以ConnID为key，连接断开后会话保留一段时间，同ConnID的新连接启用可靠通道时重传未确认的消息
+	发送消息时不持有rm.lock，同一会话的消息由会话的发送锁保证按序列号的顺序发送，加锁顺序为发送锁、rm.lock
+*/
+
+const (
+	// ReliableMsgID 可靠消息的封装ID
+	ReliableMsgID uint32 = 0xFFFFFF00
+	// ReliableAckMsgID 可靠消息的确认ID
+	ReliableAckMsgID uint32 = 0xFFFFFF01
+
+	// reliableHeadLen Seq(8字节)+MsgID(4字节)
+	reliableHeadLen = 12
+)
+
+var (
+	ErrReliableWindowFull   = errors.New("reliable window is full")
+	ErrReliableNotEnabled   = errors.New("reliable channel is not enabled")
+	ErrReliableTooManyConns = errors.New("too many reliable sessions")
+)
+
+var _ interfaces.IReliableMgr = (*ReliableManager)(nil)
+
+// reliableMsg 等待确认的消息
+type reliableMsg struct {
+	seq   uint64
+	msgID uint32
+	data  []byte
+}
+
+// reliableSession 可靠会话，记录收发序列号以及未确认的消息
+type reliableSession struct {
+	connID uint64
+	//当前绑定的连接，断开时为nil
+	conn interfaces.IConnection
+	//最后一个分配的发送序列号
+	sendSeq uint64
+	//已经收到的最大序列号
+	recvSeq uint64
+	//按序列号排序的未确认消息
+	pending []*reliableMsg
+	//解除绑定的时间，用于判断保留期
+	detachedAt time.Time
+	//发送消息时持有，保证消息按序列号的顺序发送
+	sendLock sync.Mutex
+}
+
+// ReliableManager 可靠会话的管理模块
+type ReliableManager struct {
+	//每个会话允许的最大未确认消息数
+	WindowSize int
+	//连接断开后会话的保留时间
+	Retention time.Duration
+	//最多保留的会话个数
+	MaxSessions int
+	//ConnID对应的可靠会话
+	sessions map[uint64]*reliableSession
+	//保护会话集合的锁
+	lock sync.Mutex
+}
+
+func NewReliableManager(windowSize int, retention time.Duration, maxSessions int) *ReliableManager {
+	return &ReliableManager{
+		WindowSize:  windowSize,
+		Retention:   retention,
+		MaxSessions: maxSessions,
+		sessions:    make(map[uint64]*reliableSession),
+	}
+}
+
+// Attach 为连接启用可靠通道，如果存在相同ConnID的未过期会话，则恢复会话并重传未确认的消息
+func (rm *ReliableManager) Attach(conn interfaces.IConnection) error {
+	for {
+		session, err := rm.getOrCreateSession(conn.GetConnID())
+		if err != nil {
+			return err
+		}
+		session.sendLock.Lock()
+		rm.lock.Lock()
+		if rm.sessions[session.connID] != session {
+			//加锁之前会话已经被删除，重新获取
+			rm.lock.Unlock()
+			session.sendLock.Unlock()
+			continue
+		}
+		session.conn = conn
+		session.detachedAt = time.Time{}
+		pending := append([]*reliableMsg(nil), session.pending...)
+		rm.lock.Unlock()
+
+		//重连后按序重传所有未确认的消息，客户端根据序列号去重
+		err = nil
+		for _, msg := range pending {
+			if err = conn.SendMsg(ReliableMsgID, encodeReliable(msg)); err != nil {
+				break
+			}
+		}
+		session.sendLock.Unlock()
+		return err
+	}
+}
+
+// getOrCreateSession 获取ConnID对应的会话，不存在时创建一个未绑定连接的会话
+func (rm *ReliableManager) getOrCreateSession(connID uint64) (*reliableSession, error) {
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+
+	if session, ok := rm.sessions[connID]; ok {
+		return session, nil
+	}
+	if rm.MaxSessions > 0 && len(rm.sessions) >= rm.MaxSessions && !rm.evictOldest() {
+		return nil, ErrReliableTooManyConns
+	}
+	session := &reliableSession{connID: connID, detachedAt: time.Now()}
+	rm.sessions[connID] = session
+	return session, nil
+}
+
+// Detach 连接断开时解除绑定，会话会在保留期内等待重连
+func (rm *ReliableManager) Detach(conn interfaces.IConnection) {
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+
+	session, ok := rm.sessions[conn.GetConnID()]
+	if !ok || session.conn != conn {
+		return
+	}
+	session.conn = nil
+	session.detachedAt = time.Now()
+	detachedAt := session.detachedAt
+
+	//保留期过后如果仍未重连，则删除该会话
+	time.AfterFunc(rm.Retention, func() {
+		rm.lock.Lock()
+		defer rm.lock.Unlock()
+		if s, ok := rm.sessions[session.connID]; ok && s == session && s.conn == nil && s.detachedAt.Equal(detachedAt) {
+			delete(rm.sessions, session.connID)
+		}
+	})
+}
+
+// Send 为消息分配序列号，保留至客户端确认后发送
+func (rm *ReliableManager) Send(conn interfaces.IConnection, msgID uint32, data []byte) error {
+	session, err := rm.lockSession(conn)
+	if err != nil {
+		return err
+	}
+	defer session.sendLock.Unlock()
+
+	if rm.WindowSize > 0 && len(session.pending) >= rm.WindowSize {
+		rm.lock.Unlock()
+		return ErrReliableWindowFull
+	}
+	session.sendSeq++
+	msg := &reliableMsg{
+		seq:   session.sendSeq,
+		msgID: msgID,
+		data:  data,
+	}
+	session.pending = append(session.pending, msg)
+	rm.lock.Unlock()
+	return conn.SendMsg(ReliableMsgID, encodeReliable(msg))
+}
+
+// HandleAck 处理客户端发送的确认消息，删除确认序列号及之前的全部消息
+func (rm *ReliableManager) HandleAck(conn interfaces.IConnection, data []byte) error {
+	if len(data) < 8 {
+		return errors.New("reliable ack too short")
+	}
+	ackSeq := binary.LittleEndian.Uint64(data)
+
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+
+	session, err := rm.getSession(conn)
+	if err != nil {
+		return err
+	}
+	index := 0
+	for index < len(session.pending) && session.pending[index].seq <= ackSeq {
+		index++
+	}
+	session.pending = session.pending[index:]
+	return nil
+}
+
+// HandleData 处理客户端发送的可靠消息，返回解封装后的消息，重复的消息返回nil
+func (rm *ReliableManager) HandleData(conn interfaces.IConnection, data []byte) (interfaces.IMessage, error) {
+	if len(data) < reliableHeadLen {
+		return nil, errors.New("reliable msg too short")
+	}
+	seq := binary.LittleEndian.Uint64(data[:8])
+	msgID := binary.LittleEndian.Uint32(data[8:reliableHeadLen])
+
+	rm.lock.Lock()
+	session, err := rm.getSession(conn)
+	if err != nil {
+		rm.lock.Unlock()
+		return nil, err
+	}
+	isNew := seq > session.recvSeq
+	if isNew {
+		session.recvSeq = seq
+	}
+	ack := make([]byte, 8)
+	binary.LittleEndian.PutUint64(ack, session.recvSeq)
+	rm.lock.Unlock()
+
+	//无论是否重复都需要回复确认，避免客户端的确认丢失后无限重传
+	if err := conn.SendMsg(ReliableAckMsgID, ack); err != nil {
+		return nil, err
+	}
+	if !isNew {
+		return nil, nil
+	}
+	return pack.NewMessage(msgID, data[reliableHeadLen:]), nil
+}
+
+// PendingLen 返回连接对应会话中未确认的消息个数
+func (rm *ReliableManager) PendingLen(connID uint64) int {
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+
+	if session, ok := rm.sessions[connID]; ok {
+		return len(session.pending)
+	}
+	return 0
+}
+
+// getSession 获取连接当前绑定的会话，调用方需要持有锁
+func (rm *ReliableManager) getSession(conn interfaces.IConnection) (*reliableSession, error) {
+	session, ok := rm.sessions[conn.GetConnID()]
+	if !ok || session.conn != conn {
+		return nil, ErrReliableNotEnabled
+	}
+	return session, nil
+}
+
+// lockSession 获取连接当前绑定的会话，成功时返回的会话持有发送锁，同时持有rm.lock
+func (rm *ReliableManager) lockSession(conn interfaces.IConnection) (*reliableSession, error) {
+	rm.lock.Lock()
+	session, err := rm.getSession(conn)
+	rm.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	session.sendLock.Lock()
+	rm.lock.Lock()
+	//加锁之前连接可能已经解除绑定
+	if current, err := rm.getSession(conn); err != nil || current != session {
+		rm.lock.Unlock()
+		session.sendLock.Unlock()
+		return nil, ErrReliableNotEnabled
+	}
+	return session, nil
+}
+
+// evictOldest 删除最早断开的会话，调用方需要持有锁
+func (rm *ReliableManager) evictOldest() bool {
+	var oldest *reliableSession
+	for _, session := range rm.sessions {
+		if session.conn != nil {
+			continue
+		}
+		if oldest == nil || session.detachedAt.Before(oldest.detachedAt) {
+			oldest = session
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	delete(rm.sessions, oldest.connID)
+	return true
+}
+
+// encodeReliable Seq|MsgID|MsgData
+func encodeReliable(msg *reliableMsg) []byte {
+	dataBuff := bytes.NewBuffer(make([]byte, 0, reliableHeadLen+len(msg.data)))
+	_ = binary.Write(dataBuff, binary.LittleEndian, msg.seq)
+	_ = binary.Write(dataBuff, binary.LittleEndian, msg.msgID)
+	dataBuff.Write(msg.data)
+	return dataBuff.Bytes()
+}
@@ -0,0 +1,192 @@
+package net
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"gonet/interfaces"
+)
+
+// sentMsg 记录stubConn发送的消息
+type sentMsg struct {
+	msgID uint32
+	data  []byte
+}
+
+// stubConn 只记录发送消息的连接，用于测试可靠会话
+type stubConn struct {
+	connID uint64
+	sent   []sentMsg
+	//不为nil时在每次发送消息时调用
+	onSend func()
+}
+
+func (c *stubConn) Start()                                  {}
+func (c *stubConn) Stop()                                   {}
+func (c *stubConn) Context() context.Context                { return context.Background() }
+func (c *stubConn) GetTCPConnection() *net.TCPConn          { return nil }
+func (c *stubConn) GetConnection() net.Conn                 { return nil }
+func (c *stubConn) GetListenerName() string                 { return "" }
+func (c *stubConn) GetTags() []string                       { return nil }
+func (c *stubConn) HasTag(string) bool                      { return false }
+func (c *stubConn) GetConnID() uint64                       { return c.connID }
+func (c *stubConn) RemoteAddr() net.Addr                    { return nil }
+func (c *stubConn) EnableReliable() error                   { return nil }
+func (c *stubConn) SendReliableMsg(uint32, []byte) error    { return nil }
+func (c *stubConn) SetProperty(string, interface{})         {}
+func (c *stubConn) GetProperty(string) (interface{}, error) { return nil, errors.New("none") }
+func (c *stubConn) DeleteProperty(string)                   {}
+func (c *stubConn) AfterFunc(time.Duration, func(interfaces.IConnection)) interfaces.IConnTimer {
+	return nil
+}
+func (c *stubConn) Every(time.Duration, func(interfaces.IConnection)) interfaces.IConnTimer {
+	return nil
+}
+func (c *stubConn) SendMsg(msgID uint32, data []byte) error {
+	if c.onSend != nil {
+		c.onSend()
+	}
+	c.sent = append(c.sent, sentMsg{msgID: msgID, data: data})
+	return nil
+}
+
+var _ interfaces.IConnection = (*stubConn)(nil)
+
+func ackData(seq uint64) []byte {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, seq)
+	return data
+}
+
+func TestReliableManager_SendAndAck(t *testing.T) {
+	rm := NewReliableManager(2, time.Minute, 0)
+	conn := &stubConn{connID: 1}
+
+	if err := rm.Send(conn, 1, []byte("a")); err != ErrReliableNotEnabled {
+		t.Fatalf("send before attach: got %v", err)
+	}
+	if err := rm.Attach(conn); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := rm.Send(conn, 10, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rm.Send(conn, 10, nil); err != ErrReliableWindowFull {
+		t.Fatalf("expected window full, got %v", err)
+	}
+	for i, msg := range conn.sent {
+		if msg.msgID != ReliableMsgID || binary.LittleEndian.Uint64(msg.data) != uint64(i+1) {
+			t.Fatalf("unexpected frame %d: %+v", i, msg)
+		}
+	}
+
+	if err := rm.HandleAck(conn, ackData(1)); err != nil {
+		t.Fatal(err)
+	}
+	if n := rm.PendingLen(1); n != 1 {
+		t.Fatalf("pending = %d, want 1", n)
+	}
+}
+
+func TestReliableManager_Reconnect(t *testing.T) {
+	rm := NewReliableManager(16, time.Minute, 0)
+	oldConn := &stubConn{connID: 7}
+	_ = rm.Attach(oldConn)
+	_ = rm.Send(oldConn, 1, []byte("a"))
+	_ = rm.Send(oldConn, 2, []byte("b"))
+	_ = rm.HandleAck(oldConn, ackData(1))
+	rm.Detach(oldConn)
+
+	newConn := &stubConn{connID: 7}
+	if err := rm.Attach(newConn); err != nil {
+		t.Fatal(err)
+	}
+	if len(newConn.sent) != 1 {
+		t.Fatalf("retransmitted %d msgs, want 1", len(newConn.sent))
+	}
+	frame := newConn.sent[0].data
+	if seq := binary.LittleEndian.Uint64(frame); seq != 2 || string(frame[reliableHeadLen:]) != "b" {
+		t.Fatalf("unexpected retransmit seq=%d data=%q", seq, frame[reliableHeadLen:])
+	}
+	//新的消息继续使用递增的序列号
+	_ = rm.Send(newConn, 3, nil)
+	if seq := binary.LittleEndian.Uint64(newConn.sent[1].data); seq != 3 {
+		t.Fatalf("seq = %d, want 3", seq)
+	}
+}
+
+func TestReliableManager_SendWithoutLock(t *testing.T) {
+	rm := NewReliableManager(16, time.Minute, 0)
+	conn := &stubConn{connID: 3}
+	_ = rm.Attach(conn)
+	_ = rm.Send(conn, 1, []byte("a"))
+	rm.Detach(conn)
+
+	//发送消息时不持有rm.lock，发送过程中可以再调用ReliableManager的方法
+	newConn := &stubConn{connID: 3}
+	pending := make([]int, 0, 3)
+	newConn.onSend = func() { pending = append(pending, rm.PendingLen(3)) }
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := rm.Attach(newConn); err != nil {
+			t.Error(err)
+		}
+		if err := rm.Send(newConn, 2, nil); err != nil {
+			t.Error(err)
+		}
+		if _, err := rm.HandleData(newConn, encodeReliable(&reliableMsg{seq: 1, msgID: 5})); err != nil {
+			t.Error(err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("SendMsg should be called without holding the manager lock")
+	}
+	if len(pending) != 3 || pending[0] != 1 || pending[1] != 2 || pending[2] != 2 {
+		t.Fatalf("unexpected pending lens %v", pending)
+	}
+}
+
+func TestReliableManager_Retention(t *testing.T) {
+	rm := NewReliableManager(16, 10*time.Millisecond, 1)
+	conn := &stubConn{connID: 1}
+	_ = rm.Attach(conn)
+	_ = rm.Send(conn, 1, nil)
+
+	if err := rm.Attach(&stubConn{connID: 2}); err != ErrReliableTooManyConns {
+		t.Fatalf("expected too many sessions, got %v", err)
+	}
+	rm.Detach(conn)
+	time.Sleep(50 * time.Millisecond)
+	if n := rm.PendingLen(1); n != 0 {
+		t.Fatalf("session should expire, pending = %d", n)
+	}
+}
+
+func TestReliableManager_Dedup(t *testing.T) {
+	rm := NewReliableManager(16, time.Minute, 0)
+	conn := &stubConn{connID: 3}
+	_ = rm.Attach(conn)
+
+	frame := encodeReliable(&reliableMsg{seq: 1, msgID: 5, data: []byte("buy")})
+	msg, err := rm.HandleData(conn, frame)
+	if err != nil || msg == nil || msg.GetMsgId() != 5 || string(msg.GetData()) != "buy" {
+		t.Fatalf("first delivery: msg=%v err=%v", msg, err)
+	}
+	msg, err = rm.HandleData(conn, frame)
+	if err != nil || msg != nil {
+		t.Fatalf("duplicate should be dropped: msg=%v err=%v", msg, err)
+	}
+	//重复消息同样需要回复确认
+	if len(conn.sent) != 2 || conn.sent[1].msgID != ReliableAckMsgID || binary.LittleEndian.Uint64(conn.sent[1].data) != 1 {
+		t.Fatalf("unexpected acks: %+v", conn.sent)
+	}
+}
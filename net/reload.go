@@ -0,0 +1,94 @@
+package net
+
+import (
+	"errors"
+	"gonet/config"
+	"gonet/logger"
+	"strings"
+)
+
+/*
+	配置热加载
+	MaxConn、MaxPacketSize及LogLevel在运行中立即生效，
+	其余配置项(端口、worker池、连接模式等)需要重启服务器，变化时被拒绝并输出日志
+	生效的配置项同时更新到Config返回的配置中，被拒绝的配置项保持原来的值
+*/
+
+// maxPacketSizeSetter 支持运行中修改最大消息长度的封包拆包方式
+type maxPacketSizeSetter interface {
+	SetMaxPacketSize(maxPacketSize uint32)
+}
+
+// ApplyConfig 在运行中应用配置的变化，需要重启才能生效的配置项被忽略，返回的错误中包含这些配置项
+func (s *Server) ApplyConfig(changes []config.Change) error {
+	_, err := s.applyConfig(changes)
+	return err
+}
+
+// applyConfig 应用配置的变化，返回实际生效的配置项
+func (s *Server) applyConfig(changes []config.Change) ([]config.Change, error) {
+	var (
+		applied  []config.Change
+		rejected []string
+	)
+	for _, c := range changes {
+		switch c.Field {
+		case "MaxConn":
+			s.SetMaxConn(c.New.(int))
+		case "MaxPacketSize":
+			dp, ok := s.packet.(maxPacketSizeSetter)
+			if !ok {
+				rejected = append(rejected, c.Field)
+				continue
+			}
+			dp.SetMaxPacketSize(c.New.(uint32))
+		case "LogLevel":
+			level, err := logger.ParseLevel(c.New.(string))
+			if err != nil {
+				rejected = append(rejected, c.Field)
+				continue
+			}
+			s.logger.SetLevel(level)
+		default:
+			rejected = append(rejected, c.Field)
+			continue
+		}
+		applied = append(applied, c)
+		s.logger.Infof("config %s changed from %v to %v", c.Field, c.Old, c.New)
+	}
+	if len(applied) > 0 {
+		s.confLock.Lock()
+		s.conf = config.Apply(s.conf, applied)
+		s.confLock.Unlock()
+	}
+	if len(rejected) > 0 {
+		return applied, errors.New("config changes require restart: " + strings.Join(rejected, ", "))
+	}
+	return applied, nil
+}
+
+// WatchConfig 订阅w中配置的变化并在运行中应用，Stop或Shutdown时取消订阅
+// 以服务器当前生效的配置为基准，通过选项设置的值在文件修改该项之前保持不变
+func (s *Server) WatchConfig(w *config.Watcher) {
+	cancel := w.SubscribeFrom(s.Config(), func(prev *config.GlobalObj, next *config.GlobalObj, changes []config.Change) []config.Change {
+		applied, err := s.applyConfig(changes)
+		if err != nil {
+			s.logger.WithFields(logger.Fields{"path": next.ConfFilePath, logger.FieldError: err}).
+				Warn("config changes rejected, restart the server to apply them")
+		}
+		return applied
+	})
+	s.watchLock.Lock()
+	defer s.watchLock.Unlock()
+	s.unwatchConfig = append(s.unwatchConfig, cancel)
+}
+
+// unwatch 取消全部配置变化的订阅
+func (s *Server) unwatch() {
+	s.watchLock.Lock()
+	defer s.watchLock.Unlock()
+	for _, cancel := range s.unwatchConfig {
+		cancel()
+	}
+	s.unwatchConfig = nil
+}
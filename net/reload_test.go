@@ -0,0 +1,114 @@
+package net_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gonet/config"
+	"gonet/gonettest"
+	"gonet/logger"
+	gnet "gonet/net"
+)
+
+func TestServer_WatchConfig(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "server.ini")
+	if err := os.WriteFile(path, []byte("[Server]\nMaxPacketSize=1024\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := config.NewWatcher(conf, time.Second)
+
+	s := gonettest.NewServer(gnet.WithMaxPacketSize(conf.MaxPacketSize))
+	s.AddRouter(1, &echoRouter{})
+	s.WatchConfig(w)
+	s.Start()
+	defer s.Stop()
+
+	if err := os.WriteFile(path, []byte("[Server]\nMaxPacketSize=16\nMaxConn=5\nTCPPort=9100\n[Log]\nLevel=error\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if s.GetMaxConn() != 5 || s.GetLogger().GetLevel() != logger.ErrorLevel {
+		t.Fatal("safe changes should be applied")
+	}
+	if s.Port == 9100 {
+		t.Fatal("TCPPort should require restart")
+	}
+	//Config只反映已经生效的配置项
+	if c := s.Config(); c.MaxConn != 5 || c.MaxPacketSize != 16 || c.LogLevel != "error" || c.TCPPort == 9100 {
+		t.Fatalf("config should reflect applied changes only, got %+v", c)
+	}
+
+	//新的MaxPacketSize对已经建立的连接同样生效
+	client, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	go func() { _ = client.SendMsg(1, bytes.Repeat([]byte("x"), 64)) }()
+	if _, err := client.ExpectMessage(1, time.Second); err != gonettest.ErrClosed {
+		t.Fatalf("expected closed connection, got %v", err)
+	}
+
+	//Stop之后不再应用配置的变化
+	s.Stop()
+	if err := os.WriteFile(path, []byte("[Server]\nMaxConn=7\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if s.GetMaxConn() != 5 {
+		t.Fatal("stopped server should not watch config")
+	}
+}
+
+func TestServer_WatchConfigKeepsOptions(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "server.ini")
+	if err := os.WriteFile(path, []byte("[Server]\nMaxPacketSize=1024\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := config.NewWatcher(conf, time.Second)
+
+	//选项设置的值与文件不同，修改文件中的其他配置项之后保持不变
+	s := gonettest.NewServer(gnet.WithMaxPacketSize(256))
+	s.WatchConfig(w)
+	s.Start()
+	defer s.Stop()
+
+	if err := os.WriteFile(path, []byte("[Server]\nMaxPacketSize=1024\nMaxConn=5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if c := s.Config(); c.MaxConn != 5 || c.MaxPacketSize != 256 {
+		t.Fatalf("option should be kept until the file changes it, got MaxConn %d MaxPacketSize %d", c.MaxConn, c.MaxPacketSize)
+	}
+}
+
+func TestServer_ApplyConfig(t *testing.T) {
+	t.Parallel()
+	s := gonettest.NewServer()
+	err := s.ApplyConfig([]config.Change{
+		{Field: "MaxConn", Old: 12000, New: 3},
+		{Field: "WorkerPoolSize", Old: uint(10), New: uint(20)},
+	})
+	if err == nil || s.GetMaxConn() != 3 {
+		t.Fatalf("unexpected result %v, MaxConn %d", err, s.GetMaxConn())
+	}
+}
@@ -1,6 +1,9 @@
 package net
 
-import "gonet/interfaces"
+import (
+	"context"
+	"gonet/interfaces"
+)
 
 var _ interfaces.IRequest = (*Request)(nil)
 
@@ -10,6 +13,16 @@ type Request struct {
 	//客户端请求的数据
 	//data []byte
 	msg interfaces.IMessage
+	//请求的ctx，为nil时使用连接的ctx
+	ctx context.Context
+}
+
+// NewRequest 创建一个请求，用于不经过网络直接调用MsgHandle或IRouter
+func NewRequest(conn interfaces.IConnection, msg interfaces.IMessage) *Request {
+	return &Request{
+		conn: conn,
+		msg:  msg,
+	}
 }
 
 // GetConn 得到当前连接
@@ -24,3 +37,19 @@ func (r *Request) GetData() []byte {
 func (r *Request) GetMsgID() uint32 {
 	return r.msg.GetMsgId()
 }
+
+// Context 得到请求的ctx，DoMsgHandle中会根据处理超时时间从连接的ctx派生
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	if r.conn != nil {
+		return r.conn.Context()
+	}
+	return context.Background()
+}
+
+// SetContext 替换请求的ctx
+func (r *Request) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
@@ -0,0 +1,76 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"gonet/interfaces"
+	"gonet/utils"
+)
+
+// ctxRouter 在PreHandle中附加traceID，Handle中等待ctx结束并回复traceID及结束原因
+type ctxRouter struct {
+	BaseRouter
+	errs chan error
+}
+
+func (r *ctxRouter) PreHandle(request interfaces.IRequest) {
+	request.SetContext(utils.WithTraceID(request.Context(), string(request.GetData())))
+}
+
+func (r *ctxRouter) Handle(request interfaces.IRequest) {
+	ctx := request.Context()
+	select {
+	case <-ctx.Done():
+	case <-time.After(3 * time.Second):
+	}
+	r.errs <- ctx.Err()
+	_ = request.GetConn().SendMsg(request.GetMsgID(), []byte(utils.TraceID(ctx)))
+}
+
+func TestRequest_Context(t *testing.T) {
+	s := NewServerWithParam("request-ctx", "tcp4", "127.0.0.1", 0, 100).(*Server)
+	router := &ctxRouter{errs: make(chan error, 2)}
+	s.AddRouter(1, router)
+	s.AddRouter(2, router)
+	s.MsgHandler.SetHandlerTimeout(1, 50*time.Millisecond)
+	s.Start()
+	defer s.Stop()
+	addr := s.ListenerAddrs()[DefaultListenerName].String()
+
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	//超过该msgID的处理超时时间后ctx被取消，请求范围内的值保留
+	start := time.Now()
+	if msg := echoOnce(t, conn, 1, []byte("trace-1")); string(msg.GetData()) != "trace-1" {
+		t.Fatalf("got trace id %q", msg.GetData())
+	}
+	if err := <-router.errs; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("handler timeout took %v", elapsed)
+	}
+
+	//未设置超时的msgID在连接断开时被取消
+	if _, err := conn.Write(mustPack(t, 2, []byte("trace-2"))); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	_ = conn.Close()
+	select {
+	case err := <-router.errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, want canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("request ctx should be canceled when conn stops")
+	}
+}
@@ -1,13 +1,19 @@
 package net
 
 import (
+	"context"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/sony/sonyflake"
 	"gonet/config"
 	"gonet/interfaces"
+	"gonet/logger"
 	"gonet/pack"
-	"net"
+	"gonet/timer"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var _ interfaces.IServer = (*Server)(nil)
@@ -24,50 +30,163 @@ type Server struct {
 	Host string
 	//服务绑定的端口
 	Port int
+	//服务绑定的UDP端口，0表示不启用
+	UDPPort int
+	//UDP监听
+	udp *udpListener
+	//默认监听的SO_REUSEPORT监听个数，大于1时开启多个accept
+	ReusePort int
+	//在创建Connection之前设置到TCP连接上的socket选项
+	SocketOptions SocketOptions
+	//连接处理模式，ConnModeGoroutine或ConnModeEpoll
+	ConnMode string
+	//epoll模式下的poller个数，0表示使用CPU核数
+	EpollPollers int
+	//epoll模式下同步写的超时时间，超时的连接被关闭，0表示不限制
+	EpollWriteTimeout time.Duration
+	//epoll模式下的reactor，在Start时创建
+	reactor reactor
+	//监听配置，为空时只监听Host:Port
+	listenerConfigs []*ListenerConfig
+	//正在运行的监听
+	listeners    []*listener
+	listenerLock sync.Mutex
 	//消息管理模块，用来绑定MsgID和对应的处理业务api关系
 	MsgHandler interfaces.IMsgHandle
 	//该server的连接管理模块
 	ConnMgr interfaces.IConnMgr
+	//该server的可靠消息管理模块
+	ReliableMgr interfaces.IReliableMgr
 	//该Server创建连接之后自动调用函数-OnConnStart()
 	OnConnStart func(interfaces.IConnection)
 	//该Server销毁连接之前自动调用函数-OnConnStop()
 	OnConnStop func(interfaces.IConnection)
 	// ID生成器
 	idGenerator *sonyflake.Sonyflake
-	// 最大连接数
-	MaxConn int
+	//最大连接数，运行中可以通过SetMaxConn修改
+	maxConn int32
+	//每个连接发送缓冲的长度
+	MaxMsgChanLen uint32
+	//创建时使用的配置，运行中生效的配置项会更新到副本中
+	conf     *config.GlobalObj
+	confLock sync.Mutex
 	//封/拆包方式
 	packet interfaces.IDataPack
+	//日志，连接、worker等子模块在此基础上附加各自的字段
+	logger logger.Logger
+	//根据配置创建的fluentd输出，Stop及Shutdown时关闭
+	fluentd *logger.FluentdHook
+	//消息录制，为nil时不录制
+	recorder interfaces.IRecorder
+	//Stop及Shutdown都会关闭录制，只关闭一次
+	closeRecorderOnce sync.Once
+	//定时器调度器，Start时启动，Stop及Shutdown时停止
+	scheduler *timer.TimerScheduler
+	//取消订阅配置变化，Stop时调用
+	unwatchConfig []func()
+	watchLock     sync.Mutex
 }
 
-// NewServer 创建一个服务器句柄
-func NewServer() interfaces.IServer {
-	return NewServerWithParam(
-		config.GlobalServerConfig.Name,
-		config.GlobalServerConfig.IPVersion,
-		config.GlobalServerConfig.Host,
-		config.GlobalServerConfig.TCPPort,
-		config.GlobalServerConfig.MaxConn,
-	)
+// NewServer 使用全局配置创建一个服务器句柄，opts在全局配置的副本上修改，不影响全局配置
+func NewServer(opts ...Option) interfaces.IServer {
+	return NewServerWithConfig(config.GlobalServerConfig, opts...)
 }
 
-// NewServerWithParam 创建一个服务器句柄
+// NewServerWithConfig 使用指定的配置创建一个服务器句柄，配置中的额外监听也会被开启
+func NewServerWithConfig(conf *config.GlobalObj, opts ...Option) interfaces.IServer {
+	return newServer(conf, true, opts...)
+}
+
+// NewServerWithParam 创建一个服务器句柄，只监听host:port，其余参数使用全局配置
 func NewServerWithParam(name string, version string, host string, port int, maxConn int) interfaces.IServer {
+	return newServer(config.GlobalServerConfig, false,
+		WithName(name), WithIPVersion(version), WithAddress(host, port), WithMaxConn(maxConn))
+}
+
+// newServer 各个子模块的参数均从配置的副本中读取，不再读取全局配置
+func newServer(conf *config.GlobalObj, confListeners bool, opts ...Option) *Server {
+	confCopy := *conf
+	o := &serverOptions{conf: &confCopy, confListeners: confListeners}
+	for _, opt := range opts {
+		opt(o)
+	}
+	conf = o.conf
+
 	s := &Server{
-		Name:        name,
-		IPVersion:   version,
-		Host:        host,
-		Port:        port,
-		MsgHandler:  NewMsgHandle(),
-		ConnMgr:     NewConnManager(),
-		packet:      pack.FactoryInstance.NewPack(interfaces.GoNetDataPack),
-		MaxConn:     maxConn,
-		idGenerator: NewIDGenerator(),
+		Name:              conf.Name,
+		IPVersion:         conf.IPVersion,
+		Host:              conf.Host,
+		Port:              conf.TCPPort,
+		MsgHandler:        NewMsgHandleWithConfig(conf),
+		UDPPort:           conf.UDPPort,
+		ConnMgr:           NewConnManager(),
+		ReusePort:         conf.TCPReusePort,
+		SocketOptions:     socketOptionsFromConfig(conf),
+		ConnMode:          conf.ConnMode,
+		EpollPollers:      conf.EpollPollers,
+		EpollWriteTimeout: time.Duration(conf.EpollWriteTimeout) * time.Millisecond,
+		ReliableMgr: NewReliableManager(
+			conf.ReliableWindowSize,
+			time.Duration(conf.ReliableRetention)*time.Second,
+			conf.ReliableMaxSessions,
+		),
+		packet:        o.packet,
+		maxConn:       int32(conf.MaxConn),
+		MaxMsgChanLen: conf.MaxMsgChanLen,
+		conf:          conf,
+	}
+	idGen, err := NewIDGenerator(uint16(conf.MachineID))
+	if err != nil {
+		panic(err)
+	}
+	s.idGenerator = idGen
+	if s.packet == nil {
+		s.packet = pack.FactoryInstance.NewPackWithMaxSize(interfaces.GoNetDataPack, conf.MaxPacketSize)
+	}
+	if o.logger == nil {
+		var l logger.Logger
+		l, s.fluentd = loggerFromConfig(conf)
+		o.logger = l.WithField(logger.FieldServer, conf.Name)
+	}
+	s.SetLogger(o.logger)
+	s.recorder = o.recorder
+	if s.recorder == nil {
+		s.recorder = recorderFromConfig(conf.RecordPath, s.logger)
+	}
+	s.scheduler = o.scheduler
+	if s.scheduler == nil {
+		//使用最小堆，连接定时器及tick房间都需要毫秒级的精度
+		s.scheduler = timer.NewTimerScheduler(
+			timer.WithBackend(timer.BackendHeap),
+			timer.WithExecutor(timer.DefaultExecWorkers, timer.MaxChanBuff),
+			timer.WithErrorHandler(s.timerErrorHandler),
+		)
 	}
 
+	//配置文件中的额外监听
+	if confListeners {
+		for _, lc := range conf.Listeners {
+			listenerConfig, err := ListenerConfigFromConf(lc)
+			if err != nil {
+				s.logger.WithFields(logger.Fields{logger.FieldListener: lc.Name, logger.FieldError: err}).Error("load listener failed")
+				continue
+			}
+			s.AddListener(listenerConfig)
+		}
+	}
+	for _, lc := range o.listeners {
+		s.AddListener(lc)
+	}
 	return s
 }
 
+// Config 返回服务器当前生效的配置，运行中通过ApplyConfig生效的配置项也包含在内
+func (s *Server) Config() *config.GlobalObj {
+	s.confLock.Lock()
+	defer s.confLock.Unlock()
+	return s.conf
+}
+
 // ============== 实现 IServer 里的全部接口方法 ========
 
 func (s *Server) GetID() uint64 {
@@ -76,61 +195,75 @@ func (s *Server) GetID() uint64 {
 
 // Start 开启网络服务
 func (s *Server) Start() {
-	//可以考虑做一个日志模块，将日志写到日志文件中
-	logrus.Info("Server Name: %s, listener at Host: %s, Port is %d is starting ...\n", config.GlobalServerConfig.Name,
-		s.Host, s.Port)
-	//开启一个go去做服务端listener业务
-	go func() {
-		//初始化消息队列及Worker工作池
-		s.MsgHandler.StartWorkerPool()
-
-		//1 获取一个TCP的Addr
-		addr, err := net.ResolveTCPAddr(s.IPVersion, fmt.Sprintf("%s:%d", s.Host, s.Port))
-		if err != nil {
-			fmt.Println("resolve tcp addr err: ", err)
-			return
-		}
+	s.logger.Infof("server is starting, host: %s, port: %d", s.Host, s.Port)
+	//初始化消息队列及Worker工作池
+	s.MsgHandler.StartWorkerPool()
+	s.scheduler.Start()
 
-		//2 监听服务器地址
-		listener, err := net.ListenTCP(s.IPVersion, addr)
+	//epoll模式下创建reactor，不支持时退回goroutine模式
+	if s.ConnMode == ConnModeEpoll {
+		r, err := newReactor(s.EpollPollers)
 		if err != nil {
-			fmt.Println("listen", s.IPVersion, "err", err)
-			return
+			s.logger.WithField(logger.FieldError, err).Error("start epoll reactor failed, fallback to goroutine mode")
+		} else {
+			s.reactor = r
 		}
+	}
 
-		//开始监听
-		fmt.Println("start GoNet server  ", s.Name, " success, now listening...")
-		//3 启动server网络连接业务
-		for {
-			//3.1 阻塞等待客户端建立连接请求
-			conn, err := listener.AcceptTCP()
-			if err != nil {
-				fmt.Println("Accept err ", err)
-				continue
-			}
-
-			//3.2 Server.Start() 设置服务器最大连接控制,如果超过最大连接，那么则关闭此新的连接
-			if s.ConnMgr.GetConnLen() >= s.MaxConn {
-				logrus.Debug("Too many connections MaxConn= ", s.MaxConn)
-				_ = conn.Close()
-				continue
-			}
-			//3.3 Server.Start() 处理该新连接请求的业务方法， 此时应该有 handler 和 conn是绑定的
-			//server和connection集成
+	//有监听失败时不通知热升级的父进程，父进程等待失败后继续提供服务
+	failed := false
+	//开启UDP监听，UDP会话与TCP连接共用消息处理模块及连接管理模块
+	if s.UDPPort > 0 {
+		if err := s.serveUDP(); err != nil {
+			s.logger.WithField(logger.FieldError, err).Error("listen udp failed")
+			failed = true
+		}
+	}
 
-			dealConn := NewConnection(s, conn, s.MsgHandler)
-			dealConn.SetConnID(s.GenNextID())
-			//启动当前的连接业务处理
-			go dealConn.Start()
+	//开启全部监听，所有监听共用消息处理模块及连接管理模块
+	//监听在Start返回前完成，每个监听用一个go来承载accept业务
+	s.listenerLock.Lock()
+	defer s.listenerLock.Unlock()
+	for _, lc := range s.getListenerConfigs() {
+		listeners, err := s.listen(lc)
+		if err != nil {
+			s.logger.WithFields(logger.Fields{logger.FieldListener: lc.Name, logger.FieldError: err}).
+				Errorf("listen %s %s failed", lc.Network, lc.Address)
+			failed = true
+			continue
+		}
+		for _, l := range listeners {
+			s.listeners = append(s.listeners, l)
+			s.logger.WithField(logger.FieldListener, lc.Name).Infof("listening at %s", l.ln.Addr())
+			go s.acceptLoop(l)
+		}
+	}
+	//热升级启动的子进程在监听全部准备完成后通知父进程
+	if failed {
+		if IsUpgradedProcess() {
+			s.logger.Error("some listeners failed, do not notify the parent process")
 		}
-	}()
+		return
+	}
+	notifyReady()
 }
 
 // Stop 关闭网络服务
 func (s *Server) Stop() {
 	//将其他需要清理的连接信息或者其他信息 也要一并停止或者清理
-	logrus.Debug("[Stop] server name = ", s.Name)
+	s.logger.Info("server is stopping")
+	s.closeListeners()
 	s.ConnMgr.ClearConn()
+	if s.udp != nil {
+		s.udp.close()
+	}
+	if s.reactor != nil {
+		s.reactor.close()
+	}
+	s.closeRecorder()
+	s.unwatch()
+	s.stopScheduler(context.Background())
+	s.closeFluentd(context.Background())
 }
 
 func (s *Server) Serve() {
@@ -140,13 +273,17 @@ func (s *Server) Serve() {
 }
 func (s *Server) AddRouter(msgID uint32, router interfaces.IRouter) {
 	s.MsgHandler.AddRouter(msgID, router)
-	fmt.Println("Add Router successful!")
+	s.logger.WithField(logger.FieldMsgID, msgID).Debug("add router")
 }
 
 func (s *Server) GetConnMgr() interfaces.IConnMgr {
 	return s.ConnMgr
 }
 
+func (s *Server) GetReliableMgr() interfaces.IReliableMgr {
+	return s.ReliableMgr
+}
+
 // SetOnConnStart 注册OnConnStart钩子函数的方法
 func (s *Server) SetOnConnStart(hookFunc func(conn interfaces.IConnection)) {
 	s.OnConnStart = hookFunc
@@ -160,7 +297,6 @@ func (s *Server) SetOnConnStop(hookFunc func(conn interfaces.IConnection)) {
 // CallOnConnStart 调用OnConnStart钩子函数的方法
 func (s *Server) CallOnConnStart(conn interfaces.IConnection) {
 	if s.OnConnStart != nil {
-		fmt.Println("----> Call OnConnStart() ...")
 		s.OnConnStart(conn)
 	}
 }
@@ -168,33 +304,148 @@ func (s *Server) CallOnConnStart(conn interfaces.IConnection) {
 // CallOnConnStop 调用OnConnStop钩子函数的方法
 func (s *Server) CallOnConnStop(conn interfaces.IConnection) {
 	if s.OnConnStop != nil {
-		fmt.Println("----> Call OnConnStop() ...")
 		s.OnConnStop(conn)
 	}
 }
 
-// NewIDGenerator 初始化ID生成器
-func NewIDGenerator() *sonyflake.Sonyflake {
+// NewIDGenerator 初始化ID生成器，machineID为0时使用私有IP地址的低16位作为机器ID
+// 没有私有IP地址时(如本地回环测试、部分容器环境)返回错误，需要配置Server.MachineID
+func NewIDGenerator(machineID uint16) (*sonyflake.Sonyflake, error) {
 	var st sonyflake.Settings
-	idGen := sonyflake.NewSonyflake(st)
-	if idGen == nil {
-		panic("sony flake not created")
+	if machineID != 0 {
+		st.MachineID = func() (uint16, error) { return machineID, nil }
 	}
-	return idGen
+	idGen, err := sonyflake.New(st)
+	if err != nil {
+		if machineID == 0 {
+			return nil, fmt.Errorf("create id generator failed, set Server.MachineID: %w", err)
+		}
+		return nil, fmt.Errorf("create id generator with machine id %d failed: %w", machineID, err)
+	}
+	return idGen, nil
 }
+
 func (s *Server) GenNextID() uint64 {
 	id, err := s.idGenerator.NextID()
 	if err != nil {
-		logrus.Warnf("gonet-id-generator generates id failed: %s", err.Error())
+		s.logger.WithField(logger.FieldError, err).Error("gonet-id-generator generates id failed")
 		panic(err)
 	}
 	return id
 }
 
+// GetMaxConn 返回最大连接数
+func (s *Server) GetMaxConn() int {
+	return int(atomic.LoadInt32(&s.maxConn))
+}
+
+// SetMaxConn 修改最大连接数，可以在运行中调用，已经建立的连接不受影响
+func (s *Server) SetMaxConn(maxConn int) {
+	atomic.StoreInt32(&s.maxConn, int32(maxConn))
+}
+
 func (s *Server) Packet() interfaces.IDataPack {
 	return s.packet
 }
 
-func init() {
+// GetLogger 返回该服务器的日志
+func (s *Server) GetLogger() logger.Logger {
+	return s.logger
+}
+
+// SetLogger 替换该服务器的日志，同时替换消息处理模块及连接管理模块的日志
+func (s *Server) SetLogger(l logger.Logger) {
+	s.logger = l
+	if mh, ok := s.MsgHandler.(*MsgHandle); ok {
+		mh.Logger = l
+	}
+	if cm, ok := s.ConnMgr.(*ConnManager); ok {
+		cm.Logger = l
+	}
+}
+
+// GetRecorder 返回消息录制，未开启录制时返回nil
+func (s *Server) GetRecorder() interfaces.IRecorder {
+	return s.recorder
+}
+
+// SetRecorder 设置消息录制，需要在Start之前调用，Stop时会关闭录制
+func (s *Server) SetRecorder(r interfaces.IRecorder) {
+	s.recorder = r
+}
+
+// GetTimerScheduler 返回该服务器的定时器调度器
+func (s *Server) GetTimerScheduler() *timer.TimerScheduler {
+	return s.scheduler
+}
+
+// timerStopTimeout Stop等待定时任务结束的最长时间
+const timerStopTimeout = 5 * time.Second
+
+// stopScheduler 停止定时器调度器，最多等待timerStopTimeout
+func (s *Server) stopScheduler(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, timerStopTimeout)
+	defer cancel()
+	if err := s.scheduler.Stop(ctx); err != nil {
+		s.logger.WithField(logger.FieldError, err).Error("stop timer scheduler failed")
+	}
+}
+
+// timerErrorHandler 使用服务器的日志输出定时任务的错误
+func (s *Server) timerErrorHandler(err *timer.TaskError) {
+	timer.LogTaskError(s.logger, err)
+}
+
+// closeRecorder 关闭消息录制，关闭之后仍在处理的消息调用Record不再录制，不需要重置recorder
+func (s *Server) closeRecorder() {
+	if s.recorder == nil {
+		return
+	}
+	s.closeRecorderOnce.Do(func() {
+		if err := s.recorder.Close(); err != nil {
+			s.logger.WithField(logger.FieldError, err).Error("close recorder failed")
+		}
+	})
+}
+
+// defaultLoggerOnce 第一个根据配置创建的日志同时作为不属于某个服务器的模块(timer、db等)的默认日志
+var defaultLoggerOnce sync.Once
+
+// loggerFromConfig 根据配置创建日志，第一次创建的日志同时设置为默认日志
+// 开启fluentd时日志同时发送给fluentd，DebugMode为false时不再输出到标准输出，返回的hook由调用方关闭
+func loggerFromConfig(conf *config.GlobalObj) (logger.Logger, *logger.FluentdHook) {
+	l := logrus.New()
+	var hook *logger.FluentdHook
+	if conf.FluentdEnable {
+		address := fmt.Sprintf("%s:%d", conf.FluentdHost, conf.FluentdPort)
+		hook = logger.NewFluentdHook(address, conf.FluentdTag)
+		l.AddHook(hook)
+		if !conf.FluentdDebugMode {
+			l.SetOutput(io.Discard)
+		}
+	}
+	log := logger.NewLogrus(l)
+	level, err := logger.ParseLevel(conf.LogLevel)
+	if err != nil {
+		log.WithField(logger.FieldError, err).Warn("parse log level failed")
+	}
+	log.SetLevel(level)
+	defaultLoggerOnce.Do(func() { logger.SetDefault(log) })
+	return log, hook
+}
+
+// fluentdCloseTimeout 关闭fluentd输出时等待剩余日志发送的最长时间
+const fluentdCloseTimeout = 5 * time.Second
 
+// closeFluentd 发送完剩余的日志后关闭fluentd输出，之后的日志不再发送给fluentd，最多等待fluentdCloseTimeout
+// 该日志同时是默认日志时，其他模块的日志也不再发送给fluentd
+func (s *Server) closeFluentd(ctx context.Context) {
+	if s.fluentd == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, fluentdCloseTimeout)
+	defer cancel()
+	if err := s.fluentd.Close(ctx); err != nil {
+		s.logger.WithField(logger.FieldError, err).Error("close fluentd hook failed")
+	}
 }
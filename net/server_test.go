@@ -1,47 +1,198 @@
-package net
+package net_test
 
 import (
-	"fmt"
-	"net"
+	"context"
+	"os"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/sony/sonyflake"
+
+	"gonet/config"
+	"gonet/gonettest"
+	"gonet/interfaces"
+	gnet "gonet/net"
+	"gonet/timer"
 )
 
-func ClientTest() {
-	fmt.Println("Client Test ... start")
-	//阻塞三秒后发起测试请求，给服务端留出开始服务的时间
-	time.Sleep(3 * time.Second)
-	conn, err := net.Dial("tcp", "127.0.0.1:8888")
+// TestMain 测试环境不一定有私有IP地址，使用全局配置的Server均使用固定的机器ID
+func TestMain(m *testing.M) {
+	config.GlobalServerConfig.MachineID = 1
+	os.Exit(m.Run())
+}
+
+func TestNewIDGenerator(t *testing.T) {
+	idGen, err := gnet.NewIDGenerator(42)
 	if err != nil {
-		fmt.Println("client start err: ", err)
-		return
-	}
-	for {
-		_, err = conn.Write([]byte("hello zinx_v1.0"))
-		if err != nil {
-			fmt.Println("write error: ", err)
-			return
-		}
-		buf := make([]byte, 512)
-		cnt, err := conn.Read(buf)
-		if err != nil {
-			fmt.Println("recv buf error: ", err)
-			return
+		t.Fatal(err)
+	}
+	id, err := idGen.NextID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sonyflake.MachineID(id); got != 42 {
+		t.Fatalf("want machine id 42, got %d", got)
+	}
+}
+
+// traceRouter 记录PreHandle、Handle、PostHandle的调用顺序
+type traceRouter struct {
+	gnet.BaseRouter
+	lock  sync.Mutex
+	calls []string
+}
+
+func (r *traceRouter) record(call string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.calls = append(r.calls, call)
+}
+
+func (r *traceRouter) PreHandle(request interfaces.IRequest) {
+	r.record("pre")
+	request.GetConn().SetProperty("user", string(request.GetData()))
+}
+
+func (r *traceRouter) Handle(request interfaces.IRequest) {
+	r.record("handle")
+	user, _ := request.GetConn().GetProperty("user")
+	_ = request.GetConn().SendMsg(request.GetMsgID()+1, []byte("hello "+user.(string)))
+}
+
+func (r *traceRouter) PostHandle(request interfaces.IRequest) {
+	r.record("post")
+}
+
+func TestServer_Router(t *testing.T) {
+	t.Parallel()
+	router := &traceRouter{}
+	conn := gonettest.NewFakeConn(1)
+	mh := gnet.NewMsgHandle()
+	mh.AddRouter(1, router)
+	mh.DoMsgHandle(gonettest.NewRequest(conn, 1, []byte("gonet")))
+
+	msg, err := conn.ExpectMessage(2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.GetData()) != "hello gonet" {
+		t.Fatalf("unexpected reply %q", msg.GetData())
+	}
+	if got := router.calls; len(got) != 3 || got[0] != "pre" || got[1] != "handle" || got[2] != "post" {
+		t.Fatalf("unexpected call order %v", got)
+	}
+
+	//未注册的msgID不回复
+	mh.DoMsgHandle(gonettest.NewRequest(conn, 9, nil))
+	if err := conn.ExpectNoMessage(20 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_Hooks(t *testing.T) {
+	t.Parallel()
+	s := gonettest.NewServer()
+	s.AddRouter(1, &traceRouter{})
+	s.SetOnConnStart(func(conn interfaces.IConnection) {
+		_ = conn.SendMsg(100, []byte("welcome"))
+	})
+	stopped := make(chan uint64, 1)
+	s.SetOnConnStop(func(conn interfaces.IConnection) {
+		stopped <- conn.GetConnID()
+	})
+	s.Start()
+	defer s.Stop()
+
+	client, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ExpectMessage(100, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendMsg(1, []byte("client")); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := client.ExpectMessage(2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.GetData()) != "hello client" {
+		t.Fatalf("unexpected reply %q", msg.GetData())
+	}
+	if s.GetConnMgr().GetConnLen() != 1 {
+		t.Fatalf("want 1 conn, got %d", s.GetConnMgr().GetConnLen())
+	}
+
+	//客户端关闭后服务器停止连接并调用OnConnStop
+	_ = client.Close()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("OnConnStop should be called")
+	}
+}
+
+func TestServer_MaxConn(t *testing.T) {
+	t.Parallel()
+	s := gonettest.NewServer()
+	s.SetMaxConn(1)
+	s.Start()
+	defer s.Stop()
+
+	first, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	deadline := time.Now().Add(time.Second)
+	for s.GetConnMgr().GetConnLen() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("first conn should be accepted")
 		}
-		fmt.Printf("server call back: %s, cnt = %d\n", buf, cnt)
-		time.Sleep(1 * time.Second)
+		time.Sleep(time.Millisecond)
+	}
+
+	//超过最大连接数的连接被直接关闭
+	second, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.ExpectMessage(1, time.Second); err != gonettest.ErrClosed {
+		t.Fatalf("want ErrClosed, got %v", err)
 	}
 }
 
-func TestServer(t *testing.T) {
-	/*
-		服务端测试
-	*/
-	//创建一个server句柄
-	s := NewServer()
+func TestServer_TimerScheduler(t *testing.T) {
+	t.Parallel()
+	s := gonettest.NewServer()
+	ts := s.GetTimerScheduler()
 	s.Start()
-	/*
-		客户端测试
-	*/
-	go ClientTest()
+
+	fired := make(chan struct{}, 2)
+	ts.AfterFunc(timer.NewTaskFunc("fired", func(context.Context) error {
+		fired <- struct{}{}
+		return nil
+	}), 10*time.Millisecond)
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("timer should fire after Start")
+	}
+
+	//服务器停止时调度器随之停止，剩余的定时器不再触发
+	ts.AfterFunc(timer.NewTaskFunc("pending", func(context.Context) error {
+		fired <- struct{}{}
+		return nil
+	}), 100*time.Millisecond)
+	s.Stop()
+	if ts.Context().Err() == nil {
+		t.Fatal("scheduler should be stopped with the server")
+	}
+	select {
+	case <-fired:
+		t.Fatal("timer should not fire after Stop")
+	case <-time.After(200 * time.Millisecond):
+	}
 }
@@ -0,0 +1,74 @@
+package net
+
+import (
+	"crypto/tls"
+	"gonet/config"
+	"net"
+	"time"
+)
+
+// SocketOptions 在创建Connection之前设置到TCP连接上的socket选项
+type SocketOptions struct {
+	//是否关闭Nagle算法
+	NoDelay bool
+	//TCP keepalive周期，0表示使用系统默认值，小于0表示关闭
+	KeepAlive time.Duration
+	//socket接收/发送缓冲区大小，0表示使用系统默认值
+	ReadBuffer  int
+	WriteBuffer int
+	//SO_LINGER(秒)，小于0表示使用系统默认行为
+	Linger int
+}
+
+// socketOptionsFromConfig 从配置中读取socket选项
+func socketOptionsFromConfig(conf *config.GlobalObj) SocketOptions {
+	return SocketOptions{
+		NoDelay:     conf.TCPNoDelay,
+		KeepAlive:   time.Duration(conf.TCPKeepAlive) * time.Second,
+		ReadBuffer:  conf.TCPReadBuffer,
+		WriteBuffer: conf.TCPWriteBuffer,
+		Linger:      conf.TCPLinger,
+	}
+}
+
+// apply 将socket选项设置到连接上，非TCP连接(如unix socket)直接忽略
+func (o *SocketOptions) apply(conn net.Conn) error {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if err := tcpConn.SetNoDelay(o.NoDelay); err != nil {
+		return err
+	}
+	if o.KeepAlive < 0 {
+		if err := tcpConn.SetKeepAlive(false); err != nil {
+			return err
+		}
+	} else if o.KeepAlive > 0 {
+		if err := tcpConn.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := tcpConn.SetKeepAlivePeriod(o.KeepAlive); err != nil {
+			return err
+		}
+	}
+	if o.ReadBuffer > 0 {
+		if err := tcpConn.SetReadBuffer(o.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := tcpConn.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	if o.Linger >= 0 {
+		if err := tcpConn.SetLinger(o.Linger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+//go:build linux
+
+package net
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSocketOptions_Apply(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	opts := SocketOptions{NoDelay: false, ReadBuffer: 64 * 1024, Linger: 0}
+	if err := opts.apply(client); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := client.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var noDelay, rcvBuf int
+	var linger *unix.Linger
+	_ = raw.Control(func(fd uintptr) {
+		noDelay, _ = unix.GetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_NODELAY)
+		rcvBuf, _ = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF)
+		linger, _ = unix.GetsockoptLinger(int(fd), unix.SOL_SOCKET, unix.SO_LINGER)
+	})
+	if noDelay != 0 {
+		t.Fatal("TCP_NODELAY should be off")
+	}
+	//内核会将设置的值翻倍
+	if rcvBuf < 64*1024 {
+		t.Fatalf("SO_RCVBUF = %d, want >= %d", rcvBuf, 64*1024)
+	}
+	if linger == nil || linger.Onoff != 1 || linger.Linger != 0 {
+		t.Fatalf("unexpected SO_LINGER %+v", linger)
+	}
+}
+
+func TestServer_ReusePort(t *testing.T) {
+	s := NewServerWithParam("reuseport", "tcp4", "127.0.0.1", 0, 100).(*Server)
+	s.ReusePort = 4
+	s.AddRouter(1, &echoRouter{})
+	s.Start()
+	defer s.Stop()
+
+	s.listenerLock.Lock()
+	count := len(s.listeners)
+	addr := ""
+	for _, l := range s.listeners {
+		if addr != "" && l.ln.Addr().String() != addr {
+			s.listenerLock.Unlock()
+			t.Fatalf("reuseport listeners on different addr %s and %s", addr, l.ln.Addr())
+		}
+		addr = l.ln.Addr().String()
+	}
+	s.listenerLock.Unlock()
+	if count != 4 {
+		t.Fatalf("got %d listeners, want 4", count)
+	}
+
+	for i := 0; i < 16; i++ {
+		conn, err := net.Dial("tcp4", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg := echoOnce(t, conn, 1, []byte("reuse")); string(msg.GetData()) != "reuse" {
+			t.Fatalf("unexpected echo %q", msg.GetData())
+		}
+		_ = conn.Close()
+	}
+}
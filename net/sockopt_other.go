@@ -0,0 +1,13 @@
+//go:build !linux
+
+package net
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePortControl 当前平台不支持SO_REUSEPORT
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is not supported on this platform")
+}
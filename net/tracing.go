@@ -0,0 +1,49 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"gonet/interfaces"
+	"gonet/pack"
+	"gonet/tracing"
+)
+
+/*
+	链路追踪的头部扩展
+	携带链路信息的消息使用保留的MsgID进行封装：
+		TraceMsgID: HeaderLen(2字节)|Header|MsgID(4字节)|MsgData
+	Header为W3C trace context，由tracing.Inject编码，服务端解封装后作为请求ctx的父span
+*/
+
+// TraceMsgID 携带链路信息的消息的封装ID
+const TraceMsgID uint32 = 0xFFFFFF02
+
+// encodeTrace 将ctx中的链路信息封装到消息中，ctx中没有有效的span时返回原消息
+func encodeTrace(ctx context.Context, msgID uint32, data []byte) (uint32, []byte) {
+	header := tracing.Inject(ctx)
+	if len(header) == 0 {
+		return msgID, data
+	}
+	dataBuff := bytes.NewBuffer(make([]byte, 0, 6+len(header)+len(data)))
+	_ = binary.Write(dataBuff, binary.LittleEndian, uint16(len(header)))
+	dataBuff.Write(header)
+	_ = binary.Write(dataBuff, binary.LittleEndian, msgID)
+	dataBuff.Write(data)
+	return TraceMsgID, dataBuff.Bytes()
+}
+
+// decodeTrace 解封装携带链路信息的消息，返回携带远端span的ctx及原消息
+func decodeTrace(ctx context.Context, data []byte) (context.Context, interfaces.IMessage, error) {
+	if len(data) < 2 {
+		return nil, nil, errors.New("trace msg too short")
+	}
+	headerLen := int(binary.LittleEndian.Uint16(data[:2]))
+	if len(data) < 2+headerLen+4 {
+		return nil, nil, errors.New("trace msg too short")
+	}
+	ctx = tracing.Extract(ctx, data[2:2+headerLen])
+	msgID := binary.LittleEndian.Uint32(data[2+headerLen : 6+headerLen])
+	return ctx, pack.NewMessage(msgID, data[6+headerLen:]), nil
+}
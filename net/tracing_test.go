@@ -0,0 +1,120 @@
+package net
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gonet/interfaces"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/oteltest"
+)
+
+// forwardRouter 将收到的消息通过Client转发给另一个服务，并把对方的回复返回给调用方
+type forwardRouter struct {
+	BaseRouter
+	client *Client
+	msgID  uint32
+}
+
+func (r *forwardRouter) Handle(request interfaces.IRequest) {
+	if err := r.client.SendMsg(request.Context(), r.msgID, request.GetData()); err != nil {
+		return
+	}
+	reply := <-r.client.Messages()
+	_ = request.GetConn().SendMsg(request.GetMsgID(), reply.GetData())
+}
+
+// 在任何服务器创建span之前设置全局TracerProvider
+// otel的全局TracerProvider第一次设置时会替换已经创建的tracer，与正在创建span的worker存在竞争
+func init() {
+	otel.SetTracerProvider(oteltest.NewTracerProvider())
+}
+
+// recordSpans 设置记录span的全局TracerProvider，测试结束后恢复
+func recordSpans(t *testing.T) *oteltest.SpanRecorder {
+	recorder := new(oteltest.SpanRecorder)
+	old := otel.GetTracerProvider()
+	otel.SetTracerProvider(oteltest.NewTracerProvider(oteltest.WithSpanRecorder(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(old) })
+	return recorder
+}
+
+func completedSpans(t *testing.T, recorder *oteltest.SpanRecorder, n int) map[string]*oteltest.Span {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for len(recorder.Completed()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d completed spans, want %d", len(recorder.Completed()), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	spans := make(map[string]*oteltest.Span)
+	for _, span := range recorder.Completed() {
+		spans[span.Name()] = span
+	}
+	return spans
+}
+
+func TestTracing_Propagation(t *testing.T) {
+	recorder := recordSpans(t)
+
+	backend := NewServerWithParam("backend", "tcp4", "127.0.0.1", 0, 100).(*Server)
+	backend.AddRouter(2, &echoRouter{})
+	backend.Start()
+	defer backend.Stop()
+	backendClient, err := Dial("tcp4", backend.ListenerAddrs()[DefaultListenerName].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backendClient.Close()
+
+	frontend := NewServerWithParam("frontend", "tcp4", "127.0.0.1", 0, 100).(*Server)
+	frontend.AddRouter(1, &forwardRouter{client: backendClient, msgID: 2})
+	frontend.Start()
+	defer frontend.Stop()
+	client, err := Dial("tcp4", frontend.ListenerAddrs()[DefaultListenerName].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, root := otel.Tracer("test").Start(context.Background(), "root")
+	if err := client.SendMsg(ctx, 1, []byte("traced")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case reply := <-client.Messages():
+		if string(reply.GetData()) != "traced" {
+			t.Fatalf("unexpected reply %q", reply.GetData())
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no reply")
+	}
+	root.End()
+
+	spans := completedSpans(t, recorder, 5)
+	//root -> send/1 -> handle/1 -> send/2 -> handle/2
+	chain := []string{"root", "gonet.send/1", "gonet.handle/1", "gonet.send/2", "gonet.handle/2"}
+	for i, name := range chain {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("span %s not recorded", name)
+		}
+		if span.SpanContext().TraceID != root.SpanContext().TraceID {
+			t.Fatalf("span %s in another trace", name)
+		}
+		if i > 0 && span.ParentSpanID() != spans[chain[i-1]].SpanContext().SpanID {
+			t.Fatalf("span %s should be child of %s", name, chain[i-1])
+		}
+	}
+}
+
+func TestTracing_WithoutSpan(t *testing.T) {
+	//ctx中没有span时消息不做封装
+	msgID, data := encodeTrace(context.Background(), 1, []byte("plain"))
+	if msgID != 1 || string(data) != "plain" {
+		t.Fatalf("got %d %q", msgID, data)
+	}
+}
@@ -0,0 +1,578 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gonet/config"
+	"gonet/interfaces"
+	"gonet/logger"
+	"gonet/pack"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	UDP传输模块
+	每个远程地址对应一个UDPSession，通过ARQ提供可靠有序的消息通道以及不可靠的有序通道
+	UDPSession实现了IConnection，可以直接使用Server的IRouter、ConnMgr以及连接钩子
+*/
+
+var _ interfaces.IConnection = (*UDPSession)(nil)
+
+var errUDPSessionClosed = errors.New("udp session closed when send msg")
+
+// UDPListenerName UDP会话的监听名称
+const UDPListenerName = "udp"
+
+// udpSettings UDP会话的ARQ参数
+type udpSettings struct {
+	mtu         int
+	sndWnd      int
+	rcvWnd      int
+	interval    time.Duration
+	idleTimeout time.Duration
+}
+
+func udpSettingsFromConfig(conf *config.GlobalObj) udpSettings {
+	return udpSettings{
+		mtu:         conf.UDPMtu,
+		sndWnd:      conf.UDPSendWindow,
+		rcvWnd:      conf.UDPRecvWindow,
+		interval:    time.Duration(conf.UDPInterval) * time.Millisecond,
+		idleTimeout: time.Duration(conf.UDPIdleTimeout) * time.Second,
+	}
+}
+
+// unpackFrame 从一个完整的数据中拆出消息，UDP的消息边界由ARQ保证
+func unpackFrame(dp interfaces.IDataPack, data []byte) (interfaces.IMessage, error) {
+	if uint32(len(data)) < dp.GetHeadLen() {
+		return nil, errors.New("udp frame too short")
+	}
+	msg, err := dp.UnPack(data[:dp.GetHeadLen()])
+	if err != nil {
+		return nil, err
+	}
+	body := data[dp.GetHeadLen():]
+	if uint32(len(body)) < msg.GetMsgLen() {
+		return nil, errors.New("udp frame data too short")
+	}
+	msg.SetMsgData(body[:msg.GetMsgLen()])
+	return msg, nil
+}
+
+// UDPSession 服务端的UDP会话
+type UDPSession struct {
+	//当前session属于哪个server
+	TcpServer interfaces.IServer
+	//会话ID
+	ConnID uint64
+	//消息管理模块
+	MsgHandler interfaces.IMsgHandle
+
+	//所属的UDP监听
+	listener *udpListener
+	//远程地址
+	raddr *net.UDPAddr
+	//ARQ状态机
+	kcp      *arq
+	settings udpSettings
+
+	//最后一次收到数据的时间
+	lastRecv time.Time
+	//当前的会话状态，1表示已经关闭
+	isClosed int32
+	ctx      context.Context
+	cancel   context.CancelFunc
+	sync.RWMutex
+
+	//会话属性集合
+	property     map[string]interface{}
+	propertyLock sync.RWMutex
+
+	//是否已经启用可靠通道
+	reliable bool
+
+	//会话的定时器，会话停止时全部取消
+	timers *ConnTimers
+}
+
+func newUDPSession(l *udpListener, raddr *net.UDPAddr) *UDPSession {
+	s := &UDPSession{
+		TcpServer:  l.server,
+		MsgHandler: l.server.MsgHandler,
+		listener:   l,
+		raddr:      raddr,
+		settings:   l.settings,
+		lastRecv:   time.Now(),
+		property:   make(map[string]interface{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.timers = NewConnTimers(s, l.server.GetTimerScheduler(), func(fn func()) {
+		dispatchTask(s, s.MsgHandler, fn)
+	})
+	s.kcp = newARQ(s.settings.mtu, s.settings.sndWnd, s.settings.rcvWnd, func(packet []byte) {
+		if _, err := l.conn.WriteToUDP(packet, raddr); err != nil {
+			connLogger(s.TcpServer, s).WithField(logger.FieldError, err).Error("udp write failed")
+		}
+	})
+	return s
+}
+
+// Start 启动会话，开始定时驱动ARQ
+func (s *UDPSession) Start() {
+	connLogger(s.TcpServer, s).Debug("udp session start")
+	go s.update()
+	s.TcpServer.CallOnConnStart(s)
+}
+
+// update 定时刷新ARQ，并检查会话是否超时
+func (s *UDPSession) update() {
+	ticker := time.NewTicker(s.settings.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.kcp.Flush(now); err != nil {
+				connLogger(s.TcpServer, s).WithField(logger.FieldError, err).Error("udp session flush failed")
+				s.stop(false)
+				return
+			}
+			s.RLock()
+			idle := now.Sub(s.lastRecv)
+			s.RUnlock()
+			if s.settings.idleTimeout > 0 && idle > s.settings.idleTimeout {
+				connLogger(s.TcpServer, s).Debug("udp session idle timeout")
+				s.stop(true)
+				return
+			}
+		}
+	}
+}
+
+// input 处理监听收到的数据包
+func (s *UDPSession) input(packet []byte) {
+	now := time.Now()
+	unreliable, fin, err := s.kcp.Input(packet, now)
+	if err != nil {
+		connLogger(s.TcpServer, s).WithField(logger.FieldError, err).Error("udp session input failed")
+		return
+	}
+	s.Lock()
+	s.lastRecv = now
+	s.Unlock()
+
+	for _, data := range unreliable {
+		s.deliver(data)
+	}
+	for _, data := range s.kcp.Recv() {
+		s.deliver(data)
+	}
+	//立即回复确认
+	_ = s.kcp.Flush(now)
+	if fin {
+		s.stop(false)
+	}
+}
+
+// deliver 拆包后交给消息处理模块
+func (s *UDPSession) deliver(data []byte) {
+	msg, err := unpackFrame(s.TcpServer.Packet(), data)
+	if err != nil {
+		connLogger(s.TcpServer, s).WithField(logger.FieldError, err).Error("udp session unpack failed")
+		return
+	}
+	dispatchMsg(s, s.TcpServer, s.MsgHandler, msg)
+}
+
+// Stop 停止会话并通知对端
+func (s *UDPSession) Stop() {
+	s.stop(true)
+}
+
+// stop 首先标记为已关闭，之后不持有锁调用OnConnStop及清理，钩子中可以调用会话的方法
+func (s *UDPSession) stop(notify bool) {
+	if !atomic.CompareAndSwapInt32(&s.isClosed, 0, 1) {
+		return
+	}
+	s.RLock()
+	reliable := s.reliable
+	s.RUnlock()
+	connLogger(s.TcpServer, s).Debug("udp session stop")
+	s.TcpServer.CallOnConnStop(s)
+	if notify {
+		s.kcp.Fin()
+	}
+	s.cancel()
+	s.timers.Stop()
+	if reliable {
+		s.TcpServer.GetReliableMgr().Detach(s)
+	}
+	s.listener.removeSession(s)
+	s.TcpServer.GetConnMgr().DeleteConn(s)
+}
+
+// AfterFunc 在d之后于该会话的worker中调用fn，会话停止时自动取消
+func (s *UDPSession) AfterFunc(d time.Duration, fn func(conn interfaces.IConnection)) interfaces.IConnTimer {
+	return s.timers.AfterFunc(d, fn)
+}
+
+// Every 每隔d于该会话的worker中调用一次fn，会话停止时自动停止
+func (s *UDPSession) Every(d time.Duration, fn func(conn interfaces.IConnection)) interfaces.IConnTimer {
+	return s.timers.Every(d, fn)
+}
+
+// Context 获取当前会话的ctx，会话停止时被取消
+func (s *UDPSession) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// GetTCPConnection UDP会话没有TCP套接字
+func (s *UDPSession) GetTCPConnection() *net.TCPConn {
+	return nil
+}
+
+// GetConnection UDP会话共用监听的套接字，没有独立的连接
+func (s *UDPSession) GetConnection() net.Conn {
+	return nil
+}
+
+// GetListenerName UDP会话所属的监听名称
+func (s *UDPSession) GetListenerName() string {
+	return UDPListenerName
+}
+
+// GetTags UDP会话没有标签
+func (s *UDPSession) GetTags() []string {
+	return nil
+}
+
+// HasTag UDP会话没有标签
+func (s *UDPSession) HasTag(string) bool {
+	return false
+}
+
+// GetConnID 获取会话ID
+func (s *UDPSession) GetConnID() uint64 {
+	return s.ConnID
+}
+
+func (s *UDPSession) RemoteAddr() net.Addr {
+	return s.raddr
+}
+
+// SendMsg 通过可靠通道发送消息
+func (s *UDPSession) SendMsg(msgId uint32, data []byte) error {
+	if atomic.LoadInt32(&s.isClosed) == 1 {
+		return errUDPSessionClosed
+	}
+	msg := pack.NewMessage(msgId, data)
+	binaryMsg, err := s.TcpServer.Packet().Pack(msg)
+	if err != nil {
+		return err
+	}
+	recordMsg(s.TcpServer, s, interfaces.RecordOutbound, msg)
+	if err := s.kcp.Send(binaryMsg); err != nil {
+		return err
+	}
+	return s.kcp.Flush(time.Now())
+}
+
+// SendUnreliableMsg 通过不可靠的有序通道发送消息，适用于位置同步等只关心最新状态的消息
+// chn不能为0，不同的chn之间互不影响
+func (s *UDPSession) SendUnreliableMsg(chn uint8, msgId uint32, data []byte) error {
+	if atomic.LoadInt32(&s.isClosed) == 1 {
+		return errUDPSessionClosed
+	}
+	msg := pack.NewMessage(msgId, data)
+	binaryMsg, err := s.TcpServer.Packet().Pack(msg)
+	if err != nil {
+		return err
+	}
+	recordMsg(s.TcpServer, s, interfaces.RecordOutbound, msg)
+	return s.kcp.SendUnreliable(chn, binaryMsg)
+}
+
+// EnableReliable 为当前会话启用跨重连的可靠通道
+func (s *UDPSession) EnableReliable() error {
+	if err := s.TcpServer.GetReliableMgr().Attach(s); err != nil {
+		return err
+	}
+	s.Lock()
+	s.reliable = true
+	s.Unlock()
+	return nil
+}
+
+// SendReliableMsg 发送可靠消息，消息会被分配序列号并保留至客户端确认
+func (s *UDPSession) SendReliableMsg(msgId uint32, data []byte) error {
+	recordMsg(s.TcpServer, s, interfaces.RecordOutbound, pack.NewMessage(msgId, data))
+	return s.TcpServer.GetReliableMgr().Send(s, msgId, data)
+}
+
+// SetProperty 设置会话属性
+func (s *UDPSession) SetProperty(key string, value interface{}) {
+	s.propertyLock.Lock()
+	defer s.propertyLock.Unlock()
+	s.property[key] = value
+}
+
+// GetProperty 获取会话属性
+func (s *UDPSession) GetProperty(key string) (interface{}, error) {
+	s.propertyLock.RLock()
+	defer s.propertyLock.RUnlock()
+	if value, ok := s.property[key]; ok {
+		return value, nil
+	}
+	return nil, errors.New("key doesn't exit")
+}
+
+// DeleteProperty 删除会话属性
+func (s *UDPSession) DeleteProperty(key string) {
+	s.propertyLock.Lock()
+	defer s.propertyLock.Unlock()
+	delete(s.property, key)
+}
+
+// udpListener UDP监听，根据远程地址将数据包分发给对应的会话
+type udpListener struct {
+	server   *Server
+	conn     *net.UDPConn
+	settings udpSettings
+	//远程地址对应的会话
+	sessions map[string]*UDPSession
+	lock     sync.RWMutex
+}
+
+func (l *udpListener) serve() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, raddr, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			l.server.logger.WithFields(logger.Fields{logger.FieldListener: UDPListenerName, logger.FieldError: err}).Error("udp read failed")
+			continue
+		}
+		//不足一个segment头部的数据包不是ARQ数据，直接丢弃
+		if n < arqHeadLen {
+			l.server.logger.WithFields(logger.Fields{logger.FieldListener: UDPListenerName, logger.FieldRemoteAddr: raddr.String()}).Debugf("drop short udp packet, len = %d", n)
+			continue
+		}
+		packet := append([]byte(nil), buf[:n]...)
+		session := l.getSession(raddr, packet)
+		if session != nil {
+			session.input(packet)
+		}
+	}
+}
+
+// getSession 获取远程地址对应的会话，不存在时创建新的会话
+func (l *udpListener) getSession(raddr *net.UDPAddr, packet []byte) *UDPSession {
+	key := raddr.String()
+	l.lock.RLock()
+	session, ok := l.sessions[key]
+	l.lock.RUnlock()
+	if ok {
+		return session
+	}
+	//已经关闭的会话发来的关闭通知不需要创建会话
+	if packet[0] == arqCmdFin {
+		return nil
+	}
+	//不是新会话的第一个数据包时丢弃，避免伪造的数据包创建会话
+	if !isInitialPacket(packet) {
+		l.server.logger.WithFields(logger.Fields{logger.FieldListener: UDPListenerName, logger.FieldRemoteAddr: key}).Debugf("drop invalid initial udp packet, len = %d", len(packet))
+		return nil
+	}
+	if maxConn := l.server.GetMaxConn(); l.server.ConnMgr.GetConnLen() >= maxConn {
+		l.server.logger.WithFields(logger.Fields{logger.FieldListener: UDPListenerName, logger.FieldRemoteAddr: key}).Debugf("too many connections, MaxConn = %d", maxConn)
+		return nil
+	}
+
+	session = newUDPSession(l, raddr)
+	session.ConnID = l.server.GenNextID()
+	l.lock.Lock()
+	l.sessions[key] = session
+	l.lock.Unlock()
+	l.server.ConnMgr.AddConn(session)
+	session.Start()
+	return session
+}
+
+func (l *udpListener) removeSession(s *UDPSession) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.sessions[s.raddr.String()] == s {
+		delete(l.sessions, s.raddr.String())
+	}
+}
+
+// close 关闭UDP套接字并停止剩余的会话，套接字已经关闭，停止时不再通知对端
+func (l *udpListener) close() {
+	_ = l.conn.Close()
+	l.lock.RLock()
+	sessions := make([]*UDPSession, 0, len(l.sessions))
+	for _, session := range l.sessions {
+		sessions = append(sessions, session)
+	}
+	l.lock.RUnlock()
+	for _, session := range sessions {
+		session.stop(false)
+	}
+}
+
+// serveUDP 开启UDP监听，优先使用热升级时父进程传递的UDP套接字
+func (s *Server) serveUDP() error {
+	conn := inheritedUDPConn()
+	if conn == nil {
+		addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", s.Host, s.UDPPort))
+		if err != nil {
+			return err
+		}
+		conn, err = net.ListenUDP("udp", addr)
+		if err != nil {
+			return err
+		}
+	} else {
+		s.logger.WithField(logger.FieldListener, UDPListenerName).Infof("use inherited udp socket at %s", conn.LocalAddr())
+	}
+	s.udp = &udpListener{
+		server:   s,
+		conn:     conn,
+		settings: udpSettingsFromConfig(s.Config()),
+		sessions: make(map[string]*UDPSession),
+	}
+	go s.udp.serve()
+	return nil
+}
+
+// UDPClient UDP客户端，与UDPSession使用相同的ARQ协议
+type UDPClient struct {
+	conn     *net.UDPConn
+	kcp      *arq
+	packet   interfaces.IDataPack
+	settings udpSettings
+	//收到的消息
+	msgChan chan interfaces.IMessage
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// DialUDP 连接一个开启了UDP的GoNet服务器，opts与Dial相同
+func DialUDP(address string, opts ...DialOption) (*UDPClient, error) {
+	o := newDialOptions(opts)
+	raddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	c := &UDPClient{
+		conn:     conn,
+		packet:   pack.FactoryInstance.NewPackWithMaxSize(interfaces.GoNetDataPack, o.conf.MaxPacketSize),
+		settings: udpSettingsFromConfig(o.conf),
+		msgChan:  make(chan interfaces.IMessage, o.conf.MaxMsgChanLen),
+	}
+	c.kcp = newARQ(c.settings.mtu, c.settings.sndWnd, c.settings.rcvWnd, func(packet []byte) {
+		_, _ = conn.Write(packet)
+	})
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	go c.read()
+	go c.update()
+	return c, nil
+}
+
+func (c *UDPClient) read() {
+	defer close(c.msgChan)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			return
+		}
+		unreliable, fin, err := c.kcp.Input(append([]byte(nil), buf[:n]...), time.Now())
+		if err != nil {
+			logger.Default().WithField(logger.FieldError, err).Error("udp client input failed")
+			continue
+		}
+		for _, data := range append(unreliable, c.kcp.Recv()...) {
+			msg, err := unpackFrame(c.packet, data)
+			if err != nil {
+				logger.Default().WithField(logger.FieldError, err).Error("udp client unpack failed")
+				continue
+			}
+			c.msgChan <- msg
+		}
+		_ = c.kcp.Flush(time.Now())
+		if fin {
+			c.cancel()
+			_ = c.conn.Close()
+			return
+		}
+	}
+}
+
+func (c *UDPClient) update() {
+	ticker := time.NewTicker(c.settings.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := c.kcp.Flush(now); err != nil {
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
+// SendMsg 通过可靠通道发送消息
+func (c *UDPClient) SendMsg(msgId uint32, data []byte) error {
+	binaryMsg, err := c.packet.Pack(pack.NewMessage(msgId, data))
+	if err != nil {
+		return err
+	}
+	if err := c.kcp.Send(binaryMsg); err != nil {
+		return err
+	}
+	return c.kcp.Flush(time.Now())
+}
+
+// SendUnreliableMsg 通过不可靠的有序通道发送消息
+func (c *UDPClient) SendUnreliableMsg(chn uint8, msgId uint32, data []byte) error {
+	binaryMsg, err := c.packet.Pack(pack.NewMessage(msgId, data))
+	if err != nil {
+		return err
+	}
+	return c.kcp.SendUnreliable(chn, binaryMsg)
+}
+
+// Messages 返回收到的消息，客户端关闭后通道关闭
+func (c *UDPClient) Messages() <-chan interfaces.IMessage {
+	return c.msgChan
+}
+
+// Close 关闭客户端并通知服务端
+func (c *UDPClient) Close() {
+	select {
+	case <-c.ctx.Done():
+		return
+	default:
+	}
+	c.kcp.Fin()
+	c.cancel()
+	_ = c.conn.Close()
+}
@@ -0,0 +1,309 @@
+package net
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"gonet/interfaces"
+)
+
+// lossyLink 按顺序连接两个arq，每dropEvery个数据包丢弃一个
+type lossyLink struct {
+	dropEvery int
+	count     int
+	queue     [][]byte
+}
+
+func (l *lossyLink) output(packet []byte) {
+	l.count++
+	if l.dropEvery > 0 && l.count%l.dropEvery == 0 {
+		return
+	}
+	l.queue = append(l.queue, append([]byte(nil), packet...))
+}
+
+func (l *lossyLink) deliver(t *testing.T, to *arq, now time.Time) {
+	queue := l.queue
+	l.queue = nil
+	for _, packet := range queue {
+		if _, _, err := to.Input(packet, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestARQ_LossyLink(t *testing.T) {
+	aToB, bToA := &lossyLink{dropEvery: 3}, &lossyLink{dropEvery: 4}
+	a := newARQ(64, 8, 32, aToB.output)
+	b := newARQ(64, 8, 32, bToA.output)
+
+	var want [][]byte
+	for i := 0; i < 20; i++ {
+		//部分消息超过mss，需要分片
+		msg := bytes.Repeat([]byte{byte(i)}, 10+i*7)
+		want = append(want, msg)
+		if err := a.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got [][]byte
+	now := time.Unix(0, 0)
+	for step := 0; step < 1000 && a.WaitSnd() > 0; step++ {
+		now = now.Add(10 * time.Millisecond)
+		if err := a.Flush(now); err != nil {
+			t.Fatal(err)
+		}
+		aToB.deliver(t, b, now)
+		got = append(got, b.Recv()...)
+		if err := b.Flush(now); err != nil {
+			t.Fatal(err)
+		}
+		bToA.deliver(t, a, now)
+	}
+	if a.WaitSnd() != 0 {
+		t.Fatalf("%d segments still unacked", a.WaitSnd())
+	}
+	if len(got) != len(want) {
+		t.Fatalf("received %d msgs, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("msg %d mismatch", i)
+		}
+	}
+}
+
+func TestARQ_UnreliableSequenced(t *testing.T) {
+	var packets [][]byte
+	a := newARQ(64, 8, 8, func(p []byte) { packets = append(packets, append([]byte(nil), p...)) })
+	b := newARQ(64, 8, 8, func([]byte) {})
+	for i := byte(1); i <= 3; i++ {
+		_ = a.SendUnreliable(1, []byte{i})
+	}
+	//乱序到达，旧的消息被丢弃
+	var got []byte
+	for _, index := range []int{0, 2, 1} {
+		msgs, _, _ := b.Input(packets[index], time.Now())
+		for _, msg := range msgs {
+			got = append(got, msg...)
+		}
+	}
+	if !bytes.Equal(got, []byte{1, 3}) {
+		t.Fatalf("got %v, want [1 3]", got)
+	}
+}
+
+// echoRouter 将收到的消息原样返回
+type echoRouter struct {
+	BaseRouter
+}
+
+func (r *echoRouter) Handle(request interfaces.IRequest) {
+	_ = request.GetConn().SendMsg(request.GetMsgID(), request.GetData())
+}
+
+func freeUDPPort(t *testing.T) int {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestUDPSession_Echo(t *testing.T) {
+	s := NewServerWithParam("udp-test", "tcp4", "127.0.0.1", 0, 100).(*Server)
+	s.UDPPort = freeUDPPort(t)
+	s.AddRouter(1, &echoRouter{})
+	started, stopped := make(chan uint64, 1), make(chan uint64, 1)
+	s.SetOnConnStart(func(conn interfaces.IConnection) { started <- conn.GetConnID() })
+	s.SetOnConnStop(func(conn interfaces.IConnection) { stopped <- conn.GetConnID() })
+	s.MsgHandler.StartWorkerPool()
+	if err := s.serveUDP(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	client, err := DialUDP(fmt.Sprintf("127.0.0.1:%d", s.UDPPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//大于MTU的消息会被分片
+	payload := bytes.Repeat([]byte("gonet"), 600)
+	if err := client.SendMsg(1, payload); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-client.Messages():
+		if msg.GetMsgId() != 1 || !bytes.Equal(msg.GetData(), payload) {
+			t.Fatalf("unexpected echo msgID=%d len=%d", msg.GetMsgId(), len(msg.GetData()))
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("echo timeout")
+	}
+
+	connID := <-started
+	if _, err := s.GetConnMgr().GetConn(connID); err != nil {
+		t.Fatal("udp session should be managed by ConnMgr")
+	}
+	client.Close()
+	select {
+	case id := <-stopped:
+		if id != connID {
+			t.Fatalf("stopped %d, want %d", id, connID)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("session should stop after client close")
+	}
+}
+
+func TestUDPSession_SendInStopHook(t *testing.T) {
+	s := NewServerWithParam("udp-stop-hook", "tcp4", "127.0.0.1", 0, 100).(*Server)
+	s.UDPPort = freeUDPPort(t)
+	s.AddRouter(1, &echoRouter{})
+	//钩子中发送消息不能死锁，会话已经标记为关闭，发送返回错误
+	hookErr := make(chan error, 2)
+	s.SetOnConnStop(func(conn interfaces.IConnection) {
+		hookErr <- conn.SendMsg(2, []byte("bye"))
+		hookErr <- conn.(*UDPSession).SendUnreliableMsg(1, 2, []byte("bye"))
+	})
+	s.MsgHandler.StartWorkerPool()
+	if err := s.serveUDP(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	client, err := DialUDP(fmt.Sprintf("127.0.0.1:%d", s.UDPPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendMsg(1, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-client.Messages():
+	case <-time.After(3 * time.Second):
+		t.Fatal("echo timeout")
+	}
+	client.Close()
+	select {
+	case err := <-hookErr:
+		if err != errUDPSessionClosed {
+			t.Fatalf("send in OnConnStop should report the closed session, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("OnConnStop should be called")
+	}
+	if err := <-hookErr; err != errUDPSessionClosed {
+		t.Fatalf("unreliable send in OnConnStop should report the closed session, got %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for s.GetConnMgr().GetConnLen() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("session should be removed after OnConnStop returns")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUDPListener_ShortPacket(t *testing.T) {
+	s := NewServerWithParam("udp-short", "tcp4", "127.0.0.1", 0, 100).(*Server)
+	s.UDPPort = freeUDPPort(t)
+	s.AddRouter(1, &echoRouter{})
+	s.MsgHandler.StartWorkerPool()
+	if err := s.serveUDP(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	raw, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: s.UDPPort})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	//空的及1字节的数据包被丢弃，不创建会话
+	for _, packet := range [][]byte{nil, {arqCmdFin}, {arqCmdPush}} {
+		if _, err := raw.Write(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := s.GetConnMgr().GetConnLen(); n != 0 {
+		t.Fatalf("short packets should not create sessions, got %d", n)
+	}
+
+	//监听仍然正常工作
+	client, err := DialUDP(fmt.Sprintf("127.0.0.1:%d", s.UDPPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.SendMsg(1, []byte("ok")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-client.Messages():
+		if string(msg.GetData()) != "ok" {
+			t.Fatalf("unexpected echo %q", msg.GetData())
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("echo timeout")
+	}
+}
+
+func TestUDPListener_InvalidInitialPacket(t *testing.T) {
+	s := NewServerWithParam("udp-invalid", "tcp4", "127.0.0.1", 0, 100).(*Server)
+	s.UDPPort = freeUDPPort(t)
+	s.MsgHandler.StartWorkerPool()
+	if err := s.serveUDP(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	raw, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: s.UDPPort})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	push := (&arqSegment{cmd: arqCmdPush, data: []byte("hi")}).encode(nil)
+	//长度超过头部但不是新会话第一个数据包的数据包被丢弃
+	packets := [][]byte{
+		bytes.Repeat([]byte{0xFF}, 32),
+		(&arqSegment{cmd: arqCmdAck, sn: 3}).encode(nil),
+		(&arqSegment{cmd: arqCmdPush, sn: 5, data: []byte("hi")}).encode(nil),
+		(&arqSegment{cmd: arqCmdPush, una: 7, data: []byte("hi")}).encode(nil),
+		(&arqSegment{cmd: arqCmdUnreliable, chn: ReliableChannel, sn: 1}).encode(nil),
+		push[:len(push)-1],
+		append(append([]byte(nil), push...), 0),
+	}
+	for _, packet := range packets {
+		if isInitialPacket(packet) {
+			t.Fatalf("packet %v should be invalid", packet)
+		}
+		if _, err := raw.Write(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := s.GetConnMgr().GetConnLen(); n != 0 {
+		t.Fatalf("invalid packets should not create sessions, got %d", n)
+	}
+
+	if !isInitialPacket(push) || !isInitialPacket((&arqSegment{cmd: arqCmdUnreliable, chn: 1, sn: 1}).encode(nil)) {
+		t.Fatal("first segment of a new session should be valid")
+	}
+	if _, err := raw.Write(push); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for s.GetConnMgr().GetConnLen() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("valid initial packet should create a session")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -6,16 +6,30 @@ import (
 	"errors"
 	"gonet/config"
 	"gonet/interfaces"
+	"sync/atomic"
 )
 
 var defaultHeaderLen uint32 = 8
 
 // DataPack 拆包、封包的具体模块
 type DataPack struct {
+	//允许的最大消息长度，0表示不限制，运行中修改需要使用SetMaxPacketSize
+	MaxPacketSize uint32
 }
 
+// NewDataPack 使用全局配置中的最大消息长度创建拆包封包对象
 func NewDataPack() *DataPack {
-	return &DataPack{}
+	return NewDataPackWithMaxSize(config.GlobalServerConfig.MaxPacketSize)
+}
+
+// NewDataPackWithMaxSize 创建拆包封包对象，maxPacketSize为0表示不限制消息长度
+func NewDataPackWithMaxSize(maxPacketSize uint32) *DataPack {
+	return &DataPack{MaxPacketSize: maxPacketSize}
+}
+
+// SetMaxPacketSize 修改允许的最大消息长度，可以在拆包的同时调用
+func (d *DataPack) SetMaxPacketSize(maxPacketSize uint32) {
+	atomic.StoreUint32(&d.MaxPacketSize, maxPacketSize)
 }
 
 // GetHeadLen 获取包的头部长度
@@ -57,7 +71,7 @@ func (d *DataPack) UnPack(binaryData []byte) (interfaces.IMessage, error) {
 		return nil, err
 	}
 	//判断是否已经超出了允许的MaxPackageSize
-	if config.GlobalServerConfig.MaxPacketSize > 0 && msg.DataLen > config.GlobalServerConfig.MaxPacketSize {
+	if maxPacketSize := atomic.LoadUint32(&d.MaxPacketSize); maxPacketSize > 0 && msg.DataLen > maxPacketSize {
 		return nil, errors.New("msg beyond the limitation")
 	}
 	//这里只需要把head的数据拆包出来就可以了，然后再通过head的长度，再从conn读取一次数据
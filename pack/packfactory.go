@@ -1,6 +1,7 @@
 package pack
 
 import (
+	"gonet/config"
 	"gonet/interfaces"
 	"sync"
 )
@@ -20,15 +21,20 @@ func init() {
 	})
 }
 
-// NewPack 创建一个具体的拆包解包对象
+// NewPack 创建一个具体的拆包解包对象，最大消息长度使用全局配置
 // 工厂方法的设计模式
 func (f *packFactory) NewPack(kind string) interfaces.IDataPack {
+	return f.NewPackWithMaxSize(kind, config.GlobalServerConfig.MaxPacketSize)
+}
+
+// NewPackWithMaxSize 创建一个具体的拆包解包对象，maxPacketSize为0表示不限制消息长度
+func (f *packFactory) NewPackWithMaxSize(kind string, maxPacketSize uint32) interfaces.IDataPack {
 	var dataPack interfaces.IDataPack
 	switch kind {
 	case interfaces.GoNetDataPack:
-		dataPack = NewDataPack()
+		dataPack = NewDataPackWithMaxSize(maxPacketSize)
 	default:
-		dataPack = NewDataPack()
+		dataPack = NewDataPackWithMaxSize(maxPacketSize)
 	}
 	return dataPack
 }
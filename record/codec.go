@@ -0,0 +1,199 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gonet/config"
+	"gonet/interfaces"
+	"io"
+	"os"
+	"time"
+)
+
+/*
+	二进制格式：
+		文件头 Magic(4字节)|Version(1字节)
+		每条录制 UnixNano(8字节)|ConnID(8字节)|Direction(1字节)|MsgID(4字节)|DataLen(4字节)|Data
+	与消息的封包一致使用小端序
+	JSONL格式：
+		{"time":"...","conn_id":1,"dir":"in","msg_id":1,"data":"base64"}
+*/
+
+const (
+	binaryMagic   = "GNRC"
+	binaryVersion = 1
+	// captureHeadLen UnixNano+ConnID+Direction+MsgID+DataLen
+	captureHeadLen = 8 + 8 + 1 + 4 + 4
+)
+
+// jsonCapture JSONL中的一行
+type jsonCapture struct {
+	Time   time.Time `json:"time"`
+	ConnID uint64    `json:"conn_id"`
+	Dir    string    `json:"dir"`
+	MsgID  uint32    `json:"msg_id"`
+	Data   []byte    `json:"data"`
+}
+
+func appendFileHeader(buf []byte) []byte {
+	buf = append(buf, binaryMagic...)
+	return append(buf, binaryVersion)
+}
+
+func appendCapture(buf []byte, format Format, c *Capture) ([]byte, error) {
+	if format == FormatJSONL {
+		line, err := json.Marshal(jsonCapture{
+			Time:   c.Time,
+			ConnID: c.ConnID,
+			Dir:    c.Direction.String(),
+			MsgID:  c.MsgID,
+			Data:   c.Data,
+		})
+		if err != nil {
+			return buf, err
+		}
+		buf = append(buf, line...)
+		return append(buf, '\n'), nil
+	}
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(c.Time.UnixNano()))
+	buf = binary.LittleEndian.AppendUint64(buf, c.ConnID)
+	buf = append(buf, byte(c.Direction))
+	buf = binary.LittleEndian.AppendUint32(buf, c.MsgID)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(c.Data)))
+	return append(buf, c.Data...), nil
+}
+
+func parseDirection(s string) (interfaces.RecordDirection, error) {
+	switch s {
+	case "in":
+		return interfaces.RecordInbound, nil
+	case "out":
+		return interfaces.RecordOutbound, nil
+	}
+	return 0, fmt.Errorf("unknown direction %q", s)
+}
+
+// Reader 读取录制文件，根据文件头自动识别格式
+type Reader struct {
+	r      *bufio.Reader
+	format Format
+	line   int
+}
+
+// NewReader 创建录制的读取，以Magic开头的为二进制格式，否则为JSONL
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	rd := &Reader{r: br, format: FormatJSONL}
+	head, err := br.Peek(len(binaryMagic) + 1)
+	if err == nil && string(head[:len(binaryMagic)]) == binaryMagic {
+		if head[len(binaryMagic)] != binaryVersion {
+			return nil, fmt.Errorf("unsupported record version %d", head[len(binaryMagic)])
+		}
+		_, _ = br.Discard(len(head))
+		rd.format = FormatBinary
+	}
+	return rd, nil
+}
+
+// Format 返回录制文件的格式
+func (rd *Reader) Format() Format {
+	return rd.format
+}
+
+// Next 读取下一条录制，读取完毕时返回io.EOF
+func (rd *Reader) Next() (*Capture, error) {
+	if rd.format == FormatJSONL {
+		return rd.nextJSON()
+	}
+	return rd.nextBinary()
+}
+
+func (rd *Reader) nextBinary() (*Capture, error) {
+	head := make([]byte, captureHeadLen)
+	if _, err := io.ReadFull(rd.r, head); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated record")
+		}
+		return nil, err
+	}
+	c := &Capture{
+		Time:      time.Unix(0, int64(binary.LittleEndian.Uint64(head[0:8]))),
+		ConnID:    binary.LittleEndian.Uint64(head[8:16]),
+		Direction: interfaces.RecordDirection(head[16]),
+		MsgID:     binary.LittleEndian.Uint32(head[17:21]),
+	}
+	dataLen := binary.LittleEndian.Uint32(head[21:25])
+	//长度超过数据包的上限时文件已经损坏，不按该长度分配内存
+	if dataLen > config.MaxPacketSizeLimit {
+		return nil, fmt.Errorf("record data length %d exceeds limit %d", dataLen, config.MaxPacketSizeLimit)
+	}
+	if dataLen > 0 {
+		c.Data = make([]byte, dataLen)
+		if _, err := io.ReadFull(rd.r, c.Data); err != nil {
+			return nil, errors.New("truncated record data")
+		}
+	}
+	return c, nil
+}
+
+func (rd *Reader) nextJSON() (*Capture, error) {
+	for {
+		line, err := rd.r.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return nil, err
+		}
+		rd.line++
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var jc jsonCapture
+		if err := json.Unmarshal(line, &jc); err != nil {
+			return nil, fmt.Errorf("line %d: %v", rd.line, err)
+		}
+		dir, err := parseDirection(jc.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", rd.line, err)
+		}
+		return &Capture{
+			Time:      jc.Time,
+			ConnID:    jc.ConnID,
+			Direction: dir,
+			MsgID:     jc.MsgID,
+			Data:      jc.Data,
+		}, nil
+	}
+}
+
+// ReadAll 读取r中全部的录制
+func ReadAll(r io.Reader) ([]Capture, error) {
+	rd, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var captures []Capture
+	for {
+		c, err := rd.Next()
+		if err == io.EOF {
+			return captures, nil
+		}
+		if err != nil {
+			return captures, err
+		}
+		captures = append(captures, *c)
+	}
+}
+
+// Load 读取录制文件
+func Load(path string) ([]Capture, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadAll(file)
+}
@@ -0,0 +1,102 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"gonet/interfaces"
+	"gonet/pack"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubConn 只提供ConnID的连接
+type stubConn struct {
+	interfaces.IConnection
+	id uint64
+}
+
+func (c *stubConn) GetConnID() uint64 {
+	return c.id
+}
+
+func (c *stubConn) Context() context.Context {
+	return context.Background()
+}
+
+func (c *stubConn) RemoteAddr() net.Addr {
+	return nil
+}
+
+func TestRecorder_RoundTrip(t *testing.T) {
+	for _, name := range []string{"session.bin", "session.jsonl"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			r, err := Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			//只录制ConnID为1的连接
+			r.Filter = func(conn interfaces.IConnection) bool { return conn.GetConnID() == 1 }
+			start := time.Now()
+			r.Record(&stubConn{id: 1}, interfaces.RecordInbound, pack.NewMessage(1, []byte("ping")))
+			r.Record(&stubConn{id: 2}, interfaces.RecordInbound, pack.NewMessage(1, []byte("skip")))
+			r.Record(&stubConn{id: 1}, interfaces.RecordOutbound, pack.NewMessage(2, nil))
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+			r.Record(&stubConn{id: 1}, interfaces.RecordInbound, pack.NewMessage(3, []byte("closed")))
+
+			captures, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(captures) != 2 {
+				t.Fatalf("want 2 captures, got %d", len(captures))
+			}
+			want := []Capture{
+				{ConnID: 1, Direction: interfaces.RecordInbound, MsgID: 1, Data: []byte("ping")},
+				{ConnID: 1, Direction: interfaces.RecordOutbound, MsgID: 2},
+			}
+			for i, c := range captures {
+				if c.Time.Before(start.Add(-time.Second)) || c.Time.After(time.Now()) {
+					t.Fatalf("capture %d has unexpected time %v", i, c.Time)
+				}
+				c.Time = time.Time{}
+				if !reflect.DeepEqual(c, want[i]) {
+					t.Fatalf("capture %d: want %+v, got %+v", i, want[i], c)
+				}
+			}
+		})
+	}
+}
+
+func TestReader_Errors(t *testing.T) {
+	if _, err := ReadAll(bytes.NewBufferString("{\"dir\":\"sideways\"}\n")); err == nil {
+		t.Fatal("unknown direction should fail")
+	}
+	var buf bytes.Buffer
+	r := NewRecorder(&buf, FormatBinary)
+	r.Record(&stubConn{id: 1}, interfaces.RecordInbound, pack.NewMessage(1, []byte("truncated")))
+	_ = r.Close()
+	captures, err := ReadAll(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	if err == nil || len(captures) != 0 {
+		t.Fatalf("truncated record should fail, got %v %v", captures, err)
+	}
+	//损坏的DataLen超过上限时返回错误，不按该长度分配内存
+	data := append([]byte(nil), buf.Bytes()...)
+	binary.LittleEndian.PutUint32(data[len(binaryMagic)+1+captureHeadLen-4:], 0xFFFFFFFF)
+	if captures, err := ReadAll(bytes.NewReader(data)); err == nil || !strings.Contains(err.Error(), "exceeds limit") {
+		t.Fatalf("corrupt data length should fail, got %v %v", captures, err)
+	}
+	//空的二进制录制只有文件头
+	buf.Reset()
+	_ = NewRecorder(&buf, FormatBinary).Close()
+	if captures, err := ReadAll(&buf); err != nil || len(captures) != 0 {
+		t.Fatalf("empty record: %v %v", captures, err)
+	}
+}
@@ -0,0 +1,137 @@
+package record
+
+import (
+	"errors"
+	"gonet/interfaces"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/*
+	消息录制
+	每条录制包含时间、连接ID、方向、msgID及消息内容，可以写为紧凑的二进制格式或JSONL格式
+	录制的文件可以通过Replay交给服务器的MsgHandle重放，并与录制时的发送结果进行比较
+*/
+
+var _ interfaces.IRecorder = (*Recorder)(nil)
+
+// Format 录制文件的格式
+type Format int
+
+const (
+	// FormatBinary 紧凑的二进制格式
+	FormatBinary Format = iota
+	// FormatJSONL 每行一条JSON，便于直接查看
+	FormatJSONL
+)
+
+// FormatFromPath 根据文件扩展名选择格式，.jsonl及.json为JSONL，其他为二进制
+func FormatFromPath(path string) Format {
+	switch filepath.Ext(path) {
+	case ".jsonl", ".json":
+		return FormatJSONL
+	}
+	return FormatBinary
+}
+
+// Capture 一条录制的消息
+type Capture struct {
+	Time      time.Time
+	ConnID    uint64
+	Direction interfaces.RecordDirection
+	MsgID     uint32
+	Data      []byte
+}
+
+// Recorder 将消息写入文件，多个连接可以并发录制
+type Recorder struct {
+	// Filter 只录制返回true的连接，为nil时录制全部连接
+	Filter func(conn interfaces.IConnection) bool
+
+	format Format
+	w      io.Writer
+	lock   sync.Mutex
+	buf    []byte
+	//第一次写入失败的错误，之后的录制被丢弃
+	err    error
+	closed bool
+}
+
+// NewRecorder 创建写入w的录制，w实现io.Closer时Close会关闭w
+func NewRecorder(w io.Writer, format Format) *Recorder {
+	r := &Recorder{
+		format: format,
+		w:      w,
+	}
+	if format == FormatBinary {
+		r.buf = appendFileHeader(r.buf)
+	}
+	return r
+}
+
+// Create 创建录制文件，格式由文件扩展名决定
+func Create(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecorder(file, FormatFromPath(path)), nil
+}
+
+// Record 录制一条消息，每条消息直接写入，进程异常退出时不会丢失已经录制的消息
+func (r *Recorder) Record(conn interfaces.IConnection, dir interfaces.RecordDirection, msg interfaces.IMessage) {
+	if r.Filter != nil && !r.Filter(conn) {
+		return
+	}
+	c := Capture{
+		Time:      time.Now(),
+		ConnID:    conn.GetConnID(),
+		Direction: dir,
+		MsgID:     msg.GetMsgId(),
+		Data:      msg.GetData(),
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed || r.err != nil {
+		return
+	}
+	var err error
+	r.buf, err = appendCapture(r.buf, r.format, &c)
+	if err != nil {
+		r.buf = r.buf[:0]
+		return
+	}
+	_, r.err = r.w.Write(r.buf)
+	r.buf = r.buf[:0]
+}
+
+// Err 返回写入失败的错误
+func (r *Recorder) Err() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.err
+}
+
+// Close 停止录制，返回录制过程中写入失败的错误
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return errors.New("recorder already closed")
+	}
+	r.closed = true
+	//没有录制任何消息时也要写入文件头
+	if len(r.buf) > 0 && r.err == nil {
+		_, r.err = r.w.Write(r.buf)
+	}
+	if closer, ok := r.w.(io.Closer); ok {
+		if err := closer.Close(); err != nil && r.err == nil {
+			r.err = err
+		}
+	}
+	return r.err
+}
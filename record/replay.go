@@ -0,0 +1,293 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"gonet/interfaces"
+	"gonet/pack"
+	"gonet/timer"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+	重放
+	将录制中收到的消息按原有顺序交给MsgHandle处理，每个录制的连接对应一个内存中的连接
+	处理过程中发送的消息会被收集，并与录制时发送的消息逐条比较
+*/
+
+// Mismatch 重放结果与录制不一致的一条发送消息，Expected或Actual为nil表示缺少或多出的消息
+type Mismatch struct {
+	ConnID   uint64
+	Index    int
+	Expected *Capture
+	Actual   *Capture
+}
+
+func (m Mismatch) String() string {
+	switch {
+	case m.Expected == nil:
+		return fmt.Sprintf("conn %d out #%d: unexpected msgID %d data %q", m.ConnID, m.Index, m.Actual.MsgID, m.Actual.Data)
+	case m.Actual == nil:
+		return fmt.Sprintf("conn %d out #%d: missing msgID %d data %q", m.ConnID, m.Index, m.Expected.MsgID, m.Expected.Data)
+	}
+	return fmt.Sprintf("conn %d out #%d: want msgID %d data %q, got msgID %d data %q",
+		m.ConnID, m.Index, m.Expected.MsgID, m.Expected.Data, m.Actual.MsgID, m.Actual.Data)
+}
+
+// Result 重放的结果
+type Result struct {
+	// Outputs 重放过程中每个连接发送的消息
+	Outputs map[uint64][]Capture
+	// Mismatches 与录制中发送的消息不一致的部分
+	Mismatches []Mismatch
+}
+
+// OK 重放结果与录制完全一致
+func (r *Result) OK() bool {
+	return len(r.Mismatches) == 0
+}
+
+// Replay 将录制中收到的消息依次交给handler同步处理，并与录制时发送的消息比较
+// 处理方法中通过其他协程发送的消息只有在Replay返回前发送才会被收集
+func Replay(handler interfaces.IMsgHandle, captures []Capture) *Result {
+	conns := make(map[uint64]*replayConn)
+	expected := make(map[uint64][]Capture)
+	for i := range captures {
+		c := &captures[i]
+		conn, ok := conns[c.ConnID]
+		if !ok {
+			conn = newReplayConn(c.ConnID)
+			conns[c.ConnID] = conn
+		}
+		switch c.Direction {
+		case interfaces.RecordInbound:
+			handler.DoMsgHandle(&replayRequest{conn: conn, msg: pack.NewMessage(c.MsgID, c.Data)})
+		case interfaces.RecordOutbound:
+			expected[c.ConnID] = append(expected[c.ConnID], *c)
+		}
+	}
+
+	result := &Result{Outputs: make(map[uint64][]Capture)}
+	ids := make([]uint64, 0, len(conns))
+	for id, conn := range conns {
+		conn.Stop()
+		result.Outputs[id] = conn.outputs()
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		result.Mismatches = append(result.Mismatches, Diff(id, expected[id], result.Outputs[id])...)
+	}
+	return result
+}
+
+// Diff 逐条比较一个连接发送的msgID及内容，不比较时间
+func Diff(connID uint64, expected, actual []Capture) []Mismatch {
+	var mismatches []Mismatch
+	for i := 0; i < len(expected) || i < len(actual); i++ {
+		m := Mismatch{ConnID: connID, Index: i}
+		if i < len(expected) {
+			m.Expected = &expected[i]
+		}
+		if i < len(actual) {
+			m.Actual = &actual[i]
+		}
+		if m.Expected != nil && m.Actual != nil &&
+			m.Expected.MsgID == m.Actual.MsgID && bytes.Equal(m.Expected.Data, m.Actual.Data) {
+			continue
+		}
+		mismatches = append(mismatches, m)
+	}
+	return mismatches
+}
+
+var _ interfaces.IRequest = (*replayRequest)(nil)
+
+// replayRequest 重放的请求
+type replayRequest struct {
+	conn interfaces.IConnection
+	msg  interfaces.IMessage
+	ctx  context.Context
+}
+
+func (r *replayRequest) GetConn() interfaces.IConnection {
+	return r.conn
+}
+
+func (r *replayRequest) GetData() []byte {
+	return r.msg.GetData()
+}
+
+func (r *replayRequest) GetMsgID() uint32 {
+	return r.msg.GetMsgId()
+}
+
+func (r *replayRequest) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return r.conn.Context()
+}
+
+func (r *replayRequest) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+var _ interfaces.IConnection = (*replayConn)(nil)
+
+// replayConn 重放使用的内存连接，发送的消息被收集而不是写入网络
+type replayConn struct {
+	connID uint64
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock     sync.Mutex
+	sent     []Capture
+	closed   bool
+	property map[string]interface{}
+	timers   []*timer.Timer
+	jobs     []*timer.Job
+}
+
+// replayScheduler 重放时连接的定时器使用的调度器，不会启动，定时器不会触发，保证重放的结果只取决于录制的消息
+var replayScheduler = timer.NewTimerScheduler()
+
+func newReplayConn(connID uint64) *replayConn {
+	c := &replayConn{
+		connID:   connID,
+		property: make(map[string]interface{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+func (c *replayConn) outputs() []Capture {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]Capture(nil), c.sent...)
+}
+
+func (c *replayConn) Start() {}
+
+func (c *replayConn) Stop() {
+	c.lock.Lock()
+	c.closed = true
+	timers, jobs := c.timers, c.jobs
+	c.timers, c.jobs = nil, nil
+	c.lock.Unlock()
+	c.cancel()
+	for _, t := range timers {
+		t.Stop()
+	}
+	for _, job := range jobs {
+		job.Stop()
+	}
+}
+
+// AfterFunc 重放时定时器不会触发
+func (c *replayConn) AfterFunc(d time.Duration, fn func(conn interfaces.IConnection)) interfaces.IConnTimer {
+	t := replayScheduler.AfterFunc(timer.NewDelayFunc(func(...interface{}) { fn(c) }, nil), d)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		t.Stop()
+	} else {
+		c.timers = append(c.timers, t)
+	}
+	return t
+}
+
+// Every 重放时定时任务不会触发
+func (c *replayConn) Every(d time.Duration, fn func(conn interfaces.IConnection)) interfaces.IConnTimer {
+	job := replayScheduler.Every(timer.NewDelayFunc(func(...interface{}) { fn(c) }, nil), d)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		job.Stop()
+	} else {
+		c.jobs = append(c.jobs, job)
+	}
+	return job
+}
+
+func (c *replayConn) Context() context.Context {
+	return c.ctx
+}
+
+func (c *replayConn) GetTCPConnection() *net.TCPConn {
+	return nil
+}
+
+func (c *replayConn) GetConnection() net.Conn {
+	return nil
+}
+
+func (c *replayConn) GetListenerName() string {
+	return "replay"
+}
+
+func (c *replayConn) GetTags() []string {
+	return nil
+}
+
+func (c *replayConn) HasTag(string) bool {
+	return false
+}
+
+func (c *replayConn) GetConnID() uint64 {
+	return c.connID
+}
+
+func (c *replayConn) RemoteAddr() net.Addr {
+	return nil
+}
+
+func (c *replayConn) SendMsg(msgID uint32, data []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return errors.New("replay conn closed when send msg")
+	}
+	c.sent = append(c.sent, Capture{
+		ConnID:    c.connID,
+		Direction: interfaces.RecordOutbound,
+		MsgID:     msgID,
+		Data:      append([]byte(nil), data...),
+	})
+	return nil
+}
+
+func (c *replayConn) EnableReliable() error {
+	return nil
+}
+
+// SendReliableMsg 录制中可靠消息按解封装后的msgID记录，重放时与普通消息相同
+func (c *replayConn) SendReliableMsg(msgID uint32, data []byte) error {
+	return c.SendMsg(msgID, data)
+}
+
+func (c *replayConn) SetProperty(key string, value interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.property[key] = value
+}
+
+func (c *replayConn) GetProperty(key string) (interface{}, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if value, ok := c.property[key]; ok {
+		return value, nil
+	}
+	return nil, errors.New("no property found")
+}
+
+func (c *replayConn) DeleteProperty(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.property, key)
+}
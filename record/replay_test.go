@@ -0,0 +1,55 @@
+package record
+
+import (
+	"gonet/interfaces"
+	"strings"
+	"testing"
+	"time"
+)
+
+// upperHandle 将收到的消息转为大写后回复，msgID为2时回复两次
+type upperHandle struct {
+	interfaces.IMsgHandle
+}
+
+func (h *upperHandle) DoMsgHandle(request interfaces.IRequest) {
+	reply := []byte(strings.ToUpper(string(request.GetData())))
+	_ = request.GetConn().SendMsg(request.GetMsgID(), reply)
+	if request.GetMsgID() == 2 {
+		_ = request.GetConn().SendReliableMsg(request.GetMsgID(), reply)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	captures := []Capture{
+		{ConnID: 1, Direction: interfaces.RecordInbound, MsgID: 1, Data: []byte("a")},
+		{ConnID: 2, Direction: interfaces.RecordInbound, MsgID: 2, Data: []byte("b")},
+		{ConnID: 1, Direction: interfaces.RecordOutbound, MsgID: 1, Data: []byte("A")},
+		{ConnID: 2, Direction: interfaces.RecordOutbound, MsgID: 2, Data: []byte("B")},
+		{ConnID: 2, Direction: interfaces.RecordOutbound, MsgID: 2, Data: []byte("B")},
+	}
+	result := Replay(&upperHandle{}, captures)
+	if !result.OK() {
+		t.Fatalf("replay should match, got %v", result.Mismatches)
+	}
+	if len(result.Outputs[2]) != 2 {
+		t.Fatalf("conn 2 should send 2 msgs, got %d", len(result.Outputs[2]))
+	}
+
+	//录制时的回复与重放不一致
+	captures[2].Data = []byte("a")
+	captures = append(captures, Capture{Time: time.Now(), ConnID: 1, Direction: interfaces.RecordOutbound, MsgID: 9})
+	result = Replay(&upperHandle{}, captures)
+	if len(result.Mismatches) != 2 {
+		t.Fatalf("want 2 mismatches, got %v", result.Mismatches)
+	}
+	if m := result.Mismatches[0]; m.ConnID != 1 || m.Index != 0 || string(m.Actual.Data) != "A" {
+		t.Fatalf("unexpected mismatch %v", m)
+	}
+	if m := result.Mismatches[1]; m.Actual != nil || m.Expected.MsgID != 9 {
+		t.Fatalf("unexpected mismatch %v", m)
+	}
+	if !strings.Contains(result.Mismatches[1].String(), "missing msgID 9") {
+		t.Fatalf("unexpected mismatch string %s", result.Mismatches[1])
+	}
+}
@@ -0,0 +1,212 @@
+package tick
+
+import (
+	"context"
+	"gonet/interfaces"
+	"gonet/logger"
+	"gonet/timer"
+	"sort"
+	"sync"
+)
+
+type options struct {
+	scheduler  *timer.TimerScheduler
+	maxInputs  int
+	maxCatchUp int
+}
+
+// Option Manager及房间的选项，传给NewManager时作为全部房间的默认值，传给NewRoom时只对该房间生效
+type Option func(*options)
+
+// WithScheduler 使用ts调度tick，ts需要自动执行触发的任务(timer.WithExecutor)，只对NewManager有效
+// ts需要使用timer.BackendHeap，否则tick的误差达到timer.MaxDelayTime毫秒，NewManager时输出警告
+// 不设置时Manager创建一个使用timer.BackendHeap的调度器，Stop时一并停止
+func WithScheduler(ts *timer.TimerScheduler) Option {
+	return func(o *options) { o.scheduler = ts }
+}
+
+// WithMaxInputs 房间队列中等待tick的请求的上限，默认为DefaultMaxInputs
+func WithMaxInputs(n int) Option {
+	return func(o *options) { o.maxInputs = n }
+}
+
+// WithMaxCatchUp 一次落后时最多连续补执行的tick数，默认为DefaultMaxCatchUp，0表示不补执行
+func WithMaxCatchUp(n int) Option {
+	return func(o *options) { o.maxCatchUp = n }
+}
+
+// Manager 管理全部房间的tick，并将请求路由到连接所在的房间
+type Manager struct {
+	ts *timer.TimerScheduler
+	//ts由Manager创建，Stop时一并停止
+	ownScheduler bool
+	opts         options
+
+	lock    sync.RWMutex
+	rooms   map[uint64]*Room
+	stopped bool
+}
+
+// NewManager 创建一个Manager
+func NewManager(opts ...Option) *Manager {
+	o := options{maxInputs: DefaultMaxInputs, maxCatchUp: DefaultMaxCatchUp}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	m := &Manager{opts: o, rooms: make(map[uint64]*Room)}
+	if o.scheduler != nil {
+		m.ts = o.scheduler
+		if m.ts.Backend() != timer.BackendHeap {
+			logger.Default().WithField("role", "tick").Warn("scheduler does not use timer.BackendHeap, ticks may be late")
+		}
+	} else {
+		m.ts = timer.NewAutoExecTimerScheduler(timer.WithBackend(timer.BackendHeap))
+		m.ownScheduler = true
+	}
+	return m
+}
+
+// NewRoom 创建一个以rate(Hz)tick的房间并开始tick，opts覆盖Manager的默认选项
+func (m *Manager) NewRoom(id uint64, rate int, logic Logic, opts ...Option) (*Room, error) {
+	if rate < MinRate || rate > MaxRate {
+		return nil, ErrInvalidRate
+	}
+	o := m.opts
+	for _, opt := range opts {
+		opt(&o)
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.stopped {
+		return nil, ErrManagerStopped
+	}
+	if _, ok := m.rooms[id]; ok {
+		return nil, ErrRoomExists
+	}
+	r := newRoom(m, id, rate, logic, o)
+	m.rooms[id] = r
+	r.arm()
+	return r, nil
+}
+
+// Room 根据ID返回房间
+func (m *Manager) Room(id uint64) (*Room, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	r, ok := m.rooms[id]
+	return r, ok
+}
+
+// Rooms 返回全部房间，按ID排序
+func (m *Manager) Rooms() []*Room {
+	m.lock.RLock()
+	rooms := make([]*Room, 0, len(m.rooms))
+	for _, r := range m.rooms {
+		rooms = append(rooms, r)
+	}
+	m.lock.RUnlock()
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].id < rooms[j].id })
+	return rooms
+}
+
+// Stats 返回全部房间的运行统计，按ID排序
+func (m *Manager) Stats() []RoomStats {
+	rooms := m.Rooms()
+	stats := make([]RoomStats, 0, len(rooms))
+	for _, r := range rooms {
+		stats = append(stats, r.Stats())
+	}
+	return stats
+}
+
+// roomOf 返回连接所在的房间
+func (m *Manager) roomOf(conn interfaces.IConnection) (*Room, bool) {
+	v, err := conn.GetProperty(RoomProperty)
+	if err != nil {
+		return nil, false
+	}
+	id, ok := v.(uint64)
+	if !ok {
+		return nil, false
+	}
+	return m.Room(id)
+}
+
+// Join 连接加入id对应的房间，已经在其他房间时先离开原来的房间
+func (m *Manager) Join(id uint64, conn interfaces.IConnection) error {
+	r, ok := m.Room(id)
+	if !ok {
+		return ErrRoomNotFound
+	}
+	return r.Join(conn)
+}
+
+// Leave 连接离开所在的房间
+func (m *Manager) Leave(conn interfaces.IConnection) {
+	if r, ok := m.roomOf(conn); ok {
+		r.Leave(conn)
+	}
+}
+
+// Route 将请求放入请求所属连接所在房间的队列
+func (m *Manager) Route(request interfaces.IRequest) error {
+	if _, err := request.GetConn().GetProperty(RoomProperty); err != nil {
+		return ErrNotInRoom
+	}
+	r, ok := m.roomOf(request.GetConn())
+	if !ok {
+		return ErrRoomNotFound
+	}
+	return r.Enqueue(request)
+}
+
+// Router 返回一个将请求路由到房间的IRouter，为需要在房间的tick中处理的msgID注册该路由
+func (m *Manager) Router() interfaces.IRouter {
+	return &roomRouter{m: m}
+}
+
+// remove 删除已经停止的房间
+func (m *Manager) remove(r *Room) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.rooms[r.id] == r {
+		delete(m.rooms, r.id)
+	}
+}
+
+// Stop 停止全部房间，Manager创建的调度器一并停止，ctx结束时不再等待正在执行的tick
+func (m *Manager) Stop(ctx context.Context) error {
+	m.lock.Lock()
+	m.stopped = true
+	rooms := m.rooms
+	m.rooms = make(map[uint64]*Room)
+	m.lock.Unlock()
+	for _, r := range rooms {
+		r.stop()
+	}
+	if m.ownScheduler {
+		return m.ts.Stop(ctx)
+	}
+	return nil
+}
+
+var _ interfaces.IRouter = (*roomRouter)(nil)
+
+// roomRouter 将请求放入房间的队列，放入失败时记录日志
+type roomRouter struct {
+	m *Manager
+}
+
+func (rr *roomRouter) PreHandle(interfaces.IRequest) {}
+
+func (rr *roomRouter) Handle(request interfaces.IRequest) {
+	if err := rr.m.Route(request); err != nil {
+		logger.Default().WithFields(logger.Fields{
+			logger.FieldConnID: request.GetConn().GetConnID(),
+			logger.FieldMsgID:  request.GetMsgID(),
+			logger.FieldError:  err,
+		}).Warn("route request to room failed")
+	}
+}
+
+func (rr *roomRouter) PostHandle(interfaces.IRequest) {}
@@ -0,0 +1,316 @@
+package tick
+
+import (
+	"context"
+	"gonet/interfaces"
+	"gonet/logger"
+	"gonet/timer"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RoomStats 房间的运行统计
+type RoomStats struct {
+	// ID 房间ID
+	ID uint64
+	// Rate tick频率(Hz)
+	Rate int
+	// Members 房间中的连接数
+	Members int
+	// Queued 队列中等待下一次tick的请求数
+	Queued int
+	// Ticks 执行过的tick总数
+	Ticks uint64
+	// CatchUps 落后之后补执行的tick总数
+	CatchUps uint64
+	// Skipped 落后太多而跳过的tick总数
+	Skipped uint64
+	// Overruns 耗时超过interval的tick总数
+	Overruns uint64
+	// Inputs 交给逻辑处理的请求总数
+	Inputs uint64
+	// Dropped 因为队列已满而丢弃的请求总数
+	Dropped uint64
+	// LastTickTime 最近一次tick的耗时
+	LastTickTime time.Duration
+	// AvgTickTime tick的平均耗时
+	AvgTickTime time.Duration
+	// MaxTickTime tick的最长耗时
+	MaxTickTime time.Duration
+	// MaxLag tick开始执行时相对计划时间的最大延迟
+	MaxLag time.Duration
+}
+
+// Room 以固定频率tick的房间，同时是一组连接，可以向全部成员广播
+type Room struct {
+	id       uint64
+	rate     int
+	interval time.Duration
+	logic    Logic
+	m        *Manager
+	opts     options
+	log      logger.Logger
+	//房间创建的时间，第k次tick计划在start+k*interval
+	start time.Time
+
+	//保护以下字段
+	lock    sync.Mutex
+	seq     uint64
+	inputs  []*Input
+	joined  []interfaces.IConnection
+	left    []interfaces.IConnection
+	members map[uint64]interfaces.IConnection
+	timer   *timer.Timer
+	stopped bool
+	stats   RoomStats
+	//tick的总耗时，用于计算平均耗时
+	tickTotal time.Duration
+}
+
+func newRoom(m *Manager, id uint64, rate int, logic Logic, opts options) *Room {
+	r := &Room{
+		id:       id,
+		rate:     rate,
+		interval: time.Second / time.Duration(rate),
+		logic:    logic,
+		m:        m,
+		opts:     opts,
+		log:      logger.Default().WithField(logger.FieldRoom, id),
+		start:    m.ts.Clock().Now(),
+		seq:      1,
+		members:  make(map[uint64]interfaces.IConnection),
+	}
+	r.stats.ID, r.stats.Rate = id, rate
+	return r
+}
+
+// ID 房间ID
+func (r *Room) ID() uint64 {
+	return r.id
+}
+
+// Rate tick频率(Hz)
+func (r *Room) Rate() int {
+	return r.rate
+}
+
+// Interval 两次tick之间的时间步长
+func (r *Room) Interval() time.Duration {
+	return r.interval
+}
+
+// scheduled 第seq次tick计划的执行时间
+func (r *Room) scheduled(seq uint64) time.Time {
+	return r.start.Add(time.Duration(seq) * r.interval)
+}
+
+// arm 安排下一次tick，定时器以毫秒计时，触发时间向上取整保证不早于计划的时间
+func (r *Room) arm() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.stopped {
+		return
+	}
+	at := r.scheduled(r.seq).UnixNano()
+	if rem := at % int64(time.Millisecond); rem != 0 {
+		at += int64(time.Millisecond) - rem
+	}
+	df := timer.NewTaskFunc("tick", r.run).WithLabels(map[string]string{logger.FieldRoom: strconv.FormatUint(r.id, 10)})
+	r.timer = r.m.ts.AtFunc(df, at)
+}
+
+// run 执行全部到期的tick，落后超过MaxCatchUp时跳过错过的tick，最后安排下一次tick
+// 逻辑panic时由调度器的ErrorHandler处理，之后的tick照常进行
+func (r *Room) run(ctx context.Context) error {
+	defer r.arm()
+	clock := r.m.ts.Clock()
+	for ran := 0; ; ran++ {
+		now := clock.Now()
+		r.lock.Lock()
+		if r.stopped {
+			r.lock.Unlock()
+			return nil
+		}
+		next := r.scheduled(r.seq)
+		if next.After(now) {
+			r.lock.Unlock()
+			return nil
+		}
+		if ran > r.opts.maxCatchUp {
+			missed := uint64(now.Sub(next)/r.interval) + 1
+			r.seq += missed
+			r.stats.Skipped += missed
+			r.lock.Unlock()
+			r.log.Warnf("room is behind, skip %d ticks", missed)
+			return nil
+		}
+		t := r.next(ran > 0)
+		r.lock.Unlock()
+		r.tick(ctx, clock, t)
+	}
+}
+
+// next 取出队列中的请求及成员变化，生成下一次tick，调用者持有r.lock
+func (r *Room) next(catchUp bool) *Tick {
+	//已经断开的连接离开房间
+	for connID, conn := range r.members {
+		if conn.Context().Err() != nil {
+			delete(r.members, connID)
+			r.left = append(r.left, conn)
+		}
+	}
+	t := &Tick{
+		Seq:     r.seq,
+		Time:    r.scheduled(r.seq),
+		Dt:      r.interval,
+		CatchUp: catchUp,
+		Inputs:  r.inputs,
+		Joined:  r.joined,
+		Left:    r.left,
+	}
+	r.seq++
+	r.inputs, r.joined, r.left = nil, nil, nil
+	return t
+}
+
+// tick 调用逻辑并记录统计
+func (r *Room) tick(ctx context.Context, clock timer.Clock, t *Tick) {
+	begin := clock.Now()
+	r.logic.OnTick(ctx, r, t)
+	elapsed := clock.Now().Sub(begin)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	s := &r.stats
+	s.Ticks++
+	s.Inputs += uint64(len(t.Inputs))
+	if t.CatchUp {
+		s.CatchUps++
+	}
+	if lag := begin.Sub(t.Time); lag > s.MaxLag {
+		s.MaxLag = lag
+	}
+	s.LastTickTime = elapsed
+	if elapsed > s.MaxTickTime {
+		s.MaxTickTime = elapsed
+	}
+	r.tickTotal += elapsed
+	if elapsed > r.interval {
+		s.Overruns++
+		r.log.WithField("seq", t.Seq).Warnf("tick overrun, took %v, interval %v", elapsed, r.interval)
+	}
+}
+
+// Enqueue 将请求放入队列，在下一次tick中交给逻辑处理
+func (r *Room) Enqueue(request interfaces.IRequest) error {
+	now := r.m.ts.Clock().Now()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.stopped {
+		return ErrRoomStopped
+	}
+	if len(r.inputs) >= r.opts.maxInputs {
+		r.stats.Dropped++
+		return ErrInputQueueFull
+	}
+	r.inputs = append(r.inputs, newInput(request, now))
+	return nil
+}
+
+// Join 连接加入房间，已经在其他房间时先离开原来的房间，在下一次tick的Joined中通知逻辑
+// 同一个连接的Join及Leave应当在连接的worker中调用
+func (r *Room) Join(conn interfaces.IConnection) error {
+	if old, ok := r.m.roomOf(conn); ok && old != r {
+		old.Leave(conn)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.stopped {
+		return ErrRoomStopped
+	}
+	if _, ok := r.members[conn.GetConnID()]; ok {
+		return nil
+	}
+	r.members[conn.GetConnID()] = conn
+	r.joined = append(r.joined, conn)
+	conn.SetProperty(RoomProperty, r.id)
+	return nil
+}
+
+// Leave 连接离开房间，在下一次tick的Left中通知逻辑，不在房间中时忽略
+func (r *Room) Leave(conn interfaces.IConnection) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.members[conn.GetConnID()]; !ok {
+		return
+	}
+	delete(r.members, conn.GetConnID())
+	r.left = append(r.left, conn)
+	if id, err := conn.GetProperty(RoomProperty); err == nil && id == r.id {
+		conn.DeleteProperty(RoomProperty)
+	}
+}
+
+// Members 返回房间中的连接，按ConnID排序
+func (r *Room) Members() []interfaces.IConnection {
+	r.lock.Lock()
+	conns := make([]interfaces.IConnection, 0, len(r.members))
+	for _, conn := range r.members {
+		conns = append(conns, conn)
+	}
+	r.lock.Unlock()
+	sort.Slice(conns, func(i, j int) bool { return conns[i].GetConnID() < conns[j].GetConnID() })
+	return conns
+}
+
+// Broadcast 向房间中全部未断开的连接发送消息，发送失败的连接记录日志后跳过
+func (r *Room) Broadcast(msgID uint32, data []byte) {
+	for _, conn := range r.Members() {
+		if conn.Context().Err() != nil {
+			continue
+		}
+		if err := conn.SendMsg(msgID, data); err != nil {
+			r.log.WithFields(logger.Fields{logger.FieldConnID: conn.GetConnID(), logger.FieldMsgID: msgID, logger.FieldError: err}).Warn("room broadcast failed")
+		}
+	}
+}
+
+// Stop 停止tick并从Manager中删除，队列中的请求被丢弃，正在执行的tick不受影响
+func (r *Room) Stop() {
+	r.stop()
+	r.m.remove(r)
+}
+
+// stop 停止tick并清除成员的房间属性
+func (r *Room) stop() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.stopped {
+		return
+	}
+	r.stopped = true
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	for _, conn := range r.members {
+		if id, err := conn.GetProperty(RoomProperty); err == nil && id == r.id {
+			conn.DeleteProperty(RoomProperty)
+		}
+	}
+	r.members, r.inputs, r.joined, r.left = map[uint64]interfaces.IConnection{}, nil, nil, nil
+}
+
+// Stats 返回房间的运行统计
+func (r *Room) Stats() RoomStats {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	s := r.stats
+	s.Members = len(r.members)
+	s.Queued = len(r.inputs)
+	if s.Ticks > 0 {
+		s.AvgTickTime = r.tickTotal / time.Duration(s.Ticks)
+	}
+	return s
+}
@@ -0,0 +1,117 @@
+// Package tick 房间的固定步长tick循环
+// 每个房间以固定的频率tick，路由到房间的请求先进入房间的队列，在下一次tick中按到达的顺序交给房间的逻辑处理
+package tick
+
+import (
+	"context"
+	"errors"
+	"gonet/interfaces"
+	"time"
+)
+
+/*
+	tick的调度
+	房间的每次tick都是timer.TimerScheduler中的一个一次性定时器，tick结束后安排下一次，同一个房间的tick不会并发执行
+	第k次tick计划在房间创建时间+k*interval执行，执行耗时不影响频率
+	tick的耗时超过interval时记为overrun，之后已经到期的tick立即连续执行(catch-up)，最多补MaxCatchUp次
+	超过MaxCatchUp仍然落后时跳过错过的tick，从下一个计划的时间继续
+	定时器使用的backend决定tick的精度，默认使用timer.BackendHeap
+*/
+
+const (
+	// MinRate 房间的最低tick频率(Hz)
+	MinRate = 20
+	// MaxRate 房间的最高tick频率(Hz)
+	MaxRate = 60
+	// DefaultMaxInputs 房间队列中等待tick的请求的默认上限
+	DefaultMaxInputs = 1024
+	// DefaultMaxCatchUp 一次落后时默认最多连续补执行的tick数
+	DefaultMaxCatchUp = 5
+	// RoomProperty 连接所在房间的ID保存在该连接属性中
+	RoomProperty = "tick.room"
+)
+
+var (
+	// ErrInvalidRate tick频率不在[MinRate, MaxRate]之间
+	ErrInvalidRate = errors.New("tick rate out of range")
+	// ErrRoomExists 房间ID已经存在
+	ErrRoomExists = errors.New("room already exists")
+	// ErrRoomNotFound 房间不存在
+	ErrRoomNotFound = errors.New("room not found")
+	// ErrRoomStopped 房间已经停止
+	ErrRoomStopped = errors.New("room stopped")
+	// ErrNotInRoom 连接没有加入房间
+	ErrNotInRoom = errors.New("connection not in room")
+	// ErrInputQueueFull 房间的请求队列已满
+	ErrInputQueueFull = errors.New("room input queue full")
+	// ErrManagerStopped Manager已经停止
+	ErrManagerStopped = errors.New("tick manager stopped")
+)
+
+// Logic 房间的逻辑
+type Logic interface {
+	// OnTick 每次tick调用一次，同一个房间不会并发调用，ctx在Manager停止时取消
+	OnTick(ctx context.Context, room *Room, tick *Tick)
+}
+
+// LogicFunc 使用函数实现Logic
+type LogicFunc func(ctx context.Context, room *Room, tick *Tick)
+
+func (f LogicFunc) OnTick(ctx context.Context, room *Room, tick *Tick) {
+	f(ctx, room, tick)
+}
+
+// Tick 一次tick
+type Tick struct {
+	// Seq tick的序号，从1开始，跳过的tick也占用序号
+	Seq uint64
+	// Time 计划的执行时间
+	Time time.Time
+	// Dt 固定的时间步长，即1/rate
+	Dt time.Duration
+	// CatchUp 是否为落后之后补执行的tick
+	CatchUp bool
+	// Inputs 上一次tick之后进入房间的请求，按到达的顺序排列
+	Inputs []*Input
+	// Joined 上一次tick之后加入房间的连接
+	Joined []interfaces.IConnection
+	// Left 上一次tick之后离开房间或者已经断开的连接
+	Left []interfaces.IConnection
+}
+
+// Input 进入房间队列的请求
+type Input struct {
+	interfaces.IRequest
+	// Received 进入队列的时间
+	Received time.Time
+	ctx      context.Context
+}
+
+// newInput 请求的ctx在处理方法返回后被取消，Input的ctx保留请求ctx中的值，在连接停止时取消
+func newInput(request interfaces.IRequest, received time.Time) *Input {
+	return &Input{
+		IRequest: request,
+		Received: received,
+		ctx:      inputContext{Context: request.GetConn().Context(), values: request.Context()},
+	}
+}
+
+// Context 在连接停止时取消，携带进入队列时请求ctx中的值
+func (in *Input) Context() context.Context {
+	return in.ctx
+}
+
+// SetContext 替换Input的ctx
+func (in *Input) SetContext(ctx context.Context) {
+	in.ctx = ctx
+}
+
+// inputContext 使用连接的ctx的取消及请求的ctx的值
+type inputContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c inputContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
@@ -0,0 +1,291 @@
+package tick
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gonet/gonettest"
+	"gonet/interfaces"
+	"gonet/timer"
+)
+
+// fakeManager 使用FakeClock的Manager，tick只在Advance时到期
+func fakeManager(t *testing.T, opts ...Option) (*Manager, *timer.FakeClock) {
+	t.Helper()
+	clock := timer.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	ts := timer.NewAutoExecTimerScheduler(timer.WithClock(clock), timer.WithBackend(timer.BackendHeap))
+	m := NewManager(append([]Option{WithScheduler(ts)}, opts...)...)
+	t.Cleanup(func() {
+		if err := m.Stop(context.Background()); err != nil {
+			t.Error(err)
+		}
+		if err := ts.Stop(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+	return m, clock
+}
+
+// recordLogic 将每次tick写入返回的channel
+func recordLogic() (Logic, chan *Tick) {
+	ticks := make(chan *Tick, 16)
+	return LogicFunc(func(_ context.Context, _ *Room, t *Tick) { ticks <- t }), ticks
+}
+
+func expectTick(t *testing.T, ticks chan *Tick, seq uint64) *Tick {
+	t.Helper()
+	select {
+	case tk := <-ticks:
+		if tk.Seq != seq {
+			t.Fatalf("want tick %d, got %d", seq, tk.Seq)
+		}
+		return tk
+	case <-time.After(time.Second):
+		t.Fatalf("tick %d should run", seq)
+	}
+	return nil
+}
+
+func expectNoTick(t *testing.T, ticks chan *Tick) {
+	t.Helper()
+	select {
+	case tk := <-ticks:
+		t.Fatalf("unexpected tick %d", tk.Seq)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// expectStats 等待tick结束后统计更新
+func expectStats(t *testing.T, r *Room, ok func(s RoomStats) bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !ok(r.Stats()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected stats %+v", r.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRoom_Inputs(t *testing.T) {
+	m, clock := fakeManager(t)
+	logic, ticks := recordLogic()
+	r, err := m.NewRoom(1, 20, logic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := gonettest.NewFakeConn(1)
+	if err := r.Join(conn); err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"a", "b", "c"} {
+		if err := m.Route(gonettest.NewRequest(conn, 1, []byte(data))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Route(gonettest.NewRequest(gonettest.NewFakeConn(2), 1, nil)); err != ErrNotInRoom {
+		t.Fatalf("want ErrNotInRoom, got %v", err)
+	}
+
+	//请求在下一次tick中按到达的顺序交给逻辑
+	clock.Advance(49 * time.Millisecond)
+	expectNoTick(t, ticks)
+	clock.Advance(time.Millisecond)
+	tk := expectTick(t, ticks, 1)
+	if tk.Dt != 50*time.Millisecond || tk.CatchUp || len(tk.Joined) != 1 || len(tk.Inputs) != 3 {
+		t.Fatalf("unexpected tick %+v", tk)
+	}
+	for i, data := range []string{"a", "b", "c"} {
+		if got := string(tk.Inputs[i].GetData()); got != data {
+			t.Fatalf("input %d: want %s, got %s", i, data, got)
+		}
+	}
+	expectStats(t, r, func(s RoomStats) bool { return s.Ticks == 1 && s.Inputs == 3 && s.Members == 1 })
+
+	//断开的连接在下一次tick中离开房间
+	conn.Stop()
+	clock.Advance(50 * time.Millisecond)
+	tk = expectTick(t, ticks, 2)
+	if len(tk.Inputs) != 0 || len(tk.Left) != 1 || tk.Left[0] != interfaces.IConnection(conn) {
+		t.Fatalf("unexpected tick %+v", tk)
+	}
+	if len(r.Members()) != 0 {
+		t.Fatal("stopped connection should leave the room")
+	}
+}
+
+func TestRoom_CatchUp(t *testing.T) {
+	m, clock := fakeManager(t)
+	logic, ticks := recordLogic()
+	r, err := m.NewRoom(1, 20, logic, WithMaxCatchUp(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//落后5次tick：执行1次并补执行2次，其余2次跳过
+	clock.Advance(250 * time.Millisecond)
+	for seq := uint64(1); seq <= 3; seq++ {
+		if tk := expectTick(t, ticks, seq); tk.CatchUp != (seq > 1) {
+			t.Fatalf("tick %d catch up %v", seq, tk.CatchUp)
+		}
+	}
+	expectNoTick(t, ticks)
+	expectStats(t, r, func(s RoomStats) bool {
+		return s.Ticks == 3 && s.CatchUps == 2 && s.Skipped == 2 && s.MaxLag == 200*time.Millisecond
+	})
+
+	//跳过之后从下一个计划的时间继续
+	clock.Advance(50 * time.Millisecond)
+	if tk := expectTick(t, ticks, 6); !tk.Time.Equal(r.start.Add(300 * time.Millisecond)) {
+		t.Fatalf("unexpected tick time %v", tk.Time)
+	}
+}
+
+func TestRoom_Overrun(t *testing.T) {
+	m, clock := fakeManager(t)
+	ticks := make(chan *Tick, 16)
+	r, err := m.NewRoom(1, 20, LogicFunc(func(_ context.Context, _ *Room, tk *Tick) {
+		//第一次tick耗时60ms，超过50ms的步长
+		if tk.Seq == 1 {
+			clock.Advance(60 * time.Millisecond)
+		}
+		ticks <- tk
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(50 * time.Millisecond)
+	expectTick(t, ticks, 1)
+	if tk := expectTick(t, ticks, 2); !tk.CatchUp {
+		t.Fatal("tick after overrun should catch up")
+	}
+	expectStats(t, r, func(s RoomStats) bool {
+		return s.Ticks == 2 && s.Overruns == 1 && s.CatchUps == 1 && s.MaxTickTime == 60*time.Millisecond
+	})
+}
+
+func TestManager_Rooms(t *testing.T) {
+	m, _ := fakeManager(t)
+	logic, _ := recordLogic()
+	if _, err := m.NewRoom(1, 10, logic); err != ErrInvalidRate {
+		t.Fatalf("want ErrInvalidRate, got %v", err)
+	}
+	r1, _ := m.NewRoom(1, 20, logic, WithMaxInputs(1))
+	r2, _ := m.NewRoom(2, 60, logic)
+	if _, err := m.NewRoom(1, 20, logic); err != ErrRoomExists {
+		t.Fatalf("want ErrRoomExists, got %v", err)
+	}
+
+	//加入其他房间时离开原来的房间
+	conn := gonettest.NewFakeConn(1)
+	if err := m.Join(1, conn); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Join(2, conn); err != nil {
+		t.Fatal(err)
+	}
+	if len(r1.Members()) != 0 || len(r2.Members()) != 1 {
+		t.Fatal("connection should move to room 2")
+	}
+	if id, _ := conn.GetProperty(RoomProperty); id != uint64(2) {
+		t.Fatalf("want room 2, got %v", id)
+	}
+
+	//队列已满时丢弃请求
+	_ = m.Join(1, conn)
+	if err := m.Route(gonettest.NewRequest(conn, 1, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Route(gonettest.NewRequest(conn, 1, nil)); err != ErrInputQueueFull {
+		t.Fatalf("want ErrInputQueueFull, got %v", err)
+	}
+	if s := r1.Stats(); s.Queued != 1 || s.Dropped != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	//停止的房间从Manager中删除，成员不再属于该房间
+	r1.Stop()
+	if _, ok := m.Room(1); ok || len(m.Rooms()) != 1 {
+		t.Fatal("stopped room should be removed")
+	}
+	if err := m.Route(gonettest.NewRequest(conn, 1, nil)); err != ErrNotInRoom {
+		t.Fatalf("want ErrNotInRoom, got %v", err)
+	}
+	if err := r1.Join(conn); err != ErrRoomStopped {
+		t.Fatalf("want ErrRoomStopped, got %v", err)
+	}
+}
+
+func TestManager_Router(t *testing.T) {
+	t.Parallel()
+	m := NewManager()
+	defer m.Stop(context.Background())
+	room, err := m.NewRoom(7, 30, LogicFunc(func(_ context.Context, room *Room, tk *Tick) {
+		for _, conn := range tk.Joined {
+			_ = conn.SendMsg(100, nil)
+		}
+		for _, in := range tk.Inputs {
+			room.Broadcast(2, in.GetData())
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := gonettest.NewServer()
+	s.AddRouter(1, m.Router())
+	s.SetOnConnStart(func(conn interfaces.IConnection) {
+		_ = m.Join(7, conn)
+	})
+	s.Start()
+	defer s.Stop()
+
+	client, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.ExpectMessage(100, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"x", "y"} {
+		if err := client.SendMsg(1, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, data := range []string{"x", "y"} {
+		msg, err := client.ExpectMessage(2, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.GetData()) != data {
+			t.Fatalf("want %s, got %s", data, msg.GetData())
+		}
+	}
+	expectStats(t, room, func(s RoomStats) bool { return s.Inputs == 2 && s.Members == 1 })
+}
+
+func TestManager_ServerScheduler(t *testing.T) {
+	s := gonettest.NewServer()
+	s.Start()
+	defer s.Stop()
+	ts := s.GetTimerScheduler()
+	if ts.Backend() != timer.BackendHeap {
+		t.Fatalf("server scheduler should use BackendHeap, got %v", ts.Backend())
+	}
+	m := NewManager(WithScheduler(ts))
+	defer m.Stop(context.Background())
+
+	logic, ticks := recordLogic()
+	if _, err := m.NewRoom(1, 50, logic); err != nil {
+		t.Fatal(err)
+	}
+	//使用服务器的调度器同样按计划的时间tick，不会因为时间轮的检测间隔成批触发
+	for seq := uint64(1); seq <= 5; seq++ {
+		tk := expectTick(t, ticks, seq)
+		if late := time.Since(tk.Time); late > 40*time.Millisecond {
+			t.Fatalf("tick %d ran %v late", seq, late)
+		}
+	}
+}
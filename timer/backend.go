@@ -0,0 +1,128 @@
+package timer
+
+import (
+	"context"
+	"gonet/logger"
+	"time"
+)
+
+/*
+	调度器的backend
+	backend保存等待触发的定时器，检测到期之后通过TimerScheduler.trigger写入触发通道
+	BackendTimeWheel使用分层时间轮，每隔MaxDelayTime/2毫秒检测一次，误差在MaxDelayTime毫秒以内，适合大量的低精度定时器
+	BackendHeap使用4叉最小堆及一个系统定时器，在到期时立即触发，误差在毫秒级，适合战斗帧等需要高精度的定时器
+*/
+
+// Backend 调度器保存定时器及检测到期的方式
+type Backend int
+
+const (
+	// BackendTimeWheel 分层时间轮
+	BackendTimeWheel Backend = iota
+	// BackendHeap 4叉最小堆
+	BackendHeap
+)
+
+// backend 保存等待触发的定时器并检测到期
+type backend interface {
+	// add 添加等待触发的定时器，调用者持有t.lock
+	add(t *Timer) error
+	// remove 删除定时器，返回是否找到，调用者持有t.lock
+	remove(t *Timer) bool
+	// start 开始检测到期的定时器，由TimerScheduler.Start调用一次
+	start()
+	// stop 停止检测并等待检测的协程退出，调用之前调度器的ctx已经取消
+	stop(ctx context.Context) error
+}
+
+// wheelBackend 分层时间轮
+type wheelBackend struct {
+	ts *TimerScheduler
+	//最高级时间轮
+	tw *TimeWheel
+}
+
+// newWheelBackend 创建小时、分钟、秒三级时间轮并做关联
+func newWheelBackend(ts *TimerScheduler) *wheelBackend {
+	// 创建秒级时间轮
+	secondTimeWheel := NewTimeWheel(SecondName, SecondInterval, SecondScales, TimersMaxCap)
+	// 创建分钟级时间轮
+	minuteTimeWheel := NewTimeWheel(MinuteName, MinuteInterval, MinuteScales, TimersMaxCap)
+	// 创建小时级时间轮
+	hourTimeWheel := NewTimeWheel(HourName, HourInterval, HourScales, TimersMaxCap)
+
+	//分层时间轮做关联
+	hourTimeWheel.nextTimeWheel = minuteTimeWheel
+	minuteTimeWheel.nextTimeWheel = secondTimeWheel
+	for _, tw := range []*TimeWheel{secondTimeWheel, minuteTimeWheel, hourTimeWheel} {
+		tw.clock = ts.clock
+	}
+	return &wheelBackend{ts: ts, tw: hourTimeWheel}
+}
+
+func (b *wheelBackend) add(t *Timer) error {
+	return b.tw.AddTimer(t.id, t)
+}
+
+func (b *wheelBackend) remove(t *Timer) bool {
+	return b.tw.RemoveTimer(t.id)
+}
+
+// start 依次启动时间轮，将Start之前添加的定时器按当前时间重新挂载，然后启动调度协程
+func (b *wheelBackend) start() {
+	for tw := b.tw; tw != nil; tw = tw.nextTimeWheel {
+		tw.Run()
+	}
+	b.rehash()
+	b.ts.loops.Add(1)
+	go b.poll()
+}
+
+func (b *wheelBackend) stop(ctx context.Context) error {
+	for tw := b.tw; tw != nil; tw = tw.nextTimeWheel {
+		if err := tw.Stop(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rehash 将等待触发的定时器从时间轮中取下再重新添加
+func (b *wheelBackend) rehash() {
+	ts := b.ts
+	ts.RLock()
+	timers := make([]*Timer, 0, len(ts.timers))
+	for _, t := range ts.timers {
+		timers = append(timers, t)
+	}
+	ts.RUnlock()
+	for _, t := range timers {
+		t.lock.Lock()
+		if t.state == timerPending && b.tw.RemoveTimer(t.id) {
+			if err := b.tw.AddTimer(t.id, t); err != nil {
+				logger.Default().WithFields(logger.Fields{logger.FieldTimer: t.id, logger.FieldError: err}).Error("add timer failed")
+			}
+		}
+		t.lock.Unlock()
+	}
+}
+
+// poll 每隔MaxDelayTime/2毫秒取出到期的定时器，将延迟方法写入触发通道
+func (b *wheelBackend) poll() {
+	ts := b.ts
+	defer ts.loops.Done()
+	for {
+		now := unixMill(ts.clock)
+		// 获取最近MaxTimeDelay 毫秒的超时定时器集合
+		timerList := b.tw.GetTimerWithin(MaxDelayTime * time.Millisecond)
+		for _, timer := range timerList {
+			if !ts.trigger(timer, now, MaxDelayTime) {
+				return
+			}
+		}
+		//每隔50毫秒进行读取1次过期的timer
+		if !sleep(ts.clock, MaxDelayTime/2*time.Millisecond, ts.ctx.Done()) {
+			return
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package timer
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+	时间来源
+	时间轮、调度器及定时器都通过Clock获取当前时间及等待，测试时使用FakeClock手动推进时间
+*/
+
+// Clock 时间来源
+type Clock interface {
+	// Now 当前时间
+	Now() time.Time
+	// Sleep 阻塞d
+	Sleep(d time.Duration)
+	// AfterFunc 在d之后调用f
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+// ClockTimer Clock.AfterFunc返回的定时器，与time.Timer相同
+type ClockTimer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// RealClock 使用系统时间的Clock
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+// stoppableSleeper 可以被打断的Sleep，FakeClock实现该接口以便Advance统计正在Sleep的协程
+type stoppableSleeper interface {
+	sleepUntil(d time.Duration, stop <-chan struct{}) bool
+}
+
+// sleep 使用clock阻塞d，stop关闭时提前返回false，时间轮及调度协程通过sleep等待以便能够被停止
+func sleep(clock Clock, d time.Duration, stop <-chan struct{}) bool {
+	if s, ok := clock.(stoppableSleeper); ok {
+		return s.sleepUntil(d, stop)
+	}
+	wake := make(chan struct{})
+	t := clock.AfterFunc(d, func() { close(wake) })
+	select {
+	case <-wake:
+		return true
+	case <-stop:
+		t.Stop()
+		return false
+	}
+}
+
+// unixMill 返回clock的当前时间经历的毫秒数
+func unixMill(clock Clock) int64 {
+	return clock.Now().UnixNano() / 1e6
+}
+
+// settleTimeout Advance等待被唤醒的协程再次Sleep的最长时间
+const settleTimeout = time.Second
+
+// FakeClock 手动推进的Clock，时间只在调用Advance时前进
+type FakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+	seq     uint64
+	//正在Sleep的协程数
+	sleepers int
+	//每次有新的等待时关闭并替换，用于通知BlockUntil及Advance
+	changed chan struct{}
+}
+
+// fakeWaiter 一个Sleep或AfterFunc
+type fakeWaiter struct {
+	clock    *FakeClock
+	deadline time.Time
+	seq      uint64
+	//Sleep时不为nil
+	wake chan struct{}
+	//AfterFunc时不为nil
+	f func()
+}
+
+// NewFakeClock 创建一个从now开始的FakeClock
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, changed: make(chan struct{})}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// Sleep 阻塞直到Advance推进到now+d
+func (c *FakeClock) Sleep(d time.Duration) {
+	c.sleepUntil(d, nil)
+}
+
+// sleepUntil 阻塞直到Advance推进到now+d或者stop关闭
+func (c *FakeClock) sleepUntil(d time.Duration, stop <-chan struct{}) bool {
+	if d <= 0 {
+		return true
+	}
+	w := &fakeWaiter{wake: make(chan struct{})}
+	c.lock.Lock()
+	c.add(w, d)
+	c.lock.Unlock()
+	select {
+	case <-w.wake:
+		return true
+	case <-stop:
+		c.lock.Lock()
+		c.remove(w)
+		c.lock.Unlock()
+		return false
+	}
+}
+
+// AfterFunc 在Advance推进到now+d时调用f，f在调用Advance的协程中执行
+// d不大于0时在下一次Advance(包括Advance(0))时调用
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	w := &fakeWaiter{f: f}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.add(w, d)
+	return w
+}
+
+// add 登记一个等待，调用者持有c.lock
+func (c *FakeClock) add(w *fakeWaiter, d time.Duration) {
+	c.seq++
+	w.clock = c
+	w.seq = c.seq
+	w.deadline = c.now.Add(d)
+	c.waiters = append(c.waiters, w)
+	if w.wake != nil {
+		c.sleepers++
+	}
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// remove 删除一个等待，调用者持有c.lock
+func (c *FakeClock) remove(w *fakeWaiter) bool {
+	for i, waiter := range c.waiters {
+		if waiter == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			if w.wake != nil {
+				c.sleepers--
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Advance 推进时间d，按到期的先后依次唤醒Sleep及调用AfterFunc
+// 每唤醒一个Sleep都等待被唤醒的协程再次Sleep之后才继续推进，保证时间轮的每个刻度都按顺序处理
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	target := c.now.Add(d)
+	c.lock.Unlock()
+	for {
+		c.lock.Lock()
+		sort.SliceStable(c.waiters, func(i, j int) bool {
+			if c.waiters[i].deadline.Equal(c.waiters[j].deadline) {
+				return c.waiters[i].seq < c.waiters[j].seq
+			}
+			return c.waiters[i].deadline.Before(c.waiters[j].deadline)
+		})
+		if len(c.waiters) == 0 || c.waiters[0].deadline.After(target) {
+			c.now = target
+			c.lock.Unlock()
+			return
+		}
+		w := c.waiters[0]
+		if w.deadline.After(c.now) {
+			c.now = w.deadline
+		}
+		sleepers := c.sleepers
+		c.remove(w)
+		c.lock.Unlock()
+
+		if w.f != nil {
+			w.f()
+			continue
+		}
+		close(w.wake)
+		c.waitSleepers(sleepers, settleTimeout)
+	}
+}
+
+// BlockUntil 阻塞直到至少有n个协程在Sleep
+func (c *FakeClock) BlockUntil(n int) {
+	for !c.waitSleepers(n, time.Hour) {
+	}
+}
+
+// waitSleepers 等待Sleep的协程数达到n，超时返回false
+func (c *FakeClock) waitSleepers(n int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		c.lock.Lock()
+		if c.sleepers >= n {
+			c.lock.Unlock()
+			return true
+		}
+		changed := c.changed
+		c.lock.Unlock()
+		select {
+		case <-changed:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// Stop 实现ClockTimer
+func (w *fakeWaiter) Stop() bool {
+	w.clock.lock.Lock()
+	defer w.clock.lock.Unlock()
+	return w.clock.remove(w)
+}
+
+// Reset 实现ClockTimer
+func (w *fakeWaiter) Reset(d time.Duration) bool {
+	w.clock.lock.Lock()
+	defer w.clock.lock.Unlock()
+	active := w.clock.remove(w)
+	w.clock.add(w, d)
+	return active
+}
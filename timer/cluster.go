@@ -0,0 +1,57 @@
+package timer
+
+import (
+	"context"
+	"gonet/logger"
+	"strconv"
+	"time"
+)
+
+/*
+	集群任务
+	多个实例创建同名的集群任务时，每次触发只由认领成功的一个实例执行
+	Locker可以为每次触发单独加锁，持有锁的实例退出后下一次触发由其他实例认领
+	也可以只让持有租约的实例执行，租约过期后由其他实例接替
+	每次认领得到一个单调递增的fencing token，任务通过FencingToken从ctx中取得，写入外部存储时用于拒绝过期的持有者
+*/
+
+// Locker 认领集群任务的一次触发
+type Locker interface {
+	// Claim 认领key对应的一次触发，ttl内其他实例不能认领，成功时返回fencing token
+	Claim(ctx context.Context, key string, ttl time.Duration) (token int64, ok bool, err error)
+}
+
+// WithCluster 集群任务，name在集群中唯一，每次触发以name及计划的触发时间为key通过locker认领
+// ttl为认领的有效时间，应当大于各个实例之间的时钟误差
+// 集群任务的触发时间按interval对齐，各个实例计划的触发时间相同，WithFixedDelay对集群任务无效
+func WithCluster(locker Locker, name string, ttl time.Duration) RepeatOption {
+	return func(o *repeatOptions) {
+		o.locker = locker
+		o.clusterName = name
+		o.claimTTL = ttl
+	}
+}
+
+type fencingTokenKey struct{}
+
+// FencingToken 返回集群任务本次执行认领时得到的fencing token
+func FencingToken(ctx context.Context) (int64, bool) {
+	token, ok := ctx.Value(fencingTokenKey{}).(int64)
+	return token, ok
+}
+
+// claim 认领计划在occurrence的触发，成功时返回携带fencing token的ctx
+func (j *Job) claim(occurrence time.Time) (context.Context, bool) {
+	key := j.opts.clusterName + ":" + strconv.FormatInt(occurrence.UnixNano()/1e6, 10)
+	log := logger.Default().WithFields(logger.Fields{logger.FieldTask: j.df.Name(), logger.FieldTimer: key})
+	token, ok, err := j.opts.locker.Claim(j.ts.ctx, key, j.opts.claimTTL)
+	if err != nil {
+		log.WithField(logger.FieldError, err).Error("claim cluster job failed")
+		return nil, false
+	}
+	if !ok {
+		log.Debug("cluster job is claimed by another instance")
+		return nil, false
+	}
+	return context.WithValue(j.ts.ctx, fencingTokenKey{}, token), true
+}
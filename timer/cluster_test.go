@@ -0,0 +1,80 @@
+package timer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memLocker 进程内的Locker，多个调度器共用时模拟集群
+type memLocker struct {
+	lock   sync.Mutex
+	claims map[string]time.Time
+	token  int64
+}
+
+func (l *memLocker) Claim(_ context.Context, key string, ttl time.Duration) (int64, bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.claims == nil {
+		l.claims = make(map[string]time.Time)
+	}
+	if expire, ok := l.claims[key]; ok && time.Now().Before(expire) {
+		return 0, false, nil
+	}
+	l.claims[key] = time.Now().Add(ttl)
+	l.token++
+	return l.token, true, nil
+}
+
+func TestJob_Cluster(t *testing.T) {
+	locker := &memLocker{}
+	var (
+		lock   sync.Mutex
+		tokens = map[int64]int{}
+	)
+	run := func(instance int) *DelayFunc {
+		return NewTaskFunc("reset", func(ctx context.Context) error {
+			token, ok := FencingToken(ctx)
+			if !ok {
+				t.Error("cluster job should carry a fencing token")
+			}
+			lock.Lock()
+			tokens[token] = instance
+			lock.Unlock()
+			return nil
+		})
+	}
+	var schedulers []*TimerScheduler
+	for i := 0; i < 3; i++ {
+		ts := autoScheduler(t)
+		ts.Every(run(i), 100*time.Millisecond, WithCluster(locker, "reset", time.Second))
+		schedulers = append(schedulers, ts)
+	}
+	time.Sleep(550 * time.Millisecond)
+
+	//认领的实例退出之后由其他实例执行
+	if err := schedulers[0].Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	before := len(tokens)
+	lock.Unlock()
+	time.Sleep(300 * time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	//三个实例共执行约9次(时间轮最多提前MaxDelayTime触发)，每次触发只被认领一次
+	if len(tokens) < 6 || len(tokens) > 11 || len(locker.claims) != len(tokens) {
+		t.Fatalf("unexpected runs %v, claims %d", tokens, len(locker.claims))
+	}
+	if len(tokens)-before < 2 {
+		t.Fatalf("other instances should take over, got %d runs after stop", len(tokens)-before)
+	}
+	for token, instance := range tokens {
+		if token > int64(before) && instance == 0 {
+			t.Fatal("stopped instance should not run")
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package timer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronSchedule_Next(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	base := time.Date(2024, 3, 8, 4, 59, 30, 0, shanghai)
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		//每天5点
+		{"0 5 * * *", base, time.Date(2024, 3, 8, 5, 0, 0, 0, shanghai)},
+		{"0 5 * * *", base.Add(time.Hour), time.Date(2024, 3, 9, 5, 0, 0, 0, shanghai)},
+		//6个字段，每10秒
+		{"*/10 * * * * *", base, time.Date(2024, 3, 8, 4, 59, 40, 0, shanghai)},
+		//每周一20点，周的名称
+		{"0 20 * * MON", base, time.Date(2024, 3, 11, 20, 0, 0, 0, shanghai)},
+		//周日可以写作7
+		{"0 0 * * 7", base, time.Date(2024, 3, 10, 0, 0, 0, 0, shanghai)},
+		//范围、步长及列表
+		{"15,45 9-17/4 * * *", base, time.Date(2024, 3, 8, 9, 15, 0, 0, shanghai)},
+		//日和周都有限制时满足其一即可
+		{"0 0 1 * FRI", base, time.Date(2024, 3, 15, 0, 0, 0, 0, shanghai)},
+		//闰年2月29日
+		{"0 0 29 FEB *", base, time.Date(2028, 2, 29, 0, 0, 0, 0, shanghai)},
+		{"@monthly", base, time.Date(2024, 4, 1, 0, 0, 0, 0, shanghai)},
+		//CRON_TZ指定时区，返回的时间使用from的时区
+		{"CRON_TZ=America/New_York 0 5 * * *", base, time.Date(2024, 3, 8, 5, 0, 0, 0, newYork).In(shanghai)},
+		//夏令时开始的那天2点不存在
+		{"TZ=America/New_York 30 2 * * *", time.Date(2024, 3, 9, 3, 0, 0, 0, newYork), time.Date(2024, 3, 11, 2, 30, 0, 0, newYork)},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.spec, shanghai)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.spec, err)
+		}
+		if got := s.Next(tt.from); !got.Equal(tt.want) || got.Location() != tt.from.Location() {
+			t.Errorf("%s: Next(%v) = %v, want %v", tt.spec, tt.from, got, tt.want)
+		}
+	}
+
+	s, _ := ParseCron("0 0 30 FEB *")
+	if !s.Next(base).IsZero() {
+		t.Fatal("impossible schedule should return zero time")
+	}
+}
+
+func TestParseCron_Errors(t *testing.T) {
+	for _, spec := range []string{
+		"", "* * * *", "* * * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *",
+		"* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "@every 5s", "CRON_TZ=Nowhere/City * * * * *",
+	} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("%q should fail", spec)
+		}
+	}
+}
@@ -1,18 +1,38 @@
 package timer
 
 import (
+	"context"
 	"fmt"
+	"gonet/logger"
 	"reflect"
+	"runtime"
+	"runtime/debug"
 )
 
 /*
 	    定义一个延迟调用函数
 		延迟调用函数就是 时间定时器超时的时候，触发的事先注册好的
 		回调函数
+		推荐使用NewTaskFunc创建，任务接收ctx并返回error，错误及panic交给调度器的ErrorHandler
 */
+
+// Task 定时器触发时执行的任务，ctx在调度器关闭时被取消
+type Task func(ctx context.Context) error
+
+// TaskOf 将带有类型参数的函数转换为Task，参数不需要再做类型断言
+func TaskOf[T any](f func(ctx context.Context, arg T) error, arg T) Task {
+	return func(ctx context.Context) error {
+		return f(ctx, arg)
+	}
+}
+
 type DelayFunc struct {
 	f    func(...interface{}) //延迟调用函数原型
 	args []interface{}        //延迟调用函数的参数
+	task Task                 //NewTaskFunc创建时的任务
+	//任务名称及标签，用于日志及错误报告
+	name   string
+	labels map[string]string
 }
 
 // NewDelayFunc 创建一个延迟调用函数
@@ -20,20 +40,115 @@ func NewDelayFunc(f func(v ...interface{}), args []interface{}) *DelayFunc {
 	return &DelayFunc{
 		f:    f,
 		args: args,
+		name: runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name(),
 	}
 }
 
+// NewTaskFunc 使用Task创建一个延迟调用函数，name用于日志及错误报告
+func NewTaskFunc(name string, task Task) *DelayFunc {
+	return &DelayFunc{
+		task: task,
+		name: name,
+	}
+}
+
+// WithLabels 附加用于诊断的标签，返回df本身
+func (df *DelayFunc) WithLabels(labels map[string]string) *DelayFunc {
+	df.labels = make(map[string]string, len(labels))
+	for k, v := range labels {
+		df.labels[k] = v
+	}
+	return df
+}
+
+// Name 返回任务名称
+func (df *DelayFunc) Name() string {
+	return df.name
+}
+
+// Labels 返回任务的标签
+func (df *DelayFunc) Labels() map[string]string {
+	return df.labels
+}
+
 // String 打印当前延迟函数的信息，用于日志记录
 func (df *DelayFunc) String() string {
-	return fmt.Sprintf("{DelayFunc:%s, args:%v}", reflect.TypeOf(df.f).Name(), df.args)
+	if df.task != nil {
+		return fmt.Sprintf("{DelayFunc:%s, labels:%v}", df.name, df.labels)
+	}
+	return fmt.Sprintf("{DelayFunc:%s, args:%v}", df.name, df.args)
 }
 
-func (df *DelayFunc) Call() {
+// CallContext 调用延迟方法，任务返回的错误及panic都转换为*TaskError返回
+func (df *DelayFunc) CallContext(ctx context.Context) (err error) {
 	defer func() {
-		if err := recover(); err != nil {
-			_ = fmt.Errorf("%v Call error: %v", df.String(), err)
+		if r := recover(); r != nil {
+			err = &TaskError{
+				Name:   df.name,
+				Labels: df.labels,
+				Err:    fmt.Errorf("panic: %v", r),
+				Panic:  r,
+				Stack:  debug.Stack(),
+			}
 		}
 	}()
-	//调用定时器超时函数
-	df.f(df.args...)
+	if df.task == nil {
+		//调用定时器超时函数
+		df.f(df.args...)
+		return nil
+	}
+	if taskErr := df.task(ctx); taskErr != nil {
+		return &TaskError{Name: df.name, Labels: df.labels, Err: taskErr}
+	}
+	return nil
+}
+
+// Call 调用延迟方法，错误交给DefaultErrorHandler
+func (df *DelayFunc) Call() {
+	if err := df.CallContext(context.Background()); err != nil {
+		DefaultErrorHandler(err.(*TaskError))
+	}
+}
+
+// TaskError 任务返回的错误或者任务中的panic
+type TaskError struct {
+	Name   string
+	Labels map[string]string
+	//任务返回的错误，panic时为描述panic的错误
+	Err error
+	//panic的值及调用栈，任务没有panic时为nil
+	Panic interface{}
+	Stack []byte
+}
+
+func (e *TaskError) Error() string {
+	return "timer task " + e.Name + ": " + e.Err.Error()
+}
+
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorHandler 处理任务返回的错误及panic
+type ErrorHandler func(err *TaskError)
+
+// DefaultErrorHandler 默认的ErrorHandler，使用默认日志输出错误
+func DefaultErrorHandler(err *TaskError) {
+	LogTaskError(logger.Default(), err)
+}
+
+// LogTaskError 使用log输出任务的错误及panic，自定义的ErrorHandler可以使用各自的日志调用
+func LogTaskError(log logger.Logger, err *TaskError) {
+	log = log.WithFields(logger.Fields{
+		logger.FieldTask:  err.Name,
+		logger.FieldError: err.Err,
+	})
+	if len(err.Labels) > 0 {
+		log = log.WithField("labels", err.Labels)
+	}
+	if err.Panic != nil {
+		log.Errorf("timer task panic\n%s", err.Stack)
+		return
+	}
+	log.Error("timer task failed")
 }
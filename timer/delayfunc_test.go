@@ -1,8 +1,17 @@
 package timer
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"gonet/logger"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 func SayHello(message ...interface{}) {
@@ -36,3 +45,115 @@ func TestDelayFunc_Call(t *testing.T) {
 		})
 	}
 }
+
+type reward struct {
+	PlayerID uint64
+	Gold     int
+}
+
+func TestNewTaskFunc(t *testing.T) {
+	var got reward
+	df := NewTaskFunc("reward", TaskOf(func(ctx context.Context, r reward) error {
+		got = r
+		return nil
+	}, reward{PlayerID: 1, Gold: 100})).WithLabels(map[string]string{"player": "1"})
+	if err := df.CallContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got.PlayerID != 1 || got.Gold != 100 || df.Name() != "reward" || df.Labels()["player"] != "1" {
+		t.Fatalf("unexpected result %+v %s", got, df)
+	}
+
+	//任务返回的错误及panic都转换为TaskError
+	errFailed := errors.New("failed")
+	err := NewTaskFunc("fail", func(context.Context) error { return errFailed }).CallContext(context.Background())
+	var taskErr *TaskError
+	if !errors.As(err, &taskErr) || !errors.Is(err, errFailed) || taskErr.Name != "fail" || taskErr.Panic != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err = NewTaskFunc("panic", func(context.Context) error { panic("boom") }).CallContext(context.Background())
+	if !errors.As(err, &taskErr) || taskErr.Panic != "boom" || len(taskErr.Stack) == 0 {
+		t.Fatalf("unexpected error %v", err)
+	}
+	//旧的延迟函数同样可以报告panic
+	err = NewDelayFunc(func(...interface{}) { panic("legacy") }, nil).CallContext(context.Background())
+	if !errors.As(err, &taskErr) || taskErr.Panic != "legacy" || !strings.Contains(taskErr.Name, "TestNewTaskFunc") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestTimerScheduler_ErrorHandler(t *testing.T) {
+	errs := make(chan *TaskError, 2)
+	ts := autoScheduler(t, WithErrorHandler(func(err *TaskError) { errs <- err }))
+	ts.AfterFunc(NewTaskFunc("daily-reset", func(context.Context) error {
+		return errors.New("db unavailable")
+	}).WithLabels(map[string]string{"shard": "3"}), 10*time.Millisecond)
+	ts.Every(NewTaskFunc("tick", func(context.Context) error { panic("tick panic") }), 50*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			switch err.Name {
+			case "daily-reset":
+				if err.Labels["shard"] != "3" || err.Err.Error() != "db unavailable" {
+					t.Fatalf("unexpected error %+v", err)
+				}
+			case "tick":
+				if err.Panic == nil {
+					t.Fatalf("unexpected error %+v", err)
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatal("error handler should be called")
+		}
+	}
+}
+
+func TestTimerScheduler_Context(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ts := autoScheduler(t, WithContext(ctx))
+
+	started, done := make(chan struct{}), make(chan error)
+	ts.AfterFunc(NewTaskFunc("wait", func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		done <- ctx.Err()
+		return nil
+	}), 10*time.Millisecond)
+	<-started
+
+	//关闭之后正在执行的任务得到通知，等待中的定时器不再触发
+	df, called := notifyFunc()
+	ts.AfterFunc(df, 100*time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("unexpected ctx error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("task ctx should be cancelled")
+	}
+	expectNoCall(t, called, 300*time.Millisecond)
+}
+
+func TestLogTaskError(t *testing.T) {
+	var out bytes.Buffer
+	l := logrus.New()
+	l.SetOutput(&out)
+	l.SetFormatter(&logrus.JSONFormatter{})
+	LogTaskError(logger.NewLogrus(l).WithField("server", "test"), &TaskError{
+		Name:   "reset",
+		Labels: map[string]string{"room": "1"},
+		Err:    errors.New("db unavailable"),
+	})
+	var record map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	//保留调用方日志中的字段
+	if record["msg"] != "timer task failed" || record[logger.FieldTask] != "reset" ||
+		record[logger.FieldError] != "db unavailable" || record["server"] != "test" {
+		t.Fatalf("unexpected record %v", record)
+	}
+}
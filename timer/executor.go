@@ -0,0 +1,179 @@
+package timer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	执行触发的延迟方法的协程池
+	固定数量的worker从一个有界队列中取任务执行，队列满时提交的一方阻塞，避免每个触发都创建一个协程
+*/
+
+// ExecutorStats 协程池的运行统计
+type ExecutorStats struct {
+	// Workers worker的个数
+	Workers int
+	// QueueCap 队列的容量
+	QueueCap int
+	// Queued 正在队列中等待的任务数
+	Queued int
+	// Running 正在执行的任务数
+	Running int
+	// Submitted 进入队列的任务总数
+	Submitted uint64
+	// Executed 执行完毕的任务总数
+	Executed uint64
+	// Rejected 因为协程池停止或者提交的ctx结束而没有进入队列的任务总数
+	Rejected uint64
+	// AvgQueueWait 开始执行的任务在队列中的平均等待时间
+	AvgQueueWait time.Duration
+	// MaxQueueWait 开始执行的任务在队列中的最长等待时间
+	MaxQueueWait time.Duration
+}
+
+// execTask 队列中的任务
+type execTask struct {
+	df       *DelayFunc
+	queuedAt time.Time
+}
+
+// executor 有界的协程池
+type executor struct {
+	//统计，原子操作，64位的字段放在最前面保证对齐
+	submitted uint64
+	executed  uint64
+	rejected  uint64
+	//开始执行的任务数及排队时间(ns)
+	started   uint64
+	waitTotal int64
+	waitMax   int64
+	running   int32
+
+	workers int
+	queue   chan execTask
+	//执行一个任务
+	exec func(df *DelayFunc)
+	//关闭之后不再接收任务，worker执行完队列中剩余的任务后退出
+	stop      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+	wg        sync.WaitGroup
+}
+
+func newExecutor(workers int, queueLen int, exec func(df *DelayFunc)) *executor {
+	if workers <= 0 {
+		workers = DefaultExecWorkers
+	}
+	if queueLen < 0 {
+		queueLen = 0
+	}
+	return &executor{
+		workers: workers,
+		queue:   make(chan execTask, queueLen),
+		exec:    exec,
+		stop:    make(chan struct{}),
+	}
+}
+
+// start 启动全部worker，只有第一次调用生效
+func (e *executor) start() {
+	e.startOnce.Do(func() {
+		e.wg.Add(e.workers)
+		for i := 0; i < e.workers; i++ {
+			go e.work()
+		}
+	})
+}
+
+// submit 将任务放入队列，队列满时阻塞，协程池停止或ctx结束时放弃并返回false
+func (e *executor) submit(ctx context.Context, df *DelayFunc) bool {
+	select {
+	case <-e.stop:
+		atomic.AddUint64(&e.rejected, 1)
+		return false
+	default:
+	}
+	select {
+	case e.queue <- execTask{df: df, queuedAt: time.Now()}:
+		atomic.AddUint64(&e.submitted, 1)
+		return true
+	case <-e.stop:
+	case <-ctx.Done():
+	}
+	atomic.AddUint64(&e.rejected, 1)
+	return false
+}
+
+func (e *executor) work() {
+	defer e.wg.Done()
+	for {
+		select {
+		case task := <-e.queue:
+			e.run(task)
+		case <-e.stop:
+			//执行队列中剩余的任务，任务的ctx此时已经取消
+			for {
+				select {
+				case task := <-e.queue:
+					e.run(task)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *executor) run(task execTask) {
+	wait := int64(time.Since(task.queuedAt))
+	atomic.AddUint64(&e.started, 1)
+	atomic.AddInt64(&e.waitTotal, wait)
+	for cur := atomic.LoadInt64(&e.waitMax); wait > cur; cur = atomic.LoadInt64(&e.waitMax) {
+		if atomic.CompareAndSwapInt64(&e.waitMax, cur, wait) {
+			break
+		}
+	}
+
+	atomic.AddInt32(&e.running, 1)
+	defer func() {
+		atomic.AddInt32(&e.running, -1)
+		atomic.AddUint64(&e.executed, 1)
+	}()
+	e.exec(task.df)
+}
+
+// shutdown 不再接收新的任务，等待worker执行完队列中剩余的任务后退出，ctx结束时不再等待
+func (e *executor) shutdown(ctx context.Context) error {
+	e.stopOnce.Do(func() { close(e.stop) })
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *executor) stats() ExecutorStats {
+	s := ExecutorStats{
+		Workers:      e.workers,
+		QueueCap:     cap(e.queue),
+		Queued:       len(e.queue),
+		Running:      int(atomic.LoadInt32(&e.running)),
+		Submitted:    atomic.LoadUint64(&e.submitted),
+		Executed:     atomic.LoadUint64(&e.executed),
+		Rejected:     atomic.LoadUint64(&e.rejected),
+		MaxQueueWait: time.Duration(atomic.LoadInt64(&e.waitMax)),
+	}
+	if started := atomic.LoadUint64(&e.started); started > 0 {
+		s.AvgQueueWait = time.Duration(atomic.LoadInt64(&e.waitTotal) / int64(started))
+	}
+	return s
+}
@@ -0,0 +1,76 @@
+package timer
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTimerScheduler_Executor(t *testing.T) {
+	const workers, queueLen = 2, 4
+	ts := autoScheduler(t, WithExecutor(workers, queueLen))
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 8)
+	for i := 0; i < 6; i++ {
+		ts.AfterFunc(NewTaskFunc("block", func(ctx context.Context) error {
+			started <- struct{}{}
+			<-release
+			return nil
+		}), 10*time.Millisecond)
+	}
+	for i := 0; i < workers; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatal("task should start")
+		}
+	}
+
+	//worker全部被占用，其余的任务在队列中等待
+	deadline := time.Now().Add(time.Second)
+	for ts.ExecutorStats().Queued != 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stats := ts.ExecutorStats()
+	if stats.Workers != workers || stats.QueueCap != queueLen || stats.Running != workers || stats.Queued != 4 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	select {
+	case <-started:
+		t.Fatal("at most workers tasks should run")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	deadline = time.Now().Add(time.Second)
+	for ts.ExecutorStats().Executed != 6 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stats = ts.ExecutorStats()
+	if stats.Submitted != 6 || stats.Executed != 6 || stats.Queued != 0 || stats.Running != 0 || stats.MaxQueueWait < 40*time.Millisecond {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestTimerScheduler_StopTimeout(t *testing.T) {
+	ts := NewAutoExecTimerScheduler()
+	started, release := make(chan struct{}), make(chan struct{})
+	ts.AfterFunc(NewTaskFunc("ignore ctx", func(context.Context) error {
+		close(started)
+		<-release
+		return nil
+	}), 10*time.Millisecond)
+	<-started
+
+	//任务没有响应ctx的取消，Stop等待到ctx结束
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := ts.Stop(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error %v", err)
+	}
+	close(release)
+	if err := ts.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
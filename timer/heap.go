@@ -0,0 +1,209 @@
+package timer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+	4叉最小堆backend
+	按触发时间排序的4叉堆，堆顶是最早触发的定时器，只使用一个系统定时器对准堆顶的触发时间
+	4叉堆比2叉堆的层数少一半，下沉时比较的子节点更多，但子节点在内存中相邻，整体上插入及删除更快
+	系统定时器到期时唤醒触发协程，取出全部到期的定时器后重新对准新的堆顶
+*/
+
+// heapArity 堆的叉数
+const heapArity = 4
+
+// heapItem 堆中的定时器，触发时间在加入时复制，Reset只会在删除之后修改定时器的触发时间
+type heapItem struct {
+	deadline int64
+	t        *Timer
+}
+
+// heapBackend 4叉最小堆
+type heapBackend struct {
+	ts *TimerScheduler
+	//保护以下字段及Timer.heapIndex
+	lock  sync.Mutex
+	items []heapItem
+	//对准堆顶的系统定时器，第一次对准时创建
+	timer ClockTimer
+	//timer对准的触发时间(ms)，为0时没有对准
+	armedAt int64
+	started bool
+	//timer到期时唤醒触发协程
+	wake chan struct{}
+}
+
+func newHeapBackend(ts *TimerScheduler) *heapBackend {
+	return &heapBackend{ts: ts, wake: make(chan struct{}, 1)}
+}
+
+func (h *heapBackend) add(t *Timer) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.contains(t) {
+		h.removeAt(t.heapIndex)
+	}
+	h.items = append(h.items, heapItem{deadline: t.deadline(), t: t})
+	h.up(len(h.items) - 1)
+	if h.items[0].t == t {
+		h.arm()
+	}
+	return nil
+}
+
+// remove 删除定时器，堆顶被删除时系统定时器仍然对准原来的时间，到期后重新对准
+func (h *heapBackend) remove(t *Timer) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !h.contains(t) {
+		return false
+	}
+	h.removeAt(t.heapIndex)
+	return true
+}
+
+func (h *heapBackend) start() {
+	h.lock.Lock()
+	h.started = true
+	h.arm()
+	h.lock.Unlock()
+	h.ts.loops.Add(1)
+	go h.loop()
+}
+
+func (h *heapBackend) stop(context.Context) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.started = false
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	return nil
+}
+
+// loop 被唤醒时取出全部到期的定时器，将延迟方法按触发时间的顺序写入触发通道
+func (h *heapBackend) loop() {
+	ts := h.ts
+	defer ts.loops.Done()
+	var due []*Timer
+	for {
+		select {
+		case <-h.wake:
+		case <-ts.ctx.Done():
+			return
+		}
+		now := unixMill(ts.clock)
+		h.lock.Lock()
+		h.armedAt = 0
+		for len(h.items) > 0 && h.items[0].deadline <= now {
+			due = append(due, h.removeAt(0))
+		}
+		h.arm()
+		h.lock.Unlock()
+		for i, t := range due {
+			if !ts.trigger(t, now, 0) {
+				return
+			}
+			due[i] = nil
+		}
+		due = due[:0]
+	}
+}
+
+// notify 系统定时器到期，唤醒触发协程
+func (h *heapBackend) notify() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// arm 将系统定时器对准堆顶的触发时间，已经对准更早的时间时不修改，调用者持有h.lock
+func (h *heapBackend) arm() {
+	if !h.started || len(h.items) == 0 {
+		return
+	}
+	deadline := h.items[0].deadline
+	if h.armedAt != 0 && h.armedAt <= deadline {
+		return
+	}
+	d := time.Duration(deadline*1e6 - h.ts.clock.Now().UnixNano())
+	if h.timer == nil {
+		h.timer = h.ts.clock.AfterFunc(d, h.notify)
+	} else {
+		h.timer.Reset(d)
+	}
+	h.armedAt = deadline
+}
+
+// contains 定时器是否在堆中，调用者持有h.lock
+func (h *heapBackend) contains(t *Timer) bool {
+	i := t.heapIndex
+	return i >= 0 && i < len(h.items) && h.items[i].t == t
+}
+
+// removeAt 删除位置i的定时器，调用者持有h.lock
+func (h *heapBackend) removeAt(i int) *Timer {
+	t := h.items[i].t
+	last := len(h.items) - 1
+	if i != last {
+		h.items[i] = h.items[last]
+		h.items[i].t.heapIndex = i
+	}
+	h.items[last] = heapItem{}
+	h.items = h.items[:last]
+	if i < last && !h.down(i) {
+		h.up(i)
+	}
+	t.heapIndex = -1
+	return t
+}
+
+// up 将位置i的定时器上浮
+func (h *heapBackend) up(i int) {
+	item := h.items[i]
+	for i > 0 {
+		p := (i - 1) / heapArity
+		if h.items[p].deadline <= item.deadline {
+			break
+		}
+		h.items[i] = h.items[p]
+		h.items[i].t.heapIndex = i
+		i = p
+	}
+	h.items[i] = item
+	item.t.heapIndex = i
+}
+
+// down 将位置i的定时器下沉，返回是否移动
+func (h *heapBackend) down(i int) bool {
+	n := len(h.items)
+	item := h.items[i]
+	start := i
+	for {
+		first := i*heapArity + 1
+		if first >= n {
+			break
+		}
+		//找到最早触发的子节点
+		m := first
+		for j := first + 1; j < first+heapArity && j < n; j++ {
+			if h.items[j].deadline < h.items[m].deadline {
+				m = j
+			}
+		}
+		if item.deadline <= h.items[m].deadline {
+			break
+		}
+		h.items[i] = h.items[m]
+		h.items[i].t.heapIndex = i
+		i = m
+	}
+	h.items[i] = item
+	item.t.heapIndex = i
+	return i != start
+}
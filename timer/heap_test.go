@@ -0,0 +1,235 @@
+package timer
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestHeapBackend_Order(t *testing.T) {
+	ts := NewTimerScheduler(WithBackend(BackendHeap))
+	h := ts.backend.(*heapBackend)
+	rnd := rand.New(rand.NewSource(1))
+
+	//随机添加及删除，剩余的定时器按触发时间依次取出
+	var kept []int64
+	for i := 0; i < 2000; i++ {
+		tm := NewTimerAt(nil, rnd.Int63n(1e6)*1e6)
+		if err := h.add(tm); err != nil {
+			t.Fatal(err)
+		}
+		if i%3 == 0 {
+			if !h.remove(tm) || h.remove(tm) {
+				t.Fatal("timer should be removed once")
+			}
+			continue
+		}
+		kept = append(kept, tm.deadline())
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i] < kept[j] })
+	for i, want := range kept {
+		for j, item := range h.items {
+			if item.t.heapIndex != j {
+				t.Fatalf("heap index of %d is %d", j, item.t.heapIndex)
+			}
+		}
+		if got := h.removeAt(0).deadline(); got != want {
+			t.Fatalf("pop %d: want %d, got %d", i, want, got)
+		}
+	}
+	if len(h.items) != 0 {
+		t.Fatalf("heap should be empty, got %d", len(h.items))
+	}
+}
+
+func TestHeapBackend_FakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	ts := NewTimerScheduler(WithClock(clock), WithBackend(BackendHeap))
+	//Start之前添加的定时器在Start时开始计时
+	ts.AfterFunc(NewDelayFunc(nil, []interface{}{"c"}), 30*time.Millisecond)
+	ts.Start()
+	t.Cleanup(func() {
+		if err := ts.Stop(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+	ts.AfterFunc(NewDelayFunc(nil, []interface{}{"a"}), 10*time.Millisecond)
+	b := ts.AfterFunc(NewDelayFunc(nil, []interface{}{"b"}), 20*time.Millisecond)
+	stopped := ts.AfterFunc(NewDelayFunc(nil, []interface{}{"stopped"}), 15*time.Millisecond)
+	stopped.Stop()
+
+	expectTrigger := func(want string) {
+		t.Helper()
+		select {
+		case df := <-ts.GetTriggerChan():
+			if got := df.args[0].(string); got != want {
+				t.Fatalf("want %s, got %s", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s should fire", want)
+		}
+	}
+	expectNone := func() {
+		t.Helper()
+		select {
+		case df := <-ts.GetTriggerChan():
+			t.Fatalf("unexpected fire %v", df.args[0])
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	//每个定时器都在触发时间准时触发
+	clock.Advance(9 * time.Millisecond)
+	expectNone()
+	clock.Advance(time.Millisecond)
+	expectTrigger("a")
+	//Reset推迟之后按新的时间触发
+	b.Reset(25 * time.Millisecond)
+	clock.Advance(10 * time.Millisecond)
+	expectNone()
+	clock.Advance(10 * time.Millisecond)
+	expectTrigger("c")
+	clock.Advance(5 * time.Millisecond)
+	expectTrigger("b")
+	expectNone()
+}
+
+func TestTimerScheduler_HeapPrecision(t *testing.T) {
+	ts := autoScheduler(t, WithBackend(BackendHeap))
+	type fire struct {
+		want time.Time
+		got  time.Time
+	}
+	fired := make(chan fire, 8)
+	start := time.Now()
+	for i := 1; i <= 5; i++ {
+		want := start.Add(time.Duration(i*7) * time.Millisecond)
+		ts.AtFunc(NewDelayFunc(func(...interface{}) {
+			fired <- fire{want: want, got: time.Now()}
+		}, nil), want.UnixNano())
+	}
+	for i := 0; i < 5; i++ {
+		select {
+		case f := <-fired:
+			//触发时间以毫秒为单位，最多提前1ms
+			if late := f.got.Sub(f.want); late < -time.Millisecond || late > 10*time.Millisecond {
+				t.Fatalf("timer fired %v late", late)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timer should fire")
+		}
+	}
+}
+
+// benchTimers 基准测试中预先添加的定时器个数
+const benchTimers = 1000000
+
+// benchScheduler 创建一个已经添加了benchTimers个一小时之后触发的定时器的调度器
+func benchScheduler(b *testing.B, backend Backend, start bool) (*TimerScheduler, *rand.Rand) {
+	ts := NewTimerScheduler(WithBackend(backend))
+	rnd := rand.New(rand.NewSource(1))
+	df := NewDelayFunc(func(...interface{}) {}, nil)
+	now := time.Now()
+	for i := 0; i < benchTimers; i++ {
+		ts.AtFunc(df, benchDeadline(now, rnd))
+	}
+	if start {
+		ts.Start()
+	}
+	b.Cleanup(func() { _ = ts.Stop(context.Background()) })
+	return ts, rnd
+}
+
+// benchDeadline 一小时到十一小时之间的随机触发时间
+func benchDeadline(now time.Time, rnd *rand.Rand) int64 {
+	return now.Add(time.Hour + time.Duration(rnd.Int63n(int64(10*time.Hour)))).UnixNano()
+}
+
+var benchBackends = []struct {
+	name    string
+	backend Backend
+}{
+	{"TimeWheel", BackendTimeWheel},
+	{"Heap", BackendHeap},
+}
+
+func BenchmarkBackend_Insert(b *testing.B) {
+	for _, bb := range benchBackends {
+		bb := bb
+		b.Run(bb.name, func(b *testing.B) {
+			ts, rnd := benchScheduler(b, bb.backend, false)
+			df := NewDelayFunc(func(...interface{}) {}, nil)
+			now := time.Now()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ts.AtFunc(df, benchDeadline(now, rnd))
+			}
+		})
+	}
+}
+
+func BenchmarkBackend_Cancel(b *testing.B) {
+	for _, bb := range benchBackends {
+		bb := bb
+		b.Run(bb.name, func(b *testing.B) {
+			ts, rnd := benchScheduler(b, bb.backend, false)
+			df := NewDelayFunc(func(...interface{}) {}, nil)
+			now := time.Now()
+			timers := make([]*Timer, b.N)
+			for i := range timers {
+				timers[i] = ts.AtFunc(df, benchDeadline(now, rnd))
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for _, tm := range timers {
+				tm.Stop()
+			}
+		})
+	}
+}
+
+// BenchmarkBackend_Fire 每批最多1024个同时到期的定时器，计时从第一个定时器写入触发通道开始，到全部写入为止
+// err-ms为定时器写入触发通道的时间与触发时间之差的平均绝对值
+func BenchmarkBackend_Fire(b *testing.B) {
+	const batch = 1024
+	for _, bb := range benchBackends {
+		bb := bb
+		b.Run(bb.name, func(b *testing.B) {
+			ts, _ := benchScheduler(b, bb.backend, true)
+			df := NewDelayFunc(func(...interface{}) {}, nil)
+			var errSum time.Duration
+			received := func(at time.Time) {
+				d := time.Since(at)
+				if d < 0 {
+					d = -d
+				}
+				errSum += d
+			}
+			b.ResetTimer()
+			for done := 0; done < b.N; {
+				b.StopTimer()
+				n := b.N - done
+				if n > batch {
+					n = batch
+				}
+				at := time.Now().Add(20 * time.Millisecond)
+				for i := 0; i < n; i++ {
+					ts.AtFunc(df, at.UnixNano())
+				}
+				<-ts.GetTriggerChan()
+				received(at)
+				b.StartTimer()
+				for i := 1; i < n; i++ {
+					<-ts.GetTriggerChan()
+					received(at)
+				}
+				done += n
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(errSum)/float64(b.N)/1e6, "err-ms")
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package timer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gonet/logger"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+	持久化的定时器
+	定时器的定义(名称、触发时间、负载、处理方法的key)保存在Store中，进程重启后通过Restore重新加载到时间轮
+	处理方法不能持久化，需要在Restore之前通过Handle按key注册
+	处理方法成功返回之后才从Store中删除，返回错误或进程在执行期间退出时，重启后会再次触发
+	处理方法返回错误时不在当前进程中重试，定时器从Pending中移除，Store中的定义保留到下一次Restore
+*/
+
+// ErrHandlerNotFound 定时器的处理方法没有注册
+var ErrHandlerNotFound = errors.New("timer handler not registered")
+
+// TimerRecord 持久化的定时器定义
+type TimerRecord struct {
+	// Name 定时器的唯一名称，同名的定时器会被替换
+	Name string `json:"name"`
+	// FireAt 触发时间
+	FireAt time.Time `json:"fire_at"`
+	// Payload 序列化之后的负载，交给处理方法
+	Payload []byte `json:"payload,omitempty"`
+	// Handler 处理方法的key
+	Handler string `json:"handler"`
+}
+
+// Store 持久化定时器的存储
+type Store interface {
+	// Save 保存定时器，同名的定时器被覆盖
+	Save(ctx context.Context, rec *TimerRecord) error
+	// Delete 删除定时器，不存在时不返回错误
+	Delete(ctx context.Context, name string) error
+	// LoadAll 读取全部定时器
+	LoadAll(ctx context.Context) ([]*TimerRecord, error)
+}
+
+// PersistHandler 持久化定时器的处理方法
+type PersistHandler func(ctx context.Context, rec *TimerRecord) error
+
+// CatchUpPolicy Restore时已经过期的定时器的处理方式
+type CatchUpPolicy int
+
+const (
+	// CatchUpFire 立即触发
+	CatchUpFire CatchUpPolicy = iota
+	// CatchUpSkip 不再触发，直接从Store中删除
+	CatchUpSkip
+)
+
+type persistOptions struct {
+	catchUp     CatchUpPolicy
+	maxLateness time.Duration
+}
+
+// PersistOption 持久化调度器的选项
+type PersistOption func(*persistOptions)
+
+// WithCatchUp Restore时已经过期的定时器的处理方式，默认为CatchUpFire
+func WithCatchUp(policy CatchUpPolicy) PersistOption {
+	return func(o *persistOptions) { o.catchUp = policy }
+}
+
+// WithMaxLateness 过期超过d的定时器按CatchUpSkip处理，0表示不限制
+func WithMaxLateness(d time.Duration) PersistOption {
+	return func(o *persistOptions) { o.maxLateness = d }
+}
+
+// persistEntry 一个已经加入时间轮的持久化定时器
+type persistEntry struct {
+	rec   TimerRecord
+	timer *Timer
+}
+
+// PersistentScheduler 将定时器保存到Store的调度器
+type PersistentScheduler struct {
+	ts    *TimerScheduler
+	store Store
+	opts  persistOptions
+	//保护以下字段，Store的读写也在锁内进行，保证同名定时器的保存及删除有序
+	lock     sync.Mutex
+	handlers map[string]PersistHandler
+	entries  map[string]*persistEntry
+}
+
+// NewPersistentScheduler 创建一个使用ts调度、保存到store的持久化调度器
+func NewPersistentScheduler(ts *TimerScheduler, store Store, opts ...PersistOption) *PersistentScheduler {
+	ps := &PersistentScheduler{
+		ts:       ts,
+		store:    store,
+		handlers: make(map[string]PersistHandler),
+		entries:  make(map[string]*persistEntry),
+	}
+	for _, opt := range opts {
+		opt(&ps.opts)
+	}
+	return ps
+}
+
+// Handle 注册处理方法，需要在Schedule及Restore之前调用
+func (ps *PersistentScheduler) Handle(key string, h PersistHandler) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	ps.handlers[key] = h
+}
+
+// Schedule 保存定时器并加入时间轮，同名的定时器被替换
+func (ps *PersistentScheduler) Schedule(ctx context.Context, name string, fireAt time.Time, handler string, payload []byte) error {
+	rec := TimerRecord{Name: name, FireAt: fireAt, Payload: payload, Handler: handler}
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	if _, ok := ps.handlers[handler]; !ok {
+		return fmt.Errorf("%w: %s", ErrHandlerNotFound, handler)
+	}
+	if err := ps.store.Save(ctx, &rec); err != nil {
+		return err
+	}
+	ps.schedule(rec, fireAt)
+	return nil
+}
+
+// Cancel 停止定时器并从Store中删除，返回是否阻止了处理方法的调用
+func (ps *PersistentScheduler) Cancel(ctx context.Context, name string) (bool, error) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	stopped := false
+	if e, ok := ps.entries[name]; ok {
+		stopped = e.timer.Stop()
+		delete(ps.entries, name)
+	}
+	return stopped, ps.store.Delete(ctx, name)
+}
+
+// Pending 返回等待触发的定时器定义，按触发时间排序
+func (ps *PersistentScheduler) Pending() []TimerRecord {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	recs := make([]TimerRecord, 0, len(ps.entries))
+	for _, e := range ps.entries {
+		recs = append(recs, e.rec)
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].FireAt.Before(recs[j].FireAt) })
+	return recs
+}
+
+// Restore 从Store中加载全部定时器，未到期的加入时间轮，已经过期的按CatchUpPolicy处理
+// 处理方法没有注册的定时器保留在Store中，不加入时间轮
+func (ps *PersistentScheduler) Restore(ctx context.Context) error {
+	recs, err := ps.store.LoadAll(ctx)
+	if err != nil {
+		return err
+	}
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	now := ps.ts.clock.Now()
+	for _, rec := range recs {
+		log := logger.Default().WithFields(logger.Fields{logger.FieldTimer: rec.Name, logger.FieldTask: rec.Handler})
+		if _, ok := ps.handlers[rec.Handler]; !ok {
+			log.Error("restore timer failed, handler not registered")
+			continue
+		}
+		fireAt := rec.FireAt
+		if late := now.Sub(fireAt); late > 0 {
+			if ps.opts.catchUp == CatchUpSkip || (ps.opts.maxLateness > 0 && late > ps.opts.maxLateness) {
+				log.Warnf("skip overdue timer, late %v", late)
+				if err := ps.store.Delete(ctx, rec.Name); err != nil {
+					return err
+				}
+				continue
+			}
+			fireAt = now
+		}
+		ps.schedule(*rec, fireAt)
+	}
+	return nil
+}
+
+// schedule 将定时器加入时间轮，替换同名的定时器，调用者持有ps.lock
+func (ps *PersistentScheduler) schedule(rec TimerRecord, fireAt time.Time) {
+	if old, ok := ps.entries[rec.Name]; ok {
+		old.timer.Stop()
+	}
+	e := &persistEntry{rec: rec}
+	df := NewTaskFunc(rec.Handler, func(ctx context.Context) error {
+		return ps.fire(ctx, e)
+	}).WithLabels(map[string]string{logger.FieldTimer: rec.Name})
+	e.timer = ps.ts.AtFunc(df, fireAt.UnixNano())
+	ps.entries[rec.Name] = e
+}
+
+// fire 调用处理方法，成功之后从Store中删除，失败时只从entries中移除，定时器在执行期间被替换时不处理新的定义
+func (ps *PersistentScheduler) fire(ctx context.Context, e *persistEntry) error {
+	ps.lock.Lock()
+	h := ps.handlers[e.rec.Handler]
+	ps.lock.Unlock()
+	rec := e.rec
+	err := h(ctx, &rec)
+
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	if ps.entries[rec.Name] != e {
+		return err
+	}
+	delete(ps.entries, rec.Name)
+	if err != nil {
+		return err
+	}
+	return ps.store.Delete(ctx, rec.Name)
+}
+
+// MemoryStore 保存在内存中的Store，用于测试
+type MemoryStore struct {
+	lock    sync.Mutex
+	records map[string]TimerRecord
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore 创建一个空的MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]TimerRecord)}
+}
+
+func (s *MemoryStore) Save(_ context.Context, rec *TimerRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r := *rec
+	r.Payload = append([]byte(nil), rec.Payload...)
+	s.records[rec.Name] = r
+	return nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.records, name)
+	return nil
+}
+
+func (s *MemoryStore) LoadAll(_ context.Context) ([]*TimerRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	recs := make([]*TimerRecord, 0, len(s.records))
+	for _, r := range s.records {
+		r := r
+		recs = append(recs, &r)
+	}
+	return recs, nil
+}
@@ -0,0 +1,155 @@
+package timer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// persistScheduler 使用FakeClock及store的持久化调度器，注册了处理方法"notify"
+func persistScheduler(t *testing.T, store Store, opts ...PersistOption) (*PersistentScheduler, *FakeClock, chan string) {
+	ts, clock := fakeScheduler(t)
+	ps := NewPersistentScheduler(ts, store, opts...)
+	fired := make(chan string, 8)
+	ps.Handle("notify", func(ctx context.Context, rec *TimerRecord) error {
+		fired <- rec.Name + ":" + string(rec.Payload)
+		return nil
+	})
+	return ps, clock, fired
+}
+
+// advanceAndExec 推进时间并执行触发的延迟方法
+func advanceAndExec(ts *TimerScheduler, clock *FakeClock, d time.Duration) {
+	for end := clock.Now().Add(d); clock.Now().Before(end); {
+		clock.Advance(50 * time.Millisecond)
+		for {
+			select {
+			case df := <-ts.GetTriggerChan():
+				ts.Exec(df)
+				continue
+			default:
+			}
+			break
+		}
+	}
+}
+
+func expectFired(t *testing.T, fired chan string, want ...string) {
+	t.Helper()
+	got := map[string]bool{}
+	for len(fired) > 0 {
+		got[<-fired] = true
+	}
+	if len(got) != len(want) {
+		t.Fatalf("fired %v, want %v", got, want)
+	}
+	for _, w := range want {
+		if !got[w] {
+			t.Fatalf("fired %v, want %v", got, want)
+		}
+	}
+}
+
+func TestPersistentScheduler_Schedule(t *testing.T) {
+	store := NewMemoryStore()
+	ps, clock, fired := persistScheduler(t, store)
+	ctx := context.Background()
+	now := clock.Now()
+
+	if err := ps.Schedule(ctx, "auction:1", now.Add(time.Minute), "unknown", nil); !errors.Is(err, ErrHandlerNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, name := range []string{"auction:1", "auction:2", "building:1"} {
+		if err := ps.Schedule(ctx, name, now.Add(time.Minute), "notify", []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//同名的定时器被替换
+	if err := ps.Schedule(ctx, "auction:2", now.Add(2*time.Minute), "notify", []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if stopped, err := ps.Cancel(ctx, "building:1"); !stopped || err != nil {
+		t.Fatalf("cancel failed: %v %v", stopped, err)
+	}
+	if recs, _ := store.LoadAll(ctx); len(recs) != 2 || len(ps.Pending()) != 2 {
+		t.Fatalf("unexpected records %v", recs)
+	}
+
+	advanceAndExec(ps.ts, clock, time.Minute+time.Second)
+	expectFired(t, fired, "auction:1:auction:1")
+	advanceAndExec(ps.ts, clock, time.Minute)
+	expectFired(t, fired, "auction:2:v2")
+	if recs, _ := store.LoadAll(ctx); len(recs) != 0 || len(ps.Pending()) != 0 {
+		t.Fatalf("fired timers should be deleted, got %v", recs)
+	}
+}
+
+func TestPersistentScheduler_Restore(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []*TimerRecord{
+		{Name: "future", FireAt: base.Add(time.Minute), Handler: "notify"},
+		{Name: "late", FireAt: base.Add(-time.Second), Handler: "notify"},
+		{Name: "very-late", FireAt: base.Add(-time.Hour), Handler: "notify"},
+		{Name: "orphan", FireAt: base.Add(-time.Second), Handler: "removed"},
+	}
+	tests := []struct {
+		name      string
+		opts      []PersistOption
+		immediate []string
+		remaining int
+	}{
+		{"fire", nil, []string{"late:", "very-late:"}, 2},
+		{"skip", []PersistOption{WithCatchUp(CatchUpSkip)}, nil, 2},
+		{"max lateness", []PersistOption{WithMaxLateness(time.Minute)}, []string{"late:"}, 2},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			for _, rec := range records {
+				_ = store.Save(ctx, rec)
+			}
+			ps, clock, fired := persistScheduler(t, store, tt.opts...)
+			if err := ps.Restore(ctx); err != nil {
+				t.Fatal(err)
+			}
+			advanceAndExec(ps.ts, clock, time.Second)
+			expectFired(t, fired, tt.immediate...)
+			//处理方法未注册的定时器保留在store中
+			if recs, _ := store.LoadAll(ctx); len(recs) != tt.remaining {
+				t.Fatalf("expected %d records, got %d", tt.remaining, len(recs))
+			}
+			advanceAndExec(ps.ts, clock, time.Minute)
+			expectFired(t, fired, "future:")
+		})
+	}
+}
+
+func TestPersistentScheduler_HandlerError(t *testing.T) {
+	store := NewMemoryStore()
+	ts, clock := fakeScheduler(t)
+	ps := NewPersistentScheduler(ts, store)
+	ps.Handle("fail", func(context.Context, *TimerRecord) error { return errors.New("db unavailable") })
+	ctx := context.Background()
+	if err := ps.Schedule(ctx, "reward", clock.Now().Add(time.Second), "fail", nil); err != nil {
+		t.Fatal(err)
+	}
+	advanceAndExec(ts, clock, 2*time.Second)
+	//处理失败的定时器保留在store中，重启后再次触发
+	if recs, _ := store.LoadAll(ctx); len(recs) != 1 {
+		t.Fatalf("failed timer should be kept, got %v", recs)
+	}
+	//当前进程中不再重试，不再出现在Pending中
+	if pending := ps.Pending(); len(pending) != 0 {
+		t.Fatalf("failed timer should leave pending, got %v", pending)
+	}
+	//下一次Restore时重新加入
+	if err := ps.Restore(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if pending := ps.Pending(); len(pending) != 1 || pending[0].Name != "reward" {
+		t.Fatalf("failed timer should be restored, got %v", pending)
+	}
+}
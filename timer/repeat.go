@@ -0,0 +1,262 @@
+package timer
+
+import (
+	"context"
+	"gonet/logger"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+/*
+	重复执行的任务
+	每次触发都是时间轮中的一个一次性定时器，触发时再安排下一次
+	固定频率：下一次的时间从上一次计划的时间推算，执行耗时不影响频率，错过的触发不会补上
+	固定间隔：上一次执行结束之后再等待interval
+	上一次执行还未结束时又到了触发时间，按OverlapPolicy处理
+*/
+
+// OverlapPolicy 上一次执行还未结束时又到了触发时间的处理方式
+type OverlapPolicy int
+
+const (
+	// OverlapSkip 跳过本次触发
+	OverlapSkip OverlapPolicy = iota
+	// OverlapAllow 与上一次并发执行
+	OverlapAllow
+	// OverlapQueue 上一次结束之后立即执行，最多排队一次
+	OverlapQueue
+)
+
+type repeatOptions struct {
+	jitter     time.Duration
+	fixedDelay bool
+	overlap    OverlapPolicy
+	loc        *time.Location
+	//集群任务的认领方式，为nil时不是集群任务
+	locker      Locker
+	clusterName string
+	claimTTL    time.Duration
+}
+
+// RepeatOption 重复任务的选项
+type RepeatOption func(*repeatOptions)
+
+// WithJitter 每次触发随机推迟[0, jitter)，避免大量任务在同一时刻触发
+func WithJitter(jitter time.Duration) RepeatOption {
+	return func(o *repeatOptions) { o.jitter = jitter }
+}
+
+// WithFixedDelay 上一次执行结束之后再等待interval，默认为固定频率，对cron任务无效
+func WithFixedDelay() RepeatOption {
+	return func(o *repeatOptions) { o.fixedDelay = true }
+}
+
+// WithOverlap 上一次执行还未结束时又到了触发时间的处理方式，默认为OverlapSkip
+func WithOverlap(policy OverlapPolicy) RepeatOption {
+	return func(o *repeatOptions) { o.overlap = policy }
+}
+
+// WithLocation cron表达式使用的时区，表达式中的CRON_TZ优先，默认为本地时区
+func WithLocation(loc *time.Location) RepeatOption {
+	return func(o *repeatOptions) { o.loc = loc }
+}
+
+// jitterRand 生成jitter的随机数
+var jitterRand = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// Job 重复执行的任务
+type Job struct {
+	ts *TimerScheduler
+	df *DelayFunc
+	//返回下一次计划的触发时间，prev为上一次计划的时间，返回零值时不再触发
+	schedule func(prev time.Time, now time.Time) time.Time
+	opts     repeatOptions
+
+	lock sync.Mutex
+	//当前等待触发的定时器
+	timer *Timer
+	//下一次计划的触发时间，不包含jitter
+	next    time.Time
+	running int
+	//执行期间排队的触发使用的ctx，为nil时没有排队
+	queued  context.Context
+	stopped bool
+}
+
+// Every 创建一个每隔interval执行一次的任务
+func (ts *TimerScheduler) Every(df *DelayFunc, interval time.Duration, opts ...RepeatOption) *Job {
+	j := newJob(ts, df, opts)
+	if j.opts.locker != nil {
+		//集群中各个实例计划的触发时间按interval对齐
+		j.opts.fixedDelay = false
+		j.schedule = func(prev time.Time, now time.Time) time.Time {
+			next := now.Truncate(interval).Add(interval)
+			if next.After(prev) {
+				return next
+			}
+			return prev.Add(interval)
+		}
+	} else if j.opts.fixedDelay {
+		j.schedule = func(_ time.Time, now time.Time) time.Time {
+			return now.Add(interval)
+		}
+	} else {
+		j.schedule = func(prev time.Time, now time.Time) time.Time {
+			if prev.IsZero() {
+				return now.Add(interval)
+			}
+			//跳过已经错过的触发
+			next := prev.Add(interval)
+			if !next.After(now) {
+				next = prev.Add((now.Sub(prev)/interval + 1) * interval)
+			}
+			return next
+		}
+	}
+	j.start()
+	return j
+}
+
+// Cron 创建一个按cron表达式执行的任务，cron任务总是按计划的时间触发
+func (ts *TimerScheduler) Cron(df *DelayFunc, spec string, opts ...RepeatOption) (*Job, error) {
+	j := newJob(ts, df, opts)
+	loc := j.opts.loc
+	if loc == nil {
+		loc = time.Local
+	}
+	cron, err := parseCron(spec, loc)
+	if err != nil {
+		return nil, err
+	}
+	j.opts.fixedDelay = false
+	j.schedule = func(prev time.Time, now time.Time) time.Time {
+		if prev.After(now) {
+			now = prev
+		}
+		return cron.Next(now)
+	}
+	j.start()
+	return j, nil
+}
+
+func newJob(ts *TimerScheduler, df *DelayFunc, opts []RepeatOption) *Job {
+	j := &Job{ts: ts, df: df}
+	for _, opt := range opts {
+		opt(&j.opts)
+	}
+	return j
+}
+
+func (j *Job) start() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.arm(j.schedule(time.Time{}, j.ts.clock.Now()))
+}
+
+// arm 安排下一次触发，调用者持有j.lock
+func (j *Job) arm(next time.Time) {
+	j.next = next
+	if next.IsZero() {
+		j.stopped = true
+		return
+	}
+	at := next
+	if j.opts.jitter > 0 {
+		jitterRand.Lock()
+		at = at.Add(time.Duration(jitterRand.Int63n(int64(j.opts.jitter))))
+		jitterRand.Unlock()
+	}
+	j.timer = j.ts.AtFunc(NewDelayFunc(j.fire, nil), at.UnixNano())
+}
+
+// fire 定时器触发，固定频率的任务先安排下一次触发再执行
+func (j *Job) fire(...interface{}) {
+	j.lock.Lock()
+	if j.stopped {
+		j.lock.Unlock()
+		return
+	}
+	occurrence := j.next
+	if !j.opts.fixedDelay {
+		j.arm(j.schedule(j.next, j.ts.clock.Now()))
+	}
+	j.lock.Unlock()
+
+	ctx := j.ts.ctx
+	if j.opts.locker != nil {
+		var ok bool
+		if ctx, ok = j.claim(occurrence); !ok {
+			return
+		}
+	}
+
+	j.lock.Lock()
+	if j.stopped {
+		j.lock.Unlock()
+		return
+	}
+	if j.running > 0 {
+		switch j.opts.overlap {
+		case OverlapSkip:
+			j.lock.Unlock()
+			logger.Default().WithField(logger.FieldTask, j.df.Name()).Debug("previous run is not finished, skip")
+			return
+		case OverlapQueue:
+			j.queued = ctx
+			j.lock.Unlock()
+			return
+		}
+	}
+	j.running++
+	j.lock.Unlock()
+	j.run(ctx)
+}
+
+// run 执行延迟方法，执行期间排队的触发在结束后立即执行
+func (j *Job) run(ctx context.Context) {
+	for {
+		j.ts.exec(ctx, j.df)
+
+		j.lock.Lock()
+		if j.queued != nil && !j.stopped {
+			ctx, j.queued = j.queued, nil
+			j.lock.Unlock()
+			continue
+		}
+		j.running--
+		if j.opts.fixedDelay && !j.stopped {
+			j.arm(j.schedule(j.next, j.ts.clock.Now()))
+		}
+		j.lock.Unlock()
+		return
+	}
+}
+
+// Next 返回下一次计划的触发时间，不包含jitter，任务停止后返回零值
+func (j *Job) Next() time.Time {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.stopped {
+		return time.Time{}
+	}
+	return j.next
+}
+
+// Stop 停止任务，正在执行的延迟方法不受影响，返回任务之前是否在运行
+func (j *Job) Stop() bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.stopped {
+		return false
+	}
+	j.stopped = true
+	j.queued = nil
+	if j.timer != nil {
+		j.timer.Stop()
+	}
+	return true
+}
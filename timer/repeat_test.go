@@ -0,0 +1,97 @@
+package timer
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimerScheduler_Every(t *testing.T) {
+	ts := autoScheduler(t)
+	var runs int32
+	job := ts.Every(NewDelayFunc(func(...interface{}) { atomic.AddInt32(&runs, 1) }, nil), 100*time.Millisecond)
+	if next := job.Next(); next.IsZero() || time.Until(next) > 100*time.Millisecond {
+		t.Fatalf("unexpected next %v", next)
+	}
+	time.Sleep(550 * time.Millisecond)
+	if !job.Stop() || job.Stop() {
+		t.Fatal("job should be stopped once")
+	}
+	n := atomic.LoadInt32(&runs)
+	if n < 3 || n > 6 {
+		t.Fatalf("expected about 5 runs, got %d", n)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if atomic.LoadInt32(&runs) != n || !job.Next().IsZero() {
+		t.Fatal("stopped job should not run")
+	}
+}
+
+func TestTimerScheduler_EveryOverlap(t *testing.T) {
+	ts := autoScheduler(t)
+	slow := func(runs *int32, concurrent *int32, maxConcurrent *int32) *DelayFunc {
+		return NewDelayFunc(func(...interface{}) {
+			atomic.AddInt32(runs, 1)
+			c := atomic.AddInt32(concurrent, 1)
+			for {
+				m := atomic.LoadInt32(maxConcurrent)
+				if c <= m || atomic.CompareAndSwapInt32(maxConcurrent, m, c) {
+					break
+				}
+			}
+			time.Sleep(250 * time.Millisecond)
+			atomic.AddInt32(concurrent, -1)
+		}, nil)
+	}
+
+	tests := []struct {
+		name          string
+		opts          []RepeatOption
+		minRuns       int32
+		maxRuns       int32
+		maxConcurrent int32
+	}{
+		//执行250ms，间隔100ms，跳过执行期间的触发
+		{"skip", nil, 2, 3, 1},
+		//排队的触发在结束后立即执行
+		{"queue", []RepeatOption{WithOverlap(OverlapQueue)}, 3, 4, 1},
+		{"allow", []RepeatOption{WithOverlap(OverlapAllow)}, 5, 8, 4},
+		//固定间隔，每次执行结束之后再等待100ms
+		{"fixed delay", []RepeatOption{WithFixedDelay(), WithJitter(time.Millisecond)}, 2, 3, 1},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var runs, concurrent, maxConcurrent int32
+			job := ts.Every(slow(&runs, &concurrent, &maxConcurrent), 100*time.Millisecond, tt.opts...)
+			time.Sleep(750 * time.Millisecond)
+			job.Stop()
+			if n := atomic.LoadInt32(&runs); n < tt.minRuns || n > tt.maxRuns {
+				t.Errorf("expected %d-%d runs, got %d", tt.minRuns, tt.maxRuns, n)
+			}
+			if m := atomic.LoadInt32(&maxConcurrent); m > tt.maxConcurrent {
+				t.Errorf("expected at most %d concurrent runs, got %d", tt.maxConcurrent, m)
+			}
+		})
+	}
+}
+
+func TestTimerScheduler_Cron(t *testing.T) {
+	ts := autoScheduler(t)
+	if _, err := ts.Cron(NewDelayFunc(func(...interface{}) {}, nil), "* * *"); err == nil {
+		t.Fatal("bad spec should fail")
+	}
+
+	df, called := notifyFunc()
+	job, err := ts.Cron(df, "* * * * * *", WithLocation(time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer job.Stop()
+	if next := job.Next(); next.Nanosecond() != 0 || next.Location() != time.Local {
+		t.Fatalf("unexpected next %v", next)
+	}
+	expectCall(t, called, 1500*time.Millisecond)
+	expectCall(t, called, 1500*time.Millisecond)
+}
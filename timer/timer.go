@@ -1,6 +1,11 @@
 package timer
 
-import "time"
+import (
+	"gonet/logger"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 const (
 	// HourName 小时
@@ -37,11 +42,33 @@ const (
 	time.Now().UnixNano() ==> time.Nanosecond (纳秒)
 */
 
+// 定时器的状态
+const (
+	timerPending = iota
+	timerFired
+	timerStopped
+)
+
+// Timer 定时器，由TimerScheduler调度或者通过Run单独运行
+// Stop、Reset、Remaining可以在任意协程中调用
 type Timer struct {
+	//定时器ID，由TimerScheduler分配，单独运行的定时器为0
+	id uint32
 	//延迟调用函数
 	delayFunc *DelayFunc
-	//调用时间(unix时间，单位ms)
+	//调用时间(unix时间，单位ms)，时间轮中读取时不持有lock，需要原子操作
 	unixts int64
+	//保护state及以下字段
+	lock  sync.Mutex
+	state int
+	//调度该定时器的调度器，单独运行时为nil
+	ts *TimerScheduler
+	//单独运行时使用的定时器
+	rt ClockTimer
+	//时间来源，由调度器创建时使用调度器的Clock
+	clock Clock
+	//在BackendHeap的堆中的位置，只在持有堆的锁时访问
+	heapIndex int
 }
 
 // UnixMill 返回从1970-01-01到此时经历的毫秒数
@@ -55,28 +82,118 @@ func UnixMill() int64 {
 func NewTimerAt(df *DelayFunc, unixNano int64) *Timer {
 	return &Timer{
 		delayFunc: df,
-		unixts:    unixNano,
+		unixts:    unixNano / 1e6,
+		clock:     RealClock,
 	}
 }
 
 // NewTimerAfter 创建一个定时器，在当前时间延迟duration之后触发 定时器方法
 func NewTimerAfter(df *DelayFunc, duration time.Duration) *Timer {
-	return &Timer{
-		delayFunc: df,
-		unixts:    time.Now().UnixNano() + int64(duration),
-	}
+	return NewTimerAfterWithClock(df, duration, RealClock)
+}
+
+// NewTimerAfterWithClock 创建一个使用clock计时的定时器，在clock的当前时间延迟duration之后触发
+func NewTimerAfterWithClock(df *DelayFunc, duration time.Duration, clock Clock) *Timer {
+	t := NewTimerAt(df, clock.Now().UnixNano()+int64(duration))
+	t.clock = clock
+	return t
 }
 
-// Run 启动定时器，用一个go承载
+// ID 返回TimerScheduler分配的定时器ID
+func (t *Timer) ID() uint32 {
+	return t.id
+}
+
+// Name 返回延迟方法的名称
+func (t *Timer) Name() string {
+	return t.delayFunc.Name()
+}
+
+// deadline 返回触发时间(ms)
+func (t *Timer) deadline() int64 {
+	return atomic.LoadInt64(&t.unixts)
+}
+
+// Run 单独运行定时器，不经过时间轮，到时间后在新的协程中调用延迟方法
 func (t *Timer) Run() {
-	go func() {
-		now := UnixMill()
-		//设置的定时器是否在当前时间之后
-		if t.unixts > now {
-			//睡眠直到超时
-			time.Sleep(time.Duration(t.unixts-now) * time.Millisecond)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.state != timerPending || t.ts != nil || t.rt != nil {
+		return
+	}
+	t.rt = t.clock.AfterFunc(t.remaining(), func() {
+		if t.fire(unixMill(t.clock), MaxDelayTime) {
+			t.delayFunc.Call()
+		}
+	})
+}
+
+// fire 将定时器标记为已触发，已经停止、已经触发或者距离触发时间超过slack毫秒(被Reset推迟)时返回false
+func (t *Timer) fire(now int64, slack int64) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.state != timerPending || t.deadline()-now > slack {
+		return false
+	}
+	t.state = timerFired
+	if t.ts != nil {
+		t.ts.forget(t)
+	}
+	return true
+}
+
+// Stop 停止定时器，阻止了延迟方法的调用时返回true，已经触发或已经停止时返回false
+func (t *Timer) Stop() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.state != timerPending {
+		return false
+	}
+	t.state = timerStopped
+	if t.rt != nil {
+		t.rt.Stop()
+	}
+	if t.ts != nil {
+		t.ts.backend.remove(t)
+		t.ts.forget(t)
+	}
+	return true
+}
+
+// Reset 将定时器改为在duration之后触发，已经触发或停止的定时器会重新生效
+// 返回调用前定时器是否处于等待触发的状态
+func (t *Timer) Reset(duration time.Duration) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	active := t.state == timerPending
+	atomic.StoreInt64(&t.unixts, (t.clock.Now().UnixNano()+int64(duration))/1e6)
+	t.state = timerPending
+	if t.rt != nil {
+		t.rt.Reset(duration)
+	}
+	if t.ts != nil {
+		t.ts.backend.remove(t)
+		if err := t.ts.schedule(t); err != nil {
+			logger.Default().WithFields(logger.Fields{logger.FieldTimer: t.id, logger.FieldError: err}).Error("reset timer failed")
 		}
-		//到时间了调用注册的延迟方法
-		t.delayFunc.Call()
-	}()
+	}
+	return active
+}
+
+// Remaining 返回距离触发的剩余时间，已经触发或停止时返回0
+func (t *Timer) Remaining() time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.remaining()
+}
+
+func (t *Timer) remaining() time.Duration {
+	if t.state != timerPending {
+		return 0
+	}
+	remaining := time.Duration(t.deadline()-unixMill(t.clock)) * time.Millisecond
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
 }
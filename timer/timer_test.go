@@ -1 +1,251 @@
 package timer
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// autoScheduler 创建自动调度的调度器，测试结束时停止
+func autoScheduler(t *testing.T, opts ...SchedulerOption) *TimerScheduler {
+	ts := NewAutoExecTimerScheduler(opts...)
+	t.Cleanup(func() {
+		if err := ts.Stop(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+	return ts
+}
+
+// notifyFunc 返回一个延迟调用函数，调用时向返回的channel写入
+func notifyFunc() (*DelayFunc, chan struct{}) {
+	called := make(chan struct{}, 4)
+	return NewDelayFunc(func(...interface{}) { called <- struct{}{} }, nil), called
+}
+
+func expectCall(t *testing.T, called chan struct{}, within time.Duration) {
+	t.Helper()
+	select {
+	case <-called:
+	case <-time.After(within):
+		t.Fatal("delay func should be called")
+	}
+}
+
+func expectNoCall(t *testing.T, called chan struct{}, wait time.Duration) {
+	t.Helper()
+	select {
+	case <-called:
+		t.Fatal("delay func should not be called")
+	case <-time.After(wait):
+	}
+}
+
+func TestTimerScheduler_AfterFunc(t *testing.T) {
+	ts := autoScheduler(t)
+
+	df, called := notifyFunc()
+	start := time.Now()
+	timer := ts.AfterFunc(df, 200*time.Millisecond)
+	if !ts.HasTimer(timer.ID()) {
+		t.Fatal("timer should be pending")
+	}
+	if r := timer.Remaining(); r <= 0 || r > 200*time.Millisecond {
+		t.Fatalf("unexpected remaining %v", r)
+	}
+	expectCall(t, called, time.Second)
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("fired too early: %v", elapsed)
+	}
+	if timer.Stop() || ts.HasTimer(timer.ID()) || timer.Remaining() != 0 {
+		t.Fatal("fired timer should not be stoppable")
+	}
+
+	//CreateTimerAt使用纳秒时间戳
+	df, called = notifyFunc()
+	if _, err := ts.CreateTimerAt(df, time.Now().Add(100*time.Millisecond).UnixNano()); err != nil {
+		t.Fatal(err)
+	}
+	expectCall(t, called, time.Second)
+}
+
+func TestTimer_Stop(t *testing.T) {
+	ts := autoScheduler(t)
+
+	df, called := notifyFunc()
+	timer := ts.AfterFunc(df, 100*time.Millisecond)
+	if !timer.Stop() {
+		t.Fatal("Stop should prevent the fire")
+	}
+	if timer.Stop() {
+		t.Fatal("second Stop should return false")
+	}
+	expectNoCall(t, called, 300*time.Millisecond)
+
+	//CancelTimer同样会从时间轮中删除定时器
+	df, called = notifyFunc()
+	tID, err := ts.CreateTimerAfter(df, 1500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm, _ := ts.GetTimer(tID)
+	if !ts.CancelTimer(tID) || ts.HasTimer(tID) || ts.backend.remove(tm) {
+		t.Fatal("cancelled timer should be removed from the time wheel")
+	}
+}
+
+func TestTimer_Reset(t *testing.T) {
+	ts := autoScheduler(t)
+
+	df, called := notifyFunc()
+	timer := ts.AfterFunc(df, 100*time.Millisecond)
+	if !timer.Reset(400 * time.Millisecond) {
+		t.Fatal("Reset of a pending timer should return true")
+	}
+	expectNoCall(t, called, 250*time.Millisecond)
+	expectCall(t, called, time.Second)
+
+	//已经触发的定时器Reset之后再次触发
+	if timer.Reset(100 * time.Millisecond) {
+		t.Fatal("Reset of a fired timer should return false")
+	}
+	expectCall(t, called, time.Second)
+
+	//已经停止的定时器Reset之后重新生效
+	timer.Reset(time.Hour)
+	timer.Stop()
+	timer.Reset(100 * time.Millisecond)
+	expectCall(t, called, time.Second)
+}
+
+func TestTimer_Run(t *testing.T) {
+	df, called := notifyFunc()
+	timer := NewTimerAfter(df, 50*time.Millisecond)
+	timer.Run()
+	expectCall(t, called, time.Second)
+
+	timer = NewTimerAfter(df, 50*time.Millisecond)
+	timer.Run()
+	if !timer.Stop() {
+		t.Fatal("Stop should prevent the fire")
+	}
+	expectNoCall(t, called, 150*time.Millisecond)
+}
+
+func TestTimeWheel_RemoveTimer(t *testing.T) {
+	tw := NewTimeWheel(SecondName, SecondInterval, SecondScales, TimersMaxCap)
+	timer := NewTimerAfter(nil, 5*time.Second)
+	if err := tw.AddTimer(1, timer); err != nil {
+		t.Fatal(err)
+	}
+	if !tw.RemoveTimer(1) || tw.RemoveTimer(1) {
+		t.Fatal("timer should be removed once")
+	}
+	for slot, timers := range tw.timerQueue {
+		if len(timers) != 0 {
+			t.Fatalf("slot %d should be empty", slot)
+		}
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	var calls []time.Duration
+	clock.AfterFunc(2*time.Second, func() { calls = append(calls, clock.Now().Sub(start)) })
+	stopped := clock.AfterFunc(time.Second, func() { t.Error("stopped func should not be called") })
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatal("Stop should remove the func once")
+	}
+	reset := clock.AfterFunc(time.Second, func() { calls = append(calls, clock.Now().Sub(start)) })
+	reset.Reset(3 * time.Second)
+
+	woken := make(chan time.Duration)
+	go func() {
+		clock.Sleep(time.Second)
+		woken <- clock.Now().Sub(start)
+	}()
+	clock.BlockUntil(1)
+	go clock.Advance(5 * time.Second)
+	if d := <-woken; d != time.Second {
+		t.Fatalf("sleeper woken at %v", d)
+	}
+	for clock.Now().Sub(start) != 5*time.Second {
+		time.Sleep(time.Millisecond)
+	}
+	if len(calls) != 2 || calls[0] != 2*time.Second || calls[1] != 3*time.Second {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+
+	//单独运行的定时器
+	df, called := notifyFunc()
+	timer := NewTimerAfterWithClock(df, time.Minute, clock)
+	timer.Run()
+	if timer.Remaining() != time.Minute {
+		t.Fatalf("unexpected remaining %v", timer.Remaining())
+	}
+	clock.Advance(59 * time.Second)
+	expectNoCall(t, called, 10*time.Millisecond)
+	clock.Advance(time.Second)
+	expectCall(t, called, time.Second)
+}
+
+// expectGoroutines 等待协程数回落到n以内
+func expectGoroutines(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines leaked: %d > %d", runtime.NumGoroutine(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTimerScheduler_Stop(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ts := NewAutoExecTimerScheduler(WithExecutor(4, 16))
+	df, called := notifyFunc()
+	ts.AfterFunc(df, 50*time.Millisecond)
+	expectCall(t, called, time.Second)
+
+	//Stop之后时间轮、调度协程及协程池全部退出，剩余的定时器不再触发
+	df, called = notifyFunc()
+	ts.AfterFunc(df, 100*time.Millisecond)
+	if err := ts.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectGoroutines(t, before)
+	expectNoCall(t, called, 200*time.Millisecond)
+	//Stop之后Start不再生效
+	ts.Start()
+	expectGoroutines(t, before)
+	if err := ts.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	//没有Start过的调度器同样可以Stop
+	if err := NewTimerScheduler().Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimerScheduler_StartRehash(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	ts := NewTimerSchedulerWithClock(clock)
+	defer ts.Stop(context.Background())
+
+	//Start之前时间轮没有转动，Start时按当前时间重新挂载
+	ts.AfterFunc(NewDelayFunc(func(...interface{}) {}, []interface{}{"90s"}), 90*time.Second)
+	clock.Advance(30 * time.Second)
+	ts.Start()
+	clock.BlockUntil(4)
+	fired := advanceAndCollect(ts, clock, 90*time.Second, 50*time.Millisecond)
+	if at, ok := fired["90s"]; !ok {
+		t.Fatal("timer should fire")
+	} else if d := at.Sub(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); d < 90*time.Second-MaxDelayTime*time.Millisecond || d > 90*time.Second+MaxDelayTime*time.Millisecond {
+		t.Fatalf("timer fired at %v", d)
+	}
+}
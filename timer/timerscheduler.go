@@ -1,7 +1,8 @@
 package timer
 
 import (
-	"fmt"
+	"context"
+	"gonet/logger"
 	"math"
 	"sync"
 	"time"
@@ -12,80 +13,212 @@ const (
 	MaxChanBuff = 2048
 	// MaxDelayTime 默认最大误差时间
 	MaxDelayTime = 100
+	// DefaultExecWorkers 自动调度时执行延迟方法的默认worker数
+	DefaultExecWorkers = 32
 )
 
 // TimerScheduler 计时器调度器
 type TimerScheduler struct {
-	//当前调度器的最高级时间轮
-	tw *TimeWheel
+	//保存等待触发的定时器并检测到期
+	backend backend
+	//创建时选择的backend
+	backendKind Backend
 	//定时器编号累加器
 	IDGen uint32
 	//已经触发定时器的延迟方法的channel
 	triggerChan chan *DelayFunc
 	//互斥锁
 	sync.RWMutex
-	//所有注册的timerID集合
-	IDs []uint32
+	//等待触发的定时器，触发或停止之后删除
+	timers map[uint32]*Timer
+	//时间来源
+	clock Clock
+	//任务的ctx，取消之后不再触发定时器
+	ctx    context.Context
+	cancel context.CancelFunc
+	//处理任务返回的错误及panic
+	errorHandler ErrorHandler
+	//执行触发的延迟方法的协程池，为nil时由调用者读取GetTriggerChan
+	executor *executor
+	//Start只生效一次
+	startOnce sync.Once
+	//Start启动的调度协程
+	loops sync.WaitGroup
 }
 
-// NewTimerScheduler 返回一个定时器调度器 ，主要创建分层定时器，并做关联，并依次启动
-func NewTimerScheduler() *TimerScheduler {
-	// 创建秒级时间轮
-	secondTimeWheel := NewTimeWheel(SecondName, SecondInterval, SecondScales, TimersMaxCap)
-	// 创建分钟级时间轮
-	minuteTimeWheel := NewTimeWheel(MinuteName, MinuteInterval, MinuteScales, TimersMaxCap)
-	// 创建小时级时间轮
-	hourTimeWheel := NewTimeWheel(HourName, HourInterval, HourScales, TimersMaxCap)
+// SchedulerOption 创建调度器时的选项
+type SchedulerOption func(*schedulerOptions)
 
-	//分层时间轮做关联
-	hourTimeWheel.nextTimeWheel = minuteTimeWheel
-	minuteTimeWheel.nextTimeWheel = secondTimeWheel
+type schedulerOptions struct {
+	clock        Clock
+	ctx          context.Context
+	errorHandler ErrorHandler
+	//workers大于0时使用协程池自动执行触发的延迟方法
+	workers  int
+	queueLen int
+	backend  Backend
+}
+
+// WithClock 使用clock计时，测试时使用FakeClock
+func WithClock(clock Clock) SchedulerOption {
+	return func(o *schedulerOptions) { o.clock = clock }
+}
+
+// WithContext 任务的ctx从ctx派生，ctx取消时调度器不再触发定时器，正在执行的任务通过ctx得到通知
+func WithContext(ctx context.Context) SchedulerOption {
+	return func(o *schedulerOptions) { o.ctx = ctx }
+}
 
-	//时间轮运行
-	secondTimeWheel.Run()
-	minuteTimeWheel.Run()
-	hourTimeWheel.Run()
+// WithErrorHandler 处理任务返回的错误及panic，默认为DefaultErrorHandler
+func WithErrorHandler(h ErrorHandler) SchedulerOption {
+	return func(o *schedulerOptions) { o.errorHandler = h }
+}
 
-	return &TimerScheduler{
-		tw:          hourTimeWheel,
-		triggerChan: make(chan *DelayFunc, MaxChanBuff),
-		IDs:         make([]uint32, 0),
+// WithExecutor 使用workers个协程自动执行触发的延迟方法，等待执行的延迟方法最多queueLen个，队列满时暂停触发
+// 不设置时由调用者读取GetTriggerChan并执行
+func WithExecutor(workers int, queueLen int) SchedulerOption {
+	return func(o *schedulerOptions) {
+		o.workers = workers
+		o.queueLen = queueLen
 	}
 }
 
+// WithBackend 保存定时器及检测到期的方式，默认为BackendTimeWheel
+func WithBackend(b Backend) SchedulerOption {
+	return func(o *schedulerOptions) { o.backend = b }
+}
+
+// NewTimerSchedulerWithClock 返回一个使用clock计时的定时器调度器，测试时使用FakeClock
+func NewTimerSchedulerWithClock(clock Clock) *TimerScheduler {
+	return NewTimerScheduler(WithClock(clock))
+}
+
+// NewTimerScheduler 返回一个定时器调度器，默认使用分层时间轮，Start时启动
+func NewTimerScheduler(opts ...SchedulerOption) *TimerScheduler {
+	o := &schedulerOptions{clock: RealClock, ctx: context.Background(), errorHandler: DefaultErrorHandler}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	ts := &TimerScheduler{
+		triggerChan:  make(chan *DelayFunc, MaxChanBuff),
+		timers:       make(map[uint32]*Timer),
+		clock:        o.clock,
+		errorHandler: o.errorHandler,
+		backendKind:  o.backend,
+	}
+	ts.ctx, ts.cancel = context.WithCancel(o.ctx)
+	switch o.backend {
+	case BackendHeap:
+		ts.backend = newHeapBackend(ts)
+	default:
+		ts.backend = newWheelBackend(ts)
+	}
+	if o.workers > 0 {
+		ts.executor = newExecutor(o.workers, o.queueLen, ts.Exec)
+	}
+	return ts
+}
+
+// Backend 返回调度器保存定时器及检测到期的方式
+func (ts *TimerScheduler) Backend() Backend {
+	return ts.backendKind
+}
+
+// Clock 返回调度器的时间来源
+func (ts *TimerScheduler) Clock() Clock {
+	return ts.clock
+}
+
+// Context 返回任务使用的ctx
+func (ts *TimerScheduler) Context() context.Context {
+	return ts.ctx
+}
+
+// Exec 使用调度器的ctx执行延迟方法，错误及panic交给ErrorHandler
+// 直接读取GetTriggerChan的调用者应当使用Exec执行取到的延迟方法
+func (ts *TimerScheduler) Exec(df *DelayFunc) {
+	ts.exec(ts.ctx, df)
+}
+
+// exec 使用从调度器的ctx派生的ctx执行延迟方法
+func (ts *TimerScheduler) exec(ctx context.Context, df *DelayFunc) {
+	if err := df.CallContext(ctx); err != nil {
+		ts.errorHandler(err.(*TaskError))
+	}
+}
+
+// AtFunc 创建一个在unixNano时触发的定时器，返回的Timer可以停止或重新设置
+func (ts *TimerScheduler) AtFunc(df *DelayFunc, unixNano int64) *Timer {
+	t, err := ts.add(NewTimerAt(df, unixNano))
+	if err != nil {
+		logger.Default().WithFields(logger.Fields{logger.FieldTimer: t.id, logger.FieldError: err}).Error("add timer failed")
+	}
+	return t
+}
+
+// AfterFunc 创建一个在duration之后触发的定时器，返回的Timer可以停止或重新设置
+func (ts *TimerScheduler) AfterFunc(df *DelayFunc, duration time.Duration) *Timer {
+	return ts.AtFunc(df, ts.clock.Now().UnixNano()+int64(duration))
+}
+
 // CreateTimerAt 创建一个定点Timer 并将Timer添加到分层时间轮中， 返回Timer的tID
 func (ts *TimerScheduler) CreateTimerAt(df *DelayFunc, unixNano int64) (uint32, error) {
-	ts.Lock()
-	defer ts.Unlock()
-	ts.IDGen++
-	ts.IDs = append(ts.IDs, ts.IDGen)
-	return ts.IDGen, ts.tw.AddTimer(ts.IDGen, NewTimerAt(df, unixNano))
+	t, err := ts.add(NewTimerAt(df, unixNano))
+	return t.id, err
 }
 
 // CreateTimerAfter 创建一个延迟Timer 并将Timer添加到分层时间轮中， 返回Timer的tID
 func (ts *TimerScheduler) CreateTimerAfter(df *DelayFunc, duration time.Duration) (uint32, error) {
+	return ts.CreateTimerAt(df, ts.clock.Now().UnixNano()+int64(duration))
+}
+
+// add 为定时器分配ID并添加到调度器中
+func (ts *TimerScheduler) add(t *Timer) (*Timer, error) {
 	ts.Lock()
-	defer ts.Unlock()
 	ts.IDGen++
-	ts.IDs = append(ts.IDs, ts.IDGen)
-	return ts.IDGen, ts.tw.AddTimer(ts.IDGen, NewTimerAfter(df, duration))
+	t.id = ts.IDGen
+	t.ts = ts
+	t.clock = ts.clock
+	ts.Unlock()
+	//持有定时器的锁，避免添加的同时被Stop
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t, ts.schedule(t)
+}
+
+// schedule 登记定时器并交给backend，调用者持有t.lock
+func (ts *TimerScheduler) schedule(t *Timer) error {
+	ts.Lock()
+	ts.timers[t.id] = t
+	ts.Unlock()
+	return ts.backend.add(t)
 }
 
-// CancelTimer 删除timer
-func (ts *TimerScheduler) CancelTimer(tID uint32) {
+// forget 删除定时器的登记，调用者持有t.lock
+func (ts *TimerScheduler) forget(t *Timer) {
 	ts.Lock()
 	defer ts.Unlock()
-	//删除timerID
-	var index = -1
-	for i := 0; i < len(ts.IDs); i++ {
-		if ts.IDs[i] == tID {
-			index = i
-		}
+	if ts.timers[t.id] == t {
+		delete(ts.timers, t.id)
 	}
-	//直接从IDs里删除，而不需要从底层时间轮中删除，通过tID来进行调度
-	if index > -1 {
-		ts.IDs = append(ts.IDs[:index], ts.IDs[index+1:]...)
+}
+
+// GetTimer 根据ID获取等待触发的定时器
+func (ts *TimerScheduler) GetTimer(tID uint32) (*Timer, bool) {
+	ts.RLock()
+	defer ts.RUnlock()
+	t, ok := ts.timers[tID]
+	return t, ok
+}
+
+// CancelTimer 停止timer并从调度器中删除，返回是否阻止了延迟方法的调用
+func (ts *TimerScheduler) CancelTimer(tID uint32) bool {
+	t, ok := ts.GetTimer(tID)
+	if !ok {
+		return false
 	}
+	return t.Stop()
 }
 
 // GetTriggerChan 获取计时结束的延迟执行函数通道
@@ -93,52 +226,104 @@ func (ts *TimerScheduler) GetTriggerChan() chan *DelayFunc {
 	return ts.triggerChan
 }
 
-// HasTimer 是否有时间轮
+// HasTimer 定时器是否在等待触发
 func (ts *TimerScheduler) HasTimer(tID uint32) bool {
-	for i := 0; i < len(ts.IDs); i++ {
-		if ts.IDs[i] == tID {
-			return true
+	_, ok := ts.GetTimer(tID)
+	return ok
+}
+
+// Start 非阻塞式启动backend及调度协程，只有第一次调用生效，Stop之后不能再次Start
+// Start之前添加的定时器在Start时开始计时，已经到期的立即触发
+func (ts *TimerScheduler) Start() {
+	ts.startOnce.Do(func() {
+		if ts.ctx.Err() != nil {
+			return
+		}
+		ts.backend.start()
+		if ts.executor != nil {
+			ts.executor.start()
+			ts.loops.Add(1)
+			go ts.dispatch()
+		}
+	})
+}
+
+// trigger 将到期的定时器的延迟方法写入触发通道，调度器停止时返回false
+// slack为允许提前触发的毫秒数，定时器已经停止或者被Reset推迟时不触发
+func (ts *TimerScheduler) trigger(t *Timer, now int64, slack int64) bool {
+	if !t.fire(now, slack) {
+		return true
+	}
+	if unixts := t.deadline(); math.Abs(float64(now-unixts)) > MaxDelayTime {
+		// 已经超时的定时器，报警
+		logger.Default().WithFields(logger.Fields{logger.FieldTimer: t.id, logger.FieldTask: t.delayFunc.Name()}).Warnf("want call at: %v; real call at: %v; delay %v", unixts, now, now-unixts)
+	}
+	// 将超时触发函数写入管道
+	select {
+	case ts.triggerChan <- t.delayFunc:
+		return true
+	case <-ts.ctx.Done():
+		return false
+	}
+}
+
+// dispatch 将触发的延迟方法交给协程池
+func (ts *TimerScheduler) dispatch() {
+	defer ts.loops.Done()
+	for {
+		select {
+		case df := <-ts.triggerChan:
+			ts.executor.submit(ts.ctx, df)
+		case <-ts.ctx.Done():
+			return
 		}
 	}
-	return false
 }
 
-// Start 非阻塞式启动
-func (ts *TimerScheduler) Start() {
+// Stop 停止调度器：取消任务的ctx，不再触发定时器，停止backend及调度协程，等待协程池中的任务执行完毕
+// ctx结束时不再等待并返回ctx.Err()，停止之后不能再次Start
+func (ts *TimerScheduler) Stop(ctx context.Context) error {
+	//阻止之后的Start，同时等待正在进行的Start完成
+	ts.startOnce.Do(func() {})
+	ts.cancel()
+	if err := ts.backend.stop(ctx); err != nil {
+		return err
+	}
+	done := make(chan struct{})
 	go func() {
-		for {
-			now := UnixMill()
-			// 获取最近MaxTimeDelay 毫秒的超时定时器集合
-			timerList := ts.tw.GetTimerWithin(MaxDelayTime * time.Millisecond)
-			for tID, timer := range timerList {
-				if math.Abs(float64(now-timer.unixts)) > MaxDelayTime {
-					// 已经超时的定时器，报警
-					_ = fmt.Errorf("want call at: %v; real call at: %v; delay %v", timer.unixts, now, now-timer.unixts)
-				}
-				if ts.HasTimer(tID) {
-					// 将超时触发函数写入管道
-					ts.triggerChan <- timer.delayFunc
-				}
-			}
-			//每隔50微秒进行读取1次过期的timer
-			time.Sleep(MaxDelayTime / 2 * time.Millisecond)
-		}
+		ts.loops.Wait()
+		close(done)
 	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if ts.executor != nil {
+		return ts.executor.shutdown(ctx)
+	}
+	return nil
+}
+
+// ExecutorStats 返回自动执行延迟方法的协程池的统计，没有使用协程池时返回零值
+func (ts *TimerScheduler) ExecutorStats() ExecutorStats {
+	if ts.executor == nil {
+		return ExecutorStats{}
+	}
+	return ts.executor.stats()
+}
+
+// NewAutoExecTimerSchedulerWithClock 使用clock计时的自动调度的时间轮定时器
+func NewAutoExecTimerSchedulerWithClock(clock Clock) *TimerScheduler {
+	return NewAutoExecTimerScheduler(WithClock(clock))
 }
 
 // NewAutoExecTimerScheduler 时间轮定时器 自动调度
-func NewAutoExecTimerScheduler() *TimerScheduler {
+// 触发的延迟方法由DefaultExecWorkers个协程执行，可以通过WithExecutor修改，不再使用时调用Stop
+func NewAutoExecTimerScheduler(opts ...SchedulerOption) *TimerScheduler {
 	// 创建一个调度器
-	autoExecScheduler := NewTimerScheduler()
+	autoExecScheduler := NewTimerScheduler(append([]SchedulerOption{WithExecutor(DefaultExecWorkers, MaxChanBuff)}, opts...)...)
 	// 启动调度器
 	autoExecScheduler.Start()
-
-	// 永久从调度器中获取超时触发的函数 并执行
-	go func() {
-		delayFuncChan := autoExecScheduler.GetTriggerChan()
-		for df := range delayFuncChan {
-			go df.Call()
-		}
-	}()
 	return autoExecScheduler
 }
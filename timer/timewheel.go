@@ -1,8 +1,10 @@
 package timer
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"gonet/logger"
 	"sync"
 	"time"
 )
@@ -23,8 +25,16 @@ type TimeWheel struct {
 	//map[int]中的int表示某个刻度数
 	//map[uint32]中的uint32表示Timer的ID
 	timerQueue map[int]map[uint32]*Timer
+	//Timer的ID到所在刻度的索引，用于O(1)删除
+	index map[uint32]int
 	//下一级时间轮
 	nextTimeWheel *TimeWheel
+	//时间来源，需要在Run之前设置
+	clock Clock
+	//Run或Stop之后不为nil，关闭stop使转动的协程退出，协程退出时关闭done
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
 	//互斥锁，保护timerQueue的操作
 	sync.RWMutex
 }
@@ -41,12 +51,14 @@ func NewTimeWheel(name string, interval int64, scales int, maxCap int) *TimeWhee
 		maxCap:   maxCap,
 		//初始化外层map
 		timerQueue: make(map[int]map[uint32]*Timer, scales),
+		index:      make(map[uint32]int),
+		clock:      RealClock,
 	}
 	//初始化内层map
-	for i := 0; i < maxCap; i++ {
+	for i := 0; i < scales; i++ {
 		tw.timerQueue[i] = make(map[uint32]*Timer, maxCap)
 	}
-	fmt.Println("Init timeWheel name = ", tw.name, "is Done!")
+	logger.Default().WithField(logger.FieldTimer, tw.name).Debug("init time wheel done")
 	return tw
 }
 
@@ -63,24 +75,23 @@ func NewTimeWheel(name string, interval int64, scales int, maxCap int) *TimeWhee
 */
 
 // addTimer中操作timerQueue时没有加锁，而是放到了外部调用该方法的方法中进行
-func (tw *TimeWheel) addTimer(tID uint32, t *Timer, forceNext bool) error {
-	defer func() error {
-		if err := recover(); err != nil {
-			erst := fmt.Sprintf("addTimer function err: %s", err)
-			_ = fmt.Errorf(erst)
-			return errors.New(erst)
+func (tw *TimeWheel) addTimer(tID uint32, t *Timer, forceNext bool) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			erst := fmt.Sprintf("addTimer function err: %s", r)
+			logger.Default().WithField(logger.FieldTimer, tw.name).Error(erst)
+			err = errors.New(erst)
 		}
-		return nil
 	}()
 	//得到当前延迟任务的超时时间间隔(ms)
-	delayInterval := t.unixts - UnixMill()
+	delayInterval := t.deadline() - unixMill(tw.clock)
 
 	//如果当前超时时间间隔大于一个刻度的时间间隔
 	if delayInterval >= tw.interval {
 		//得到需要跨越的刻度数
 		dn := delayInterval / tw.interval
 		//在对应的刻度上的定时器Timer集合map加入当前定时器(由于是环形，所以要求余)
-		tw.timerQueue[(tw.curIndex+int(dn))%tw.scales][tID] = t
+		tw.put((tw.curIndex+int(dn))%tw.scales, tID, t)
 		return nil
 	}
 	//精度最小的时间轮
@@ -91,10 +102,10 @@ func (tw *TimeWheel) addTimer(tID uint32, t *Timer, forceNext bool) error {
 			// 因为这是底层时间轮，该定时器在转动的时候，如果没有被调度者取走的话，该定时器将不会再被发现
 			// 因为时间轮刻度已经过去，如果不强制把该定时器Timer移至下时刻，就永远不会被取走并触发调用
 			// 所以这里强制将timer移至下个刻度的集合中，等待调用者在下次轮转之前取走该定时器
-			tw.timerQueue[(tw.curIndex+1)%tw.scales][tID] = t
+			tw.put((tw.curIndex+1)%tw.scales, tID, t)
 		} else {
 			// 如果手动添加定时器，那么直接将timer添加到对应底层时间轮的当前刻度集合中
-			tw.timerQueue[tw.curIndex][tID] = t
+			tw.put(tw.curIndex, tID, t)
 		}
 		return nil
 	}
@@ -104,6 +115,25 @@ func (tw *TimeWheel) addTimer(tID uint32, t *Timer, forceNext bool) error {
 	return nil
 }
 
+// put 将定时器挂载到刻度slot上并更新索引
+func (tw *TimeWheel) put(slot int, tID uint32, t *Timer) {
+	if old, ok := tw.index[tID]; ok {
+		delete(tw.timerQueue[old], tID)
+	}
+	tw.timerQueue[slot][tID] = t
+	tw.index[tID] = slot
+}
+
+// take 取走刻度slot上的全部定时器
+func (tw *TimeWheel) take(slot int) map[uint32]*Timer {
+	timers := tw.timerQueue[slot]
+	tw.timerQueue[slot] = make(map[uint32]*Timer, len(timers))
+	for tID := range timers {
+		delete(tw.index, tID)
+	}
+	return timers
+}
+
 // AddTimer 添加一个timer到一个时间轮中(非时间轮自转情况)
 func (tw *TimeWheel) AddTimer(tID uint32, t *Timer) error {
 	tw.Lock()
@@ -111,38 +141,49 @@ func (tw *TimeWheel) AddTimer(tID uint32, t *Timer) error {
 	return tw.addTimer(tID, t, false)
 }
 
-// RemoveTimer 删除一个定时器，根据定时器的ID
-func (tw *TimeWheel) RemoveTimer(tID uint32) {
+// RemoveTimer 根据定时器的ID从该时间轮及下层时间轮中删除定时器，返回是否找到
+// 定时器只会从上层时间轮移动到下层时间轮，且移动时持有上层的锁，所以从上往下依次查找不会遗漏
+func (tw *TimeWheel) RemoveTimer(tID uint32) bool {
+	for w := tw; w != nil; w = w.nextTimeWheel {
+		if w.removeTimer(tID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (tw *TimeWheel) removeTimer(tID uint32) bool {
 	tw.Lock()
-	defer tw.Lock()
-	//这里只能通过遍历每个外层来寻找tID对应的内层map
-	for i := 0; i < tw.scales; i++ {
-		delete(tw.timerQueue[i], tID)
+	defer tw.Unlock()
+	slot, ok := tw.index[tID]
+	if !ok {
+		return false
 	}
+	delete(tw.timerQueue[slot], tID)
+	delete(tw.index, tID)
+	return true
 }
 
 // AddTimeWheel 给一个时间轮添加下层时间轮 比如给小时时间轮添加分钟时间轮，给分钟时间轮添加秒时间轮
 func (tw *TimeWheel) AddTimeWheel(nextTimeWheel *TimeWheel) {
 	tw.nextTimeWheel = nextTimeWheel
-	fmt.Println("Add timerWheel[", tw.name, "]'s next [", nextTimeWheel.name, "] is successful!")
+	logger.Default().WithField(logger.FieldTimer, tw.name).Debugf("add next time wheel %s", nextTimeWheel.name)
 }
 
 // 启动时间轮
-func (tw *TimeWheel) run() {
-	for {
-		time.Sleep(time.Duration(tw.interval) * time.Millisecond)
+func (tw *TimeWheel) run(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	for sleep(tw.clock, time.Duration(tw.interval)*time.Millisecond, stop) {
 		tw.Lock()
 		// 取出挂载在当前刻度的全部定时器
-		curTimers := tw.timerQueue[tw.curIndex]
 		// 当前定时器要重新添加 所给当前刻度再重新开辟一个map Timer容器
-		tw.timerQueue[tw.curIndex] = make(map[uint32]*Timer, tw.maxCap)
+		curTimers := tw.take(tw.curIndex)
 		for tID, timer := range curTimers {
 			// 这里属于时间轮自动转动，forceNext设置为true
 			_ = tw.addTimer(tID, timer, true)
 		}
 		// 取出下一个刻度 挂载的全部定时器 进行重新添加 (为了安全起见,待考慮)
-		nextTimers := tw.timerQueue[(tw.curIndex+1)%tw.scales]
-		tw.timerQueue[(tw.curIndex+1)%tw.scales] = make(map[uint32]*Timer, tw.maxCap)
+		nextTimers := tw.take((tw.curIndex + 1) % tw.scales)
 		for tID, timer := range nextTimers {
 			_ = tw.addTimer(tID, timer, true)
 		}
@@ -154,9 +195,36 @@ func (tw *TimeWheel) run() {
 	}
 }
 
+// Run 启动时间轮的转动，只有第一次调用生效，Stop之后不能再次Run
 func (tw *TimeWheel) Run() {
-	go tw.run()
-	fmt.Println("Timer wheel name = ", tw.name, "is running...")
+	tw.Lock()
+	defer tw.Unlock()
+	if tw.stop != nil {
+		return
+	}
+	tw.stop, tw.done = make(chan struct{}), make(chan struct{})
+	go tw.run(tw.stop, tw.done)
+	logger.Default().WithField(logger.FieldTimer, tw.name).Debug("time wheel is running")
+}
+
+// Stop 停止时间轮的转动并等待转动的协程退出，ctx结束时不再等待并返回ctx.Err()
+// 时间轮上的定时器保留在原处，但不会再被移动到下层时间轮
+func (tw *TimeWheel) Stop(ctx context.Context) error {
+	tw.Lock()
+	if tw.stop == nil {
+		//没有运行过的时间轮，标记为已停止
+		tw.stop, tw.done = make(chan struct{}), make(chan struct{})
+		close(tw.done)
+	}
+	stop, done := tw.stop, tw.done
+	tw.stopOnce.Do(func() { close(stop) })
+	tw.Unlock()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // GetTimerWithin  获取定时器在一段时间间隔内的Timer
@@ -170,15 +238,16 @@ func (tw *TimeWheel) GetTimerWithin(duration time.Duration) map[uint32]*Timer {
 	leaftw.Lock()
 	defer leaftw.Unlock()
 	timerList := make(map[uint32]*Timer)
-	now := UnixMill()
+	now := unixMill(leaftw.clock)
 
 	// 取出当前时间轮刻度内全部Timer
 	for tID, timer := range leaftw.timerQueue[leaftw.curIndex] {
 		//当前定时器在duration范围内
-		if timer.unixts-now < int64(duration/1e6) {
+		if timer.deadline()-now < int64(duration/1e6) {
 			timerList[tID] = timer
 			// 定时器已经超时被取走，从当前时间轮上 摘除该定时器
 			delete(leaftw.timerQueue[leaftw.curIndex], tID)
+			delete(leaftw.index, tID)
 		}
 	}
 	return timerList
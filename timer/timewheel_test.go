@@ -0,0 +1,112 @@
+package timer
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeScheduler 使用FakeClock的调度器，等待三个时间轮及调度协程都进入Sleep之后返回
+func fakeScheduler(t *testing.T) (*TimerScheduler, *FakeClock) {
+	t.Helper()
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	ts := NewTimerSchedulerWithClock(clock)
+	ts.Start()
+	t.Cleanup(func() {
+		if err := ts.Stop(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+	clock.BlockUntil(4)
+	return ts, clock
+}
+
+// advanceAndCollect 按step推进时间直到经过d，返回每个定时器(以延迟函数的第一个参数标识)的触发时间
+func advanceAndCollect(ts *TimerScheduler, clock *FakeClock, d time.Duration, step time.Duration) map[string]time.Time {
+	fired := map[string]time.Time{}
+	for end := clock.Now().Add(d); clock.Now().Before(end); {
+		clock.Advance(step)
+		for {
+			select {
+			case df := <-ts.GetTriggerChan():
+				fired[df.args[0].(string)] = clock.Now()
+				continue
+			default:
+			}
+			break
+		}
+	}
+	return fired
+}
+
+func TestTimeWheel_Cascade(t *testing.T) {
+	delays := map[string]time.Duration{
+		"300ms":  300 * time.Millisecond,
+		"1s":     time.Second,
+		"59.5s":  59*time.Second + 500*time.Millisecond,
+		"61s":    61 * time.Second,
+		"10m":    10 * time.Minute,
+		"59m59s": 59*time.Minute + 59*time.Second,
+		"1h":     time.Hour,
+		"1h1m1s": time.Hour + time.Minute + time.Second,
+	}
+	if testing.Short() {
+		t.Skip("simulates two hours")
+	}
+	//offset为创建定时器时时间轮已经转过的时间，覆盖刻度对齐及不对齐两种情况
+	for _, offset := range []time.Duration{0, 30*time.Minute + 29*time.Second + 750*time.Millisecond} {
+		ts, clock := fakeScheduler(t)
+		clock.Advance(offset)
+		start := clock.Now()
+		for name, d := range delays {
+			ts.AfterFunc(NewDelayFunc(func(...interface{}) {}, []interface{}{name}), d)
+		}
+
+		fired := advanceAndCollect(ts, clock, time.Hour+2*time.Minute, 50*time.Millisecond)
+		for name, d := range delays {
+			at, ok := fired[name]
+			if !ok {
+				t.Errorf("offset %v: timer %s did not fire", offset, name)
+				continue
+			}
+			if diff := at.Sub(start.Add(d)); diff < -MaxDelayTime*time.Millisecond || diff > MaxDelayTime*time.Millisecond {
+				t.Errorf("offset %v: timer %s fired at %v, expected %v", offset, name, at.Sub(start), d)
+			}
+		}
+		if len(fired) != len(delays) {
+			t.Errorf("offset %v: unexpected fired timers %v", offset, fired)
+		}
+	}
+}
+
+func TestTimeWheel_CascadeStop(t *testing.T) {
+	ts, clock := fakeScheduler(t)
+	keep := ts.AfterFunc(NewDelayFunc(func(...interface{}) {}, []interface{}{"keep"}), time.Hour+time.Second)
+	stop := ts.AfterFunc(NewDelayFunc(func(...interface{}) {}, []interface{}{"stop"}), time.Hour+time.Second)
+	reset := ts.AfterFunc(NewDelayFunc(func(...interface{}) {}, []interface{}{"reset"}), time.Hour+time.Second)
+
+	//定时器移动到下层时间轮之后依然可以停止及重新设置
+	fired := advanceAndCollect(ts, clock, 59*time.Minute+30*time.Second, time.Second)
+	if len(fired) != 0 {
+		t.Fatalf("unexpected fired timers %v", fired)
+	}
+	if r := keep.Remaining(); r != 31*time.Second {
+		t.Fatalf("unexpected remaining %v", r)
+	}
+	if !stop.Stop() {
+		t.Fatal("Stop should prevent the fire")
+	}
+	if !reset.Reset(2 * time.Minute) {
+		t.Fatal("Reset should return true for a pending timer")
+	}
+	fired = advanceAndCollect(ts, clock, 3*time.Minute, 50*time.Millisecond)
+	if _, ok := fired["keep"]; !ok || len(fired) != 2 {
+		t.Fatalf("unexpected fired timers %v", fired)
+	}
+	if _, ok := fired["stop"]; ok {
+		t.Fatal("stopped timer should not fire")
+	}
+	if at := fired["reset"]; at.Sub(time.Date(2024, 1, 1, 1, 1, 30, 0, time.UTC)) > MaxDelayTime*time.Millisecond {
+		t.Fatalf("reset timer fired at %v", at)
+	}
+}
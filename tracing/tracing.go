@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+	链路追踪模块
+	使用全局的TracerProvider创建span，未通过otel.SetTracerProvider设置时不产生任何开销
+	跨服务传递时使用W3C trace context(traceparent/tracestate)，编码后放在GoNet消息的头部扩展中
+*/
+
+// TracerName GoNet创建span时使用的instrumentation名称
+const TracerName = "gonet"
+
+// propagator 使用W3C trace context传递链路信息
+var propagator = propagation.TraceContext{}
+
+// Tracer 获取GoNet使用的tracer
+func Tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+// headerCarrier 头部扩展中的键值对
+type headerCarrier map[string]string
+
+func (h headerCarrier) Get(key string) string {
+	return h[key]
+}
+
+func (h headerCarrier) Set(key string, value string) {
+	h[key] = value
+}
+
+func (h headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Inject 将ctx中的链路信息编码为头部扩展，每行一个key=value，没有有效的span时返回nil
+func Inject(ctx context.Context) []byte {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+	carrier := headerCarrier{}
+	propagator.Inject(ctx, carrier)
+	var builder strings.Builder
+	for key, value := range carrier {
+		builder.WriteString(key)
+		builder.WriteByte('=')
+		builder.WriteString(value)
+		builder.WriteByte('\n')
+	}
+	return []byte(builder.String())
+}
+
+// Extract 解析头部扩展中的链路信息，返回携带远端span的ctx
+func Extract(ctx context.Context, header []byte) context.Context {
+	carrier := headerCarrier{}
+	for _, line := range strings.Split(string(header), "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) == 2 {
+			carrier[kv[0]] = kv[1]
+		}
+	}
+	return propagator.Extract(ctx, carrier)
+}
@@ -16,3 +16,40 @@ func MakeCtx(duration time.Duration) (ctx context.Context, cancel context.Cancel
 	ctx, cancel = context.WithTimeout(context.Background(), duration)
 	return
 }
+
+// MakeCtxFrom 从parent派生带超时的ctx，parent已有更早的截止时间时以parent为准
+// 在消息处理中应使用request.Context()作为parent，连接断开时db、cache调用会随之取消
+func MakeCtxFrom(parent context.Context, duration time.Duration) (ctx context.Context, cancel context.CancelFunc) {
+	ctx, cancel = context.WithTimeout(parent, duration)
+	return
+}
+
+// ctxKey 请求范围内的值使用的key类型，避免与其他包冲突
+type ctxKey int
+
+const (
+	traceIDKey ctxKey = iota
+	userIDKey
+)
+
+// WithTraceID 在ctx中附加traceID
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey, traceID)
+}
+
+// TraceID 获取ctx中的traceID，不存在时返回空字符串
+func TraceID(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceIDKey).(string)
+	return traceID
+}
+
+// WithUserID 在ctx中附加userID
+func WithUserID(ctx context.Context, userID uint64) context.Context {
+	return context.WithValue(ctx, userIDKey, userID)
+}
+
+// UserID 获取ctx中的userID，不存在时返回0
+func UserID(ctx context.Context) uint64 {
+	userID, _ := ctx.Value(userIDKey).(uint64)
+	return userID
+}
@@ -3,7 +3,7 @@ package utils
 import (
 	"encoding/base64"
 	"encoding/json"
-	"github.com/sirupsen/logrus"
+	"gonet/logger"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -13,12 +13,12 @@ func Base64Unmarshal[T proto.Message](dataType T, data string) T {
 	}
 	byteData, err := base64.StdEncoding.DecodeString(data)
 	if err != nil {
-		logrus.Warnf("base64 解析失败 %v", data)
+		logger.Default().Warnf("base64 解析失败 %v", data)
 		return dataType
 	}
 	err = proto.Unmarshal(byteData, dataType)
 	if err != nil {
-		logrus.Warnf("base64 proto 解析失败 %v %v", data, dataType.ProtoReflect().Descriptor())
+		logger.Default().Warnf("base64 proto 解析失败 %v %v", data, dataType.ProtoReflect().Descriptor())
 		return dataType
 	}
 	return dataType
@@ -27,7 +27,7 @@ func Base64Unmarshal[T proto.Message](dataType T, data string) T {
 func Base64Marshal(data proto.Message) string {
 	bytes, err := proto.Marshal(data)
 	if err != nil {
-		logrus.Warnf("proto 生成失败 %v %v", data, data.ProtoReflect().Descriptor())
+		logger.Default().Warnf("proto 生成失败 %v %v", data, data.ProtoReflect().Descriptor())
 	}
 	return base64.StdEncoding.EncodeToString(bytes)
 }
@@ -38,7 +38,7 @@ func JSONUnmarshal(data string, dataType interface{}) interface{} {
 	}
 	err := json.Unmarshal([]byte(data), dataType)
 	if err != nil {
-		logrus.Warnf("json 解析失败 %v", data)
+		logger.Default().Warnf("json 解析失败 %v", data)
 		return nil
 	}
 	return dataType
@@ -50,7 +50,7 @@ func JSONMarshal(data interface{}) string {
 	}
 	res, err := json.Marshal(data)
 	if err != nil {
-		logrus.Warnf("json 生成失败 %v", data)
+		logger.Default().Warnf("json 生成失败 %v", data)
 		return ""
 	}
 	return string(res)